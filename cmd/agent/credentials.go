@@ -0,0 +1,18 @@
+package main
+
+import "context"
+
+// agentTokenCredentials передаёт предварительно выданный токен агента
+// в метаданных каждого gRPC-вызова.
+type agentTokenCredentials struct {
+	token      string
+	requireTLS bool
+}
+
+func (c agentTokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c agentTokenCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
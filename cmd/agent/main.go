@@ -1,35 +1,126 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"strconv"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"calculator/internal/agent"
 	calculator "calculator/internal/grpc/calculator"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
-	computingPower := 1
-	if v := os.Getenv("COMPUTING_POWER"); v != "" {
-		if cp, err := strconv.Atoi(v); err == nil {
-			computingPower = cp
+	cfg, err := agent.LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	agent.SetLogLevel(cfg.LogLevel)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dialOpts, err := dialOptions(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var conns []*grpc.ClientConn
+	var clients []calculator.CalculatorAgentServiceClient
+	for _, addr := range cfg.Orchestrators {
+		conn, err := grpc.NewClient(addr, dialOpts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка подключения к оркестратору %s: %v\n", addr, err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		clients = append(clients, calculator.NewCalculatorAgentServiceClient(conn))
+	}
+
+	if cfg.HealthCheck {
+		if err := waitForAnyHealthy(ctx, cfg, conns); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
 	}
 
-	conn, err := grpc.Dial("localhost:50051", grpc.WithInsecure())
+	client := agent.NewFailoverClient(cfg.Orchestrators, clients)
+	spool, err := agent.NewResultSpool(cfg.Spool.Dir, cfg.Spool.MaxResults)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	breaker := agent.NewCircuitBreaker(cfg.Backoff.Initial, cfg.Backoff.Max)
+	workerOpts := agent.WorkerOptions{
+		AgentName:     cfg.Name,
+		RetryInterval: cfg.Backoff.Initial,
+		MaxRetry:      cfg.Backoff.Max,
+		Breaker:       breaker,
+		Spool:         spool,
+	}
+
+	// Буфер результатов останавливается после воркеров, чтобы дослать и то,
+	// что они отложат во время остановки.
+	spoolCtx, stopSpool := context.WithCancel(context.Background())
+	spoolDone := make(chan struct{})
+	go func() {
+		spool.Run(spoolCtx, client, breaker, agent.Backoff{Initial: cfg.Backoff.Initial, Max: cfg.Backoff.Max})
+		close(spoolDone)
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			agent.Worker(ctx, id, client, workerOpts)
+		}(i)
 	}
-	defer conn.Close()
 
-	client := calculator.NewCalculatorAgentServiceClient(conn)
-	for i := 0; i < computingPower; i++ {
-		go agent.Worker(i, client)
+	fmt.Printf("Agent %s started with %d workers (orchestrators: %v)\n", cfg.Name, cfg.Workers, cfg.Orchestrators)
+	<-ctx.Done()
+	stop() // Повторный сигнал завершит процесс сразу
+	fmt.Println("Получен сигнал остановки, ожидаем завершения воркеров...")
+	wg.Wait()
+	stopSpool()
+	<-spoolDone
+	fmt.Println("Агент остановлен.")
+}
+
+func dialOptions(cfg agent.Config) ([]grpc.DialOption, error) {
+	transport := grpc.WithInsecure()
+	if cfg.TLS.Enabled() {
+		tlsConfig, err := agent.ClientTLSConfig(cfg.TLS.CA, cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		transport = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
 	}
 
-	fmt.Printf("Agent started with %d workers\n", computingPower)
-	select {}
-}
\ No newline at end of file
+	opts := []grpc.DialOption{transport}
+	if cfg.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(agentTokenCredentials{token: cfg.Token, requireTLS: cfg.TLS.Enabled()}))
+	}
+	return opts, nil
+}
+
+// waitForAnyHealthy ждёт, пока хотя бы один из оркестраторов сообщит о готовности.
+func waitForAnyHealthy(ctx context.Context, cfg agent.Config, conns []*grpc.ClientConn) error {
+	timeout := cfg.HealthTimeout / time.Duration(len(conns))
+	var lastErr error
+	for i, conn := range conns {
+		if lastErr = agent.WaitForHealthy(ctx, conn, timeout); lastErr == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Оркестратор %s не готов: %v\n", cfg.Orchestrators[i], lastErr)
+	}
+	return fmt.Errorf("ни один оркестратор не готов: %w", lastErr)
+}
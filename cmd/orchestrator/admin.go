@@ -0,0 +1,77 @@
+package main
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/orchestrator"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const adminUsage = `Использование: orchestrator admin <команда>
+  grant <логин>   выдать пользователю права администратора
+  revoke <логин>  отозвать права администратора
+  list            показать администраторов`
+
+// runAdmin выполняет подкоманду "orchestrator admin" и возвращает код завершения.
+// Права выдаются только отсюда, а не через HTTP API: доступ к БД и есть подтверждение
+// того, что команду запускает владелец оркестратора.
+func runAdmin(cfg orchestrator.Config, args []string) int {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+	case len(args) == 2 && (args[0] == "grant" || args[0] == "revoke"):
+	default:
+		fmt.Fprintln(os.Stderr, adminUsage)
+		return 2
+	}
+	if cfg.Storage == "memory" {
+		fmt.Fprintln(os.Stderr, "Хранилище в памяти видно только запущенному оркестратору: права в нём не назначаются")
+		return 1
+	}
+
+	dbStore, err := openStorage(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка открытия БД: %v\n", err)
+		return 1
+	}
+	defer dbStore.Close()
+	if err := dbStore.InitDB(); err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка миграции БД: %v\n", err)
+		return 1
+	}
+
+	if args[0] == "list" {
+		users, err := dbStore.ListUsers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _, u := range users {
+			if u.IsAdmin {
+				fmt.Printf("%4d  %s\n", u.ID, u.Login)
+			}
+		}
+		return 0
+	}
+
+	login, grant := args[1], args[0] == "grant"
+	user, err := dbStore.GetUserByLogin(login)
+	if errors.Is(err, database.ErrUserNotFound) {
+		fmt.Fprintf(os.Stderr, "Пользователь %q не найден: сначала зарегистрируйте его\n", login)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := dbStore.SetUserAdmin(user.ID, grant); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if grant {
+		fmt.Printf("Пользователь %s (ID %d) — администратор\n", login, user.ID)
+	} else {
+		fmt.Printf("У пользователя %s (ID %d) отозваны права администратора\n", login, user.ID)
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/orchestrator"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const backupUsage = `Использование:
+  orchestrator backup [файл]   снять копию БД, не останавливая оркестратор
+                               (по умолчанию — в каталог backup.dir с удалением старых копий)
+  orchestrator restore <файл>  заменить БД копией; оркестратор должен быть остановлен`
+
+// runBackup выполняет подкоманду "orchestrator backup" и возвращает код завершения.
+func runBackup(cfg orchestrator.Config, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, backupUsage)
+		return 2
+	}
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		fmt.Fprintf(os.Stderr, "БД недоступна: %v\n", err)
+		return 1
+	}
+	dbStore, err := database.NewStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка открытия БД: %v\n", err)
+		return 1
+	}
+	defer dbStore.Close()
+
+	if len(args) == 1 {
+		if err := dbStore.Backup(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("Резервная копия сохранена: %s\n", args[0])
+		return 0
+	}
+	info, err := orchestrator.NewBackups(dbStore, cfg.Backup).Create()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("Резервная копия сохранена: %s (%d байт)\n", filepath.Join(cfg.Backup.Dir, info.Name), info.Size)
+	return 0
+}
+
+// runRestore выполняет подкоманду "orchestrator restore" и возвращает код завершения.
+func runRestore(cfg orchestrator.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, backupUsage)
+		return 2
+	}
+	previous, version, err := database.RestoreBackup(args[0], cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("БД восстановлена из %s (версия схемы %d, последняя — %d)\n", args[0], version, database.LatestSchemaVersion())
+	if previous != "" {
+		fmt.Printf("Прежняя БД сохранена как %s\n", previous)
+	}
+	return 0
+}
@@ -3,58 +3,140 @@ package main
 import (
 	"calculator/internal/database"
 	pb "calculator/internal/grpc/calculator"
+	"calculator/internal/operations"
 	"calculator/internal/orchestrator"
+	"calculator/web"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
-const (
-	httpPort     = ":8080"
-	grpcPort     = ":50051"
-	dbPath       = "calculator.db"
-	jwtSecretEnv = "JWT_SECRET"
-)
+// commands — служебные подкоманды "orchestrator <команда>"; возвращают код завершения.
+var commands = map[string]func(cfg orchestrator.Config, args []string) int{
+	"migrate": runMigrate,
+	"backup":  runBackup,
+	"restore": runRestore,
+	"admin":   runAdmin,
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			// Служебные команды принимают те же флаги конфигурации, что и сервер,
+			// перед своими аргументами: orchestrator migrate -db other.db status.
+			cfg, args, err := orchestrator.ReadCommandConfig(os.Args[2:])
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			if err != nil {
+				log.Fatalf("Ошибка конфигурации: %v", err)
+			}
+			os.Exit(command(cfg, args))
+		}
+	}
+
+	cfg, err := orchestrator.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v", err)
+	}
+
 	fmt.Println("Запуск Оркестратора...")
 
-	dbStore, err := database.NewStore(dbPath)
+	dbStore, err := openStorage(cfg)
 	if err != nil {
 		log.Fatalf("Ошибка инициализации БД: %v", err)
 	}
-	defer dbStore.Close()
 
 	if err := dbStore.InitDB(); err != nil {
 		log.Fatalf("Ошибка миграции БД: %v", err)
 	}
 	fmt.Println("База данных инициализирована.")
 
-	jwtSecret := os.Getenv(jwtSecretEnv)
-	if jwtSecret == "" {
-		log.Fatalf("Переменная окружения %s не установлена!", jwtSecretEnv)
-	}
-	authService := orchestrator.NewAuthService(dbStore, jwtSecret)
+	authService := orchestrator.NewAuthService(dbStore, cfg.JWT.Secret)
+	authService.SetTokenTTL(cfg.JWT.TTL)
 	schedulerService := orchestrator.NewScheduler(dbStore)
+	level, _ := orchestrator.ParseOptimizationLevel(cfg.Optimization) // Проверено в cfg.Validate
+	schedulerService.SetOptimizationLevel(level)
+	fmt.Printf("Уровень оптимизации выражений: %s\n", schedulerService.OptimizationLevel())
+	schedulerService.SetQuotas(orchestrator.NewQuotas(dbStore, operations.Default, cfg.Quotas))
+	if cfg.ResultCache.Size > 0 {
+		fmt.Printf("Кэш результатов включён: до %d записей, TTL %v\n", cfg.ResultCache.Size, cfg.ResultCache.TTL)
+		schedulerService.SetResultCache(orchestrator.NewResultCache(cfg.ResultCache.Size, cfg.ResultCache.TTL))
+	}
 	grpcServerInstance := orchestrator.NewCalculatorGRPCServer(dbStore, schedulerService.GetOperationTimes(), schedulerService)
 
 	httpHandlers := orchestrator.NewHTTPHandlers(authService, dbStore, schedulerService)
+	adminHandlers := orchestrator.NewAdminHandlers(dbStore, schedulerService)
+	adminHandlers.SetConfig(cfg)
+	purger := orchestrator.NewPurger(dbStore, cfg.Retention)
+	adminHandlers.SetPurger(purger)
+	if p := cfg.Retention; p.Tasks > 0 || p.Expressions > 0 || p.ErrorExpressions > 0 {
+		fmt.Printf("Очистка истории: задачи %v, выражения %v, выражения с ошибкой %v (0s — бессрочно), каждые %v\n",
+			p.Tasks, p.Expressions, p.ErrorExpressions, p.Interval)
+	}
+	var backups *orchestrator.Backups
+	if backuper, ok := dbStore.(database.Backuper); ok {
+		backups = orchestrator.NewBackups(backuper, cfg.Backup)
+		adminHandlers.SetBackups(backups)
+		if p := cfg.Backup; p.Interval > 0 {
+			fmt.Printf("Резервное копирование БД: каждые %v в %s, хранить копий %d (0 — все)\n", p.Interval, p.Dir, p.Keep)
+		}
+	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		log.Fatalf("Ошибка прослушивания gRPC порта %s: %v", cfg.GRPCAddr, err)
+	}
+	opts, err := grpcServerOptions(cfg, dbStore, authService)
+	if err != nil {
+		log.Fatalf("Ошибка настройки TLS для gRPC: %v", err)
+	}
+	grpcSrv := grpc.NewServer(opts...)
+	calculatorService := orchestrator.NewCalculatorServiceServer(authService, dbStore, schedulerService)
+	pb.RegisterCalculatorAgentServiceServer(grpcSrv, grpcServerInstance)
+	pb.RegisterCalculatorServiceServer(grpcSrv, calculatorService)
+
+	healthReporter := orchestrator.NewHealthReporter(dbStore, schedulerService)
+	healthpb.RegisterHealthServer(grpcSrv, healthReporter.Server())
+	go healthReporter.Run(ctx) // При остановке переводит сервисы в NOT_SERVING
+	go purger.Run(ctx)
+	if backups != nil {
+		go backups.Run(ctx)
+	}
 	go func() {
-		lis, err := net.Listen("tcp", grpcPort)
-		if err != nil {
-			log.Fatalf("Ошибка прослушивания gRPC порта %s: %v", grpcPort, err)
+		// Изменения выражений от других оркестраторов с той же БД (только postgres).
+		if err := schedulerService.RelayExpressionEvents(ctx); err != nil {
+			log.Printf("Ошибка получения событий выражений: %v", err)
 		}
-		s := grpc.NewServer()
-		pb.RegisterCalculatorAgentServiceServer(s, grpcServerInstance)
+	}()
+	if cfg.Reflection {
+		reflection.Register(grpcSrv)
+	}
 
-		fmt.Printf("gRPC сервер слушает на %s\n", grpcPort)
-		if err := s.Serve(lis); err != nil {
-			log.Fatalf("Ошибка gRPC сервера: %v", err)
+	serveErr := make(chan error, 2)
+	go func() {
+		fmt.Printf("gRPC сервер слушает на %s\n", cfg.GRPCAddr)
+		if err := grpcSrv.Serve(lis); err != nil {
+			serveErr <- fmt.Errorf("ошибка gRPC сервера: %w", err)
 		}
 	}()
 
@@ -66,20 +148,132 @@ func main() {
 	router.Handle("/api/v1/calculate", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.CalculateHandler)))
 	router.Handle("/api/v1/expressions", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.ExpressionsHandler)))
 	router.Handle("/api/v1/expressions/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.ExpressionsHandler))) // Для путей с ID
+	router.Handle("/api/v1/explain", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.ExplainHandler)))
+	router.Handle("/api/v1/settings", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SettingsHandler)))
+
+	router.Handle("/api/v1/admin/agent-tokens", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.AgentTokensHandler)))
+	router.Handle("/api/v1/admin/agent-tokens/", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.AgentTokensHandler)))
+	router.Handle("/api/v1/admin/operation-times", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.OperationTimesHandler)))
+	router.Handle("/api/v1/admin/operation-times/", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.OperationTimesHandler)))
+	router.Handle("/api/v1/admin/quotas", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.QuotasHandler)))
+	router.Handle("/api/v1/admin/quotas/", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.QuotasHandler)))
+	router.Handle("/api/v1/admin/result-cache", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.ResultCacheHandler)))
+	router.Handle("/api/v1/admin/retention", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.RetentionHandler)))
+	router.Handle("/api/v1/admin/backups", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.BackupsHandler)))
+	router.Handle("/api/v1/admin/config", authService.AdminMiddleware(http.HandlerFunc(adminHandlers.ConfigHandler)))
 
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			http.ServeFile(w, r, "./web/static/index.html")
-		} else {
-			http.NotFound(w, r)
+	if cfg.StaticDir != "" {
+		fmt.Printf("Веб-интерфейс загружается из каталога %s\n", cfg.StaticDir)
+	}
+	router.Handle("/", web.Handler(cfg.StaticDir, orchestrator.WriteStatusProblem))
+
+	httpSrv := &http.Server{
+		Addr:              cfg.HTTPAddr,
+		Handler:           orchestrator.EnableCORS(router),
+		ReadHeaderTimeout: cfg.Timeouts.HTTPReadHeader,
+		ReadTimeout:       cfg.Timeouts.HTTPRead,
+		WriteTimeout:      cfg.Timeouts.HTTPWrite,
+		IdleTimeout:       cfg.Timeouts.HTTPIdle,
+	}
+	go func() {
+		fmt.Printf("HTTP сервер слушает на %s\n", cfg.HTTPAddr)
+		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- fmt.Errorf("ошибка HTTP сервера: %w", err)
 		}
-	})
+	}()
 
-	fmt.Printf("HTTP сервер слушает на %s\n", httpPort)
-	corsRouter := orchestrator.EnableCORS(router)
-	if err := http.ListenAndServe(httpPort, corsRouter); err != nil {
-		log.Fatalf("Ошибка старта HTTP сервера: %v", err)
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		fmt.Println("Получен сигнал остановки, завершаем обработку запросов...")
+	case err := <-serveErr:
+		log.Print(err)
+		exitCode = 1
 	}
+	stop() // Повторный сигнал завершит процесс сразу
 
+	shutdown(cfg.Timeouts.Shutdown, httpSrv, grpcSrv, calculatorService, schedulerService)
+	if err := dbStore.Close(); err != nil {
+		log.Printf("Ошибка закрытия БД: %v", err)
+	}
 	fmt.Println("Оркестратор остановлен.")
-}
\ No newline at end of file
+	os.Exit(exitCode)
+}
+
+// shutdown останавливает приём запросов и дожидается уже начатых: HTTP-запросов,
+// gRPC-вызовов агентов и клиентов, фонового планирования. Если они не укладываются
+// в timeout, оставшиеся gRPC-вызовы прерываются.
+func shutdown(timeout time.Duration, httpSrv *http.Server, grpcSrv *grpc.Server, watchers interface{ Shutdown() }, scheduler *orchestrator.Scheduler) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP сервер остановлен не чисто: %v", err)
+	}
+
+	watchers.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("gRPC вызовы не завершились за %v, прерываем их", timeout)
+		grpcSrv.Stop()
+	}
+
+	if err := scheduler.Drain(ctx); err != nil {
+		log.Print(err)
+	}
+}
+
+// openStorage создаёт хранилище, выбранное настройкой storage.
+func openStorage(cfg orchestrator.Config) (database.Storage, error) {
+	switch cfg.Storage {
+	case "sqlite":
+		return database.NewStore(cfg.DBPath)
+	case "postgres":
+		return database.NewPostgresStore(cfg.PostgresDSN)
+	case "memory":
+		return database.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("неизвестное хранилище %q: ожидается sqlite, postgres или memory", cfg.Storage)
+	}
+}
+
+// grpcServerOptions настраивает TLS, mTLS-авторизацию агентов по сертификату,
+// проверку JWT клиентского API и, если включено, проверку токенов агентов.
+func grpcServerOptions(cfg orchestrator.Config, dbStore database.AgentTokenStore, authService *orchestrator.AuthService) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	unary := []grpc.UnaryServerInterceptor{authService.JWTUnaryInterceptor()}
+	stream := []grpc.StreamServerInterceptor{authService.JWTStreamInterceptor()}
+
+	certFile, keyFile, clientCAFile := cfg.GRPCTLS.Cert, cfg.GRPCTLS.Key, cfg.GRPCTLS.ClientCA
+	if certFile == "" && keyFile == "" {
+		fmt.Println("Внимание: gRPC сервер работает без TLS")
+	} else {
+		tlsConfig, err := orchestrator.ServerTLSConfig(certFile, keyFile, clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		if clientCAFile != "" {
+			authorizer := orchestrator.NewCertAuthorizer(cfg.GRPCTLS.AllowedAgents)
+			unary = append(unary, authorizer.UnaryInterceptor())
+			stream = append(stream, authorizer.StreamInterceptor())
+			fmt.Println("gRPC: включён mTLS, агенты авторизуются по сертификату")
+		}
+	}
+
+	if cfg.AgentAuth == "token" {
+		authenticator := orchestrator.NewAgentTokenAuthenticator(dbStore)
+		unary = append(unary, authenticator.UnaryInterceptor())
+		stream = append(stream, authenticator.StreamInterceptor())
+		fmt.Println("gRPC: агенты аутентифицируются по токену")
+	}
+
+	opts = append(opts, grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	return opts, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/orchestrator"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `Использование: orchestrator migrate <команда>
+  up [версия]    применить миграции до версии (по умолчанию — до последней)
+  down [версия]  откатить миграции до версии (по умолчанию — на одну назад)
+  status         показать миграции и время их применения`
+
+// runMigrate выполняет подкоманду "orchestrator migrate" и возвращает код завершения.
+func runMigrate(cfg orchestrator.Config, args []string) int {
+	if len(args) == 0 || len(args) > 2 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	dbStore, err := database.NewStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка открытия БД: %v\n", err)
+		return 1
+	}
+	defer dbStore.Close()
+
+	current, err := dbStore.SchemaVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var target int
+	switch args[0] {
+	case "up":
+		target = database.LatestSchemaVersion()
+	case "down":
+		target = current - 1
+	case "status":
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		return printMigrations(dbStore, current)
+	}
+	if len(args) == 2 {
+		if target, err = strconv.Atoi(args[1]); err != nil || target < 0 {
+			fmt.Fprintf(os.Stderr, "Некорректная версия схемы: %s\n", args[1])
+			return 2
+		}
+	}
+
+	if args[0] == "up" {
+		err = dbStore.MigrateUp(target)
+	} else {
+		err = dbStore.MigrateDown(max(target, 0))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	version, _ := dbStore.SchemaVersion()
+	fmt.Printf("Версия схемы БД: %d (была %d)\n", version, current)
+	return 0
+}
+
+func printMigrations(dbStore *database.Store, current int) int {
+	statuses, err := dbStore.Migrations()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("Версия схемы БД: %d, последняя: %d\n", current, database.LatestSchemaVersion())
+	for _, st := range statuses {
+		applied := "не применена"
+		if st.AppliedAt.Valid {
+			applied = st.AppliedAt.Time.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%4d  %-16s %s\n", st.Version, st.Name, applied)
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Backoff вычисляет паузы между повторами: пауза удваивается от Initial до Max,
+// а фактическое значение выбирается случайно в диапазоне [d/2, d], чтобы воркеры
+// не повторяли запросы одновременно.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+// Next возвращает паузу перед очередным повтором.
+func (b *Backoff) Next() time.Duration {
+	d := b.Initial
+	for i := 0; i < b.attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d < b.Max {
+		b.attempt++
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// Reset возвращает паузу к начальной после успешного обращения.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// breakerThreshold — число подряд неудачных обращений к оркестратору, после которого цепь размыкается.
+const breakerThreshold = 3
+
+// CircuitBreaker — общий для воркеров агента предохранитель. После breakerThreshold
+// подряд ошибок соединения цепь размыкается, и воркеры ждут окончания паузы, не обращаясь
+// к оркестратору. Первая же ошибка после паузы снова размыкает цепь на более долгий срок,
+// первый успешный вызов замыкает её.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	backoff   Backoff
+	openUntil time.Time
+}
+
+func NewCircuitBreaker(initial, max time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{backoff: Backoff{Initial: initial, Max: max}}
+}
+
+// Wait блокирует, пока цепь разомкнута. Возвращает false, если ctx отменён.
+func (c *CircuitBreaker) Wait(ctx context.Context) bool {
+	c.mu.Lock()
+	wait := time.Until(c.openUntil)
+	c.mu.Unlock()
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	return sleep(ctx, wait)
+}
+
+// Success отмечает успешное обращение к оркестратору.
+func (c *CircuitBreaker) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures >= breakerThreshold {
+		infof("Связь с оркестратором восстановлена")
+	}
+	c.failures = 0
+	c.backoff.Reset()
+	c.openUntil = time.Time{}
+}
+
+// Failure отмечает ошибку соединения. hint — пауза, запрошенная сервером, если есть.
+func (c *CircuitBreaker) Failure(hint time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	if c.failures < breakerThreshold || time.Now().Before(c.openUntil) {
+		return
+	}
+	pause := c.backoff.Next()
+	if hint > pause {
+		pause = hint
+	}
+	c.openUntil = time.Now().Add(pause)
+	warnf("Оркестратор недоступен (%d ошибок подряд), следующая попытка через %v", c.failures, pause.Round(time.Millisecond))
+}
+
+// isConnectionError сообщает, что вызов не дошёл до оркестратора или тот временно
+// не может его обработать, и вызов имеет смысл повторить позже.
+func isConnectionError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// retryHint извлекает из ошибки паузу google.rpc.RetryInfo, подсказанную сервером.
+func retryHint(err error) time.Duration {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
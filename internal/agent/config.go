@@ -0,0 +1,275 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config — настройки агента. Источники применяются по возрастанию приоритета:
+// значения по умолчанию, YAML-файл (-config или AGENT_CONFIG), переменные окружения, флаги.
+type Config struct {
+	Orchestrators []string      `yaml:"orchestrators"` // Адреса host:port; при недоступности используется следующий
+	Workers       int           `yaml:"workers"`
+	Name          string        `yaml:"name"`
+	Token         string        `yaml:"token"`
+	TLS           TLSConfig     `yaml:"tls"`
+	HealthCheck   bool          `yaml:"health_check"`
+	HealthTimeout time.Duration `yaml:"health_timeout"`
+	Backoff       BackoffConfig `yaml:"backoff"`
+	Spool         SpoolConfig   `yaml:"spool"`
+	LogLevel      string        `yaml:"log_level"`
+}
+
+type TLSConfig struct {
+	CA         string `yaml:"ca"`
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	ServerName string `yaml:"server_name"`
+}
+
+// Enabled сообщает, что соединение с оркестратором должно использовать TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CA != "" || c.Cert != "" || c.Key != ""
+}
+
+// BackoffConfig ограничивает паузы между повторами обращений к оркестратору.
+type BackoffConfig struct {
+	Initial time.Duration `yaml:"initial"`
+	Max     time.Duration `yaml:"max"`
+}
+
+// SpoolConfig задаёт буфер результатов, которые не удалось отправить оркестратору.
+type SpoolConfig struct {
+	Dir        string `yaml:"dir"`         // Каталог для хранения на диске; пусто — только в памяти
+	MaxResults int    `yaml:"max_results"` // Сколько результатов хранить, прежде чем вытеснять старые
+}
+
+const maxWorkers = 1024
+
+func DefaultConfig() Config {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "agent"
+	}
+	return Config{
+		Orchestrators: []string{"localhost:50051"},
+		Workers:       1,
+		Name:          name,
+		HealthTimeout: 60 * time.Second,
+		Backoff:       BackoffConfig{Initial: time.Second, Max: 30 * time.Second},
+		Spool:         SpoolConfig{MaxResults: 1000},
+		LogLevel:      "info",
+	}
+}
+
+// LoadConfig собирает конфигурацию из файла, окружения и аргументов командной строки
+// и проверяет её.
+func LoadConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("AGENT_CONFIG"), "путь к YAML-файлу конфигурации")
+	orchestrators := fs.String("orchestrator", "", "адреса оркестраторов host:port через запятую")
+	workers := fs.Int("workers", 0, "число воркеров")
+	name := fs.String("name", "", "имя агента")
+	token := fs.String("token", "", "токен агента")
+	tlsCA := fs.String("tls-ca", "", "CA для проверки сертификата оркестратора")
+	tlsCert := fs.String("tls-cert", "", "клиентский сертификат агента")
+	tlsKey := fs.String("tls-key", "", "ключ клиентского сертификата")
+	tlsServerName := fs.String("tls-server-name", "", "ожидаемое имя в сертификате оркестратора")
+	healthCheck := fs.Bool("health-check", false, "дождаться готовности оркестратора перед запуском воркеров")
+	healthTimeout := fs.Duration("health-timeout", 0, "сколько ждать готовности оркестратора")
+	backoffInitial := fs.Duration("backoff-initial", 0, "начальная пауза между повторами")
+	backoffMax := fs.Duration("backoff-max", 0, "максимальная пауза между повторами")
+	spoolDir := fs.String("spool-dir", "", "каталог для неотправленных результатов")
+	spoolMax := fs.Int("spool-max", 0, "сколько неотправленных результатов хранить")
+	logLevel := fs.String("log-level", "", "уровень логирования: debug, info, warn, error")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "orchestrator":
+			cfg.Orchestrators = splitList(*orchestrators)
+		case "workers":
+			cfg.Workers = *workers
+		case "name":
+			cfg.Name = *name
+		case "token":
+			cfg.Token = *token
+		case "tls-ca":
+			cfg.TLS.CA = *tlsCA
+		case "tls-cert":
+			cfg.TLS.Cert = *tlsCert
+		case "tls-key":
+			cfg.TLS.Key = *tlsKey
+		case "tls-server-name":
+			cfg.TLS.ServerName = *tlsServerName
+		case "health-check":
+			cfg.HealthCheck = *healthCheck
+		case "health-timeout":
+			cfg.HealthTimeout = *healthTimeout
+		case "backoff-initial":
+			cfg.Backoff.Initial = *backoffInitial
+		case "backoff-max":
+			cfg.Backoff.Max = *backoffMax
+		case "spool-dir":
+			cfg.Spool.Dir = *spoolDir
+		case "spool-max":
+			cfg.Spool.MaxResults = *spoolMax
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения конфигурации %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("ошибка разбора конфигурации %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: некорректная длительность %q", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	if v := os.Getenv("AGENT_ORCHESTRATORS"); v != "" {
+		c.Orchestrators = splitList(v)
+	}
+	if v := os.Getenv("COMPUTING_POWER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("COMPUTING_POWER: ожидается целое число, получено %q", v))
+		} else {
+			c.Workers = n
+		}
+	}
+	str("AGENT_NAME", &c.Name)
+	str("AGENT_TOKEN", &c.Token)
+	str("AGENT_TLS_CA", &c.TLS.CA)
+	str("AGENT_TLS_CERT", &c.TLS.Cert)
+	str("AGENT_TLS_KEY", &c.TLS.Key)
+	str("AGENT_TLS_SERVER_NAME", &c.TLS.ServerName)
+	if v := os.Getenv("AGENT_HEALTH_CHECK"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AGENT_HEALTH_CHECK: ожидается true/false, получено %q", v))
+		} else {
+			c.HealthCheck = b
+		}
+	}
+	duration("AGENT_HEALTH_TIMEOUT", &c.HealthTimeout)
+	duration("AGENT_BACKOFF_INITIAL", &c.Backoff.Initial)
+	duration("AGENT_BACKOFF_MAX", &c.Backoff.Max)
+	str("AGENT_SPOOL_DIR", &c.Spool.Dir)
+	if v := os.Getenv("AGENT_SPOOL_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("AGENT_SPOOL_MAX: ожидается целое число, получено %q", v))
+		} else {
+			c.Spool.MaxResults = n
+		}
+	}
+	str("AGENT_LOG_LEVEL", &c.LogLevel)
+
+	return errors.Join(errs...)
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки сразу.
+func (c Config) Validate() error {
+	var errs []error
+	if len(c.Orchestrators) == 0 {
+		errs = append(errs, errors.New("не задан ни один адрес оркестратора"))
+	}
+	for _, addr := range c.Orchestrators {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("адрес оркестратора %q: ожидается host:port", addr))
+			continue
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			errs = append(errs, fmt.Errorf("адрес оркестратора %q: некорректный порт", addr))
+		}
+	}
+	if c.Workers < 1 || c.Workers > maxWorkers {
+		errs = append(errs, fmt.Errorf("число воркеров должно быть от 1 до %d, получено %d", maxWorkers, c.Workers))
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		errs = append(errs, errors.New("имя агента не может быть пустым"))
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		errs = append(errs, errors.New("для клиентского сертификата нужны и tls.cert, и tls.key"))
+	}
+	if c.HealthTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("health_timeout должен быть положительным, получено %v", c.HealthTimeout))
+	}
+	if c.Backoff.Initial <= 0 {
+		errs = append(errs, fmt.Errorf("backoff.initial должен быть положительным, получено %v", c.Backoff.Initial))
+	}
+	if c.Backoff.Max < c.Backoff.Initial {
+		errs = append(errs, fmt.Errorf("backoff.max (%v) не может быть меньше backoff.initial (%v)", c.Backoff.Max, c.Backoff.Initial))
+	}
+	if c.Spool.MaxResults < 1 {
+		errs = append(errs, fmt.Errorf("spool.max_results должен быть положительным, получено %d", c.Spool.MaxResults))
+	}
+	if _, ok := logLevels[strings.ToLower(c.LogLevel)]; !ok {
+		errs = append(errs, fmt.Errorf("неизвестный уровень логирования %q (ожидается debug, info, warn или error)", c.LogLevel))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("некорректная конфигурация агента:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	yamlConfig := `
+orchestrators: ["orch-1:50051", "orch-2:50051"]
+workers: 2
+name: from-file
+backoff:
+  initial: 2s
+  max: 1m
+log_level: warn
+`
+	if err := os.WriteFile(path, []byte(yamlConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGENT_CONFIG", path)
+	t.Setenv("COMPUTING_POWER", "4")
+	t.Setenv("AGENT_NAME", "from-env")
+
+	cfg, err := LoadConfig([]string{"-name", "from-flag"})
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if len(cfg.Orchestrators) != 2 || cfg.Orchestrators[1] != "orch-2:50051" {
+		t.Errorf("Orchestrators = %v, want values from file", cfg.Orchestrators)
+	}
+	if cfg.Workers != 4 {
+		t.Errorf("Workers = %d, want 4 from env", cfg.Workers)
+	}
+	if cfg.Name != "from-flag" {
+		t.Errorf("Name = %q, want flag to win", cfg.Name)
+	}
+	if cfg.Backoff.Initial != 2*time.Second || cfg.Backoff.Max != time.Minute || cfg.LogLevel != "warn" {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"BadComputingPower", map[string]string{"COMPUTING_POWER": "four"}, nil, "COMPUTING_POWER"},
+		{"ZeroWorkers", nil, []string{"-workers", "0"}, "число воркеров"},
+		{"BadAddress", nil, []string{"-orchestrator", "localhost"}, "host:port"},
+		{"BadPort", nil, []string{"-orchestrator", "localhost:99999"}, "некорректный порт"},
+		{"CertWithoutKey", nil, []string{"-tls-cert", "agent.pem"}, "tls.key"},
+		{"BackoffOrder", nil, []string{"-backoff-initial", "10s", "-backoff-max", "1s"}, "backoff.max"},
+		{"LogLevel", map[string]string{"AGENT_LOG_LEVEL": "loud"}, nil, "уровень логирования"},
+		{"UnknownFlag", nil, []string{"-bogus"}, "bogus"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadConfig(tc.args)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("LoadConfig error = %v, want mention of %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownFileField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	os.WriteFile(path, []byte("orchestrator: typo:50051\n"), 0600)
+	if _, err := LoadConfig([]string{"-config", path}); err == nil {
+		t.Fatal("expected error for unknown field in config file")
+	}
+}
+
+// stubAgentClient возвращает заранее заданную ошибку и считает вызовы.
+type stubAgentClient struct {
+	err   error
+	calls int
+}
+
+func (s *stubAgentClient) GetTask(ctx context.Context, in *pb.GetTaskRequest, opts ...grpc.CallOption) (*pb.GetTaskResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &pb.GetTaskResponse{}, nil
+}
+
+func (s *stubAgentClient) SubmitResult(ctx context.Context, in *pb.SubmitResultRequest, opts ...grpc.CallOption) (*pb.SubmitResultResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &pb.SubmitResultResponse{Acknowledged: true}, nil
+}
+
+func (s *stubAgentClient) ReleaseTask(ctx context.Context, in *pb.ReleaseTaskRequest, opts ...grpc.CallOption) (*pb.ReleaseTaskResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &pb.ReleaseTaskResponse{Released: true}, nil
+}
+
+func TestFailoverClient(t *testing.T) {
+	down := &stubAgentClient{err: status.Error(codes.Unavailable, "down")}
+	up := &stubAgentClient{}
+	client := NewFailoverClient([]string{"a:1", "b:1"}, []pb.CalculatorAgentServiceClient{down, up})
+
+	if _, err := client.GetTask(context.Background(), &pb.GetTaskRequest{}); err != nil {
+		t.Fatalf("GetTask should fail over: %v", err)
+	}
+	if _, err := client.SubmitResult(context.Background(), &pb.SubmitResultRequest{}); err != nil {
+		t.Fatalf("SubmitResult error: %v", err)
+	}
+	if down.calls != 1 || up.calls != 2 {
+		t.Errorf("calls: down=%d up=%d, want the client to stick to the healthy orchestrator", down.calls, up.calls)
+	}
+
+	up.err = status.Error(codes.FailedPrecondition, "lease lost")
+	if _, err := client.SubmitResult(context.Background(), &pb.SubmitResultRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("non-availability errors must not fail over, got %v", err)
+	}
+	if down.calls != 1 {
+		t.Errorf("failed over on a non-availability error")
+	}
+}
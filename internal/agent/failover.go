@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"sync"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FailoverClient — клиент сервиса агентов поверх нескольких оркестраторов.
+// Вызовы идут на текущий оркестратор; если он недоступен (UNAVAILABLE),
+// клиент переключается на следующий по списку и повторяет вызов.
+type FailoverClient struct {
+	mu      sync.Mutex
+	addrs   []string
+	clients []pb.CalculatorAgentServiceClient
+	current int
+}
+
+func NewFailoverClient(addrs []string, clients []pb.CalculatorAgentServiceClient) *FailoverClient {
+	return &FailoverClient{addrs: addrs, clients: clients}
+}
+
+func (f *FailoverClient) GetTask(ctx context.Context, in *pb.GetTaskRequest, opts ...grpc.CallOption) (*pb.GetTaskResponse, error) {
+	return failoverCall(f, func(c pb.CalculatorAgentServiceClient) (*pb.GetTaskResponse, error) {
+		return c.GetTask(ctx, in, opts...)
+	})
+}
+
+func (f *FailoverClient) SubmitResult(ctx context.Context, in *pb.SubmitResultRequest, opts ...grpc.CallOption) (*pb.SubmitResultResponse, error) {
+	return failoverCall(f, func(c pb.CalculatorAgentServiceClient) (*pb.SubmitResultResponse, error) {
+		return c.SubmitResult(ctx, in, opts...)
+	})
+}
+
+func (f *FailoverClient) ReleaseTask(ctx context.Context, in *pb.ReleaseTaskRequest, opts ...grpc.CallOption) (*pb.ReleaseTaskResponse, error) {
+	return failoverCall(f, func(c pb.CalculatorAgentServiceClient) (*pb.ReleaseTaskResponse, error) {
+		return c.ReleaseTask(ctx, in, opts...)
+	})
+}
+
+func failoverCall[T any](f *FailoverClient, call func(pb.CalculatorAgentServiceClient) (T, error)) (T, error) {
+	f.mu.Lock()
+	start := f.current
+	f.mu.Unlock()
+
+	var resp T
+	var err error
+	for i := 0; i < len(f.clients); i++ {
+		idx := (start + i) % len(f.clients)
+		resp, err = call(f.clients[idx])
+		if status.Code(err) != codes.Unavailable {
+			f.mu.Lock()
+			if f.current != idx {
+				infof("Переключение на оркестратор %s", f.addrs[idx])
+				f.current = idx
+			}
+			f.mu.Unlock()
+			return resp, err
+		}
+		if len(f.clients) > 1 {
+			warnf("Оркестратор %s недоступен: %v", f.addrs[idx], err)
+		}
+	}
+	return resp, err
+}
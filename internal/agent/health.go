@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const healthProbeInterval = time.Second
+
+// WaitForHealthy опрашивает grpc.health.v1.Health оркестратора, пока сервис агентов
+// не перейдёт в SERVING. Возвращает ошибку, если этого не произошло до истечения timeout.
+func WaitForHealthy(ctx context.Context, conn grpc.ClientConnInterface, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := healthpb.NewHealthClient(conn)
+	req := &healthpb.HealthCheckRequest{Service: pb.CalculatorAgentService_ServiceDesc.ServiceName}
+	var lastErr error
+	for {
+		resp, err := client.Check(ctx, req)
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.Status == healthpb.HealthCheckResponse_SERVING:
+			log.Printf("Оркестратор готов к работе (%s: SERVING)", req.Service)
+			return nil
+		default:
+			lastErr = fmt.Errorf("статус сервиса %s: %s", req.Service, resp.Status)
+		}
+		log.Printf("Оркестратор не готов: %v. Повтор через %v...", lastErr, healthProbeInterval)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("оркестратор не готов за %v: %w", timeout, lastErr)
+		case <-time.After(healthProbeInterval):
+		}
+	}
+}
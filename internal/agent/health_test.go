@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestWaitForHealthy(t *testing.T) {
+	healthServer := health.NewServer()
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, healthServer)
+	lis := bufconn.Listen(1024 * 1024)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	service := pb.CalculatorAgentService_ServiceDesc.ServiceName
+	healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := WaitForHealthy(context.Background(), conn, 1500*time.Millisecond); err == nil {
+		t.Fatal("WaitForHealthy succeeded for NOT_SERVING orchestrator")
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}()
+	if err := WaitForHealthy(context.Background(), conn, 5*time.Second); err != nil {
+		t.Fatalf("WaitForHealthy error: %v", err)
+	}
+}
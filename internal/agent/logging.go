@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]int32{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+var currentLogLevel = levelInfo
+
+// SetLogLevel задаёт минимальный уровень сообщений агента: debug, info, warn или error.
+func SetLogLevel(level string) {
+	if l, ok := logLevels[strings.ToLower(level)]; ok {
+		atomic.StoreInt32(&currentLogLevel, l)
+	}
+}
+
+func logf(level int32, format string, args ...interface{}) {
+	if level >= atomic.LoadInt32(&currentLogLevel) {
+		log.Printf(format, args...)
+	}
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ResultSpool хранит результаты, которые не удалось отправить оркестратору,
+// и досылает их, когда связь восстанавливается. Число результатов ограничено:
+// при переполнении вытесняется самый старый. Если задан каталог, каждый результат
+// дублируется файлом и переживает перезапуск агента.
+type ResultSpool struct {
+	mu      sync.Mutex
+	dir     string
+	max     int
+	entries []*spoolEntry
+	wake    chan struct{}
+}
+
+type spoolEntry struct {
+	req  *pb.SubmitResultRequest
+	file string // Пусто, если результат хранится только в памяти
+}
+
+// NewResultSpool создаёт буфер на max результатов. Если dir не пуст, каталог создаётся
+// при необходимости, а сохранённые в нём ранее результаты загружаются в буфер.
+func NewResultSpool(dir string, max int) (*ResultSpool, error) {
+	s := &ResultSpool{dir: dir, max: max, wake: make(chan struct{}, 1)}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ошибка создания каталога буфера результатов %s: %w", dir, err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения %s: %w", file, err)
+		}
+		req := &pb.SubmitResultRequest{}
+		if err := protojson.Unmarshal(data, req); err != nil {
+			warnf("Повреждённый файл буфера результатов %s пропущен: %v", file, err)
+			continue
+		}
+		s.entries = append(s.entries, &spoolEntry{req: req, file: file})
+	}
+	for len(s.entries) > s.max {
+		s.evictOldest()
+	}
+	if len(s.entries) > 0 {
+		infof("Загружено %d неотправленных результатов из %s", len(s.entries), dir)
+		s.wake <- struct{}{}
+	}
+	return s, nil
+}
+
+// Put откладывает результат для повторной отправки.
+func (s *ResultSpool) Put(req *pb.SubmitResultRequest) error {
+	entry := &spoolEntry{req: req}
+	if s.dir != "" {
+		data, err := protojson.Marshal(req)
+		if err != nil {
+			return err
+		}
+		// Время в имени сохраняет порядок результатов после перезапуска.
+		entry.file = filepath.Join(s.dir, fmt.Sprintf("%020d-%d-%s.json", time.Now().UnixNano(), req.TaskId, req.LeaseToken))
+		tmp := entry.file + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o600); err != nil {
+			return fmt.Errorf("ошибка записи результата задачи ID %d в буфер: %w", req.TaskId, err)
+		}
+		if err := os.Rename(tmp, entry.file); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("ошибка записи результата задачи ID %d в буфер: %w", req.TaskId, err)
+		}
+	}
+
+	s.mu.Lock()
+	if len(s.entries) >= s.max {
+		s.evictOldest()
+	}
+	s.entries = append(s.entries, entry)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len возвращает число неотправленных результатов.
+func (s *ResultSpool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// evictOldest вытесняет самый старый результат. Вызывается под s.mu.
+func (s *ResultSpool) evictOldest() {
+	oldest := s.entries[0]
+	s.entries = s.entries[1:]
+	s.removeFile(oldest)
+	warnf("Буфер результатов переполнен (%d), результат задачи ID %d отброшен", s.max, oldest.req.TaskId)
+}
+
+func (s *ResultSpool) remove(entry *spoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e == entry {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	s.removeFile(entry)
+}
+
+func (s *ResultSpool) removeFile(entry *spoolEntry) {
+	if entry.file == "" {
+		return
+	}
+	if err := os.Remove(entry.file); err != nil && !os.IsNotExist(err) {
+		warnf("Не удалось удалить %s: %v", entry.file, err)
+	}
+}
+
+// Flush отправляет накопленные результаты по порядку. Результаты, которые оркестратор
+// принял или окончательно отклонил, удаляются из буфера. При ошибке соединения
+// отправка прекращается и ошибка возвращается.
+func (s *ResultSpool) Flush(ctx context.Context, client pb.CalculatorAgentServiceClient) error {
+	s.mu.Lock()
+	pending := append([]*spoolEntry(nil), s.entries...)
+	s.mu.Unlock()
+
+	for _, entry := range pending {
+		_, err := client.SubmitResult(ctx, entry.req)
+		if err != nil && isConnectionError(err) {
+			return err
+		}
+		if err != nil {
+			warnf("Отложенный результат задачи ID %d отклонён оркестратором: %v", entry.req.TaskId, err)
+		} else {
+			infof("Отложенный результат задачи ID %d отправлен.", entry.req.TaskId)
+		}
+		s.remove(entry)
+	}
+	return nil
+}
+
+// Run досылает результаты, пока не отменён ctx, соблюдая общий предохранитель и
+// экспоненциальную паузу между неудачными попытками. При остановке делает последнюю
+// попытку отправки.
+func (s *ResultSpool) Run(ctx context.Context, client pb.CalculatorAgentServiceClient, breaker *CircuitBreaker, backoff Backoff) {
+	for {
+		select {
+		case <-ctx.Done():
+			s.finalFlush(ctx, client)
+			return
+		case <-s.wake:
+		}
+
+		for s.Len() > 0 && breaker.Wait(ctx) {
+			err := s.Flush(ctx, client)
+			if err == nil {
+				breaker.Success()
+				backoff.Reset()
+				break
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			breaker.Failure(retryHint(err))
+			delay := backoff.Next()
+			debugf("Отправка %d отложенных результатов не удалась: %v. Повтор через %v", s.Len(), err, delay)
+			sleep(ctx, delay)
+		}
+	}
+}
+
+func (s *ResultSpool) finalFlush(ctx context.Context, client pb.CalculatorAgentServiceClient) {
+	if s.Len() == 0 {
+		return
+	}
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalCallTimeout)
+	defer cancel()
+	if err := s.Flush(flushCtx, client); err != nil {
+		warnf("Не удалось отправить отложенные результаты при остановке: %v", err)
+	}
+	if n := s.Len(); n > 0 {
+		if s.dir != "" {
+			warnf("%d неотправленных результатов сохранены в %s и будут отправлены после перезапуска", n, s.dir)
+		} else {
+			warnf("%d неотправленных результатов потеряны: буфер хранится только в памяти", n)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	ceilings := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, ceiling := range ceilings {
+		ceiling *= time.Millisecond
+		d := b.Next()
+		if d < ceiling/2 || d > ceiling {
+			t.Fatalf("attempt %d: delay %v outside [%v, %v]", i, d, ceiling/2, ceiling)
+		}
+	}
+	b.Reset()
+	if d := b.Next(); d > 100*time.Millisecond {
+		t.Fatalf("delay after Reset = %v, want <= 100ms", d)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(time.Hour, time.Hour)
+	for i := 0; i < breakerThreshold-1; i++ {
+		breaker.Failure(0)
+	}
+	if !breaker.Wait(context.Background()) {
+		t.Fatal("breaker must stay closed below the threshold")
+	}
+
+	breaker.Failure(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if breaker.Wait(ctx) {
+		t.Fatal("open breaker must block until the pause ends")
+	}
+
+	breaker.Success()
+	if !breaker.Wait(context.Background()) {
+		t.Fatal("breaker must close after a success")
+	}
+}
+
+func TestRetryHint(t *testing.T) {
+	st, err := status.New(codes.Unavailable, "busy").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(7 * time.Second)})
+	if err != nil {
+		t.Fatalf("WithDetails: %v", err)
+	}
+	if got := retryHint(st.Err()); got != 7*time.Second {
+		t.Fatalf("retryHint = %v, want 7s", got)
+	}
+	if got := retryHint(status.Error(codes.Unavailable, "down")); got != 0 {
+		t.Fatalf("retryHint without details = %v, want 0", got)
+	}
+}
+
+// submitClient отвечает на SubmitResult заданными ошибками по ID задачи.
+type submitClient struct {
+	stubAgentClient
+	errs      map[int64]error
+	submitted []int64
+}
+
+func (c *submitClient) SubmitResult(ctx context.Context, in *pb.SubmitResultRequest, opts ...grpc.CallOption) (*pb.SubmitResultResponse, error) {
+	if err := c.errs[in.TaskId]; err != nil {
+		return nil, err
+	}
+	c.submitted = append(c.submitted, in.TaskId)
+	return &pb.SubmitResultResponse{Acknowledged: true}, nil
+}
+
+func spoolResult(taskID int64) *pb.SubmitResultRequest {
+	return &pb.SubmitResultRequest{
+		TaskId:       taskID,
+		LeaseToken:   "lease",
+		ResultStatus: &pb.SubmitResultRequest_Result{Result: float64(taskID)},
+	}
+}
+
+func TestResultSpoolFlush(t *testing.T) {
+	spool, _ := NewResultSpool("", 10)
+	for id := int64(1); id <= 3; id++ {
+		spool.Put(spoolResult(id))
+	}
+	client := &submitClient{errs: map[int64]error{
+		2: status.Error(codes.FailedPrecondition, "lease lost"),
+		3: status.Error(codes.Unavailable, "down"),
+	}}
+
+	if err := spool.Flush(context.Background(), client); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Flush error = %v, want Unavailable", err)
+	}
+	if spool.Len() != 1 {
+		t.Fatalf("spool keeps %d results, want only the unsent one", spool.Len())
+	}
+
+	delete(client.errs, 3)
+	if err := spool.Flush(context.Background(), client); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if spool.Len() != 0 || len(client.submitted) != 2 || client.submitted[1] != 3 {
+		t.Fatalf("after flush: len=%d submitted=%v", spool.Len(), client.submitted)
+	}
+}
+
+func TestResultSpoolOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewResultSpool(dir, 2)
+	if err != nil {
+		t.Fatalf("NewResultSpool: %v", err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		if err := spool.Put(spoolResult(id)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Перезапуск агента: результаты читаются с диска, вытесненный первый не возвращается.
+	restored, err := NewResultSpool(dir, 2)
+	if err != nil {
+		t.Fatalf("NewResultSpool after restart: %v", err)
+	}
+	client := &submitClient{}
+	if err := restored.Flush(context.Background(), client); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(client.submitted) != 2 || client.submitted[0] != 2 || client.submitted[1] != 3 {
+		t.Fatalf("submitted = %v, want [2 3]", client.submitted)
+	}
+
+	again, _ := NewResultSpool(dir, 2)
+	if again.Len() != 0 {
+		t.Fatalf("sent results must be removed from disk, %d left", again.Len())
+	}
+}
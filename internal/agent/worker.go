@@ -2,99 +2,175 @@ package agent
 
 import (
 	pb "calculator/internal/grpc/calculator" // Обновленный импорт gRPC кода
+	"calculator/internal/operations"
 	"context"
 	"fmt"
-	"log"
 	"time"
 )
 
-func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient) {
-	log.Printf("Воркер %d запущен.", workerID)
-	ctx := context.Background() // Основной контекст для gRPC вызовов
-	agentID := fmt.Sprintf("agent-%d", workerID)
+// WorkerOptions — параметры воркера, общие для всех воркеров агента.
+type WorkerOptions struct {
+	AgentName     string          // Имя агента; ID воркера — "<имя>-<номер>"
+	RetryInterval time.Duration   // Начальная пауза перед повтором после ошибки соединения
+	MaxRetry      time.Duration   // Верхняя граница экспоненциальной паузы
+	Breaker       *CircuitBreaker // Общий предохранитель; nil — у каждого воркера свой
+	Spool         *ResultSpool    // Буфер неотправленных результатов; nil — такие результаты теряются
+}
+
+func (o WorkerOptions) withDefaults() WorkerOptions {
+	if o.AgentName == "" {
+		o.AgentName = "agent"
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.MaxRetry < o.RetryInterval {
+		o.MaxRetry = o.RetryInterval
+	}
+	if o.Breaker == nil {
+		o.Breaker = NewCircuitBreaker(o.RetryInterval, o.MaxRetry)
+	}
+	return o
+}
+
+// maxRetryHint ограничивает паузу, подсказанную сервером, на случай некорректного значения.
+const maxRetryHint = 10 * time.Minute
+
+// finalCallTimeout ограничивает вызовы, которые воркер делает уже после сигнала остановки:
+// отправку готового результата и возврат незавершённой задачи.
+const finalCallTimeout = 5 * time.Second
+
+// Worker получает и выполняет задачи, пока не отменён ctx. После отмены новые задачи
+// не запрашиваются; задача, для которой результат уже посчитан, отправляется,
+// а задача, ожидающая окончания времени операции, возвращается в очередь.
+func Worker(ctx context.Context, workerID int, grpcClient pb.CalculatorAgentServiceClient, opts WorkerOptions) {
+	opts = opts.withDefaults()
+	infof("Воркер %d запущен.", workerID)
+	defer infof("Воркер %d остановлен.", workerID)
+	agentID := fmt.Sprintf("%s-%d", opts.AgentName, workerID)
+
+	backoff := Backoff{Initial: opts.RetryInterval, Max: opts.MaxRetry}
 
-	for {
-		log.Printf("Воркер %d: Запрос задачи...", workerID)
+	for opts.Breaker.Wait(ctx) {
+		debugf("Воркер %d: Запрос задачи...", workerID)
 		var task *pb.Task
-		var err error
-		var retryAfter time.Duration = 1 * time.Second // Задержка по умолчанию
 
 		getTaskReq := &pb.GetTaskRequest{AgentId: agentID}
 		getTaskResp, err := grpcClient.GetTask(ctx, getTaskReq)
 
 		if err != nil {
-			log.Printf("Воркер %d: Ошибка gRPC при получении задачи: %v. Повтор через %v...", workerID, err, retryAfter)
-			time.Sleep(retryAfter)
+			if ctx.Err() != nil {
+				return
+			}
+			delay := backoff.Next()
+			if isConnectionError(err) {
+				hint := retryHint(err)
+				opts.Breaker.Failure(hint)
+				if hint > delay {
+					delay = min(hint, maxRetryHint)
+				}
+				debugf("Воркер %d: Оркестратор недоступен: %v. Повтор через %v...", workerID, err, delay)
+			} else {
+				warnf("Воркер %d: Ошибка gRPC при получении задачи: %v. Повтор через %v...", workerID, err, delay)
+			}
+			sleep(ctx, delay)
 			continue
 		}
+		opts.Breaker.Success()
+		backoff.Reset()
 
 		switch taskInfo := getTaskResp.TaskInfo.(type) {
 		case *pb.GetTaskResponse_Task:
 			task = taskInfo.Task
-			log.Printf("Воркер %d: Получена задача ID %d: %f %s %f (время: %dms)",
+			debugf("Воркер %d: Получена задача ID %d: %f %s %f (время: %dms)",
 				workerID, task.Id, task.Arg1, task.Operation, task.Arg2, task.OperationTimeMs)
 		case *pb.GetTaskResponse_NoTask:
+			retryAfter := opts.RetryInterval
 			if taskInfo.NoTask != nil && taskInfo.NoTask.RetryAfterSeconds > 0 {
-				retryAfter = time.Duration(taskInfo.NoTask.RetryAfterSeconds) * time.Second
+				retryAfter = min(time.Duration(taskInfo.NoTask.RetryAfterSeconds)*time.Second, maxRetryHint)
 			}
-			log.Printf("Воркер %d: Нет доступных задач. Повтор через %v...", workerID, retryAfter)
-			time.Sleep(retryAfter)
+			debugf("Воркер %d: Нет доступных задач. Повтор через %v...", workerID, retryAfter)
+			sleep(ctx, retryAfter)
 			continue // Переходим к следующей итерации цикла
 		default:
-			log.Printf("Воркер %d: Получен неизвестный ответ от GetTask. Повтор через %v...", workerID, retryAfter)
-			time.Sleep(retryAfter)
+			delay := backoff.Next()
+			warnf("Воркер %d: Получен неизвестный ответ от GetTask. Повтор через %v...", workerID, delay)
+			sleep(ctx, delay)
 			continue
 		}
 
 		startTime := time.Now()
-		result, computeErr := compute(task.Arg1, task.Arg2, task.Operation)
+		result, computeErr := operations.Default.Evaluate(task.Operation, task.Arg1, task.Arg2)
 		computationDuration := time.Since(startTime)
 
 		if task.OperationTimeMs > 0 {
 			requiredDuration := time.Duration(task.OperationTimeMs) * time.Millisecond
-			if computationDuration < requiredDuration {
-				time.Sleep(requiredDuration - computationDuration)
+			if computationDuration < requiredDuration && !sleep(ctx, requiredDuration-computationDuration) {
+				releaseTask(ctx, workerID, grpcClient, agentID, task)
+				return
 			}
 		}
 
 		submitReq := &pb.SubmitResultRequest{
-			TaskId:  task.Id,
-			AgentId: agentID,
+			TaskId:     task.Id,
+			AgentId:    agentID,
+			LeaseToken: task.LeaseToken,
 		}
 		if computeErr != nil {
-			log.Printf("Воркер %d: Ошибка вычисления задачи ID %d: %v", workerID, task.Id, computeErr)
+			warnf("Воркер %d: Ошибка вычисления задачи ID %d: %v", workerID, task.Id, computeErr)
 			submitReq.ResultStatus = &pb.SubmitResultRequest_Error{
 				Error: &pb.TaskError{Message: computeErr.Error()},
 			}
 		} else {
-			log.Printf("Воркер %d: Завершено вычисление задачи ID %d. Результат: %f", workerID, task.Id, result)
+			debugf("Воркер %d: Завершено вычисление задачи ID %d. Результат: %f", workerID, task.Id, result)
 			submitReq.ResultStatus = &pb.SubmitResultRequest_Result{Result: result}
 		}
 
-		_, err = grpcClient.SubmitResult(ctx, submitReq)
-		if err != nil {
-			log.Printf("Воркер %d: Ошибка gRPC при отправке результата задачи ID %d: %v. Задача может быть переназначена.", workerID, task.Id, err)
-			time.Sleep(retryAfter) // Небольшая пауза перед запросом новой задачи
-		} else {
-			log.Printf("Воркер %d: Результат задачи ID %d успешно отправлен.", workerID, task.Id)
+		// Результат уже посчитан: отправляем его, даже если идёт остановка.
+		submitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalCallTimeout)
+		_, err = grpcClient.SubmitResult(submitCtx, submitReq)
+		cancel()
+		switch {
+		case err == nil:
+			infof("Воркер %d: Результат задачи ID %d успешно отправлен.", workerID, task.Id)
+		case isConnectionError(err) && opts.Spool != nil:
+			opts.Breaker.Failure(retryHint(err))
+			if spoolErr := opts.Spool.Put(submitReq); spoolErr != nil {
+				errorf("Воркер %d: Результат задачи ID %d не отправлен (%v) и не сохранён: %v", workerID, task.Id, err, spoolErr)
+			} else {
+				warnf("Воркер %d: Результат задачи ID %d не отправлен (%v), отложен для повторной отправки.", workerID, task.Id, err)
+			}
+		default:
+			errorf("Воркер %d: Ошибка gRPC при отправке результата задачи ID %d: %v. Задача может быть переназначена.", workerID, task.Id, err)
 		}
 	}
 }
 
-func compute(arg1, arg2 float64, op string) (float64, error) {
-	switch op {
-	case "+":
-		return arg1 + arg2, nil
-	case "-":
-		return arg1 - arg2, nil
-	case "*":
-		return arg1 * arg2, nil
-	case "/":
-		if arg2 == 0 {
-			return 0, fmt.Errorf("деление на ноль")
-		}
-		return arg1 / arg2, nil
-	default:
-		return 0, fmt.Errorf("неизвестная операция: %s", op)
+// releaseTask возвращает незавершённую задачу в очередь, чтобы её сразу
+// смог взять другой агент.
+func releaseTask(ctx context.Context, workerID int, grpcClient pb.CalculatorAgentServiceClient, agentID string, task *pb.Task) {
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalCallTimeout)
+	defer cancel()
+	_, err := grpcClient.ReleaseTask(releaseCtx, &pb.ReleaseTaskRequest{
+		TaskId:     task.Id,
+		AgentId:    agentID,
+		LeaseToken: task.LeaseToken,
+	})
+	if err != nil {
+		errorf("Воркер %d: Не удалось вернуть задачу ID %d в очередь: %v", workerID, task.Id, err)
+		return
 	}
-}
\ No newline at end of file
+	infof("Воркер %d: Задача ID %d возвращена в очередь.", workerID, task.Id)
+}
+
+// sleep ждёт d или отмены ctx. Возвращает false, если ожидание прервано.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
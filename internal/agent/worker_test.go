@@ -1,7 +1,18 @@
 package agent
 
-import "testing"
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
 
+	pb "calculator/internal/grpc/calculator"
+	"calculator/internal/operations"
+
+	"google.golang.org/grpc"
+)
+
+// TestCompute проверяет арифметику агента: воркер вычисляет задачи через operations.Default.
 func TestCompute(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -19,7 +30,7 @@ func TestCompute(t *testing.T) {
 		{"UnknownOp", 2, 3, "%", 0, true},
 	}
 	for _, tc := range tests {
-		got, err := compute(tc.arg1, tc.arg2, tc.op)
+		got, err := operations.Default.Evaluate(tc.op, tc.arg1, tc.arg2)
 		if (err != nil) != tc.wantErr {
 			t.Errorf("%s: compute(%v, %v, %q) error = %v, wantErr %v", tc.name, tc.arg1, tc.arg2, tc.op, err, tc.wantErr)
 			continue
@@ -28,4 +39,90 @@ func TestCompute(t *testing.T) {
 			t.Errorf("%s: compute(%v, %v, %q) = %v, want %v", tc.name, tc.arg1, tc.arg2, tc.op, got, tc.want)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// taskAgentClient выдаёт одну задачу и запоминает, чем воркер её завершил.
+type taskAgentClient struct {
+	mu       sync.Mutex
+	task     *pb.Task
+	leased   chan struct{}
+	released []*pb.ReleaseTaskRequest
+	results  []*pb.SubmitResultRequest
+}
+
+func (c *taskAgentClient) GetTask(ctx context.Context, in *pb.GetTaskRequest, opts ...grpc.CallOption) (*pb.GetTaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.task == nil {
+		return &pb.GetTaskResponse{TaskInfo: &pb.GetTaskResponse_NoTask{NoTask: &pb.NoTaskAvailable{RetryAfterSeconds: 1}}}, nil
+	}
+	task := c.task
+	c.task = nil
+	close(c.leased)
+	return &pb.GetTaskResponse{TaskInfo: &pb.GetTaskResponse_Task{Task: task}}, nil
+}
+
+func (c *taskAgentClient) SubmitResult(ctx context.Context, in *pb.SubmitResultRequest, opts ...grpc.CallOption) (*pb.SubmitResultResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, in)
+	return &pb.SubmitResultResponse{Acknowledged: true}, nil
+}
+
+func (c *taskAgentClient) ReleaseTask(ctx context.Context, in *pb.ReleaseTaskRequest, opts ...grpc.CallOption) (*pb.ReleaseTaskResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.released = append(c.released, in)
+	return &pb.ReleaseTaskResponse{Released: true}, nil
+}
+
+func TestWorkerReleasesTaskOnShutdown(t *testing.T) {
+	client := &taskAgentClient{
+		task:   &pb.Task{Id: 7, Arg1: 2, Arg2: 3, Operation: "+", OperationTimeMs: 60000, LeaseToken: "lease-7"},
+		leased: make(chan struct{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Worker(ctx, 0, client, WorkerOptions{AgentName: "test"})
+		close(done)
+	}()
+
+	<-client.leased
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Worker did not stop after context cancellation")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.results) != 0 {
+		t.Errorf("unfinished task must not be submitted, got %v", client.results)
+	}
+	if len(client.released) != 1 || client.released[0].TaskId != 7 || client.released[0].LeaseToken != "lease-7" {
+		t.Fatalf("released = %v, want task 7 with its lease", client.released)
+	}
+}
+
+func TestWorkerStopsWhileIdle(t *testing.T) {
+	client := &taskAgentClient{leased: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Worker(ctx, 0, client, WorkerOptions{RetryInterval: time.Minute, MaxRetry: time.Minute})
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle Worker did not stop after context cancellation")
+	}
+}
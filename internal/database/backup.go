@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backup записывает согласованную копию БД в файл dest, не останавливая работу:
+// VACUUM INTO читает снимок БД в одной транзакции чтения, а записи в режиме WAL
+// идут параллельно. Копия пишется во временный файл рядом с dest и переименовывается
+// только после успешного завершения, так что неполных копий под именем dest не бывает.
+func (s *Store) Backup(dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("файл резервной копии %s уже существует", dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("ошибка создания каталога резервных копий: %w", err)
+	}
+	tmp := dest + ".tmp"
+	os.Remove(tmp) // Остаток прерванной копии
+
+	// Пул чтения открыт с _query_only и не допускает VACUUM INTO, а в пуле записи
+	// копия заняла бы единственное соединение писателя. Поэтому — отдельное соединение.
+	conn := s.db
+	if s.path != ":memory:" {
+		var err error
+		conn, err = sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d", s.path, busyTimeout.Milliseconds()))
+		if err != nil {
+			return fmt.Errorf("ошибка открытия БД для резервного копирования: %w", err)
+		}
+		defer conn.Close()
+	}
+	if _, err := conn.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ошибка резервного копирования БД в %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ошибка сохранения резервной копии %s: %w", dest, err)
+	}
+	log.Printf("Резервная копия БД сохранена: %s", dest)
+	return nil
+}
+
+// ValidateBackup проверяет, что файл — целая БД оркестратора, которую этот код
+// может открыть, и возвращает версию её схемы. Копии с более новой схемой
+// отвергаются; более старые будут обновлены миграциями при запуске.
+func ValidateBackup(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("резервная копия недоступна: %w", err)
+	}
+	db, err := sql.Open("sqlite3", sqliteURI(path, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		return 0, fmt.Errorf("ошибка открытия резервной копии %s: %w", path, err)
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.QueryRow(`PRAGMA quick_check`).Scan(&check); err != nil {
+		return 0, fmt.Errorf("%s не является БД SQLite: %w", path, err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("резервная копия %s повреждена: %s", path, check)
+	}
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("%s не является БД оркестратора: %w", path, err)
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("в резервной копии %s нет применённых миграций", path)
+	}
+	if version > LatestSchemaVersion() {
+		return version, fmt.Errorf("версия схемы резервной копии %d новее поддерживаемой (%d): обновите оркестратор", version, LatestSchemaVersion())
+	}
+	return version, nil
+}
+
+// RestoreBackup заменяет БД dbPath копией src после проверки ValidateBackup.
+// Оркестратор должен быть остановлен: если БД открыта другим процессом,
+// восстановление отменяется, не тронув её файлов. Текущие файлы БД (вместе с -wal и -shm:
+// журнал старой БД, применённый к восстановленной, испортил бы её) не удаляются,
+// а переименовываются с суффиксом .pre-restore-<время>. Возвращает имя старого файла БД
+// (пусто, если БД ещё не было) и версию схемы восстановленной копии.
+func RestoreBackup(src, dbPath string) (previous string, version int, err error) {
+	if version, err = ValidateBackup(src); err != nil {
+		return "", 0, err
+	}
+
+	// Копия сначала целиком пишется рядом с БД: переименование в пределах
+	// каталога атомарно, и при сбое на месте БД не останется половины файла.
+	tmp := dbPath + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return "", 0, fmt.Errorf("ошибка копирования резервной копии: %w", err)
+	}
+
+	// Проверка — непосредственно перед переименованием, после долгого копирования.
+	if err := ensureNotInUse(dbPath); err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+
+	suffix := ".pre-restore-" + time.Now().Format("20060102-150405")
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dbPath+ext, dbPath+suffix+ext)
+		switch {
+		case err == nil:
+			if ext == "" {
+				previous = dbPath + suffix
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			os.Remove(tmp)
+			return "", 0, fmt.Errorf("ошибка переименования %s: %w", dbPath+ext, err)
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return previous, 0, fmt.Errorf("ошибка замены БД %s: %w", dbPath, err)
+	}
+	log.Printf("БД %s восстановлена из %s", dbPath, src)
+	return previous, version, nil
+}
+
+// restoreLockTimeout — сколько ждать, пока БД освободится, прежде чем отказаться от восстановления.
+const restoreLockTimeout = time.Second
+
+// ensureNotInUse проверяет, что БД dbPath никем не открыта: берёт на неё
+// монопольную блокировку (в режиме WAL её не получить, пока у БД есть другие
+// соединения) и переносит журнал в файл БД, чтобы отложенная в сторону БД была
+// целой без -wal. Блокировка снимается до переименования: закрытие соединения
+// после него удалило бы -wal уже восстановленной БД.
+func ensureNotInUse(dbPath string) error {
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := sql.Open("sqlite3", sqliteURI(dbPath, url.Values{
+		"_locking_mode": {"EXCLUSIVE"},
+		"_busy_timeout": {fmt.Sprint(restoreLockTimeout.Milliseconds())},
+	}))
+	if err != nil {
+		return fmt.Errorf("ошибка открытия БД %s: %w", dbPath, err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`BEGIN EXCLUSIVE; COMMIT`); err != nil {
+		return fmt.Errorf("БД %s используется (остановите оркестратор): %w", dbPath, err)
+	}
+	if _, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("ошибка переноса журнала БД %s: %w", dbPath, err)
+	}
+	return nil
+}
+
+// sqliteURI строит URI файла SQLite с параметрами: путь экранируется, чтобы
+// символы вроде ? и # в имени файла не принимались за начало параметров.
+func sqliteURI(path string, params url.Values) string {
+	return "file:" + (&url.URL{Path: path}).EscapedPath() + "?" + params.Encode()
+}
+
+// copyFile копирует src в dst и сбрасывает dst на диск.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,39 +1,93 @@
 package database
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
+var (
+	// ErrNotFound — общий признак отсутствующей записи; errors.Is(err, ErrNotFound)
+	// верно и для более частных ошибок, например ErrTaskNotFound.
+	ErrNotFound = errors.New("запись не найдена")
+	// ErrUserExists возвращается CreateUser, если логин уже занят.
+	ErrUserExists = errors.New("пользователь уже существует")
+	// ErrUserNotFound, ErrExpressionNotFound, ErrTaskNotFound и ErrAgentTokenNotFound
+	// возвращают методы поиска, если записи нет; все они совпадают с ErrNotFound.
+	ErrUserNotFound       error = notFoundError("пользователь не найден")
+	ErrExpressionNotFound error = notFoundError("выражение не найдено")
+	ErrTaskNotFound       error = notFoundError("задача не найдена")
+	ErrAgentTokenNotFound error = notFoundError("токен агента не найден")
+	// ErrLeaseLost возвращается, если результат прислан по аренде, которую задача уже не удерживает.
+	ErrLeaseLost = errors.New("аренда задачи недействительна")
+)
+
+// notFoundError — ошибка об отсутствии конкретной записи, совпадающая с ErrNotFound.
+type notFoundError string
+
+func (e notFoundError) Error() string { return string(e) }
+
+func (e notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// Store — хранилище в SQLite. Глобальной блокировки нет: SQLite сама допускает
+// одного писателя и сколько угодно читателей (WAL), поэтому записи идут через пул
+// из одного соединения, а чтения — через отдельный пул и не ждут записей.
 type Store struct {
-	db   *sql.DB
+	db   *sql.DB // Запись: одно соединение, транзакции BEGIN IMMEDIATE
+	rdb  *sql.DB // Чтение; для :memory: совпадает с db
 	path string
-	mu   sync.RWMutex
 }
 
+// busyTimeout — сколько SQLite ждёт блокировку БД, занятую другим процессом
+// (например, migrate или резервным копированием), прежде чем вернуть SQLITE_BUSY.
+const busyTimeout = 5 * time.Second
+
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_journal_mode=WAL") // Включаем foreign keys и WAL
+	// _txlock=immediate: транзакция сразу берёт блокировку записи и не падает
+	// с SQLITE_BUSY при попытке повысить блокировку чтения посреди транзакции.
+	params := fmt.Sprintf("_foreign_keys=on&_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d", busyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite3", dbPath+"?"+params+"&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("ошибка открытия БД %s: %w", dbPath, err)
 	}
-
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
-
+	// Писатель в SQLite всё равно один: очередь к единственному соединению
+	// дешевле, чем ожидание блокировки в busy_timeout.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
 	if err = db.Ping(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("ошибка подключения к БД %s: %w", dbPath, err)
 	}
 
+	rdb := db
+	if dbPath != ":memory:" { // Каждое соединение к :memory: открывает отдельную пустую БД.
+		rdb, err = sql.Open("sqlite3", dbPath+"?"+params+"&_query_only=true")
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("ошибка открытия БД %s: %w", dbPath, err)
+		}
+		rdb.SetMaxOpenConns(25)
+		rdb.SetMaxIdleConns(25)
+		rdb.SetConnMaxLifetime(5 * time.Minute)
+		if err = rdb.Ping(); err != nil {
+			db.Close()
+			rdb.Close()
+			return nil, fmt.Errorf("ошибка подключения к БД %s: %w", dbPath, err)
+		}
+	}
+
 	store := &Store{
 		db:   db,
+		rdb:  rdb,
 		path: dbPath,
 	}
 
@@ -42,59 +96,31 @@ func NewStore(dbPath string) (*Store, error) {
 }
 
 func (s *Store) Close() error {
+	if s.rdb != s.db {
+		s.rdb.Close()
+	}
 	return s.db.Close()
 }
 
+// Ping проверяет доступность БД.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// InitDB приводит схему БД к последней версии (см. migrations.go).
 func (s *Store) InitDB() error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			login TEXT NOT NULL UNIQUE,
-			password_hash TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS expressions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			expression TEXT NOT NULL,
-			status TEXT NOT NULL,
-			result REAL,
-			steps TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(user_id) REFERENCES users(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS tasks (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			expression_id INTEGER NOT NULL,
-			operation TEXT NOT NULL,
-			arg1 REAL NOT NULL,
-			arg2 REAL NOT NULL,
-			result REAL,
-			status TEXT NOT NULL,
-			retries INTEGER NOT NULL DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(expression_id) REFERENCES expressions(id)
-		)`,
-	}
-	for _, stmt := range stmts {
-		if _, err := s.db.Exec(stmt); err != nil {
-			return fmt.Errorf("database migration error: %w", err)
-		}
+	if err := s.MigrateUp(LatestSchemaVersion()); err != nil {
+		return fmt.Errorf("database migration error: %w", err)
 	}
 	return nil
 }
 
 func (s *Store) CreateUser(login, passwordHash string) (int64, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	query := `INSERT INTO users (login, password_hash) VALUES (?, ?)`
 	res, err := s.db.Exec(query, login, passwordHash)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.login") {
-			return 0, fmt.Errorf("пользователь с логином '%s' уже существует", login)
+			return 0, fmt.Errorf("%w: логин '%s'", ErrUserExists, login)
 		}
 		return 0, fmt.Errorf("ошибка создания пользователя: %w", err)
 	}
@@ -109,17 +135,14 @@ func (s *Store) CreateUser(login, passwordHash string) (int64, error) {
 }
 
 func (s *Store) GetUserByLogin(login string) (*User, error) {
-	s.mu.RLock() // Используем RLock для чтения
-	defer s.mu.RUnlock()
-
-	query := `SELECT id, login, password_hash, created_at FROM users WHERE login = ?`
-	row := s.db.QueryRow(query, login)
+	query := `SELECT id, login, password_hash, is_admin, created_at FROM users WHERE login = ?`
+	row := s.rdb.QueryRow(query, login)
 
 	user := &User{}
-	err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil // Пользователь не найден - это не ошибка для этой функции
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("ошибка поиска пользователя по логину '%s': %w", login, err)
 	}
@@ -127,12 +150,133 @@ func (s *Store) GetUserByLogin(login string) (*User, error) {
 	return user, nil
 }
 
+func (s *Store) GetUserByID(id int64) (*User, error) {
+	query := `SELECT id, login, password_hash, is_admin, created_at FROM users WHERE id = ?`
+	user := &User{}
+	err := s.rdb.QueryRow(query, id).Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("ошибка поиска пользователя ID %d: %w", id, err)
+	}
+	return user, nil
+}
+
+// GetUserSettings возвращает настройки пользователя или значения по умолчанию, если он их не менял.
+func (s *Store) GetUserSettings(userID int64) (UserSettings, error) {
+	settings := DefaultUserSettings(userID)
+	err := s.rdb.QueryRow(`SELECT use_result_cache FROM user_settings WHERE user_id = ?`, userID).Scan(&settings.UseResultCache)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, fmt.Errorf("ошибка получения настроек пользователя ID %d: %w", userID, err)
+	}
+	return settings, nil
+}
+
+func (s *Store) SaveUserSettings(settings UserSettings) error {
+	_, err := s.db.Exec(`INSERT INTO user_settings (user_id, use_result_cache, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET use_result_cache = excluded.use_result_cache, updated_at = excluded.updated_at`,
+		settings.UserID, settings.UseResultCache)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения настроек пользователя ID %d: %w", settings.UserID, err)
+	}
+	return nil
+}
+
+func (s *Store) SetUserAdmin(userID int64, admin bool) error {
+	res, err := s.db.Exec(`UPDATE users SET is_admin = ? WHERE id = ?`, admin, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка изменения прав пользователя ID %d: %w", userID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ListUsers возвращает всех пользователей по возрастанию ID.
+func (s *Store) ListUsers() ([]User, error) {
+	rows, err := s.rdb.Query(`SELECT id, login, password_hash, is_admin, created_at FROM users ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования пользователя: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUserQuota возвращает лимиты, заданные пользователю администратором, или nil, если их нет.
+func (s *Store) GetUserQuota(userID int64) (*UserQuota, error) {
+	q := &UserQuota{UserID: userID}
+	err := s.rdb.QueryRow(`SELECT submissions_per_minute, max_active_expressions, max_ast_nodes, max_expression_length, updated_at
+		FROM user_quotas WHERE user_id = ?`, userID).
+		Scan(&q.SubmissionsPerMinute, &q.MaxActiveExpressions, &q.MaxASTNodes, &q.MaxExpressionLength, &q.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения лимитов пользователя ID %d: %w", userID, err)
+	}
+	return q, nil
+}
+
+// SaveUserQuota сохраняет лимиты пользователя, полностью заменяя заданные ранее.
+func (s *Store) SaveUserQuota(q UserQuota) error {
+	_, err := s.db.Exec(`INSERT INTO user_quotas (user_id, submissions_per_minute, max_active_expressions, max_ast_nodes, max_expression_length, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			submissions_per_minute = excluded.submissions_per_minute,
+			max_active_expressions = excluded.max_active_expressions,
+			max_ast_nodes = excluded.max_ast_nodes,
+			max_expression_length = excluded.max_expression_length,
+			updated_at = excluded.updated_at`,
+		q.UserID, q.SubmissionsPerMinute, q.MaxActiveExpressions, q.MaxASTNodes, q.MaxExpressionLength)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения лимитов пользователя ID %d: %w", q.UserID, err)
+	}
+	return nil
+}
+
+// DeleteUserQuota удаляет лимиты пользователя. Возвращает false, если они не задавались.
+func (s *Store) DeleteUserQuota(userID int64) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM user_quotas WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления лимитов пользователя ID %d: %w", userID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// CountActiveExpressions возвращает число выражений пользователя, которые ещё вычисляются.
+func (s *Store) CountActiveExpressions(userID int64) (int, error) {
+	var n int
+	err := s.rdb.QueryRow(`SELECT COUNT(*) FROM expressions WHERE user_id = ? AND status IN (?, ?)`,
+		userID, StatusPending, StatusInProgress).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчёта активных выражений пользователя ID %d: %w", userID, err)
+	}
+	return n, nil
+}
+
+// CreateExpression сохраняет выражение с приоритетом PriorityInteractive.
 func (s *Store) CreateExpression(userID int64, expression string) (int64, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.CreateExpressionWithPriority(userID, expression, PriorityInteractive)
+}
 
-	query := `INSERT INTO expressions (user_id, expression, status) VALUES (?, ?, ?)`
-	res, err := s.db.Exec(query, userID, expression, StatusPending)
+func (s *Store) CreateExpressionWithPriority(userID int64, expression, priority string) (int64, error) {
+	if !IsValidPriority(priority) {
+		return 0, fmt.Errorf("неизвестный приоритет выражения: %q", priority)
+	}
+	query := `INSERT INTO expressions (user_id, expression, priority, status) VALUES (?, ?, ?, ?)`
+	res, err := s.db.Exec(query, userID, expression, priority, StatusPending)
 	if err != nil {
 		return 0, fmt.Errorf("ошибка создания выражения: %w", err)
 	}
@@ -147,21 +291,18 @@ func (s *Store) CreateExpression(userID int64, expression string) (int64, error)
 }
 
 func (s *Store) GetExpressionByID(id, userID int64) (*Expression, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	query := `SELECT id, user_id, expression, status, result, steps, created_at, updated_at
+	query := `SELECT id, user_id, expression, priority, status, result, steps, created_at, updated_at
 	         FROM expressions WHERE id = ? AND user_id = ?`
-	row := s.db.QueryRow(query, id, userID)
+	row := s.rdb.QueryRow(query, id, userID)
 
 	expr := &Expression{}
 	err := row.Scan(
-		&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
+		&expr.ID, &expr.UserID, &expr.Expression, &expr.Priority, &expr.Status,
 		&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil // Не найдено
+			return nil, ErrExpressionNotFound
 		}
 		return nil, fmt.Errorf("ошибка получения выражения ID %d: %w", id, err)
 	}
@@ -169,12 +310,9 @@ func (s *Store) GetExpressionByID(id, userID int64) (*Expression, error) {
 }
 
 func (s *Store) GetExpressionsByUserID(userID int64) ([]Expression, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	query := `SELECT id, user_id, expression, status, result, steps, created_at, updated_at
-	         FROM expressions WHERE user_id = ? ORDER BY created_at DESC`
-	rows, err := s.db.Query(query, userID)
+	query := `SELECT id, user_id, expression, priority, status, result, steps, created_at, updated_at
+	         FROM expressions WHERE user_id = ? ORDER BY created_at DESC, id DESC`
+	rows, err := s.rdb.Query(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка получения списка выражений для пользователя ID %d: %w", userID, err)
 	}
@@ -184,7 +322,7 @@ func (s *Store) GetExpressionsByUserID(userID int64) ([]Expression, error) {
 	for rows.Next() {
 		expr := Expression{}
 		err := rows.Scan(
-			&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
+			&expr.ID, &expr.UserID, &expr.Expression, &expr.Priority, &expr.Status,
 			&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
 		)
 		if err != nil {
@@ -202,12 +340,10 @@ func (s *Store) GetExpressionsByUserID(userID int64) ([]Expression, error) {
 }
 
 func (s *Store) UpdateExpressionStatusResult(id int64, status string, result sql.NullFloat64, stepsJSON sql.NullString) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	// Отменённое выражение не должно «оживать» из-за запоздавшего планирования.
 	query := `UPDATE expressions SET status = ?, result = ?, steps = ?, updated_at = CURRENT_TIMESTAMP
-	         WHERE id = ?`
-	_, err := s.db.Exec(query, status, result, stepsJSON, id)
+	         WHERE id = ? AND status != ?`
+	_, err := s.db.Exec(query, status, result, stepsJSON, id, StatusCancelled)
 	if err != nil {
 		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
 	}
@@ -215,128 +351,409 @@ func (s *Store) UpdateExpressionStatusResult(id int64, status string, result sql
 	return nil
 }
 
-func (s *Store) CreateTask(expressionID int64, operation string, arg1, arg2 float64) (int64, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// CancelExpression отменяет незавершённое выражение пользователя и снимает его ожидающие задачи.
+// Задачи, уже выданные агентам, дорабатывают, но их результаты не продвигают выражение.
+// Возвращает false, если выражение не найдено или уже завершено.
+func (s *Store) CancelExpression(id, userID int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции отмены выражения ID %d: %w", id, err)
+	}
+	defer tx.Rollback()
 
-	query := `INSERT INTO tasks (expression_id, operation, arg1, arg2, status) VALUES (?, ?, ?, ?, ?)`
-	res, err := s.db.Exec(query, expressionID, operation, arg1, arg2, StatusPending)
+	res, err := tx.Exec(`UPDATE expressions SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND status IN (?, ?)`,
+		StatusCancelled, id, userID, StatusPending, StatusInProgress)
 	if err != nil {
-		return 0, fmt.Errorf("ошибка создания задачи для выражения ID %d: %w", expressionID, err)
+		return false, fmt.Errorf("ошибка отмены выражения ID %d: %w", id, err)
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return false, nil
 	}
 
-	id, err := res.LastInsertId()
+	if _, err := tx.Exec(`UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE expression_id = ? AND status = ?`,
+		StatusCancelled, id, StatusPending); err != nil {
+		return false, fmt.Errorf("ошибка отмены задач выражения ID %d: %w", id, err)
+	}
+	if err := dequeueTask(tx, id); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("ошибка коммита отмены выражения ID %d: %w", id, err)
+	}
+
+	log.Printf("Выражение ID %d отменено пользователем ID %d", id, userID)
+	return true, nil
+}
+
+func (s *Store) CreateTask(expressionID int64, operation string, arg1, arg2 float64) (int64, error) {
+	var id int64
+	err := s.inTx(func(tx *sql.Tx) error {
+		query := `INSERT INTO tasks (expression_id, operation, arg1, arg2, status) VALUES (?, ?, ?, ?, ?)`
+		res, err := tx.Exec(query, expressionID, operation, arg1, arg2, StatusPending)
+		if err != nil {
+			return fmt.Errorf("ошибка создания задачи для выражения ID %d: %w", expressionID, err)
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return fmt.Errorf("ошибка получения ID новой задачи: %w", err)
+		}
+		return queueTask(tx, expressionID, id)
+	})
 	if err != nil {
-		return 0, fmt.Errorf("ошибка получения ID новой задачи: %w", err)
+		return 0, err
 	}
 
 	log.Printf("Создана задача ID %d для выражения ID %d: %f %s %f", id, expressionID, arg1, operation, arg2)
 	return id, nil
 }
 
-func (s *Store) GetAndLeasePendingTask() (*Task, error) {
-	s.mu.Lock() // Используем полную блокировку, так как чтение и запись
-	defer s.mu.Unlock()
+// CreateCompletedTask сохраняет шаг, результат которого известен без агента
+// (например, взят из кэша). settledBy указывает источник результата.
+func (s *Store) CreateCompletedTask(expressionID int64, operation string, arg1, arg2, result float64, settledBy string) (int64, error) {
+	query := `INSERT INTO tasks (expression_id, operation, arg1, arg2, result, status, settled_by) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := s.db.Exec(query, expressionID, operation, arg1, arg2, result, StatusDone, settledBy)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания выполненной задачи для выражения ID %d: %w", expressionID, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения ID новой задачи: %w", err)
+	}
 
-	tx, err := s.db.Begin()
+	log.Printf("Задача ID %d выражения ID %d (%f %s %f) выполнена без агента (%s): %f", id, expressionID, arg1, operation, arg2, settledBy, result)
+	return id, nil
+}
+
+// GetAndLeasePendingTask выдаёт задачу по взвешенной справедливой очереди
+// (start-time fair queuing). Поток — задачи выражений одного пользователя с одним
+// приоритетом; внутри потока задачи выдаются по порядку создания. Выдаётся задача
+// потока с наименьшей меткой начала; после выдачи метка окончания потока растёт
+// на 1/вес приоритета и становится меткой начала его следующей задачи. Поток,
+// долго не имевший задач, не накапливает преимущества: когда у него снова
+// появляется задача, его метка догоняет виртуальное время (см. queueTask).
+//
+// Голова каждого потока хранится в fair_queue_flows, поэтому выдача — один поиск
+// по индексу, а не просмотр всей очереди. Отменённые и удалённые задачи могут
+// оставаться головой потока; выдача заменяет такую голову и ищет снова.
+func (s *Store) GetAndLeasePendingTask(agentID string) (*Task, error) {
+	leaseToken, err := newLeaseToken()
 	if err != nil {
-		return nil, fmt.Errorf("ошибка начала транзакции для получения задачи: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-			panic(r) // Восстанавливаем панику
-		} else if err != nil {
-			tx.Rollback()
-		} else {
-			err = tx.Commit()
+
+	var task *Task
+	err = s.inTx(func(tx *sql.Tx) error {
+		for {
+			var flow fairFlow
+			var headID int64
+			err := tx.QueryRow(`SELECT user_id, priority, start_tag, head_task_id FROM fair_queue_flows
+				WHERE head_task_id IS NOT NULL ORDER BY start_tag, head_task_id LIMIT 1`).
+				Scan(&flow.userID, &flow.priority, &flow.start, &headID)
+			if err == sql.ErrNoRows {
+				return nil
+			}
 			if err != nil {
-				log.Printf("Ошибка коммита транзакции при получении задачи: %v", err)
+				return fmt.Errorf("ошибка выбора потока очереди: %w", err)
 			}
+
+			t := &Task{}
+			err = tx.QueryRow(`UPDATE tasks SET status = ?, lease_token = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND status = ?
+				RETURNING id, expression_id, operation, arg1, arg2, status, retries, created_at, updated_at`,
+				StatusInProgress, leaseToken, headID, StatusPending).Scan(
+				&t.ID, &t.ExpressionID, &t.Operation, &t.Arg1, &t.Arg2, &t.Status, &t.Retries, &t.CreatedAt, &t.UpdatedAt,
+			)
+			if err == sql.ErrNoRows {
+				if err := dropStaleHead(tx, flow, headID); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("ошибка выдачи ожидающей задачи: %w", err)
+			}
+			if _, err := tx.Exec(`INSERT INTO task_attempts (task_id, lease_token, agent_id, leased_at, outcome)
+				VALUES (?, ?, ?, `+sqliteNow+`, ?)`, t.ID, leaseToken, agentID, AttemptLeased); err != nil {
+				return fmt.Errorf("ошибка записи попытки задачи ID %d: %w", t.ID, err)
+			}
+
+			finish := flow.start + 1/priorityWeight(flow.priority)
+			_, err = tx.Exec(`UPDATE fair_queue_flows SET finish_tag = ?, start_tag = ? WHERE user_id = ? AND priority = ?`,
+				finish, finish, flow.userID, flow.priority)
+			if err != nil {
+				return fmt.Errorf("ошибка обновления очереди пользователя ID %d: %w", flow.userID, err)
+			}
+			_, err = tx.Exec(`INSERT INTO fair_queue_clock (id, virtual_time) VALUES (1, ?)
+				ON CONFLICT(id) DO UPDATE SET virtual_time = excluded.virtual_time`, flow.start)
+			if err != nil {
+				return fmt.Errorf("ошибка обновления виртуального времени очереди: %w", err)
+			}
+			if err := dequeueTask(tx, t.ExpressionID); err != nil {
+				return err
+			}
+
+			t.LeaseToken = leaseToken
+			task = t
+			return nil
 		}
-	}()
+	})
+	if err != nil || task == nil {
+		return nil, err
+	}
 
-	querySelect := `SELECT id, expression_id, operation, arg1, arg2, status, retries, created_at, updated_at
-	                FROM tasks WHERE status = ? ORDER BY created_at ASC LIMIT 1`
-	row := tx.QueryRow(querySelect, StatusPending)
+	log.Printf("Задача ID %d выдана агенту '%s' (Expression ID: %d)", task.ID, agentID, task.ExpressionID)
+	return task, nil
+}
 
-	task := &Task{}
-	err = row.Scan(
-		&task.ID, &task.ExpressionID, &task.Operation, &task.Arg1, &task.Arg2,
-		&task.Status, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
-	)
+// queueTask ставит ожидающую задачу в очередь: она становится головой своего
+// выражения и потока, если старше текущих. Поток, у которого не было ожидающих
+// задач, получает метку начала max(finish_tag, виртуальное время). Вызывается
+// в транзакции, которая создала задачу или вернула её в статус pending.
+func queueTask(tx *sql.Tx, expressionID, taskID int64) error {
+	var userID int64
+	var priority string
+	err := tx.QueryRow(`SELECT user_id, priority FROM expressions WHERE id = ?`, expressionID).Scan(&userID, &priority)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения очереди выражения ID %d: %w", expressionID, err)
+	}
+	_, err = tx.Exec(`INSERT INTO fair_queue_heads (expression_id, user_id, priority, task_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT(expression_id) DO UPDATE SET task_id = MIN(task_id, excluded.task_id)`,
+		expressionID, userID, priority, taskID)
+	if err != nil {
+		return fmt.Errorf("ошибка постановки задачи ID %d в очередь: %w", taskID, err)
+	}
+	_, err = tx.Exec(`INSERT INTO fair_queue_flows (user_id, priority, finish_tag, start_tag, head_task_id)
+		VALUES (?, ?, 0, (SELECT COALESCE(MAX(virtual_time), 0) FROM fair_queue_clock), ?)
+		ON CONFLICT(user_id, priority) DO UPDATE SET
+			start_tag = CASE WHEN head_task_id IS NULL THEN MAX(finish_tag, excluded.start_tag) ELSE start_tag END,
+			head_task_id = MIN(COALESCE(head_task_id, excluded.head_task_id), excluded.head_task_id)`,
+		userID, priority, taskID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления очереди пользователя ID %d: %w", userID, err)
+	}
+	return nil
+}
 
+// dequeueTask пересчитывает голову выражения и его потока после того, как задачи
+// выражения перестали ожидать выдачи: выданы или отменены.
+func dequeueTask(tx *sql.Tx, expressionID int64) error {
+	var flow fairFlow
+	err := tx.QueryRow(`SELECT user_id, priority FROM fair_queue_heads WHERE expression_id = ?`, expressionID).
+		Scan(&flow.userID, &flow.priority)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Нет ожидающих задач
-		}
-		return nil, fmt.Errorf("ошибка поиска ожидающей задачи: %w", err)
+		return fmt.Errorf("ошибка чтения очереди выражения ID %d: %w", expressionID, err)
 	}
 
-	queryUpdate := `UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err = tx.Exec(queryUpdate, StatusInProgress, task.ID)
+	var head sql.NullInt64
+	err = tx.QueryRow(`SELECT MIN(id) FROM tasks WHERE expression_id = ? AND status = ?`, expressionID, StatusPending).Scan(&head)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска задач выражения ID %d: %w", expressionID, err)
+	}
+	if head.Valid {
+		_, err = tx.Exec(`UPDATE fair_queue_heads SET task_id = ? WHERE expression_id = ?`, head.Int64, expressionID)
+	} else {
+		_, err = tx.Exec(`DELETE FROM fair_queue_heads WHERE expression_id = ?`, expressionID)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("ошибка обновления статуса задачи ID %d: %w", task.ID, err)
+		return fmt.Errorf("ошибка обновления очереди выражения ID %d: %w", expressionID, err)
 	}
+	return refreshFlowHead(tx, flow)
+}
 
-	task.Status = StatusInProgress // Обновляем статус в возвращаемом объекте
-	log.Printf("Задача ID %d взята в обработку (Expression ID: %d)", task.ID, task.ExpressionID)
-	return task, nil // err будет nil здесь, defer обработает Commit
+// dropStaleHead убирает из очереди голову потока, которая уже не ожидает выдачи
+// (задачу удалили вместе с выражением).
+func dropStaleHead(tx *sql.Tx, flow fairFlow, taskID int64) error {
+	var expressionID int64
+	err := tx.QueryRow(`SELECT expression_id FROM fair_queue_heads WHERE user_id = ? AND priority = ? AND task_id = ?`,
+		flow.userID, flow.priority, taskID).Scan(&expressionID)
+	if err == sql.ErrNoRows {
+		return refreshFlowHead(tx, flow)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка чтения очереди пользователя ID %d: %w", flow.userID, err)
+	}
+	return dequeueTask(tx, expressionID)
 }
 
-func (s *Store) CompleteTask(taskID int64, result float64) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// refreshFlowHead делает головой потока старшую из голов его выражений.
+func refreshFlowHead(tx *sql.Tx, flow fairFlow) error {
+	_, err := tx.Exec(`UPDATE fair_queue_flows SET head_task_id = (
+			SELECT MIN(task_id) FROM fair_queue_heads WHERE user_id = ? AND priority = ?)
+		WHERE user_id = ? AND priority = ?`, flow.userID, flow.priority, flow.userID, flow.priority)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления очереди пользователя ID %d: %w", flow.userID, err)
+	}
+	return nil
+}
 
-	query := `UPDATE tasks SET status = ?, result = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
-	res, err := s.db.Exec(query, StatusDone, result, taskID, StatusInProgress)
+func priorityWeight(priority string) float64 {
+	if w, ok := PriorityWeights[priority]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// fairFlow — поток справедливой очереди, у которого есть ожидающие задачи.
+type fairFlow struct {
+	userID   int64
+	priority string
+	start    float64 // Метка начала следующей задачи потока
+}
+
+// CompleteTask сохраняет результат задачи, полученный по аренде leaseToken.
+// Возвращает duplicate=true, если результат по этой аренде уже был принят ранее:
+// в этом случае состояние задачи не меняется.
+func (s *Store) CompleteTask(taskID int64, leaseToken, agentID string, result float64) (bool, error) {
+	query := `UPDATE tasks SET status = ?, result = ?, settled_lease_token = ?, settled_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	attempt := attemptOutcome{outcome: AttemptDone, result: sql.NullFloat64{Float64: result, Valid: true}}
+	duplicate, err := s.settleTask(taskID, leaseToken, attempt, query, StatusDone, result, leaseToken, agentID, taskID)
 	if err != nil {
-		return fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+		return false, fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+	if duplicate {
+		log.Printf("Повторный результат задачи ID %d по той же аренде проигнорирован", taskID)
+		return true, nil
 	}
 
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("Предупреждение: Попытка завершить задачу ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	log.Printf("Задача ID %d завершена агентом '%s' с результатом: %f", taskID, agentID, result)
+	return false, nil
+}
+
+// FailTask возвращает задачу в очередь после ошибки агента, полученной по аренде leaseToken;
+// message сохраняется в журнале попыток. Семантика повторов такая же, как у CompleteTask.
+func (s *Store) FailTask(taskID int64, leaseToken, agentID, message string) (bool, error) {
+	query := `UPDATE tasks SET status = ?, retries = retries + 1, settled_lease_token = ?, settled_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	attempt := attemptOutcome{outcome: AttemptFailed, message: sql.NullString{String: message, Valid: true}}
+	duplicate, err := s.settleTask(taskID, leaseToken, attempt, query, StatusPending, leaseToken, agentID, taskID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отметки задачи ID %d как ошибочной: %w", taskID, err)
+	}
+	if duplicate {
+		log.Printf("Повторная ошибка задачи ID %d по той же аренде проигнорирована", taskID)
+		return true, nil
 	}
 
-	log.Printf("Задача ID %d завершена с результатом: %f", taskID, result)
-	return nil
+	log.Printf("Ошибка выполнения задачи ID %d агентом '%s', возвращена в очередь.", taskID, agentID)
+	return false, nil
 }
 
-func (s *Store) FailTask(taskID int64) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// ReleaseTask возвращает задачу в очередь по просьбе агента, не засчитывая попытку.
+// Повторный вызов по той же аренде возвращает duplicate=true.
+func (s *Store) ReleaseTask(taskID int64, leaseToken, agentID string) (bool, error) {
+	query := `UPDATE tasks SET status = ?, lease_token = NULL, settled_lease_token = ?, settled_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	duplicate, err := s.settleTask(taskID, leaseToken, attemptOutcome{outcome: AttemptReleased}, query, StatusPending, leaseToken, agentID, taskID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка возврата задачи ID %d в очередь: %w", taskID, err)
+	}
+	if !duplicate {
+		log.Printf("Задача ID %d возвращена в очередь агентом '%s'", taskID, agentID)
+	}
+	return duplicate, nil
+}
+
+// attemptOutcome — чем закончилась попытка выполнения задачи.
+type attemptOutcome struct {
+	outcome string
+	result  sql.NullFloat64
+	message sql.NullString
+}
 
-	query := `UPDATE tasks SET status = ?, retries = retries + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
-	res, err := s.db.Exec(query, StatusPending, taskID, StatusInProgress)
+// settleTask проверяет аренду задачи и в одной транзакции применяет к ней update
+// и записывает исход попытки.
+func (s *Store) settleTask(taskID int64, leaseToken string, attempt attemptOutcome, update string, args ...interface{}) (bool, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("ошибка отметки задачи ID %d как ошибочной: %w", taskID, err)
+		return false, fmt.Errorf("ошибка начала транзакции: %w", err)
 	}
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("Предупреждение: Попытка отметить ошибку для задачи ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	defer tx.Rollback()
+
+	var status string
+	var expressionID int64
+	var currentToken, settledToken sql.NullString
+	err = tx.QueryRow(`SELECT status, expression_id, lease_token, settled_lease_token FROM tasks WHERE id = ?`, taskID).
+		Scan(&status, &expressionID, &currentToken, &settledToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrTaskNotFound
+		}
+		return false, err
 	}
 
-	log.Printf("Ошибка выполнения задачи ID %d, возвращена в очередь.", taskID)
-	return nil
+	if leaseToken != "" && settledToken.Valid && settledToken.String == leaseToken {
+		return true, nil
+	}
+	if leaseToken == "" || status != StatusInProgress || !currentToken.Valid || currentToken.String != leaseToken {
+		// settled_lease_token помнит только последнюю аренду. Повтор по более ранней,
+		// уже закрытой аренде узнаётся по журналу попыток.
+		var outcome string
+		err := tx.QueryRow(`SELECT outcome FROM task_attempts WHERE task_id = ? AND lease_token = ?`, taskID, leaseToken).Scan(&outcome)
+		if err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("ошибка чтения попытки задачи: %w", err)
+		}
+		if err == nil && outcome != AttemptLeased {
+			return true, nil
+		}
+		return false, ErrLeaseLost
+	}
+
+	if _, err := tx.Exec(update, args...); err != nil {
+		return false, err
+	}
+	if attempt.outcome == AttemptFailed || attempt.outcome == AttemptReleased {
+		// Задача вернулась в статус pending и снова ждёт выдачи.
+		if err := queueTask(tx, expressionID, taskID); err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(`UPDATE task_attempts SET outcome = ?, result = ?, error_message = ?, submitted_at = `+sqliteNow+`
+		WHERE lease_token = ?`, attempt.outcome, attempt.result, attempt.message, leaseToken)
+	if err != nil {
+		return false, fmt.Errorf("ошибка записи исхода попытки: %w", err)
+	}
+	return false, tx.Commit()
 }
 
-func (s *Store) GetTaskByID(taskID int64) (*Task, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// sqliteNow — текущее время с миллисекундами: CURRENT_TIMESTAMP точен лишь до секунды,
+// а длительность попытки обычно меньше.
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// ListTaskAttempts возвращает попытки всех задач выражения по порядку выдачи.
+func (s *Store) ListTaskAttempts(expressionID int64) ([]TaskAttempt, error) {
+	rows, err := s.rdb.Query(`SELECT a.id, a.task_id, a.agent_id, a.leased_at, a.submitted_at, a.outcome, a.error_message, a.result
+		FROM task_attempts a JOIN tasks t ON t.id = a.task_id
+		WHERE t.expression_id = ? ORDER BY a.id`, expressionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения попыток задач выражения ID %d: %w", expressionID, err)
+	}
+	defer rows.Close()
+
+	var attempts []TaskAttempt
+	for rows.Next() {
+		var a TaskAttempt
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.AgentID, &a.LeasedAt, &a.SubmittedAt, &a.Outcome, &a.ErrorMessage, &a.Result); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования попытки задачи: %w", err)
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
 
-	query := `SELECT id, expression_id, operation, arg1, arg2, result, status, retries, created_at, updated_at
+func (s *Store) GetTaskByID(taskID int64) (*Task, error) {
+	query := `SELECT id, expression_id, operation, arg1, arg2, result, status, retries, settled_by, created_at, updated_at
 	         FROM tasks WHERE id = ?`
-	row := s.db.QueryRow(query, taskID)
+	row := s.rdb.QueryRow(query, taskID)
 
 	task := &Task{}
 	err := row.Scan(
 		&task.ID, &task.ExpressionID, &task.Operation, &task.Arg1, &task.Arg2,
-		&task.Result, &task.Status, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
+		&task.Result, &task.Status, &task.Retries, &task.SettledBy, &task.CreatedAt, &task.UpdatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil // Не найдено
+			return nil, ErrTaskNotFound
 		}
 		return nil, fmt.Errorf("ошибка получения задачи ID %d: %w", taskID, err)
 	}
@@ -344,12 +761,9 @@ func (s *Store) GetTaskByID(taskID int64) (*Task, error) {
 }
 
 func (s *Store) HasPendingTasks(expressionID int64) (bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	query := `SELECT 1 FROM tasks WHERE expression_id = ? AND status IN (?, ?) LIMIT 1`
 	var exists int
-	err := s.db.QueryRow(query, expressionID, StatusPending, StatusInProgress).Scan(&exists)
+	err := s.rdb.QueryRow(query, expressionID, StatusPending, StatusInProgress).Scan(&exists)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil // Нет незавершенных задач
@@ -360,21 +774,18 @@ func (s *Store) HasPendingTasks(expressionID int64) (bool, error) {
 }
 
 func (s *Store) GetExpressionByIDInternal(id int64) (*Expression, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	query := `SELECT id, user_id, expression, status, result, steps, created_at, updated_at
+	query := `SELECT id, user_id, expression, priority, status, result, steps, created_at, updated_at
 	         FROM expressions WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+	row := s.rdb.QueryRow(query, id)
 
 	expr := &Expression{}
 	err := row.Scan(
-		&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
+		&expr.ID, &expr.UserID, &expr.Expression, &expr.Priority, &expr.Status,
 		&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil // Не найдено
+			return nil, ErrExpressionNotFound
 		}
 		return nil, fmt.Errorf("ошибка получения выражения ID %d (внутр.): %w", id, err)
 	}
@@ -383,12 +794,9 @@ func (s *Store) GetExpressionByIDInternal(id int64) (*Expression, error) {
 
 // GetAllTasksForExpression возвращает все задачи для данного выражения.
 func (s *Store) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	query := `SELECT id, expression_id, operation, arg1, arg2, result, status, retries, created_at, updated_at
-		FROM tasks WHERE expression_id = ?`
-	rows, err := s.db.Query(query, expressionID)
+	query := `SELECT id, expression_id, operation, arg1, arg2, result, status, retries, settled_by, created_at, updated_at
+		FROM tasks WHERE expression_id = ? ORDER BY id`
+	rows, err := s.rdb.Query(query, expressionID)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка запроса задач для выражения ID %d: %w", expressionID, err)
 	}
@@ -400,7 +808,7 @@ func (s *Store) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
 		if err := rows.Scan(
 			&task.ID, &task.ExpressionID, &task.Operation,
 			&task.Arg1, &task.Arg2, &task.Result,
-			&task.Status, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
+			&task.Status, &task.Retries, &task.SettledBy, &task.CreatedAt, &task.UpdatedAt,
 		); err != nil {
 			log.Printf("Ошибка сканирования строки задачи при GetAllTasksForExpression: %v", err)
 			continue // Пропускаем ошибочную строку, но продолжаем с остальными
@@ -414,3 +822,175 @@ func (s *Store) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
 
 	return tasks, nil
 }
+
+func (s *Store) CreateAgentToken(name, tokenHash string) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO agent_tokens (name, token_hash) VALUES (?, ?)`, name, tokenHash)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания токена агента '%s': %w", name, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения ID токена агента: %w", err)
+	}
+
+	log.Printf("Выпущен токен агента ID %d для '%s'", id, name)
+	return id, nil
+}
+
+// GetAgentTokenByHash ищет токен агента по хэшу, включая отозванные.
+func (s *Store) GetAgentTokenByHash(tokenHash string) (*AgentToken, error) {
+	query := `SELECT id, name, token_hash, created_at, revoked_at FROM agent_tokens WHERE token_hash = ?`
+	token := &AgentToken{}
+	err := s.rdb.QueryRow(query, tokenHash).Scan(&token.ID, &token.Name, &token.TokenHash, &token.CreatedAt, &token.RevokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAgentTokenNotFound
+		}
+		return nil, fmt.Errorf("ошибка поиска токена агента: %w", err)
+	}
+	return token, nil
+}
+
+func (s *Store) ListAgentTokens() ([]AgentToken, error) {
+	rows, err := s.rdb.Query(`SELECT id, name, token_hash, created_at, revoked_at FROM agent_tokens ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка токенов агентов: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []AgentToken
+	for rows.Next() {
+		var token AgentToken
+		if err := rows.Scan(&token.ID, &token.Name, &token.TokenHash, &token.CreatedAt, &token.RevokedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования токена агента: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAgentToken отзывает токен. Возвращает false, если активного токена с таким ID нет.
+func (s *Store) RevokeAgentToken(id int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE agent_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отзыва токена агента ID %d: %w", id, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected > 0 {
+		log.Printf("Токен агента ID %d отозван", id)
+	}
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) ListOperationTimes() ([]OperationTime, error) {
+	rows, err := s.rdb.Query(`SELECT operation, time_ms, updated_by, updated_at FROM operation_times ORDER BY operation`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения времени операций: %w", err)
+	}
+	defer rows.Close()
+
+	var times []OperationTime
+	for rows.Next() {
+		var t OperationTime
+		if err := rows.Scan(&t.Operation, &t.TimeMs, &t.UpdatedBy, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования времени операции: %w", err)
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
+
+// SetOperationTime задаёт время операции и записывает изменение в журнал.
+func (s *Store) SetOperationTime(operation string, timeMs int, userID int64) error {
+	return s.changeOperationTime(operation, sql.NullInt64{Int64: int64(timeMs), Valid: true}, userID)
+}
+
+// ResetOperationTime удаляет заданное время операции, возвращая значение по умолчанию.
+// Возвращает false, если время не было задано.
+func (s *Store) ResetOperationTime(operation string, userID int64) (bool, error) {
+	err := s.changeOperationTime(operation, sql.NullInt64{}, userID)
+	if err == errNoOperationTime {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+var errNoOperationTime = errors.New("время операции не задано")
+
+// changeOperationTime в одной транзакции меняет время операции (NULL — удаляет)
+// и добавляет запись в журнал изменений.
+func (s *Store) changeOperationTime(operation string, timeMs sql.NullInt64, userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old sql.NullInt64
+	err = tx.QueryRow(`SELECT time_ms FROM operation_times WHERE operation = ?`, operation).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("ошибка чтения времени операции '%s': %w", operation, err)
+	}
+
+	if timeMs.Valid {
+		_, err = tx.Exec(`INSERT INTO operation_times (operation, time_ms, updated_by, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(operation) DO UPDATE SET time_ms = excluded.time_ms, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+			operation, timeMs.Int64, userID)
+	} else {
+		if !old.Valid {
+			return errNoOperationTime
+		}
+		_, err = tx.Exec(`DELETE FROM operation_times WHERE operation = ?`, operation)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка изменения времени операции '%s': %w", operation, err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO operation_time_changes (operation, old_time_ms, new_time_ms, changed_by) VALUES (?, ?, ?, ?)`,
+		operation, old, timeMs, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка записи в журнал изменения времени операции '%s': %w", operation, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Время операции '%s' изменено пользователем ID %d: %v -> %v", operation, userID, nullInt(old), nullInt(timeMs))
+	return nil
+}
+
+// ListOperationTimeChanges возвращает последние limit изменений времени операций, новые первыми.
+func (s *Store) ListOperationTimeChanges(limit int) ([]OperationTimeChange, error) {
+	rows, err := s.rdb.Query(`SELECT c.id, c.operation, c.old_time_ms, c.new_time_ms, c.changed_by, COALESCE(u.login, ''), c.changed_at
+		FROM operation_time_changes c LEFT JOIN users u ON u.id = c.changed_by
+		ORDER BY c.id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения журнала изменений времени операций: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []OperationTimeChange
+	for rows.Next() {
+		var c OperationTimeChange
+		if err := rows.Scan(&c.ID, &c.Operation, &c.OldTimeMs, &c.NewTimeMs, &c.ChangedBy, &c.ChangedByLogin, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования записи журнала: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func nullInt(v sql.NullInt64) string {
+	if !v.Valid {
+		return "по умолчанию"
+	}
+	return strconv.FormatInt(v.Int64, 10)
+}
+
+func newLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ошибка генерации токена аренды: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
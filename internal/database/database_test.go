@@ -1,8 +1,17 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestUserAndExpressionCRUD(t *testing.T) {
@@ -82,7 +91,7 @@ func TestTaskLifecycle(t *testing.T) {
 		t.Fatalf("CreateTask error: %v", err)
 	}
 
-	task, err := store.GetAndLeasePendingTask()
+	task, err := store.GetAndLeasePendingTask("a1")
 	if err != nil {
 		t.Fatalf("GetAndLeasePendingTask error: %v", err)
 	}
@@ -90,7 +99,7 @@ func TestTaskLifecycle(t *testing.T) {
 		t.Fatalf("GetAndLeasePendingTask returned wrong: %+v", task)
 	}
 
-	if err := store.CompleteTask(tid, 6); err != nil {
+	if _, err := store.CompleteTask(tid, task.LeaseToken, "a1", 6); err != nil {
 		t.Fatalf("CompleteTask error: %v", err)
 	}
 	t2, err := store.GetTaskByID(tid)
@@ -105,11 +114,11 @@ func TestTaskLifecycle(t *testing.T) {
 	if err != nil {
 		t.Fatalf("CreateTask error: %v", err)
 	}
-	task2, _ := store.GetAndLeasePendingTask()
+	task2, _ := store.GetAndLeasePendingTask("a1")
 	if task2 == nil {
 		t.Fatalf("Expected task2 leased, got nil")
 	}
-	if err := store.FailTask(tid2); err != nil {
+	if _, err := store.FailTask(tid2, task2.LeaseToken, "a1", "boom"); err != nil {
 		t.Fatalf("FailTask error: %v", err)
 	}
 	t3, _ := store.GetTaskByID(tid2)
@@ -129,4 +138,538 @@ func TestTaskLifecycle(t *testing.T) {
 	if has2 {
 		t.Fatalf("HasPendingTasks for unknown expr should be false")
 	}
-}
\ No newline at end of file
+}
+
+func TestSubmitIdempotency(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skip DB tests: %v", err)
+		}
+		t.Fatalf("NewStore error: %v", err)
+	}
+	if err := store.InitDB(); err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+
+	uid, _ := store.CreateUser("u3", "h3")
+	exprID, _ := store.CreateExpression(uid, "2+3")
+	tid, _ := store.CreateTask(exprID, "+", 2, 3)
+
+	first, err := store.GetAndLeasePendingTask("a1")
+	if err != nil || first == nil {
+		t.Fatalf("GetAndLeasePendingTask: %+v, %v", first, err)
+	}
+	if first.LeaseToken == "" {
+		t.Fatal("leased task has empty lease token")
+	}
+	if _, err := store.FailTask(tid, first.LeaseToken, "a1", "boom"); err != nil {
+		t.Fatalf("FailTask error: %v", err)
+	}
+	dup, err := store.FailTask(tid, first.LeaseToken, "a1", "boom")
+	if err != nil || !dup {
+		t.Fatalf("repeated FailTask: dup=%v err=%v, want duplicate", dup, err)
+	}
+
+	second, _ := store.GetAndLeasePendingTask("a1")
+	if second == nil || second.LeaseToken == first.LeaseToken {
+		t.Fatalf("expected a fresh lease, got %+v", second)
+	}
+	if dup, err := store.CompleteTask(tid, first.LeaseToken, "a1", 5); err != nil || !dup {
+		t.Fatalf("CompleteTask with already settled lease: dup=%v err=%v, want duplicate", dup, err)
+	}
+	if _, err := store.CompleteTask(tid, "foreign-lease", "a1", 5); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("CompleteTask with foreign lease: err=%v, want ErrLeaseLost", err)
+	}
+	if _, err := store.CompleteTask(tid, "", "a1", 5); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("CompleteTask without lease: err=%v, want ErrLeaseLost", err)
+	}
+	if dup, err := store.CompleteTask(tid, second.LeaseToken, "a1", 5); err != nil || dup {
+		t.Fatalf("CompleteTask: dup=%v err=%v", dup, err)
+	}
+	if dup, err := store.CompleteTask(tid, second.LeaseToken, "a1", 42); err != nil || !dup {
+		t.Fatalf("repeated CompleteTask: dup=%v err=%v, want duplicate", dup, err)
+	}
+	task, _ := store.GetTaskByID(tid)
+	if task.Result.Float64 != 5 || task.Retries != 1 {
+		t.Fatalf("duplicate submission changed the task: %+v", task)
+	}
+	if _, err := store.CompleteTask(9999, "x", "a1", 1); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("CompleteTask for unknown task: err=%v, want ErrTaskNotFound", err)
+	}
+}
+
+func TestReleaseTask(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skip DB tests: %v", err)
+		}
+		t.Fatalf("NewStore error: %v", err)
+	}
+	if err := store.InitDB(); err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+
+	uid, _ := store.CreateUser("u4", "h4")
+	exprID, _ := store.CreateExpression(uid, "2*3")
+	tid, _ := store.CreateTask(exprID, "*", 2, 3)
+
+	leased, _ := store.GetAndLeasePendingTask("a1")
+	if leased == nil {
+		t.Fatal("expected a leased task")
+	}
+	if dup, err := store.ReleaseTask(tid, leased.LeaseToken, "a1"); err != nil || dup {
+		t.Fatalf("ReleaseTask: dup=%v err=%v", dup, err)
+	}
+	if dup, err := store.ReleaseTask(tid, leased.LeaseToken, "a1"); err != nil || !dup {
+		t.Fatalf("repeated ReleaseTask: dup=%v err=%v, want duplicate", dup, err)
+	}
+
+	task, _ := store.GetTaskByID(tid)
+	if task.Status != StatusPending || task.Retries != 0 {
+		t.Fatalf("released task: status=%s retries=%d, want pending without retry", task.Status, task.Retries)
+	}
+	again, _ := store.GetAndLeasePendingTask("a1")
+	if again == nil || again.ID != tid {
+		t.Fatalf("released task was not leased again: %+v", again)
+	}
+	if _, err := store.ReleaseTask(tid, "foreign-lease", "a1"); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("ReleaseTask with foreign lease: err=%v, want ErrLeaseLost", err)
+	}
+}
+
+func TestFairQueueLeasing(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skip DB tests: %v", err)
+		}
+		t.Fatalf("NewStore error: %v", err)
+	}
+	if err := store.InitDB(); err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	if _, err := store.CreateExpressionWithPriority(1, "1+1", "urgent"); err == nil {
+		t.Fatal("unknown priority must be rejected")
+	}
+
+	heavy, _ := store.CreateUser("heavy", "h")
+	light, _ := store.CreateUser("light", "h")
+	owner := make(map[int64]int64) // ID выражения -> пользователь
+	for i := 0; i < 50; i++ {
+		exprID, _ := store.CreateExpressionWithPriority(heavy, "1+1", PriorityBatch)
+		owner[exprID] = heavy
+		store.CreateTask(exprID, "+", 1, 1)
+	}
+	lease := func() int64 {
+		t.Helper()
+		task, err := store.GetAndLeasePendingTask("a1")
+		if err != nil || task == nil {
+			t.Fatalf("GetAndLeasePendingTask: task=%v err=%v", task, err)
+		}
+		return owner[task.ExpressionID]
+	}
+	for i := 0; i < 10; i++ {
+		lease()
+	}
+
+	// Новый поток не ждёт, пока очередь другого пользователя опустеет.
+	exprID, _ := store.CreateExpression(light, "2*3")
+	owner[exprID] = light
+	store.CreateTask(exprID, "*", 2, 3)
+	if got := lease(); got != light {
+		t.Fatalf("expected light user's task right after it was queued, got user %d", got)
+	}
+
+	// Собственные интерактивные задачи пользователя обгоняют его пакетные, но
+	// пакетные получают свою долю (вес 1 против 4).
+	for i := 0; i < 8; i++ {
+		exprID, _ := store.CreateExpressionWithPriority(heavy, "2+2", PriorityInteractive)
+		owner[exprID] = -exprID
+		store.CreateTask(exprID, "+", 2, 2)
+	}
+	var interactive, batch int
+	for i := 0; i < 10; i++ {
+		if lease() < 0 {
+			interactive++
+		} else {
+			batch++
+		}
+	}
+	if interactive != 8 || batch != 2 {
+		t.Fatalf("interactive/batch leases = %d/%d, want 8/2", interactive, batch)
+	}
+}
+
+func TestLeaseSkipsRemovedQueueHeads(t *testing.T) {
+	store := newFileStore(t)
+	uid, _ := store.CreateUser("alice", "h")
+
+	// Головы потока по очереди: задача удалённого выражения, затем отменённого.
+	purged, _ := store.CreateExpression(uid, "1+1")
+	store.CreateTask(purged, "+", 1, 1)
+	cancelled, _ := store.CreateExpression(uid, "2+2")
+	store.CreateTask(cancelled, "+", 2, 2)
+	live, _ := store.CreateExpression(uid, "3+3")
+	liveTask, _ := store.CreateTask(live, "+", 3, 3)
+
+	store.UpdateExpressionStatusResult(purged, StatusError, sql.NullFloat64{}, sql.NullString{})
+	if _, tasks, err := store.PurgeExpressions(StatusError, time.Now().Add(time.Hour), 10); err != nil || tasks != 1 {
+		t.Fatalf("PurgeExpressions = %d tasks, %v", tasks, err)
+	}
+	if ok, err := store.CancelExpression(cancelled, uid); !ok || err != nil {
+		t.Fatalf("CancelExpression = %v, %v", ok, err)
+	}
+
+	task, err := store.GetAndLeasePendingTask("a1")
+	if err != nil || task == nil || task.ID != liveTask {
+		t.Fatalf("GetAndLeasePendingTask = %+v, %v; want task %d", task, err, liveTask)
+	}
+	if _, err := store.ReleaseTask(task.ID, task.LeaseToken, "a1"); err != nil {
+		t.Fatalf("ReleaseTask error: %v", err)
+	}
+	if again, err := store.GetAndLeasePendingTask("a1"); err != nil || again == nil || again.ID != liveTask {
+		t.Fatalf("released task not queued again: %+v, %v", again, err)
+	}
+	if none, err := store.GetAndLeasePendingTask("a1"); none != nil || err != nil {
+		t.Fatalf("GetAndLeasePendingTask on empty queue = %+v, %v", none, err)
+	}
+}
+
+// newFileStore создаёт хранилище в файле: у :memory: всего одно соединение,
+// и конкурентный доступ на нём не проверить.
+func newFileStore(tb testing.TB) *Store {
+	tb.Helper()
+	store, err := NewStore(filepath.Join(tb.TempDir(), "calculator.db"))
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			tb.Skipf("skip DB tests: %v", err)
+		}
+		tb.Fatalf("NewStore error: %v", err)
+	}
+	tb.Cleanup(func() { store.Close() })
+	if err := store.InitDB(); err != nil {
+		tb.Fatalf("InitDB error: %v", err)
+	}
+	return store
+}
+
+// leaseConcurrently создаёт tasks задач и разбирает их leasers конкурентными
+// агентами: каждый берёт задачу, проверяет состояние выражения, как планировщик,
+// и сдаёт результат. Возвращает, сколько раз была выдана каждая задача.
+func leaseConcurrently(tb testing.TB, store Storage, leasers, tasks int, start func()) map[int64]int {
+	tb.Helper()
+	uid, _ := store.CreateUser("alice", "h")
+	exprs := make([]int64, leasers)
+	for i := range exprs {
+		exprs[i], _ = store.CreateExpression(uid, "1+1")
+	}
+	for i := 0; i < tasks; i++ {
+		if _, err := store.CreateTask(exprs[i%len(exprs)], "+", 1, 1); err != nil {
+			tb.Fatalf("CreateTask error: %v", err)
+		}
+	}
+	start()
+
+	var mu sync.Mutex
+	leased := make(map[int64]int, tasks)
+	var wg sync.WaitGroup
+	for w := 0; w < leasers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := store.GetAndLeasePendingTask("a1")
+				if err != nil {
+					tb.Errorf("GetAndLeasePendingTask error: %v", err)
+					return
+				}
+				if task == nil {
+					return
+				}
+				mu.Lock()
+				leased[task.ID]++
+				mu.Unlock()
+				if _, err := store.GetExpressionByIDInternal(task.ExpressionID); err != nil {
+					tb.Errorf("GetExpressionByIDInternal error: %v", err)
+					return
+				}
+				if _, err := store.CompleteTask(task.ID, task.LeaseToken, "agent", 2); err != nil {
+					tb.Errorf("CompleteTask error: %v", err)
+					return
+				}
+				if _, err := store.HasPendingTasks(task.ExpressionID); err != nil {
+					tb.Errorf("HasPendingTasks error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return leased
+}
+
+func checkLeasedOnce(tb testing.TB, leased map[int64]int, tasks int) {
+	tb.Helper()
+	if len(leased) != tasks {
+		tb.Fatalf("leased %d distinct tasks, want %d", len(leased), tasks)
+	}
+	for id, n := range leased {
+		if n != 1 {
+			tb.Fatalf("task %d leased %d times", id, n)
+		}
+	}
+}
+
+func TestConcurrentLeasing(t *testing.T) {
+	store := newFileStore(t)
+	leased := leaseConcurrently(t, store, 64, 500, func() {})
+	checkLeasedOnce(t, leased, 500)
+}
+
+// rwMutexStore воспроизводит Store до отказа от глобальной блокировки: один пул
+// из 25 соединений на чтение и запись, записи под RWMutex.Lock, чтения под RLock,
+// транзакции без BEGIN IMMEDIATE. Запросы те же, что у текущего Store, поэтому
+// бенчмарк сравнивает только схему блокировок и соединений.
+type rwMutexStore struct {
+	*Store
+	mu sync.RWMutex
+}
+
+func newRWMutexStore(tb testing.TB) *rwMutexStore {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "calculator.db")
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_journal_mode=WAL")
+	if err != nil {
+		tb.Fatalf("sql.Open error: %v", err)
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(25)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	tb.Cleanup(func() { db.Close() })
+	store := &rwMutexStore{Store: &Store{db: db, rdb: db, path: path}}
+	if err := store.InitDB(); err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			tb.Skipf("skip DB tests: %v", err)
+		}
+		tb.Fatalf("InitDB error: %v", err)
+	}
+	return store
+}
+
+func (s *rwMutexStore) GetAndLeasePendingTask(agentID string) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Store.GetAndLeasePendingTask(agentID)
+}
+
+func (s *rwMutexStore) GetExpressionByIDInternal(id int64) (*Expression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Store.GetExpressionByIDInternal(id)
+}
+
+func (s *rwMutexStore) CompleteTask(taskID int64, leaseToken, agentID string, result float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Store.CompleteTask(taskID, leaseToken, agentID, result)
+}
+
+func (s *rwMutexStore) HasPendingTasks(expressionID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Store.HasPendingTasks(expressionID)
+}
+
+// BenchmarkConcurrentLeasing — 64 агента одновременно разбирают очередь: с прежней
+// схемой блокировок (rwmutex) и с текущей (concurrent).
+func BenchmarkConcurrentLeasing(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, bc := range []struct {
+		name  string
+		store func(testing.TB) Storage
+	}{
+		{"rwmutex", func(tb testing.TB) Storage { return newRWMutexStore(tb) }},
+		{"concurrent", func(tb testing.TB) Storage { return newFileStore(tb) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			leased := leaseConcurrently(b, bc.store(b), 64, b.N, b.ResetTimer)
+			b.StopTimer()
+			checkLeasedOnce(b, leased, b.N)
+		})
+	}
+}
+
+func TestCompactAfterPurge(t *testing.T) {
+	store := newFileStore(t)
+	uid, _ := store.CreateUser("alice", "h")
+	long := strings.Repeat("1+", 1000) + "1"
+	for i := 0; i < 1000; i++ {
+		exprID, _ := store.CreateExpression(uid, long)
+		store.UpdateExpressionStatusResult(exprID, StatusDone, sql.NullFloat64{}, sql.NullString{})
+	}
+	if vacuumed, err := store.Compact(); err != nil || vacuumed {
+		t.Fatalf("Compact without free pages = %v, %v; want no VACUUM", vacuumed, err)
+	}
+
+	if n, _, err := store.PurgeExpressions(StatusDone, time.Now().Add(time.Hour), 1000); err != nil || n != 1000 {
+		t.Fatalf("PurgeExpressions = %d, %v", n, err)
+	}
+	if vacuumed, err := store.Compact(); err != nil || !vacuumed {
+		t.Fatalf("Compact after purge = %v, %v; want VACUUM", vacuumed, err)
+	}
+	var free int64
+	store.db.QueryRow(`PRAGMA freelist_count`).Scan(&free)
+	if free != 0 {
+		t.Fatalf("free pages after VACUUM: %d", free)
+	}
+	if info, err := os.Stat(store.path + "-wal"); err == nil && info.Size() != 0 {
+		t.Fatalf("WAL was not truncated: %d bytes", info.Size())
+	}
+}
+
+func TestDeleteUserHistoryInBatches(t *testing.T) {
+	store := newFileStore(t)
+	uid, _ := store.CreateUser("alice", "h")
+	total := 2*historyDeleteBatch + 1
+	for i := 0; i < total; i++ {
+		exprID, _ := store.CreateExpression(uid, "1+1")
+		store.CreateCompletedTask(exprID, "+", 1, 1, 2, "cache")
+		store.UpdateExpressionStatusResult(exprID, StatusDone, sql.NullFloat64{}, sql.NullString{})
+	}
+	active, _ := store.CreateExpression(uid, "2+2")
+
+	if e, n, err := store.DeleteUserHistory(uid); err != nil || e != int64(total) || n != int64(total) {
+		t.Fatalf("DeleteUserHistory = %d, %d, %v; want %d expressions and tasks", e, n, err, total)
+	}
+	if list, _ := store.GetExpressionsByUserID(uid); len(list) != 1 || list[0].ID != active {
+		t.Fatalf("expressions left after clearing history: %+v", list)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	store := newFileStore(t)
+	uid, _ := store.CreateUser("alice", "h")
+	store.CreateExpression(uid, "1+1")
+
+	// Копия снимается, пока в БД пишут.
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				store.CreateExpression(uid, "2+2")
+			}
+		}
+	}()
+	backup := filepath.Join(t.TempDir(), "backups", "calculator-1.db")
+	err := store.Backup(backup)
+	close(stop)
+	<-writerDone
+	if err != nil {
+		t.Fatalf("Backup error: %v", err)
+	}
+	if err := store.Backup(backup); err == nil {
+		t.Fatal("Backup overwrote an existing file")
+	}
+	if version, err := ValidateBackup(backup); err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("ValidateBackup = %d, %v; want %d", version, err, LatestSchemaVersion())
+	}
+	// Символы URI в имени файла не должны превращаться в параметры SQLite.
+	oddName := filepath.Join(t.TempDir(), "copy ?mode=rwc#1%20.db")
+	copyFile(backup, oddName)
+	if version, err := ValidateBackup(oddName); err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("ValidateBackup(%q) = %d, %v", oddName, version, err)
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	os.WriteFile(garbage, []byte(strings.Repeat("not a database", 512)), 0o600)
+	if _, err := ValidateBackup(garbage); err == nil {
+		t.Fatal("ValidateBackup accepted a file that is not a database")
+	}
+	newer := filepath.Join(t.TempDir(), "newer.db")
+	copyFile(backup, newer)
+	if db, err := sql.Open("sqlite3", newer); err == nil {
+		db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'future')`, LatestSchemaVersion()+1)
+		db.Close()
+	}
+	if _, err := ValidateBackup(newer); err == nil {
+		t.Fatal("ValidateBackup accepted a backup with a newer schema")
+	}
+
+	// Восстановление заменяет другую БД, а её файлы откладывает в сторону.
+	target := newFileStore(t)
+	target.CreateUser("bob", "h")
+	if _, _, err := RestoreBackup(backup, target.path); err == nil {
+		t.Fatal("RestoreBackup replaced a database that is still open")
+	}
+	if user, _ := target.GetUserByLogin("bob"); user == nil {
+		t.Fatal("failed restore touched the open database")
+	}
+	if _, err := os.Stat(target.path + ".restore"); err == nil {
+		t.Fatal("failed restore left its temporary copy")
+	}
+	target.Close()
+	if _, _, err := RestoreBackup(newer, target.path); err == nil {
+		t.Fatal("RestoreBackup accepted a backup with a newer schema")
+	}
+	previous, version, err := RestoreBackup(backup, target.path)
+	if err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("RestoreBackup = version %d, %v", version, err)
+	}
+	if _, err := os.Stat(previous); err != nil {
+		t.Fatalf("previous database was not kept: %v", err)
+	}
+	if _, err := os.Stat(target.path + "-wal"); err == nil {
+		t.Fatal("WAL of the replaced database was left next to the restored one")
+	}
+	restored, err := NewStore(target.path)
+	if err != nil {
+		t.Fatalf("NewStore after restore: %v", err)
+	}
+	defer restored.Close()
+	if err := restored.InitDB(); err != nil {
+		t.Fatalf("InitDB after restore: %v", err)
+	}
+	if user, _ := restored.GetUserByLogin("alice"); user == nil {
+		t.Fatal("restored database has no data from the backup")
+	}
+	if user, _ := restored.GetUserByLogin("bob"); user != nil {
+		t.Fatal("restored database still has data of the replaced one")
+	}
+}
+
+// BenchmarkLeaseLongQueue — выдача при 20 000 ожидающих задач у 100 пользователей:
+// время выдачи не должно зависеть от длины очереди.
+func BenchmarkLeaseLongQueue(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	store := newFileStore(b)
+	for u := 0; u < 100; u++ {
+		uid, _ := store.CreateUser(fmt.Sprintf("user%d", u), "h")
+		for e := 0; e < 20; e++ {
+			exprID, _ := store.CreateExpression(uid, "1+1")
+			for i := 0; i < 10; i++ {
+				store.CreateTask(exprID, "+", 1, 1)
+			}
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		task, err := store.GetAndLeasePendingTask("a1")
+		if err != nil || task == nil {
+			b.Fatalf("GetAndLeasePendingTask = %+v, %v", task, err)
+		}
+		if _, err := store.ReleaseTask(task.ID, task.LeaseToken, "a1"); err != nil {
+			b.Fatalf("ReleaseTask error: %v", err)
+		}
+	}
+}
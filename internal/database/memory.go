@@ -0,0 +1,801 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore — хранилище в памяти с тем же поведением, что и Store. Данные
+// теряются при остановке оркестратора; подходит для тестов и демонстраций.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users       map[int64]*User
+	userLogins  map[string]int64
+	settings    map[int64]UserSettings
+	quotas      map[int64]UserQuota
+	expressions map[int64]*Expression
+	tasks       map[int64]*memoryTask
+	agentTokens map[int64]*AgentToken
+	opTimes     map[string]OperationTime
+	opChanges   []OperationTimeChange
+	attempts    []*memoryAttempt // По порядку выдачи
+
+	flows       map[fairFlowKey]float64 // Метки окончания потоков справедливой очереди
+	virtualTime float64
+
+	lastUserID, lastExpressionID, lastTaskID, lastAgentTokenID, lastChangeID, lastAttemptID int64
+	closed                                                                                  bool
+
+	clock func() time.Time // Источник текущего времени; в тестах подменяется
+}
+
+// memoryTask — задача вместе с состоянием аренды, которое Task наружу не отдаёт.
+type memoryTask struct {
+	Task
+	leaseToken        string
+	settledLeaseToken string
+}
+
+// memoryAttempt — попытка выполнения задачи вместе с арендой, к которой она относится.
+type memoryAttempt struct {
+	TaskAttempt
+	leaseToken string
+}
+
+type fairFlowKey struct {
+	userID   int64
+	priority string
+}
+
+func NewMemoryStore() *MemoryStore {
+	log.Printf("Используется хранилище в памяти: данные не сохраняются после остановки")
+	return &MemoryStore{
+		users:       make(map[int64]*User),
+		userLogins:  make(map[string]int64),
+		settings:    make(map[int64]UserSettings),
+		quotas:      make(map[int64]UserQuota),
+		expressions: make(map[int64]*Expression),
+		tasks:       make(map[int64]*memoryTask),
+		agentTokens: make(map[int64]*AgentToken),
+		opTimes:     make(map[string]OperationTime),
+		flows:       make(map[fairFlowKey]float64),
+		clock:       time.Now,
+	}
+}
+
+// now возвращает текущее время с точностью CURRENT_TIMESTAMP в SQLite.
+func (m *MemoryStore) now() time.Time {
+	return m.clock().UTC().Truncate(time.Second)
+}
+
+// attemptNow возвращает время для попыток задач с миллисекундами, как sqliteNow в Store.
+func (m *MemoryStore) attemptNow() time.Time {
+	return m.clock().UTC().Truncate(time.Millisecond)
+}
+
+func (m *MemoryStore) InitDB() error {
+	return nil
+}
+
+// Ping сообщает об ошибке после Close, как и Store.
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errors.New("хранилище закрыто")
+	}
+	return ctx.Err()
+}
+
+func (m *MemoryStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *MemoryStore) CreateUser(login, passwordHash string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.userLogins[login]; ok {
+		return 0, fmt.Errorf("%w: логин '%s'", ErrUserExists, login)
+	}
+	m.lastUserID++
+	user := &User{ID: m.lastUserID, Login: login, PasswordHash: passwordHash, CreatedAt: m.now()}
+	m.users[user.ID] = user
+	m.userLogins[login] = user.ID
+
+	log.Printf("Создан пользователь '%s' с ID: %d", login, user.ID)
+	return user.ID, nil
+}
+
+func (m *MemoryStore) GetUserByLogin(login string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.userLogins[login]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user := *m.users[id]
+	return &user, nil
+}
+
+func (m *MemoryStore) GetUserByID(id int64) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user := *u
+	return &user, nil
+}
+
+func (m *MemoryStore) SetUserAdmin(userID int64, admin bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.IsAdmin = admin
+	return nil
+}
+
+func (m *MemoryStore) ListUsers() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []User
+	for _, u := range m.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// requireUser воспроизводит проверку внешнего ключа на users. Вызывается под m.mu.
+func (m *MemoryStore) requireUser(userID int64) error {
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("пользователь ID %d не найден", userID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUserSettings(userID int64) (UserSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if settings, ok := m.settings[userID]; ok {
+		return settings, nil
+	}
+	return DefaultUserSettings(userID), nil
+}
+
+func (m *MemoryStore) SaveUserSettings(settings UserSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireUser(settings.UserID); err != nil {
+		return fmt.Errorf("ошибка сохранения настроек пользователя ID %d: %w", settings.UserID, err)
+	}
+	m.settings[settings.UserID] = settings
+	return nil
+}
+
+func (m *MemoryStore) GetUserQuota(userID int64) (*UserQuota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.quotas[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &q, nil
+}
+
+func (m *MemoryStore) SaveUserQuota(q UserQuota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireUser(q.UserID); err != nil {
+		return fmt.Errorf("ошибка сохранения лимитов пользователя ID %d: %w", q.UserID, err)
+	}
+	q.UpdatedAt = m.now()
+	m.quotas[q.UserID] = q
+	return nil
+}
+
+func (m *MemoryStore) DeleteUserQuota(userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.quotas[userID]
+	delete(m.quotas, userID)
+	return ok, nil
+}
+
+func (m *MemoryStore) CountActiveExpressions(userID int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, e := range m.expressions {
+		if e.UserID == userID && (e.Status == StatusPending || e.Status == StatusInProgress) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) CreateExpression(userID int64, expression string) (int64, error) {
+	return m.CreateExpressionWithPriority(userID, expression, PriorityInteractive)
+}
+
+func (m *MemoryStore) CreateExpressionWithPriority(userID int64, expression, priority string) (int64, error) {
+	if !IsValidPriority(priority) {
+		return 0, fmt.Errorf("неизвестный приоритет выражения: %q", priority)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireUser(userID); err != nil {
+		return 0, fmt.Errorf("ошибка создания выражения: %w", err)
+	}
+	m.lastExpressionID++
+	now := m.now()
+	m.expressions[m.lastExpressionID] = &Expression{
+		ID:         m.lastExpressionID,
+		UserID:     userID,
+		Expression: expression,
+		Priority:   priority,
+		Status:     StatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	log.Printf("Создано выражение ID %d для пользователя ID %d: %s", m.lastExpressionID, userID, expression)
+	return m.lastExpressionID, nil
+}
+
+func (m *MemoryStore) GetExpressionByID(id, userID int64) (*Expression, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.expressions[id]
+	if !ok || e.UserID != userID {
+		return nil, ErrExpressionNotFound
+	}
+	expr := *e
+	return &expr, nil
+}
+
+func (m *MemoryStore) GetExpressionByIDInternal(id int64) (*Expression, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.expressions[id]
+	if !ok {
+		return nil, ErrExpressionNotFound
+	}
+	expr := *e
+	return &expr, nil
+}
+
+func (m *MemoryStore) GetExpressionsByUserID(userID int64) ([]Expression, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expressions []Expression
+	for _, e := range m.expressions {
+		if e.UserID == userID {
+			expressions = append(expressions, *e)
+		}
+	}
+	sort.Slice(expressions, func(i, j int) bool {
+		a, b := expressions[i], expressions[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	return expressions, nil
+}
+
+func (m *MemoryStore) UpdateExpressionStatusResult(id int64, status string, result sql.NullFloat64, stepsJSON sql.NullString) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Отменённое выражение не должно «оживать» из-за запоздавшего планирования.
+	if e, ok := m.expressions[id]; ok && e.Status != StatusCancelled {
+		e.Status, e.Result, e.Steps, e.UpdatedAt = status, result, stepsJSON, m.now()
+	}
+	log.Printf("Обновлен статус/результат выражения ID %d: Статус=%s", id, status)
+	return nil
+}
+
+func (m *MemoryStore) CancelExpression(id, userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.expressions[id]
+	if !ok || e.UserID != userID || (e.Status != StatusPending && e.Status != StatusInProgress) {
+		return false, nil
+	}
+	now := m.now()
+	e.Status, e.UpdatedAt = StatusCancelled, now
+	for _, t := range m.tasks {
+		if t.ExpressionID == id && t.Status == StatusPending {
+			t.Status, t.UpdatedAt = StatusCancelled, now
+		}
+	}
+
+	log.Printf("Выражение ID %d отменено пользователем ID %d", id, userID)
+	return true, nil
+}
+
+func (m *MemoryStore) CreateTask(expressionID int64, operation string, arg1, arg2 float64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, err := m.addTask(expressionID, operation, arg1, arg2)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания задачи для выражения ID %d: %w", expressionID, err)
+	}
+
+	log.Printf("Создана задача ID %d для выражения ID %d: %f %s %f", task.ID, expressionID, arg1, operation, arg2)
+	return task.ID, nil
+}
+
+func (m *MemoryStore) CreateCompletedTask(expressionID int64, operation string, arg1, arg2, result float64, settledBy string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, err := m.addTask(expressionID, operation, arg1, arg2)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания выполненной задачи для выражения ID %d: %w", expressionID, err)
+	}
+	task.Status = StatusDone
+	task.Result = sql.NullFloat64{Float64: result, Valid: true}
+	task.SettledBy = sql.NullString{String: settledBy, Valid: true}
+
+	log.Printf("Задача ID %d выражения ID %d (%f %s %f) выполнена без агента (%s): %f", task.ID, expressionID, arg1, operation, arg2, settledBy, result)
+	return task.ID, nil
+}
+
+// addTask добавляет ожидающую задачу. Вызывается под m.mu.
+func (m *MemoryStore) addTask(expressionID int64, operation string, arg1, arg2 float64) (*memoryTask, error) {
+	if _, ok := m.expressions[expressionID]; !ok {
+		return nil, fmt.Errorf("выражение ID %d не найдено", expressionID)
+	}
+	m.lastTaskID++
+	now := m.now()
+	task := &memoryTask{Task: Task{
+		ID:           m.lastTaskID,
+		ExpressionID: expressionID,
+		Operation:    operation,
+		Arg1:         arg1,
+		Arg2:         arg2,
+		Status:       StatusPending,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}}
+	m.tasks[task.ID] = task
+	return task, nil
+}
+
+// GetAndLeasePendingTask выдаёт задачу по той же справедливой очереди, что и Store.
+func (m *MemoryStore) GetAndLeasePendingTask(agentID string) (*Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		best   *fairFlow
+		bestID int64 // Задача best: при равных метках — самая старая
+	)
+	for _, t := range m.tasks {
+		if t.Status != StatusPending {
+			continue
+		}
+		e := m.expressions[t.ExpressionID]
+		f := fairFlow{
+			userID:   e.UserID,
+			priority: e.Priority,
+			start:    max(m.flows[fairFlowKey{e.UserID, e.Priority}], m.virtualTime),
+		}
+		if best == nil || f.start < best.start || f.start == best.start && t.ID < bestID {
+			best, bestID = &f, t.ID
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	leaseToken, err := newLeaseToken()
+	if err != nil {
+		return nil, err
+	}
+	t := m.tasks[bestID]
+	t.Status, t.leaseToken, t.UpdatedAt = StatusInProgress, leaseToken, m.now()
+	m.flows[fairFlowKey{best.userID, best.priority}] = best.start + 1/priorityWeight(best.priority)
+	m.virtualTime = best.start
+	m.lastAttemptID++
+	m.attempts = append(m.attempts, &memoryAttempt{
+		TaskAttempt: TaskAttempt{ID: m.lastAttemptID, TaskID: t.ID, AgentID: agentID, LeasedAt: m.attemptNow(), Outcome: AttemptLeased},
+		leaseToken:  leaseToken,
+	})
+
+	// Как и Store, возвращаем задачу без результата и идентичности агента.
+	task := t.Task
+	task.Result, task.SettledBy = sql.NullFloat64{}, sql.NullString{}
+	task.LeaseToken = leaseToken
+	log.Printf("Задача ID %d выдана агенту '%s' (Expression ID: %d)", task.ID, agentID, task.ExpressionID)
+	return &task, nil
+}
+
+func (m *MemoryStore) CompleteTask(taskID int64, leaseToken, agentID string, result float64) (bool, error) {
+	attempt := attemptOutcome{outcome: AttemptDone, result: sql.NullFloat64{Float64: result, Valid: true}}
+	duplicate, err := m.settleTask(taskID, leaseToken, agentID, attempt, func(t *memoryTask) {
+		t.Status = StatusDone
+		t.Result = sql.NullFloat64{Float64: result, Valid: true}
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+	if duplicate {
+		log.Printf("Повторный результат задачи ID %d по той же аренде проигнорирован", taskID)
+		return true, nil
+	}
+
+	log.Printf("Задача ID %d завершена агентом '%s' с результатом: %f", taskID, agentID, result)
+	return false, nil
+}
+
+func (m *MemoryStore) FailTask(taskID int64, leaseToken, agentID, message string) (bool, error) {
+	attempt := attemptOutcome{outcome: AttemptFailed, message: sql.NullString{String: message, Valid: true}}
+	duplicate, err := m.settleTask(taskID, leaseToken, agentID, attempt, func(t *memoryTask) {
+		t.Status = StatusPending
+		t.Retries++
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка отметки задачи ID %d как ошибочной: %w", taskID, err)
+	}
+	if duplicate {
+		log.Printf("Повторная ошибка задачи ID %d по той же аренде проигнорирована", taskID)
+		return true, nil
+	}
+
+	log.Printf("Ошибка выполнения задачи ID %d агентом '%s', возвращена в очередь.", taskID, agentID)
+	return false, nil
+}
+
+func (m *MemoryStore) ReleaseTask(taskID int64, leaseToken, agentID string) (bool, error) {
+	duplicate, err := m.settleTask(taskID, leaseToken, agentID, attemptOutcome{outcome: AttemptReleased}, func(t *memoryTask) {
+		t.Status = StatusPending
+		t.leaseToken = ""
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка возврата задачи ID %d в очередь: %w", taskID, err)
+	}
+	if !duplicate {
+		log.Printf("Задача ID %d возвращена в очередь агентом '%s'", taskID, agentID)
+	}
+	return duplicate, nil
+}
+
+// settleTask проверяет аренду задачи так же, как Store.settleTask, и применяет к ней update.
+func (m *MemoryStore) settleTask(taskID int64, leaseToken, agentID string, attempt attemptOutcome, update func(t *memoryTask)) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tasks[taskID]
+	if !ok {
+		return false, ErrTaskNotFound
+	}
+	if leaseToken != "" && t.settledLeaseToken == leaseToken {
+		return true, nil
+	}
+	if leaseToken == "" || t.Status != StatusInProgress || t.leaseToken != leaseToken {
+		// Повтор по более ранней, уже закрытой аренде узнаётся по журналу попыток.
+		for _, a := range m.attempts {
+			if a.TaskID == taskID && leaseToken != "" && a.leaseToken == leaseToken && a.Outcome != AttemptLeased {
+				return true, nil
+			}
+		}
+		return false, ErrLeaseLost
+	}
+
+	update(t)
+	t.settledLeaseToken = leaseToken
+	t.SettledBy = sql.NullString{String: agentID, Valid: true}
+	t.UpdatedAt = m.now()
+	for i := len(m.attempts) - 1; i >= 0; i-- {
+		if a := m.attempts[i]; a.leaseToken == leaseToken {
+			a.Outcome, a.Result, a.ErrorMessage = attempt.outcome, attempt.result, attempt.message
+			a.SubmittedAt = sql.NullTime{Time: m.attemptNow(), Valid: true}
+			break
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) ListTaskAttempts(expressionID int64) ([]TaskAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var attempts []TaskAttempt
+	for _, a := range m.attempts {
+		if t, ok := m.tasks[a.TaskID]; ok && t.ExpressionID == expressionID {
+			attempts = append(attempts, a.TaskAttempt)
+		}
+	}
+	return attempts, nil
+}
+
+// dropAttempts удаляет попытки удалённых задач, как ON DELETE CASCADE в Store. Вызывается под m.mu.
+func (m *MemoryStore) dropAttempts() {
+	kept := m.attempts[:0]
+	for _, a := range m.attempts {
+		if _, ok := m.tasks[a.TaskID]; ok {
+			kept = append(kept, a)
+		}
+	}
+	m.attempts = kept
+}
+
+func (m *MemoryStore) GetTaskByID(taskID int64) (*Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tasks[taskID]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	task := t.Task
+	return &task, nil
+}
+
+func (m *MemoryStore) HasPendingTasks(expressionID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tasks {
+		if t.ExpressionID == expressionID && (t.Status == StatusPending || t.Status == StatusInProgress) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tasks []Task
+	for _, t := range m.tasks {
+		if t.ExpressionID == expressionID {
+			tasks = append(tasks, t.Task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks, nil
+}
+
+func (m *MemoryStore) CreateAgentToken(name, tokenHash string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.agentTokens {
+		if token.TokenHash == tokenHash {
+			return 0, fmt.Errorf("ошибка создания токена агента '%s': токен уже выпущен", name)
+		}
+	}
+	m.lastAgentTokenID++
+	m.agentTokens[m.lastAgentTokenID] = &AgentToken{ID: m.lastAgentTokenID, Name: name, TokenHash: tokenHash, CreatedAt: m.now()}
+
+	log.Printf("Выпущен токен агента ID %d для '%s'", m.lastAgentTokenID, name)
+	return m.lastAgentTokenID, nil
+}
+
+func (m *MemoryStore) GetAgentTokenByHash(tokenHash string) (*AgentToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.agentTokens {
+		if t.TokenHash == tokenHash {
+			token := *t
+			return &token, nil
+		}
+	}
+	return nil, ErrAgentTokenNotFound
+}
+
+func (m *MemoryStore) ListAgentTokens() ([]AgentToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []AgentToken
+	for _, t := range m.agentTokens {
+		tokens = append(tokens, *t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (m *MemoryStore) RevokeAgentToken(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.agentTokens[id]
+	if !ok || t.RevokedAt.Valid {
+		return false, nil
+	}
+	t.RevokedAt = sql.NullTime{Time: m.now(), Valid: true}
+	log.Printf("Токен агента ID %d отозван", id)
+	return true, nil
+}
+
+func (m *MemoryStore) ListOperationTimes() ([]OperationTime, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var times []OperationTime
+	for _, t := range m.opTimes {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Operation < times[j].Operation })
+	return times, nil
+}
+
+func (m *MemoryStore) SetOperationTime(operation string, timeMs int, userID int64) error {
+	return m.changeOperationTime(operation, sql.NullInt64{Int64: int64(timeMs), Valid: true}, userID)
+}
+
+func (m *MemoryStore) ResetOperationTime(operation string, userID int64) (bool, error) {
+	err := m.changeOperationTime(operation, sql.NullInt64{}, userID)
+	if err == errNoOperationTime {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MemoryStore) changeOperationTime(operation string, timeMs sql.NullInt64, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireUser(userID); err != nil {
+		return fmt.Errorf("ошибка изменения времени операции '%s': %w", operation, err)
+	}
+	var old sql.NullInt64
+	if t, ok := m.opTimes[operation]; ok {
+		old = sql.NullInt64{Int64: int64(t.TimeMs), Valid: true}
+	}
+
+	now := m.now()
+	if timeMs.Valid {
+		m.opTimes[operation] = OperationTime{Operation: operation, TimeMs: int(timeMs.Int64), UpdatedBy: userID, UpdatedAt: now}
+	} else {
+		if !old.Valid {
+			return errNoOperationTime
+		}
+		delete(m.opTimes, operation)
+	}
+
+	m.lastChangeID++
+	m.opChanges = append(m.opChanges, OperationTimeChange{
+		ID:        m.lastChangeID,
+		Operation: operation,
+		OldTimeMs: old,
+		NewTimeMs: timeMs,
+		ChangedBy: userID,
+		ChangedAt: now,
+	})
+
+	log.Printf("Время операции '%s' изменено пользователем ID %d: %v -> %v", operation, userID, nullInt(old), nullInt(timeMs))
+	return nil
+}
+
+func (m *MemoryStore) ListOperationTimeChanges(limit int) ([]OperationTimeChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []OperationTimeChange
+	for i := len(m.opChanges) - 1; i >= 0 && (limit < 0 || len(changes) < limit); i-- {
+		c := m.opChanges[i]
+		if u, ok := m.users[c.ChangedBy]; ok {
+			c.ChangedByLogin = u.Login
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func (m *MemoryStore) PurgeTasks(before time.Time, limit int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int64
+	for id, t := range m.tasks {
+		if e := m.expressions[t.ExpressionID]; IsFinalStatus(e.Status) && t.Status != StatusInProgress && t.UpdatedAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	for _, id := range ids {
+		delete(m.tasks, id)
+	}
+	m.dropAttempts()
+	return int64(len(ids)), nil
+}
+
+func (m *MemoryStore) PurgeExpressions(status string, before time.Time, limit int) (int64, int64, error) {
+	if !IsFinalStatus(status) {
+		return 0, 0, fmt.Errorf("удалять можно только завершённые выражения, а не %q", status)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expressions, tasks := m.deleteExpressions(limit, func(e *Expression) bool {
+		return e.Status == status && e.UpdatedAt.Before(before)
+	})
+	return expressions, tasks, nil
+}
+
+func (m *MemoryStore) DeleteUserHistory(userID int64) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expressions, tasks := m.deleteExpressions(-1, func(e *Expression) bool { return e.UserID == userID })
+	log.Printf("История пользователя ID %d очищена: удалено выражений %d, задач %d", userID, expressions, tasks)
+	return expressions, tasks, nil
+}
+
+// deleteExpressions удаляет до limit (отрицательный — без ограничения) завершённых
+// выражений, подходящих под match и не имеющих задач у агентов, вместе с задачами.
+// Вызывается под m.mu.
+func (m *MemoryStore) deleteExpressions(limit int, match func(e *Expression) bool) (expressions, tasks int64) {
+	busy := make(map[int64]bool)
+	for _, t := range m.tasks {
+		if t.Status == StatusInProgress {
+			busy[t.ExpressionID] = true
+		}
+	}
+	var ids []int64
+	for id, e := range m.expressions {
+		if IsFinalStatus(e.Status) && !busy[id] && match(e) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if limit >= 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	deleted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+		delete(m.expressions, id)
+	}
+	for id, t := range m.tasks {
+		if deleted[t.ExpressionID] {
+			delete(m.tasks, id)
+			tasks++
+		}
+	}
+	m.dropAttempts()
+	return int64(len(ids)), tasks
+}
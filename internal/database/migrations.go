@@ -0,0 +1,470 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration — шаг изменения схемы. up и down выполняются в транзакции вместе
+// с записью в schema_migrations, поэтому шаг применяется целиком или не применяется.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+	down    func(tx *sql.Tx) error
+}
+
+// migrations — все изменения схемы по возрастанию версии. Применённую миграцию
+// менять нельзя: новые столбцы и таблицы добавляются новой миграцией в конец списка.
+//
+// БД, созданные до появления schema_migrations, содержат часть этих изменений,
+// поэтому миграции создают таблицы с IF NOT EXISTS, а столбцы — через addColumn.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS users (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					login TEXT NOT NULL UNIQUE,
+					password_hash TEXT NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS expressions (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					expression TEXT NOT NULL,
+					status TEXT NOT NULL,
+					result REAL,
+					steps TEXT,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY(user_id) REFERENCES users(id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS tasks (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					expression_id INTEGER NOT NULL,
+					operation TEXT NOT NULL,
+					arg1 REAL NOT NULL,
+					arg2 REAL NOT NULL,
+					result REAL,
+					status TEXT NOT NULL,
+					retries INTEGER NOT NULL DEFAULT 0,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY(expression_id) REFERENCES expressions(id)
+				)`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE tasks`, `DROP TABLE expressions`, `DROP TABLE users`)
+		},
+	},
+	{
+		version: 2,
+		name:    "task_leases",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "tasks", "lease_token", "TEXT"); err != nil {
+				return err
+			}
+			return addColumn(tx, "tasks", "settled_lease_token", "TEXT")
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE tasks DROP COLUMN settled_lease_token`,
+				`ALTER TABLE tasks DROP COLUMN lease_token`,
+			)
+		},
+	},
+	{
+		version: 3,
+		name:    "agent_tokens",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "tasks", "settled_by", "TEXT"); err != nil {
+				return err
+			}
+			return execAll(tx, `CREATE TABLE IF NOT EXISTS agent_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				revoked_at DATETIME
+			)`)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE agent_tokens`, `ALTER TABLE tasks DROP COLUMN settled_by`)
+		},
+	},
+	{
+		version: 4,
+		name:    "operation_times",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS operation_times (
+					operation TEXT PRIMARY KEY,
+					time_ms INTEGER NOT NULL,
+					updated_by INTEGER NOT NULL,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY(updated_by) REFERENCES users(id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS operation_time_changes (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					operation TEXT NOT NULL,
+					old_time_ms INTEGER,
+					new_time_ms INTEGER,
+					changed_by INTEGER NOT NULL,
+					changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY(changed_by) REFERENCES users(id)
+				)`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE operation_time_changes`, `DROP TABLE operation_times`)
+		},
+	},
+	{
+		version: 5,
+		name:    "user_settings",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx, `CREATE TABLE IF NOT EXISTS user_settings (
+				user_id INTEGER PRIMARY KEY,
+				use_result_cache INTEGER NOT NULL DEFAULT 1,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE user_settings`)
+		},
+	},
+	{
+		version: 6,
+		name:    "fair_queue",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "expressions", "priority", "TEXT NOT NULL DEFAULT 'interactive'"); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status, expression_id)`,
+				`CREATE TABLE IF NOT EXISTS fair_queue_flows (
+					user_id INTEGER NOT NULL,
+					priority TEXT NOT NULL,
+					finish_tag REAL NOT NULL,
+					PRIMARY KEY(user_id, priority)
+				)`,
+				`CREATE TABLE IF NOT EXISTS fair_queue_clock (
+					id INTEGER PRIMARY KEY CHECK (id = 1),
+					virtual_time REAL NOT NULL
+				)`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE fair_queue_clock`,
+				`DROP TABLE fair_queue_flows`,
+				`DROP INDEX idx_tasks_status`,
+				`ALTER TABLE expressions DROP COLUMN priority`,
+			)
+		},
+	},
+	{
+		version: 7,
+		name:    "user_quotas",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx, `CREATE TABLE IF NOT EXISTS user_quotas (
+				user_id INTEGER PRIMARY KEY,
+				submissions_per_minute INTEGER,
+				max_active_expressions INTEGER,
+				max_ast_nodes INTEGER,
+				max_expression_length INTEGER,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE user_quotas`)
+		},
+	},
+	{
+		version: 8,
+		name:    "task_attempts",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS task_attempts (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					task_id INTEGER NOT NULL,
+					lease_token TEXT NOT NULL UNIQUE,
+					agent_id TEXT NOT NULL,
+					leased_at DATETIME NOT NULL,
+					submitted_at DATETIME,
+					outcome TEXT NOT NULL,
+					error_message TEXT,
+					result REAL,
+					FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_task_attempts_task ON task_attempts(task_id)`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE task_attempts`)
+		},
+	},
+	{
+		// Права администратора хранятся у пользователя, а не выводятся из логина:
+		// иначе первый зарегистрировавший логин из конфигурации стал бы администратором.
+		version: 9,
+		name:    "user_roles",
+		up: func(tx *sql.Tx) error {
+			return addColumn(tx, "users", "is_admin", "INTEGER NOT NULL DEFAULT 0")
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `ALTER TABLE users DROP COLUMN is_admin`)
+		},
+	},
+	{
+		// Голова очереди хранится, а не вычисляется при каждой выдаче: поиск старшей
+		// задачи каждого выражения читал всю очередь, и выдача занимала единственного
+		// писателя на время, пропорциональное числу ожидающих задач.
+		version: 10,
+		name:    "fair_queue_heads",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "fair_queue_flows", "start_tag", "REAL NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			if err := addColumn(tx, "fair_queue_flows", "head_task_id", "INTEGER"); err != nil {
+				return err
+			}
+			err := execAll(tx,
+				`CREATE INDEX IF NOT EXISTS idx_tasks_expression_status ON tasks(expression_id, status, id)`,
+				`CREATE TABLE IF NOT EXISTS fair_queue_heads (
+					expression_id INTEGER PRIMARY KEY,
+					user_id INTEGER NOT NULL,
+					priority TEXT NOT NULL,
+					task_id INTEGER NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_fair_queue_heads_flow ON fair_queue_heads(user_id, priority, task_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_fair_queue_flows_head ON fair_queue_flows(start_tag, head_task_id)
+					WHERE head_task_id IS NOT NULL`,
+			)
+			if err != nil {
+				return err
+			}
+			// Очередь, накопленная до миграции, переносится в головы с теми же метками.
+			if _, err := tx.Exec(`INSERT INTO fair_queue_heads (expression_id, user_id, priority, task_id)
+				SELECT e.id, e.user_id, e.priority, MIN(t.id) FROM tasks t JOIN expressions e ON e.id = t.expression_id
+				WHERE t.status = ? GROUP BY e.id`, StatusPending); err != nil {
+				return err
+			}
+			return execAll(tx,
+				`INSERT INTO fair_queue_flows (user_id, priority, finish_tag)
+					SELECT DISTINCT user_id, priority, 0 FROM fair_queue_heads WHERE true
+					ON CONFLICT(user_id, priority) DO NOTHING`,
+				`UPDATE fair_queue_flows SET
+					head_task_id = (SELECT MIN(h.task_id) FROM fair_queue_heads h
+						WHERE h.user_id = fair_queue_flows.user_id AND h.priority = fair_queue_flows.priority),
+					start_tag = MAX(finish_tag, COALESCE((SELECT virtual_time FROM fair_queue_clock WHERE id = 1), 0))`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP INDEX idx_fair_queue_flows_head`,
+				`DROP TABLE fair_queue_heads`,
+				`DROP INDEX idx_tasks_expression_status`,
+				`ALTER TABLE fair_queue_flows DROP COLUMN head_task_id`,
+				`ALTER TABLE fair_queue_flows DROP COLUMN start_tag`,
+			)
+		},
+	},
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn добавляет столбец, если его ещё нет.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// LatestSchemaVersion — версия схемы, с которой работает этот код.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus описывает миграцию и момент её применения.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt sql.NullTime // Не задано, если миграция не применена
+}
+
+func (s *Store) ensureMigrationsTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// SchemaVersion возвращает версию схемы БД: наибольшую применённую миграцию, 0 — пустая БД.
+func (s *Store) SchemaVersion() (int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	return s.schemaVersion()
+}
+
+func (s *Store) schemaVersion() (int, error) {
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("ошибка чтения версии схемы: %w", err)
+	}
+	return version, nil
+}
+
+// Migrations возвращает все известные миграции и время их применения.
+func (s *Store) Migrations() ([]MigrationStatus, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := s.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.version, Name: m.name}
+		if at, ok := applied[m.version]; ok {
+			st.AppliedAt = sql.NullTime{Time: at, Valid: true}
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// MigrateUp применяет миграции до версии target включительно. Каждая миграция
+// выполняется в отдельной транзакции; при ошибке БД остаётся на последней успешной версии.
+func (s *Store) MigrateUp(target int) error {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return err
+	}
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("версия схемы БД %d новее поддерживаемой (%d): обновите оркестратор", current, LatestSchemaVersion())
+	}
+	if target > LatestSchemaVersion() {
+		return fmt.Errorf("миграции версии %d не существует, последняя — %d", target, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		var applied bool
+		err := s.inTx(func(tx *sql.Tx) error {
+			// Миграцию мог уже применить другой процесс, пока мы ждали блокировку записи.
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`, m.version).Scan(&applied); err != nil || applied {
+				return err
+			}
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка миграции %d (%s): %w", m.version, m.name, err)
+		}
+		if !applied {
+			log.Printf("Применена миграция БД %d: %s", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+// MigrateDown откатывает миграции новее target, начиная с последней.
+func (s *Store) MigrateDown(target int) error {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return err
+	}
+	if target < 0 {
+		return fmt.Errorf("некорректная версия схемы: %d", target)
+	}
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("версия схемы БД %d новее поддерживаемой (%d): откат невозможен", current, LatestSchemaVersion())
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		err := s.inTx(func(tx *sql.Tx) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка отката миграции %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("Откачена миграция БД %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+func (s *Store) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openFileStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(filepath.Join(t.TempDir(), "calculator.db"))
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skip DB tests: %v", err)
+		}
+		t.Fatalf("NewStore error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func hasColumn(t *testing.T, store *Store, table, column string) bool {
+	t.Helper()
+	var n int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		t.Fatalf("pragma_table_info error: %v", err)
+	}
+	return n > 0
+}
+
+// TestMigrateFixtures обновляет БД, созданные старыми версиями оркестратора.
+func TestMigrateFixtures(t *testing.T) {
+	for _, fixture := range []string{"baseline.sql", "pre_migrations.sql"} {
+		t.Run(fixture, func(t *testing.T) {
+			script, err := os.ReadFile(filepath.Join("testdata", fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := openFileStore(t)
+			if _, err := store.db.Exec(string(script)); err != nil {
+				t.Fatalf("loading fixture: %v", err)
+			}
+
+			if err := store.InitDB(); err != nil {
+				t.Fatalf("InitDB error: %v", err)
+			}
+			if v, _ := store.SchemaVersion(); v != LatestSchemaVersion() {
+				t.Fatalf("schema version = %d, want %d", v, LatestSchemaVersion())
+			}
+			statuses, _ := store.Migrations()
+			for _, st := range statuses {
+				if !st.AppliedAt.Valid {
+					t.Errorf("migration %d (%s) not applied", st.Version, st.Name)
+				}
+			}
+
+			expr, err := store.GetExpressionByIDInternal(2)
+			if err != nil || expr == nil || expr.Priority != PriorityInteractive {
+				t.Fatalf("existing expression after upgrade: %+v, err %v", expr, err)
+			}
+			task, err := store.GetAndLeasePendingTask("a1")
+			if err != nil || task == nil || task.ID != 2 || task.LeaseToken == "" {
+				t.Fatalf("leasing existing task after upgrade: %+v, err %v", task, err)
+			}
+			if _, err := store.CompleteTask(task.ID, task.LeaseToken, "agent", 3); err != nil {
+				t.Fatalf("CompleteTask error: %v", err)
+			}
+			if _, err := store.GetUserSettings(1); err != nil {
+				t.Fatalf("GetUserSettings error: %v", err)
+			}
+
+			// Откат до исходной схемы сохраняет данные, повторное обновление проходит.
+			if err := store.MigrateDown(1); err != nil {
+				t.Fatalf("MigrateDown error: %v", err)
+			}
+			if v, _ := store.SchemaVersion(); v != 1 {
+				t.Fatalf("schema version after rollback = %d, want 1", v)
+			}
+			if hasColumn(t, store, "tasks", "lease_token") || hasColumn(t, store, "expressions", "priority") {
+				t.Fatal("rollback must remove columns added by later migrations")
+			}
+			var tasks int
+			store.db.QueryRow(`SELECT COUNT(*) FROM tasks`).Scan(&tasks)
+			if tasks != 2 {
+				t.Fatalf("tasks after rollback = %d, want 2", tasks)
+			}
+			if err := store.MigrateUp(LatestSchemaVersion()); err != nil {
+				t.Fatalf("MigrateUp after rollback: %v", err)
+			}
+			if !hasColumn(t, store, "tasks", "lease_token") {
+				t.Fatal("lease_token missing after re-applying migrations")
+			}
+		})
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	store := openFileStore(t)
+	if err := store.InitDB(); err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	if _, err := store.db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'future')`, LatestSchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InitDB(); err == nil {
+		t.Fatal("InitDB must refuse a schema newer than the code")
+	}
+}
@@ -9,14 +9,57 @@ type User struct {
 	ID           int64     `json:"id"`
 	Login        string    `json:"login"`
 	PasswordHash string    `json:"-"` // Не отправляем хэш клиенту
+	IsAdmin      bool      `json:"is_admin"` // Доступ к админ-API; назначается командой "orchestrator admin"
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// UserSettings — пользовательские настройки вычислений.
+type UserSettings struct {
+	UserID         int64 `json:"-"`
+	UseResultCache bool  `json:"use_result_cache"` // false — всегда вычислять на агентах, сохраняя реалистичное время
+}
+
+// DefaultUserSettings возвращает настройки пользователя, который их не менял.
+func DefaultUserSettings(userID int64) UserSettings {
+	return UserSettings{UserID: userID, UseResultCache: true}
+}
+
+// Приоритеты выражений. Задачи выражений делят агентов по взвешенной справедливой
+// очереди: каждой паре (пользователь, приоритет) достаётся доля, пропорциональная весу.
+const (
+	PriorityInteractive = "interactive" // Короткие запросы, ответ на которые ждёт человек
+	PriorityBatch       = "batch"       // Массовые вычисления, которые не должны мешать интерактивным
+)
+
+// PriorityWeights — веса приоритетов в справедливой очереди задач.
+var PriorityWeights = map[string]float64{
+	PriorityInteractive: 4,
+	PriorityBatch:       1,
+}
+
+// IsValidPriority сообщает, известен ли приоритет.
+func IsValidPriority(priority string) bool {
+	_, ok := PriorityWeights[priority]
+	return ok
+}
+
+// UserQuota — лимиты пользователя, заданные администратором. NULL в поле означает,
+// что действует значение по умолчанию оркестратора; 0 — ограничения нет.
+type UserQuota struct {
+	UserID               int64
+	SubmissionsPerMinute sql.NullInt64
+	MaxActiveExpressions sql.NullInt64
+	MaxASTNodes          sql.NullInt64
+	MaxExpressionLength  sql.NullInt64
+	UpdatedAt            time.Time
+}
+
 type Expression struct {
 	ID         int64           `json:"id"`
 	UserID     int64           `json:"user_id"`
 	Expression string          `json:"expression"`
-	Status     string          `json:"status"`           // pending, in_progress, done, error
+	Priority   string          `json:"priority"`         // interactive, batch
+	Status     string          `json:"status"`           // pending, in_progress, done, error, cancelled
 	Result     sql.NullFloat64 `json:"result,omitempty"` // Используем NullFloat64 для поддержки NULL в БД
 	Steps      sql.NullString  `json:"steps,omitempty"`  // Шаги можно хранить как JSON строку
 	CreatedAt  time.Time       `json:"created_at"`
@@ -34,6 +77,58 @@ type Task struct {
 	CreatedAt    time.Time       `json:"created_at"`
 	UpdatedAt    time.Time       `json:"updated_at"`
 	Retries      int             `json:"retries"`
+	LeaseToken   string          `json:"-"`                    // Токен текущей аренды задачи агентом
+	SettledBy    sql.NullString  `json:"settled_by,omitempty"` // Идентичность агента, чей результат принят
+}
+
+// Исходы попыток выполнения задачи.
+const (
+	AttemptLeased   = "leased"   // Задача у агента, результата ещё нет
+	AttemptDone     = "done"     // Результат принят
+	AttemptFailed   = "failed"   // Агент сообщил об ошибке, задача вернулась в очередь
+	AttemptReleased = "released" // Агент вернул задачу, не досчитав её
+)
+
+// TaskAttempt — одна выдача задачи агенту и её исход. Попытка соответствует аренде:
+// повторная выдача той же задачи начинает новую попытку.
+type TaskAttempt struct {
+	ID           int64
+	TaskID       int64
+	AgentID      string // Агент, получивший задачу
+	LeasedAt     time.Time
+	SubmittedAt  sql.NullTime // Когда агент прислал результат, ошибку или вернул задачу
+	Outcome      string
+	ErrorMessage sql.NullString // Сообщение агента при исходе AttemptFailed
+	Result       sql.NullFloat64
+}
+
+type AgentToken struct {
+	ID        int64        `json:"id"`
+	Name      string       `json:"name"` // Идентичность агента, предъявляющего токен
+	TokenHash string       `json:"-"`
+	CreatedAt time.Time    `json:"created_at"`
+	RevokedAt sql.NullTime `json:"revoked_at,omitempty"`
+}
+
+// OperationTime — время операции, заданное администратором поверх значения по умолчанию.
+type OperationTime struct {
+	Operation string    `json:"operation"`
+	TimeMs    int       `json:"time_ms"`
+	UpdatedBy int64     `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OperationTimeChange — запись журнала изменений времени операций.
+// NULL в OldTimeMs/NewTimeMs означает, что заданного значения не было (или оно сброшено)
+// и действовало значение по умолчанию.
+type OperationTimeChange struct {
+	ID             int64         `json:"id"`
+	Operation      string        `json:"operation"`
+	OldTimeMs      sql.NullInt64 `json:"old_time_ms"`
+	NewTimeMs      sql.NullInt64 `json:"new_time_ms"`
+	ChangedBy      int64         `json:"changed_by"`
+	ChangedByLogin string        `json:"changed_by_login"`
+	ChangedAt      time.Time     `json:"changed_at"`
 }
 
 const (
@@ -41,4 +136,10 @@ const (
 	StatusInProgress = "in_progress"
 	StatusDone       = "done"
 	StatusError      = "error"
+	StatusCancelled  = "cancelled"
 )
+
+// IsFinalStatus сообщает, что выражение больше не будет меняться.
+func IsFinalStatus(status string) bool {
+	return status == StatusDone || status == StatusError || status == StatusCancelled
+}
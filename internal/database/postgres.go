@@ -0,0 +1,1055 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq" // PostgreSQL driver
+)
+
+// expressionEventsChannel — канал LISTEN/NOTIFY, по которому оркестраторы
+// сообщают друг другу об изменении выражений.
+const expressionEventsChannel = "calculator_expression_events"
+
+// migrationLockKey — ключ advisory-блокировки, под которой оркестраторы,
+// запускающиеся одновременно, по очереди применяют миграции.
+const migrationLockKey = 7243001
+
+// PostgresStore — хранилище в PostgreSQL. В отличие от Store оно не использует
+// блокировок процесса: несколько оркестраторов могут работать с одной БД и одной
+// очередью задач. Задачи выдаются через SELECT ... FOR UPDATE SKIP LOCKED, поэтому
+// оркестраторы не ждут друг друга и не выдают одну задачу дважды.
+type PostgresStore struct {
+	db  *sql.DB
+	dsn string
+}
+
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия БД PostgreSQL: %w", err)
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(25)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ошибка подключения к БД PostgreSQL: %w", err)
+	}
+
+	log.Printf("Успешное подключение к базе данных PostgreSQL")
+	return &PostgresStore{db: db, dsn: dsn}, nil
+}
+
+func (p *PostgresStore) Close() error {
+	return p.db.Close()
+}
+
+func (p *PostgresStore) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// postgresMigrations — изменения схемы PostgreSQL. Версии ведутся независимо от SQLite:
+// хранилище появилось, когда схема SQLite уже была на версии 7, и начинается с неё целиком.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE users (
+					id BIGSERIAL PRIMARY KEY,
+					login TEXT NOT NULL UNIQUE,
+					password_hash TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+				`CREATE TABLE expressions (
+					id BIGSERIAL PRIMARY KEY,
+					user_id BIGINT NOT NULL REFERENCES users(id),
+					expression TEXT NOT NULL,
+					priority TEXT NOT NULL DEFAULT 'interactive',
+					status TEXT NOT NULL,
+					result DOUBLE PRECISION,
+					steps TEXT,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+				`CREATE INDEX idx_expressions_user ON expressions(user_id, created_at)`,
+				`CREATE TABLE tasks (
+					id BIGSERIAL PRIMARY KEY,
+					expression_id BIGINT NOT NULL REFERENCES expressions(id),
+					operation TEXT NOT NULL,
+					arg1 DOUBLE PRECISION NOT NULL,
+					arg2 DOUBLE PRECISION NOT NULL,
+					result DOUBLE PRECISION,
+					status TEXT NOT NULL,
+					retries INTEGER NOT NULL DEFAULT 0,
+					lease_token TEXT,
+					settled_lease_token TEXT,
+					settled_by TEXT,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+				`CREATE INDEX idx_tasks_status ON tasks(status, expression_id)`,
+				`CREATE TABLE agent_tokens (
+					id BIGSERIAL PRIMARY KEY,
+					name TEXT NOT NULL,
+					token_hash TEXT NOT NULL UNIQUE,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					revoked_at TIMESTAMPTZ
+				)`,
+				`CREATE TABLE operation_times (
+					operation TEXT PRIMARY KEY,
+					time_ms INTEGER NOT NULL,
+					updated_by BIGINT NOT NULL REFERENCES users(id),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+				`CREATE TABLE operation_time_changes (
+					id BIGSERIAL PRIMARY KEY,
+					operation TEXT NOT NULL,
+					old_time_ms INTEGER,
+					new_time_ms INTEGER,
+					changed_by BIGINT NOT NULL REFERENCES users(id),
+					changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+				`CREATE TABLE user_settings (
+					user_id BIGINT PRIMARY KEY REFERENCES users(id),
+					use_result_cache BOOLEAN NOT NULL DEFAULT TRUE,
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+				`CREATE TABLE fair_queue_flows (
+					user_id BIGINT NOT NULL,
+					priority TEXT NOT NULL,
+					finish_tag DOUBLE PRECISION NOT NULL,
+					PRIMARY KEY(user_id, priority)
+				)`,
+				`CREATE TABLE fair_queue_clock (
+					id INTEGER PRIMARY KEY CHECK (id = 1),
+					virtual_time DOUBLE PRECISION NOT NULL
+				)`,
+				`CREATE TABLE user_quotas (
+					user_id BIGINT PRIMARY KEY REFERENCES users(id),
+					submissions_per_minute INTEGER,
+					max_active_expressions INTEGER,
+					max_ast_nodes INTEGER,
+					max_expression_length INTEGER,
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+				)`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE user_quotas`, `DROP TABLE fair_queue_clock`, `DROP TABLE fair_queue_flows`,
+				`DROP TABLE user_settings`, `DROP TABLE operation_time_changes`, `DROP TABLE operation_times`,
+				`DROP TABLE agent_tokens`, `DROP TABLE tasks`, `DROP TABLE expressions`, `DROP TABLE users`,
+			)
+		},
+	},
+	{
+		version: 2,
+		name:    "task_attempts",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE task_attempts (
+					id BIGSERIAL PRIMARY KEY,
+					task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+					lease_token TEXT NOT NULL UNIQUE,
+					agent_id TEXT NOT NULL,
+					leased_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					submitted_at TIMESTAMPTZ,
+					outcome TEXT NOT NULL,
+					error_message TEXT,
+					result DOUBLE PRECISION
+				)`,
+				`CREATE INDEX idx_task_attempts_task ON task_attempts(task_id)`,
+			)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE task_attempts`)
+		},
+	},
+	{
+		// Строка часов создаётся заранее: выдача задач сдвигает их обычным UPDATE,
+		// который не блокирует строку, если часы уже впереди.
+		version: 3,
+		name:    "fair_queue_clock_row",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx, `INSERT INTO fair_queue_clock (id, virtual_time) VALUES (1, 0) ON CONFLICT (id) DO NOTHING`)
+		},
+		down: func(tx *sql.Tx) error {
+			return nil
+		},
+	},
+	{
+		version: 4,
+		name:    "user_roles",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx, `ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false`)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `ALTER TABLE users DROP COLUMN is_admin`)
+		},
+	},
+	{
+		// Задачи выражения по статусу ищут проверка незавершённых задач и очистка
+		// истории; idx_tasks_status начинается со статуса и для них не подходит.
+		version: 5,
+		name:    "tasks_expression_status",
+		up: func(tx *sql.Tx) error {
+			return execAll(tx, `CREATE INDEX idx_tasks_expression_status ON tasks(expression_id, status, id)`)
+		},
+		down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP INDEX idx_tasks_expression_status`)
+		},
+	},
+}
+
+// InitDB применяет недостающие миграции в одной транзакции под advisory-блокировкой,
+// поэтому одновременно запущенные оркестраторы не мешают друг другу.
+func (p *PostgresStore) InitDB() error {
+	err := p.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+			return fmt.Errorf("ошибка создания таблицы schema_migrations: %w", err)
+		}
+
+		var current int
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+			return fmt.Errorf("ошибка чтения версии схемы: %w", err)
+		}
+		latest := postgresMigrations[len(postgresMigrations)-1].version
+		if current > latest {
+			return fmt.Errorf("версия схемы БД %d новее поддерживаемой (%d): обновите оркестратор", current, latest)
+		}
+		for _, m := range postgresMigrations {
+			if m.version <= current {
+				continue
+			}
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("ошибка миграции %d (%s): %w", m.version, m.name, err)
+			}
+			if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
+				return err
+			}
+			log.Printf("Применена миграция БД %d: %s", m.version, m.name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("database migration error: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// isUniqueViolation сообщает, что запрос нарушил ограничение уникальности.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (p *PostgresStore) CreateUser(login, passwordHash string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(`INSERT INTO users (login, password_hash) VALUES ($1, $2) RETURNING id`, login, passwordHash).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%w: логин '%s'", ErrUserExists, login)
+		}
+		return 0, fmt.Errorf("ошибка создания пользователя: %w", err)
+	}
+
+	log.Printf("Создан пользователь '%s' с ID: %d", login, id)
+	return id, nil
+}
+
+func (p *PostgresStore) GetUserByLogin(login string) (*User, error) {
+	user := &User{}
+	err := p.db.QueryRow(`SELECT id, login, password_hash, is_admin, created_at FROM users WHERE login = $1`, login).
+		Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("ошибка поиска пользователя по логину '%s': %w", login, err)
+	}
+	return user, nil
+}
+
+func (p *PostgresStore) GetUserByID(id int64) (*User, error) {
+	user := &User{}
+	err := p.db.QueryRow(`SELECT id, login, password_hash, is_admin, created_at FROM users WHERE id = $1`, id).
+		Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("ошибка поиска пользователя ID %d: %w", id, err)
+	}
+	return user, nil
+}
+
+func (p *PostgresStore) SetUserAdmin(userID int64, admin bool) error {
+	res, err := p.db.Exec(`UPDATE users SET is_admin = $1 WHERE id = $2`, admin, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка изменения прав пользователя ID %d: %w", userID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) ListUsers() ([]User, error) {
+	rows, err := p.db.Query(`SELECT id, login, password_hash, is_admin, created_at FROM users ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования пользователя: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (p *PostgresStore) GetUserSettings(userID int64) (UserSettings, error) {
+	settings := DefaultUserSettings(userID)
+	err := p.db.QueryRow(`SELECT use_result_cache FROM user_settings WHERE user_id = $1`, userID).Scan(&settings.UseResultCache)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, fmt.Errorf("ошибка получения настроек пользователя ID %d: %w", userID, err)
+	}
+	return settings, nil
+}
+
+func (p *PostgresStore) SaveUserSettings(settings UserSettings) error {
+	_, err := p.db.Exec(`INSERT INTO user_settings (user_id, use_result_cache, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (user_id) DO UPDATE SET use_result_cache = excluded.use_result_cache, updated_at = excluded.updated_at`,
+		settings.UserID, settings.UseResultCache)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения настроек пользователя ID %d: %w", settings.UserID, err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) GetUserQuota(userID int64) (*UserQuota, error) {
+	q := &UserQuota{UserID: userID}
+	err := p.db.QueryRow(`SELECT submissions_per_minute, max_active_expressions, max_ast_nodes, max_expression_length, updated_at
+		FROM user_quotas WHERE user_id = $1`, userID).
+		Scan(&q.SubmissionsPerMinute, &q.MaxActiveExpressions, &q.MaxASTNodes, &q.MaxExpressionLength, &q.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения лимитов пользователя ID %d: %w", userID, err)
+	}
+	return q, nil
+}
+
+func (p *PostgresStore) SaveUserQuota(q UserQuota) error {
+	_, err := p.db.Exec(`INSERT INTO user_quotas (user_id, submissions_per_minute, max_active_expressions, max_ast_nodes, max_expression_length, updated_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT (user_id) DO UPDATE SET
+			submissions_per_minute = excluded.submissions_per_minute,
+			max_active_expressions = excluded.max_active_expressions,
+			max_ast_nodes = excluded.max_ast_nodes,
+			max_expression_length = excluded.max_expression_length,
+			updated_at = excluded.updated_at`,
+		q.UserID, q.SubmissionsPerMinute, q.MaxActiveExpressions, q.MaxASTNodes, q.MaxExpressionLength)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения лимитов пользователя ID %d: %w", q.UserID, err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) DeleteUserQuota(userID int64) (bool, error) {
+	res, err := p.db.Exec(`DELETE FROM user_quotas WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления лимитов пользователя ID %d: %w", userID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+func (p *PostgresStore) CountActiveExpressions(userID int64) (int, error) {
+	var n int
+	err := p.db.QueryRow(`SELECT COUNT(*) FROM expressions WHERE user_id = $1 AND status IN ($2, $3)`,
+		userID, StatusPending, StatusInProgress).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчёта активных выражений пользователя ID %d: %w", userID, err)
+	}
+	return n, nil
+}
+
+func (p *PostgresStore) CreateExpression(userID int64, expression string) (int64, error) {
+	return p.CreateExpressionWithPriority(userID, expression, PriorityInteractive)
+}
+
+func (p *PostgresStore) CreateExpressionWithPriority(userID int64, expression, priority string) (int64, error) {
+	if !IsValidPriority(priority) {
+		return 0, fmt.Errorf("неизвестный приоритет выражения: %q", priority)
+	}
+	var id int64
+	err := p.db.QueryRow(`INSERT INTO expressions (user_id, expression, priority, status) VALUES ($1, $2, $3, $4) RETURNING id`,
+		userID, expression, priority, StatusPending).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания выражения: %w", err)
+	}
+
+	log.Printf("Создано выражение ID %d для пользователя ID %d: %s", id, userID, expression)
+	return id, nil
+}
+
+const postgresExpressionColumns = `id, user_id, expression, priority, status, result, steps, created_at, updated_at`
+
+func scanExpression(row interface{ Scan(...any) error }, expr *Expression) error {
+	return row.Scan(
+		&expr.ID, &expr.UserID, &expr.Expression, &expr.Priority, &expr.Status,
+		&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
+	)
+}
+
+func (p *PostgresStore) GetExpressionByID(id, userID int64) (*Expression, error) {
+	expr := &Expression{}
+	err := scanExpression(p.db.QueryRow(`SELECT `+postgresExpressionColumns+` FROM expressions WHERE id = $1 AND user_id = $2`, id, userID), expr)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrExpressionNotFound
+		}
+		return nil, fmt.Errorf("ошибка получения выражения ID %d: %w", id, err)
+	}
+	return expr, nil
+}
+
+func (p *PostgresStore) GetExpressionByIDInternal(id int64) (*Expression, error) {
+	expr := &Expression{}
+	err := scanExpression(p.db.QueryRow(`SELECT `+postgresExpressionColumns+` FROM expressions WHERE id = $1`, id), expr)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrExpressionNotFound
+		}
+		return nil, fmt.Errorf("ошибка получения выражения ID %d (внутр.): %w", id, err)
+	}
+	return expr, nil
+}
+
+func (p *PostgresStore) GetExpressionsByUserID(userID int64) ([]Expression, error) {
+	rows, err := p.db.Query(`SELECT `+postgresExpressionColumns+` FROM expressions WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка выражений для пользователя ID %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var expressions []Expression
+	for rows.Next() {
+		var expr Expression
+		if err := scanExpression(rows, &expr); err != nil {
+			log.Printf("Ошибка сканирования строки выражения: %v", err)
+			continue
+		}
+		expressions = append(expressions, expr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по списку выражений: %w", err)
+	}
+	return expressions, nil
+}
+
+func (p *PostgresStore) UpdateExpressionStatusResult(id int64, status string, result sql.NullFloat64, stepsJSON sql.NullString) error {
+	_, err := p.db.Exec(`UPDATE expressions SET status = $1, result = $2, steps = $3, updated_at = now()
+		WHERE id = $4 AND status != $5`, status, result, stepsJSON, id, StatusCancelled)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
+	}
+	log.Printf("Обновлен статус/результат выражения ID %d: Статус=%s", id, status)
+	return nil
+}
+
+func (p *PostgresStore) CancelExpression(id, userID int64) (bool, error) {
+	cancelled := false
+	err := p.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE expressions SET status = $1, updated_at = now()
+			WHERE id = $2 AND user_id = $3 AND status IN ($4, $5)`,
+			StatusCancelled, id, userID, StatusPending, StatusInProgress)
+		if err != nil {
+			return fmt.Errorf("ошибка отмены выражения ID %d: %w", id, err)
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return nil
+		}
+		// Задачу, которую в этот момент выдаёт другой оркестратор, UPDATE дождётся
+		// и пропустит: после выдачи она уже не в статусе pending.
+		if _, err := tx.Exec(`UPDATE tasks SET status = $1, updated_at = now() WHERE expression_id = $2 AND status = $3`,
+			StatusCancelled, id, StatusPending); err != nil {
+			return fmt.Errorf("ошибка отмены задач выражения ID %d: %w", id, err)
+		}
+		cancelled = true
+		return nil
+	})
+	if err != nil || !cancelled {
+		return false, err
+	}
+
+	log.Printf("Выражение ID %d отменено пользователем ID %d", id, userID)
+	return true, nil
+}
+
+func (p *PostgresStore) CreateTask(expressionID int64, operation string, arg1, arg2 float64) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(`INSERT INTO tasks (expression_id, operation, arg1, arg2, status) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		expressionID, operation, arg1, arg2, StatusPending).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания задачи для выражения ID %d: %w", expressionID, err)
+	}
+
+	log.Printf("Создана задача ID %d для выражения ID %d: %f %s %f", id, expressionID, arg1, operation, arg2)
+	return id, nil
+}
+
+func (p *PostgresStore) CreateCompletedTask(expressionID int64, operation string, arg1, arg2, result float64, settledBy string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(`INSERT INTO tasks (expression_id, operation, arg1, arg2, result, status, settled_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		expressionID, operation, arg1, arg2, result, StatusDone, settledBy).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания выполненной задачи для выражения ID %d: %w", expressionID, err)
+	}
+
+	log.Printf("Задача ID %d выражения ID %d (%f %s %f) выполнена без агента (%s): %f", id, expressionID, arg1, operation, arg2, settledBy, result)
+	return id, nil
+}
+
+// GetAndLeasePendingTask выдаёт задачу по той же справедливой очереди, что и Store.
+// Задачи, которые в этот момент выдают другие оркестраторы, пропускаются (SKIP LOCKED).
+// Метка потока и виртуальное время только растут, поэтому одновременные выдачи
+// из одного потока не откатывают друг друга.
+//
+// Виртуальное время общее для всех выдач, поэтому транзакция выдачи его только
+// читает, а сдвигает отдельный запрос после фиксации: иначе все выдачи всех
+// оркестраторов ждали бы блокировку одной строки до конца чужой транзакции.
+// Параллельная выдача может прочитать чуть отставшие часы: это сдвигает лишь
+// начальную метку нового потока, и не дальше меток одновременно выдаваемых задач.
+func (p *PostgresStore) GetAndLeasePendingTask(agentID string) (*Task, error) {
+	var task *Task
+	var start float64
+	err := p.inTx(func(tx *sql.Tx) error {
+		var virtualTime float64
+		err := tx.QueryRow(`SELECT virtual_time FROM fair_queue_clock WHERE id = 1`).Scan(&virtualTime)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("ошибка чтения виртуального времени очереди: %w", err)
+		}
+
+		// Все задачи потока имеют одну метку начала, поэтому первая строка — самая
+		// старая задача потока с наименьшей меткой, как в Store.GetAndLeasePendingTask.
+		t := &Task{}
+		var flow fairFlow
+		err = tx.QueryRow(`SELECT t.id, t.expression_id, t.operation, t.arg1, t.arg2, t.status, t.retries, t.created_at, t.updated_at,
+				e.user_id, e.priority, GREATEST(COALESCE(f.finish_tag, 0), $2::double precision) AS start_tag
+			FROM tasks t
+			JOIN expressions e ON e.id = t.expression_id
+			LEFT JOIN fair_queue_flows f ON f.user_id = e.user_id AND f.priority = e.priority
+			WHERE t.status = $1
+			ORDER BY start_tag, t.id
+			LIMIT 1
+			FOR UPDATE OF t SKIP LOCKED`, StatusPending, virtualTime).Scan(
+			&t.ID, &t.ExpressionID, &t.Operation, &t.Arg1, &t.Arg2, &t.Status, &t.Retries, &t.CreatedAt, &t.UpdatedAt,
+			&flow.userID, &flow.priority, &flow.start,
+		)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка поиска ожидающей задачи: %w", err)
+		}
+
+		leaseToken, err := newLeaseToken()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE tasks SET status = $1, lease_token = $2, updated_at = now() WHERE id = $3`,
+			StatusInProgress, leaseToken, t.ID); err != nil {
+			return fmt.Errorf("ошибка обновления статуса задачи ID %d: %w", t.ID, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO task_attempts (task_id, lease_token, agent_id, outcome) VALUES ($1, $2, $3, $4)`,
+			t.ID, leaseToken, agentID, AttemptLeased); err != nil {
+			return fmt.Errorf("ошибка записи попытки задачи ID %d: %w", t.ID, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO fair_queue_flows (user_id, priority, finish_tag) VALUES ($1, $2, $3::double precision + $4::double precision)
+			ON CONFLICT (user_id, priority) DO UPDATE
+			SET finish_tag = GREATEST(fair_queue_flows.finish_tag, $3::double precision) + $4::double precision`,
+			flow.userID, flow.priority, flow.start, 1/priorityWeight(flow.priority))
+		if err != nil {
+			return fmt.Errorf("ошибка обновления очереди пользователя ID %d: %w", flow.userID, err)
+		}
+
+		t.Status = StatusInProgress
+		t.LeaseToken = leaseToken
+		task, start = t, flow.start
+		return nil
+	})
+	if err != nil || task == nil {
+		return nil, err
+	}
+
+	// Строка блокируется только на время этого UPDATE и только если часы отстают.
+	// Задача уже выдана, поэтому ошибка лишь откладывает сдвиг часов до следующей выдачи.
+	if _, err := p.db.Exec(`UPDATE fair_queue_clock SET virtual_time = $1 WHERE id = 1 AND virtual_time < $1`, start); err != nil {
+		log.Printf("Ошибка обновления виртуального времени очереди: %v", err)
+	}
+
+	log.Printf("Задача ID %d выдана агенту '%s' (Expression ID: %d)", task.ID, agentID, task.ExpressionID)
+	return task, nil
+}
+
+func (p *PostgresStore) CompleteTask(taskID int64, leaseToken, agentID string, result float64) (bool, error) {
+	query := `UPDATE tasks SET status = $1, result = $2, settled_lease_token = $3, settled_by = $4, updated_at = now() WHERE id = $5`
+	attempt := attemptOutcome{outcome: AttemptDone, result: sql.NullFloat64{Float64: result, Valid: true}}
+	duplicate, err := p.settleTask(taskID, leaseToken, attempt, query, StatusDone, result, leaseToken, agentID, taskID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+	if duplicate {
+		log.Printf("Повторный результат задачи ID %d по той же аренде проигнорирован", taskID)
+		return true, nil
+	}
+
+	log.Printf("Задача ID %d завершена агентом '%s' с результатом: %f", taskID, agentID, result)
+	return false, nil
+}
+
+func (p *PostgresStore) FailTask(taskID int64, leaseToken, agentID, message string) (bool, error) {
+	query := `UPDATE tasks SET status = $1, retries = retries + 1, settled_lease_token = $2, settled_by = $3, updated_at = now() WHERE id = $4`
+	attempt := attemptOutcome{outcome: AttemptFailed, message: sql.NullString{String: message, Valid: true}}
+	duplicate, err := p.settleTask(taskID, leaseToken, attempt, query, StatusPending, leaseToken, agentID, taskID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отметки задачи ID %d как ошибочной: %w", taskID, err)
+	}
+	if duplicate {
+		log.Printf("Повторная ошибка задачи ID %d по той же аренде проигнорирована", taskID)
+		return true, nil
+	}
+
+	log.Printf("Ошибка выполнения задачи ID %d агентом '%s', возвращена в очередь.", taskID, agentID)
+	return false, nil
+}
+
+func (p *PostgresStore) ReleaseTask(taskID int64, leaseToken, agentID string) (bool, error) {
+	query := `UPDATE tasks SET status = $1, lease_token = NULL, settled_lease_token = $2, settled_by = $3, updated_at = now() WHERE id = $4`
+	duplicate, err := p.settleTask(taskID, leaseToken, attemptOutcome{outcome: AttemptReleased}, query, StatusPending, leaseToken, agentID, taskID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка возврата задачи ID %d в очередь: %w", taskID, err)
+	}
+	if !duplicate {
+		log.Printf("Задача ID %d возвращена в очередь агентом '%s'", taskID, agentID)
+	}
+	return duplicate, nil
+}
+
+// settleTask блокирует строку задачи, проверяет аренду, применяет update и записывает исход попытки.
+func (p *PostgresStore) settleTask(taskID int64, leaseToken string, attempt attemptOutcome, update string, args ...interface{}) (bool, error) {
+	duplicate := false
+	err := p.inTx(func(tx *sql.Tx) error {
+		var status string
+		var currentToken, settledToken sql.NullString
+		err := tx.QueryRow(`SELECT status, lease_token, settled_lease_token FROM tasks WHERE id = $1 FOR UPDATE`, taskID).
+			Scan(&status, &currentToken, &settledToken)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrTaskNotFound
+			}
+			return err
+		}
+
+		if leaseToken != "" && settledToken.Valid && settledToken.String == leaseToken {
+			duplicate = true
+			return nil
+		}
+		if leaseToken == "" || status != StatusInProgress || !currentToken.Valid || currentToken.String != leaseToken {
+			// Повтор по более ранней, уже закрытой аренде узнаётся по журналу попыток.
+			var outcome string
+			err := tx.QueryRow(`SELECT outcome FROM task_attempts WHERE task_id = $1 AND lease_token = $2`, taskID, leaseToken).Scan(&outcome)
+			if err != nil && err != sql.ErrNoRows {
+				return fmt.Errorf("ошибка чтения попытки задачи: %w", err)
+			}
+			if err == nil && outcome != AttemptLeased {
+				duplicate = true
+				return nil
+			}
+			return ErrLeaseLost
+		}
+		if _, err := tx.Exec(update, args...); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE task_attempts SET outcome = $1, result = $2, error_message = $3, submitted_at = now()
+			WHERE lease_token = $4`, attempt.outcome, attempt.result, attempt.message, leaseToken)
+		if err != nil {
+			return fmt.Errorf("ошибка записи исхода попытки: %w", err)
+		}
+		return nil
+	})
+	return duplicate, err
+}
+
+func (p *PostgresStore) ListTaskAttempts(expressionID int64) ([]TaskAttempt, error) {
+	rows, err := p.db.Query(`SELECT a.id, a.task_id, a.agent_id, a.leased_at, a.submitted_at, a.outcome, a.error_message, a.result
+		FROM task_attempts a JOIN tasks t ON t.id = a.task_id
+		WHERE t.expression_id = $1 ORDER BY a.id`, expressionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения попыток задач выражения ID %d: %w", expressionID, err)
+	}
+	defer rows.Close()
+
+	var attempts []TaskAttempt
+	for rows.Next() {
+		var a TaskAttempt
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.AgentID, &a.LeasedAt, &a.SubmittedAt, &a.Outcome, &a.ErrorMessage, &a.Result); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования попытки задачи: %w", err)
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+const postgresTaskColumns = `id, expression_id, operation, arg1, arg2, result, status, retries, settled_by, created_at, updated_at`
+
+func scanTask(row interface{ Scan(...any) error }, task *Task) error {
+	return row.Scan(
+		&task.ID, &task.ExpressionID, &task.Operation, &task.Arg1, &task.Arg2,
+		&task.Result, &task.Status, &task.Retries, &task.SettledBy, &task.CreatedAt, &task.UpdatedAt,
+	)
+}
+
+func (p *PostgresStore) GetTaskByID(taskID int64) (*Task, error) {
+	task := &Task{}
+	if err := scanTask(p.db.QueryRow(`SELECT `+postgresTaskColumns+` FROM tasks WHERE id = $1`, taskID), task); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("ошибка получения задачи ID %d: %w", taskID, err)
+	}
+	return task, nil
+}
+
+func (p *PostgresStore) HasPendingTasks(expressionID int64) (bool, error) {
+	var exists bool
+	err := p.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM tasks WHERE expression_id = $1 AND status IN ($2, $3))`,
+		expressionID, StatusPending, StatusInProgress).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки незавершенных задач для выражения ID %d: %w", expressionID, err)
+	}
+	return exists, nil
+}
+
+func (p *PostgresStore) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
+	rows, err := p.db.Query(`SELECT `+postgresTaskColumns+` FROM tasks WHERE expression_id = $1 ORDER BY id`, expressionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса задач для выражения ID %d: %w", expressionID, err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		var task Task
+		if err := scanTask(rows, &task); err != nil {
+			log.Printf("Ошибка сканирования строки задачи при GetAllTasksForExpression: %v", err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка после итерации по строкам задач: %w", err)
+	}
+	return tasks, nil
+}
+
+func (p *PostgresStore) CreateAgentToken(name, tokenHash string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(`INSERT INTO agent_tokens (name, token_hash) VALUES ($1, $2) RETURNING id`, name, tokenHash).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания токена агента '%s': %w", name, err)
+	}
+
+	log.Printf("Выпущен токен агента ID %d для '%s'", id, name)
+	return id, nil
+}
+
+func (p *PostgresStore) GetAgentTokenByHash(tokenHash string) (*AgentToken, error) {
+	token := &AgentToken{}
+	err := p.db.QueryRow(`SELECT id, name, token_hash, created_at, revoked_at FROM agent_tokens WHERE token_hash = $1`, tokenHash).
+		Scan(&token.ID, &token.Name, &token.TokenHash, &token.CreatedAt, &token.RevokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAgentTokenNotFound
+		}
+		return nil, fmt.Errorf("ошибка поиска токена агента: %w", err)
+	}
+	return token, nil
+}
+
+func (p *PostgresStore) ListAgentTokens() ([]AgentToken, error) {
+	rows, err := p.db.Query(`SELECT id, name, token_hash, created_at, revoked_at FROM agent_tokens ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка токенов агентов: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []AgentToken
+	for rows.Next() {
+		var token AgentToken
+		if err := rows.Scan(&token.ID, &token.Name, &token.TokenHash, &token.CreatedAt, &token.RevokedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования токена агента: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (p *PostgresStore) RevokeAgentToken(id int64) (bool, error) {
+	res, err := p.db.Exec(`UPDATE agent_tokens SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отзыва токена агента ID %d: %w", id, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected > 0 {
+		log.Printf("Токен агента ID %d отозван", id)
+	}
+	return rowsAffected > 0, nil
+}
+
+func (p *PostgresStore) ListOperationTimes() ([]OperationTime, error) {
+	rows, err := p.db.Query(`SELECT operation, time_ms, updated_by, updated_at FROM operation_times ORDER BY operation COLLATE "C"`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения времени операций: %w", err)
+	}
+	defer rows.Close()
+
+	var times []OperationTime
+	for rows.Next() {
+		var t OperationTime
+		if err := rows.Scan(&t.Operation, &t.TimeMs, &t.UpdatedBy, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования времени операции: %w", err)
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
+
+func (p *PostgresStore) SetOperationTime(operation string, timeMs int, userID int64) error {
+	return p.changeOperationTime(operation, sql.NullInt64{Int64: int64(timeMs), Valid: true}, userID)
+}
+
+func (p *PostgresStore) ResetOperationTime(operation string, userID int64) (bool, error) {
+	err := p.changeOperationTime(operation, sql.NullInt64{}, userID)
+	if err == errNoOperationTime {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *PostgresStore) changeOperationTime(operation string, timeMs sql.NullInt64, userID int64) error {
+	var old sql.NullInt64
+	err := p.inTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT time_ms FROM operation_times WHERE operation = $1 FOR UPDATE`, operation).Scan(&old)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("ошибка чтения времени операции '%s': %w", operation, err)
+		}
+
+		if timeMs.Valid {
+			_, err = tx.Exec(`INSERT INTO operation_times (operation, time_ms, updated_by, updated_at) VALUES ($1, $2, $3, now())
+				ON CONFLICT (operation) DO UPDATE SET time_ms = excluded.time_ms, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+				operation, timeMs.Int64, userID)
+		} else {
+			if !old.Valid {
+				return errNoOperationTime
+			}
+			_, err = tx.Exec(`DELETE FROM operation_times WHERE operation = $1`, operation)
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка изменения времени операции '%s': %w", operation, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO operation_time_changes (operation, old_time_ms, new_time_ms, changed_by) VALUES ($1, $2, $3, $4)`,
+			operation, old, timeMs, userID)
+		if err != nil {
+			return fmt.Errorf("ошибка записи в журнал изменения времени операции '%s': %w", operation, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Время операции '%s' изменено пользователем ID %d: %v -> %v", operation, userID, nullInt(old), nullInt(timeMs))
+	return nil
+}
+
+func (p *PostgresStore) ListOperationTimeChanges(limit int) ([]OperationTimeChange, error) {
+	// LIMIT NULL в PostgreSQL означает отсутствие ограничения, как отрицательный LIMIT в SQLite.
+	rows, err := p.db.Query(`SELECT c.id, c.operation, c.old_time_ms, c.new_time_ms, c.changed_by, COALESCE(u.login, ''), c.changed_at
+		FROM operation_time_changes c LEFT JOIN users u ON u.id = c.changed_by
+		ORDER BY c.id DESC LIMIT $1`, sql.NullInt64{Int64: int64(limit), Valid: limit >= 0})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения журнала изменений времени операций: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []OperationTimeChange
+	for rows.Next() {
+		var c OperationTimeChange
+		if err := rows.Scan(&c.ID, &c.Operation, &c.OldTimeMs, &c.NewTimeMs, &c.ChangedBy, &c.ChangedByLogin, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования записи журнала: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// PublishExpressionChanged оповещает все оркестраторы, подписанные через
+// ListenExpressionChanges, об изменении выражения.
+func (p *PostgresStore) PublishExpressionChanged(exprID int64) error {
+	if _, err := p.db.Exec(`SELECT pg_notify($1, $2)`, expressionEventsChannel, strconv.FormatInt(exprID, 10)); err != nil {
+		return fmt.Errorf("ошибка отправки события выражения ID %d: %w", exprID, err)
+	}
+	return nil
+}
+
+// ListenExpressionChanges вызывает handle для каждого изменения выражения, о котором
+// сообщил любой оркестратор, пока не отменён ctx. Отдельное соединение LISTEN
+// переподключается само; события за время переподключения теряются.
+func (p *PostgresStore) ListenExpressionChanges(ctx context.Context, handle func(exprID int64)) error {
+	listener := pq.NewListener(p.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Ошибка соединения LISTEN PostgreSQL: %v", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(expressionEventsChannel); err != nil {
+		return fmt.Errorf("ошибка подписки на события выражений: %w", err)
+	}
+
+	keepalive := time.NewTicker(90 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				continue // Соединение восстановлено
+			}
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				log.Printf("Некорректное событие выражения '%s': %v", n.Extra, err)
+				continue
+			}
+			handle(id)
+		case <-keepalive.C:
+			go listener.Ping()
+		}
+	}
+}
+
+// postgresFinishedExpressionIDs выбирает завершённые выражения, чьи задачи не выданы агентам.
+const postgresFinishedExpressionIDs = `SELECT e.id FROM expressions e
+	WHERE e.status IN ($1, $2, $3) AND NOT EXISTS (
+		SELECT 1 FROM tasks t WHERE t.expression_id = e.id AND t.status = $4)`
+
+func (p *PostgresStore) PurgeTasks(before time.Time, limit int) (int64, error) {
+	res, err := p.db.Exec(`DELETE FROM tasks WHERE id IN (
+		SELECT t.id FROM tasks t JOIN expressions e ON e.id = t.expression_id
+		WHERE e.status IN ($1, $2, $3) AND t.status <> $4 AND t.updated_at < $5
+		ORDER BY t.id LIMIT $6)`,
+		StatusDone, StatusError, StatusCancelled, StatusInProgress, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления старых задач: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (p *PostgresStore) PurgeExpressions(status string, before time.Time, limit int) (int64, int64, error) {
+	if !IsFinalStatus(status) {
+		return 0, 0, fmt.Errorf("удалять можно только завершённые выражения, а не %q", status)
+	}
+	return p.deleteExpressions(postgresFinishedExpressionIDs+` AND e.status = $5 AND e.updated_at < $6 ORDER BY e.id LIMIT $7`,
+		status, before, limit)
+}
+
+func (p *PostgresStore) DeleteUserHistory(userID int64) (int64, int64, error) {
+	expressions, tasks, err := deleteInBatches(func(limit int) (int64, int64, error) {
+		return p.deleteExpressions(postgresFinishedExpressionIDs+` AND e.user_id = $5 ORDER BY e.id LIMIT $6`, userID, limit)
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка очистки истории пользователя ID %d: %w", userID, err)
+	}
+	log.Printf("История пользователя ID %d очищена: удалено выражений %d, задач %d", userID, expressions, tasks)
+	return expressions, tasks, nil
+}
+
+// deleteExpressions в одной транзакции удаляет выбранные выражения и их задачи.
+// Выбранные строки блокируются, чтобы другой оркестратор не выдал их задачи в это время.
+func (p *PostgresStore) deleteExpressions(selectIDs string, args ...interface{}) (expressions, tasks int64, err error) {
+	args = append([]interface{}{StatusDone, StatusError, StatusCancelled, StatusInProgress}, args...)
+	err = p.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(selectIDs+` FOR UPDATE OF e`, args...)
+		if err != nil {
+			return fmt.Errorf("ошибка выбора выражений для удаления: %w", err)
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+
+		res, err := tx.Exec(`DELETE FROM tasks WHERE expression_id = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("ошибка удаления задач: %w", err)
+		}
+		tasks, _ = res.RowsAffected()
+		res, err = tx.Exec(`DELETE FROM expressions WHERE id = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("ошибка удаления выражений: %w", err)
+		}
+		expressions, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return expressions, tasks, nil
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Интеграционные тесты PostgreSQL работают с сервером из POSTGRES_TEST_DSN или
+// запускают временный кластер, если в PATH есть initdb и pg_ctl. Иначе они пропускаются.
+
+var (
+	pgOnce    sync.Once
+	pgDSN     string
+	pgSkip    string
+	pgStop    func()
+	pgSchemas int64
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if pgStop != nil {
+		pgStop()
+	}
+	os.Exit(code)
+}
+
+// startLocalPostgres запускает временный кластер PostgreSQL и возвращает строку подключения к нему.
+func startLocalPostgres() (string, func(), error) {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return "", nil, fmt.Errorf("POSTGRES_TEST_DSN не задан, initdb не найден")
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return "", nil, fmt.Errorf("POSTGRES_TEST_DSN не задан, pg_ctl не найден")
+	}
+	if os.Geteuid() == 0 {
+		return "", nil, fmt.Errorf("initdb нельзя запускать от root, задайте POSTGRES_TEST_DSN")
+	}
+
+	dir, err := os.MkdirTemp("", "calculator-pg-")
+	if err != nil {
+		return "", nil, err
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", opts, "-w", "-l", filepath.Join(dir, "log"), "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	stop := func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("host=127.0.0.1 port=%d user=postgres dbname=postgres sslmode=disable", port), stop, nil
+}
+
+// withSearchPath добавляет к строке подключения схему по умолчанию.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// postgresTestDSN возвращает строку подключения к пустой схеме, удаляемой после теста.
+func postgresTestDSN(t *testing.T) string {
+	t.Helper()
+	pgOnce.Do(func() {
+		if pgDSN = os.Getenv("POSTGRES_TEST_DSN"); pgDSN != "" {
+			return
+		}
+		var err error
+		if pgDSN, pgStop, err = startLocalPostgres(); err != nil {
+			pgSkip = err.Error()
+		}
+	})
+	if pgSkip != "" {
+		t.Skipf("skip PostgreSQL tests: %s", pgSkip)
+	}
+
+	admin, err := sql.Open("postgres", pgDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("calculator_test_%d_%d", os.Getpid(), atomic.AddInt64(&pgSchemas, 1))
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("CREATE SCHEMA: %v", err)
+	}
+	t.Cleanup(func() {
+		if admin, err := sql.Open("postgres", pgDSN); err == nil {
+			admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			admin.Close()
+		}
+	})
+	return withSearchPath(pgDSN, schema)
+}
+
+func newTestPostgresStore(t *testing.T, dsn string) *PostgresStore {
+	t.Helper()
+	store, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatalf("NewPostgresStore error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestPostgresStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage {
+		return newTestPostgresStore(t, postgresTestDSN(t))
+	})
+}
+
+// TestPostgresConcurrentLeasing имитирует несколько оркестраторов с общей очередью:
+// каждая задача выдаётся ровно один раз.
+func TestPostgresConcurrentLeasing(t *testing.T) {
+	dsn := postgresTestDSN(t)
+	const orchestrators, tasks = 4, 200
+
+	stores := make([]*PostgresStore, orchestrators)
+	for i := range stores {
+		stores[i] = newTestPostgresStore(t, dsn)
+	}
+	// Оркестраторы, запущенные одновременно, применяют миграции ровно один раз.
+	initErrs := make(chan error, orchestrators)
+	for _, store := range stores {
+		go func(store *PostgresStore) { initErrs <- store.InitDB() }(store)
+	}
+	for range stores {
+		if err := <-initErrs; err != nil {
+			t.Fatalf("InitDB error: %v", err)
+		}
+	}
+	uid, _ := stores[0].CreateUser("alice", "h")
+	exprID, _ := stores[0].CreateExpression(uid, "1+1")
+	for i := 0; i < tasks; i++ {
+		if _, err := stores[0].CreateTask(exprID, "+", 1, 1); err != nil {
+			t.Fatalf("CreateTask error: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	leased := make(map[int64]int)
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(store *PostgresStore) {
+				defer wg.Done()
+				for {
+					task, err := store.GetAndLeasePendingTask("a1")
+					if err != nil {
+						t.Errorf("GetAndLeasePendingTask error: %v", err)
+						return
+					}
+					if task == nil {
+						return
+					}
+					mu.Lock()
+					leased[task.ID]++
+					mu.Unlock()
+					if _, err := store.CompleteTask(task.ID, task.LeaseToken, "agent", 2); err != nil {
+						t.Errorf("CompleteTask error: %v", err)
+						return
+					}
+				}
+			}(store)
+		}
+	}
+	wg.Wait()
+
+	if len(leased) != tasks {
+		t.Fatalf("leased %d distinct tasks, want %d", len(leased), tasks)
+	}
+	for id, n := range leased {
+		if n != 1 {
+			t.Fatalf("task %d leased %d times", id, n)
+		}
+	}
+}
+
+func TestPostgresExpressionEvents(t *testing.T) {
+	dsn := postgresTestDSN(t)
+	listener, publisher := newTestPostgresStore(t, dsn), newTestPostgresStore(t, dsn)
+
+	received := make(chan int64, 1)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() {
+		done <- listener.ListenExpressionChanges(ctx, func(exprID int64) {
+			select {
+			case received <- exprID:
+			default:
+			}
+		})
+	}()
+
+	// LISTEN начинает действовать не сразу: повторяем, пока событие не дойдёт.
+	deadline := time.After(10 * time.Second)
+	for got := false; !got; {
+		if err := publisher.PublishExpressionChanged(42); err != nil {
+			t.Fatalf("PublishExpressionChanged error: %v", err)
+		}
+		select {
+		case id := <-received:
+			if id != 42 {
+				t.Fatalf("received event for expression %d, want 42", id)
+			}
+			got = true
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("expression event was not delivered")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("ListenExpressionChanges error: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// minVacuumFreePages — меньше этого числа свободных страниц VACUUM не запускается:
+// перестройка файла блокирует запись, и ради нескольких страниц она не окупается.
+const minVacuumFreePages = 256
+
+// sqliteTime приводит время к формату CURRENT_TIMESTAMP, чтобы сравнивать его со столбцами дат.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
+
+// finishedExpressionIDs выбирает завершённые выражения, чьи задачи не выданы агентам.
+const finishedExpressionIDs = `SELECT e.id FROM expressions e
+	WHERE e.status IN (?, ?, ?) AND NOT EXISTS (
+		SELECT 1 FROM tasks t WHERE t.expression_id = e.id AND t.status = ?)`
+
+func (s *Store) PurgeTasks(before time.Time, limit int) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM tasks WHERE id IN (
+		SELECT t.id FROM tasks t JOIN expressions e ON e.id = t.expression_id
+		WHERE e.status IN (?, ?, ?) AND t.status != ? AND t.updated_at < ?
+		ORDER BY t.id LIMIT ?)`,
+		StatusDone, StatusError, StatusCancelled, StatusInProgress, sqliteTime(before), limit)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления старых задач: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) PurgeExpressions(status string, before time.Time, limit int) (int64, int64, error) {
+	if !IsFinalStatus(status) {
+		return 0, 0, fmt.Errorf("удалять можно только завершённые выражения, а не %q", status)
+	}
+	return s.deleteExpressions(finishedExpressionIDs+` AND e.status = ? AND e.updated_at < ? ORDER BY e.id LIMIT ?`,
+		status, sqliteTime(before), limit)
+}
+
+// historyDeleteBatch — сколько выражений удалять в одной транзакции при очистке
+// истории пользователя: длинная транзакция держала бы запись БД занятой.
+const historyDeleteBatch = 500
+
+// DeleteUserHistory удаляет завершённые выражения пользователя. Отменённые выражения,
+// задачи которых ещё у агентов, остаются до следующей очистки.
+func (s *Store) DeleteUserHistory(userID int64) (int64, int64, error) {
+	expressions, tasks, err := deleteInBatches(func(limit int) (int64, int64, error) {
+		return s.deleteExpressions(finishedExpressionIDs+` AND e.user_id = ? ORDER BY e.id LIMIT ?`, userID, limit)
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка очистки истории пользователя ID %d: %w", userID, err)
+	}
+	log.Printf("История пользователя ID %d очищена: удалено выражений %d, задач %d", userID, expressions, tasks)
+	return expressions, tasks, nil
+}
+
+// deleteInBatches повторяет удаление пакетами по historyDeleteBatch выражений, пока
+// пакет не окажется неполным, и возвращает суммарное число удалённых строк. Пакеты,
+// удалённые до ошибки, не откатываются и учитываются в результате.
+func deleteInBatches(batch func(limit int) (int64, int64, error)) (expressions, tasks int64, err error) {
+	for {
+		e, t, err := batch(historyDeleteBatch)
+		expressions += e
+		tasks += t
+		if err != nil || e < historyDeleteBatch {
+			return expressions, tasks, err
+		}
+	}
+}
+
+// deleteExpressions в одной транзакции удаляет выражения, выбранные запросом
+// finishedExpressionIDs с дополнительными условиями, и их задачи.
+func (s *Store) deleteExpressions(selectIDs string, args ...interface{}) (expressions, tasks int64, err error) {
+	args = append([]interface{}{StatusDone, StatusError, StatusCancelled, StatusInProgress}, args...)
+	err = s.inTx(func(tx *sql.Tx) error {
+		// Задачи удаляются первыми: выражения, на которые они ссылаются, выбираются тем же запросом.
+		res, err := tx.Exec(`DELETE FROM tasks WHERE expression_id IN (`+selectIDs+`)`, args...)
+		if err != nil {
+			return fmt.Errorf("ошибка удаления задач: %w", err)
+		}
+		tasks, _ = res.RowsAffected()
+		res, err = tx.Exec(`DELETE FROM expressions WHERE id IN (`+selectIDs+`)`, args...)
+		if err != nil {
+			return fmt.Errorf("ошибка удаления выражений: %w", err)
+		}
+		expressions, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return expressions, tasks, nil
+}
+
+// Compact переносит WAL в файл БД и укорачивает его, а если после удаления строк
+// свободна больше четверти страниц — перестраивает файл командой VACUUM.
+func (s *Store) Compact() (bool, error) {
+	if s.path == ":memory:" {
+		return false, nil
+	}
+
+	var pages, free int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return false, fmt.Errorf("ошибка чтения размера БД: %w", err)
+	}
+	if err := s.db.QueryRow(`PRAGMA freelist_count`).Scan(&free); err != nil {
+		return false, fmt.Errorf("ошибка чтения числа свободных страниц БД: %w", err)
+	}
+	vacuumed := false
+	if free >= minVacuumFreePages && free*4 > pages {
+		if _, err := s.db.Exec(`VACUUM`); err != nil {
+			return false, fmt.Errorf("ошибка VACUUM: %w", err)
+		}
+		vacuumed = true
+		log.Printf("БД перестроена (VACUUM): освобождено страниц %d из %d", free, pages)
+	}
+
+	// Читатели, начавшие чтение раньше, не дают укоротить WAL: busy=1 означает,
+	// что контрольная точка будет повторена при следующем обслуживании.
+	var busy, logFrames, checkpointed int
+	if err := s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return vacuumed, fmt.Errorf("ошибка контрольной точки WAL: %w", err)
+	}
+	if busy != 0 {
+		log.Printf("Контрольная точка WAL выполнена не полностью: перенесено кадров %d из %d", checkpointed, logFrames)
+	}
+	return vacuumed, nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UserStore хранит пользователей, их настройки и лимиты.
+type UserStore interface {
+	// CreateUser возвращает ошибку, если логин уже занят.
+	CreateUser(login, passwordHash string) (int64, error)
+	// GetUserByLogin и GetUserByID возвращают ErrUserNotFound, если пользователя нет.
+	GetUserByLogin(login string) (*User, error)
+	GetUserByID(id int64) (*User, error)
+	ListUsers() ([]User, error)
+	// SetUserAdmin выдаёт или отзывает права администратора; ErrUserNotFound, если пользователя нет.
+	SetUserAdmin(userID int64, admin bool) error
+
+	GetUserSettings(userID int64) (UserSettings, error)
+	SaveUserSettings(settings UserSettings) error
+
+	GetUserQuota(userID int64) (*UserQuota, error)
+	SaveUserQuota(q UserQuota) error
+	DeleteUserQuota(userID int64) (bool, error)
+}
+
+// ExpressionStore хранит выражения пользователей.
+type ExpressionStore interface {
+	CreateExpression(userID int64, expression string) (int64, error)
+	CreateExpressionWithPriority(userID int64, expression, priority string) (int64, error)
+	// GetExpressionByID ищет выражение среди выражений пользователя, GetExpressionByIDInternal — среди всех.
+	// Оба возвращают ErrExpressionNotFound, если выражения нет (или оно чужое).
+	GetExpressionByID(id, userID int64) (*Expression, error)
+	GetExpressionByIDInternal(id int64) (*Expression, error)
+	// GetExpressionsByUserID возвращает выражения пользователя, новые первыми.
+	GetExpressionsByUserID(userID int64) ([]Expression, error)
+	// UpdateExpressionStatusResult не меняет отменённое выражение.
+	UpdateExpressionStatusResult(id int64, status string, result sql.NullFloat64, stepsJSON sql.NullString) error
+	CancelExpression(id, userID int64) (bool, error)
+	CountActiveExpressions(userID int64) (int, error)
+}
+
+// TaskQueue хранит задачи выражений и выдаёт их агентам в аренду.
+type TaskQueue interface {
+	CreateTask(expressionID int64, operation string, arg1, arg2 float64) (int64, error)
+	CreateCompletedTask(expressionID int64, operation string, arg1, arg2, result float64, settledBy string) (int64, error)
+	// GetAndLeasePendingTask выдаёт задачу агенту agentID, открывая новую попытку.
+	// Возвращает nil без ошибки, если ожидающих задач нет.
+	GetAndLeasePendingTask(agentID string) (*Task, error)
+	// CompleteTask, FailTask и ReleaseTask закрывают попытку аренды leaseToken. Они возвращают
+	// ErrTaskNotFound и ErrLeaseLost, а duplicate=true — если результат по этой аренде уже принят.
+	CompleteTask(taskID int64, leaseToken, agentID string, result float64) (bool, error)
+	FailTask(taskID int64, leaseToken, agentID, message string) (bool, error)
+	ReleaseTask(taskID int64, leaseToken, agentID string) (bool, error)
+	// GetTaskByID возвращает ErrTaskNotFound, если задачи нет.
+	GetTaskByID(taskID int64) (*Task, error)
+	HasPendingTasks(expressionID int64) (bool, error)
+	GetAllTasksForExpression(expressionID int64) ([]Task, error)
+	// ListTaskAttempts возвращает попытки всех задач выражения по порядку выдачи.
+	ListTaskAttempts(expressionID int64) ([]TaskAttempt, error)
+}
+
+// AgentTokenStore хранит токены агентов.
+type AgentTokenStore interface {
+	CreateAgentToken(name, tokenHash string) (int64, error)
+	// GetAgentTokenByHash возвращает ErrAgentTokenNotFound, если токена нет.
+	GetAgentTokenByHash(tokenHash string) (*AgentToken, error)
+	ListAgentTokens() ([]AgentToken, error)
+	RevokeAgentToken(id int64) (bool, error)
+}
+
+// OperationTimeStore хранит время операций, заданное администратором, и журнал его изменений.
+type OperationTimeStore interface {
+	ListOperationTimes() ([]OperationTime, error)
+	SetOperationTime(operation string, timeMs int, userID int64) error
+	ResetOperationTime(operation string, userID int64) (bool, error)
+	ListOperationTimeChanges(limit int) ([]OperationTimeChange, error)
+}
+
+// RetentionStore удаляет старую историю вычислений. Незавершённые выражения и задачи,
+// выданные агентам, не удаляются.
+type RetentionStore interface {
+	// PurgeTasks удаляет до limit задач завершённых выражений, изменённых раньше before.
+	PurgeTasks(before time.Time, limit int) (int64, error)
+	// PurgeExpressions удаляет до limit выражений в конечном статусе status, завершённых
+	// раньше before, вместе с их задачами.
+	PurgeExpressions(status string, before time.Time, limit int) (expressions, tasks int64, err error)
+	// DeleteUserHistory удаляет все завершённые выражения пользователя вместе с задачами.
+	DeleteUserHistory(userID int64) (expressions, tasks int64, err error)
+}
+
+// Compactor реализуют хранилища, которым после удаления строк нужно обслуживание
+// файлов БД (SQLite: контрольная точка WAL и VACUUM).
+type Compactor interface {
+	// Compact возвращает true, если файл БД был перестроен.
+	Compact() (bool, error)
+}
+
+// Backuper реализуют хранилища, умеющие сохранять согласованную копию БД в файл,
+// не останавливая работу оркестратора (SQLite: VACUUM INTO). Для PostgreSQL
+// резервные копии делаются средствами сервера (pg_dump).
+type Backuper interface {
+	Backup(dest string) error
+}
+
+// Storage — хранилище оркестратора целиком. Реализации: Store (SQLite), PostgresStore
+// и MemoryStore (в памяти); одинаковое поведение проверяет общий набор тестов.
+type Storage interface {
+	UserStore
+	ExpressionStore
+	TaskQueue
+	AgentTokenStore
+	OperationTimeStore
+	RetentionStore
+
+	// InitDB готовит хранилище к работе, Ping проверяет его доступность.
+	InitDB() error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// ExpressionEventBus реализуют хранилища, которые могут разделять несколько
+// оркестраторов: изменение выражения, сделанное одним из них, видят подписчики всех.
+type ExpressionEventBus interface {
+	PublishExpressionChanged(exprID int64) error
+	// ListenExpressionChanges блокируется до отмены ctx.
+	ListenExpressionChanges(ctx context.Context, handle func(exprID int64)) error
+}
+
+var (
+	_ Storage            = (*Store)(nil)
+	_ Storage            = (*MemoryStore)(nil)
+	_ Storage            = (*PostgresStore)(nil)
+	_ ExpressionEventBus = (*PostgresStore)(nil)
+	_ Compactor          = (*Store)(nil)
+	_ Backuper           = (*Store)(nil)
+)
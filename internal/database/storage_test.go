@@ -0,0 +1,601 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Оба хранилища обязаны проходить один и тот же набор тестов.
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage {
+		store, err := NewStore(":memory:")
+		if err != nil {
+			if strings.Contains(err.Error(), "requires cgo") {
+				t.Skipf("skip DB tests: %v", err)
+			}
+			t.Fatalf("NewStore error: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage { return NewMemoryStore() })
+}
+
+func TestMemoryStoreAttemptClock(t *testing.T) {
+	m := NewMemoryStore()
+	leasedAt := time.Date(2024, 5, 1, 12, 0, 0, 250_400_000, time.UTC)
+	now := leasedAt
+	m.clock = func() time.Time { return now }
+
+	uid, _ := m.CreateUser("alice", "h")
+	exprID, _ := m.CreateExpression(uid, "2*3")
+	tid, _ := m.CreateTask(exprID, "*", 2, 3)
+	task, _ := m.GetAndLeasePendingTask("agent")
+	now = leasedAt.Add(1500 * time.Millisecond)
+	m.CompleteTask(tid, task.LeaseToken, "agent", 6)
+
+	attempts, _ := m.ListTaskAttempts(exprID)
+	if len(attempts) != 1 {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+	a := attempts[0]
+	if !a.LeasedAt.Equal(leasedAt.Truncate(time.Millisecond)) || !a.SubmittedAt.Time.Equal(now.Truncate(time.Millisecond)) {
+		t.Fatalf("attempt times = %v, %v; want the store clock with millisecond precision", a.LeasedAt, a.SubmittedAt.Time)
+	}
+}
+
+func testStorageConformance(t *testing.T, newStorage func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Storage)
+	}{
+		{"Users", conformUsers},
+		{"SettingsAndQuotas", conformSettingsAndQuotas},
+		{"Expressions", conformExpressions},
+		{"Cancel", conformCancel},
+		{"TaskLeases", conformTaskLeases},
+		{"FairQueue", conformFairQueue},
+		{"TaskAttempts", conformTaskAttempts},
+		{"AgentTokens", conformAgentTokens},
+		{"OperationTimes", conformOperationTimes},
+		{"Retention", conformRetention},
+		{"Close", conformClose},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStorage(t)
+			if err := s.InitDB(); err != nil {
+				t.Fatalf("InitDB error: %v", err)
+			}
+			if err := s.Ping(t.Context()); err != nil {
+				t.Fatalf("Ping error: %v", err)
+			}
+			tc.fn(t, s)
+		})
+	}
+}
+
+func conformUsers(t *testing.T, s Storage) {
+	id, err := s.CreateUser("alice", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser error: %v", err)
+	}
+	if _, err := s.CreateUser("alice", "other"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("duplicate login: err=%v, want ErrUserExists", err)
+	}
+	bob, _ := s.CreateUser("bob", "hash2")
+
+	if u, err := s.GetUserByLogin("alice"); err != nil || u == nil || u.ID != id || u.PasswordHash != "hash" || u.CreatedAt.IsZero() {
+		t.Fatalf("GetUserByLogin = %+v, %v", u, err)
+	}
+	if u, err := s.GetUserByID(bob); err != nil || u == nil || u.Login != "bob" {
+		t.Fatalf("GetUserByID = %+v, %v", u, err)
+	}
+	if u, err := s.GetUserByLogin("nobody"); !errors.Is(err, ErrUserNotFound) || !errors.Is(err, ErrNotFound) || u != nil {
+		t.Fatalf("GetUserByLogin of unknown user = %+v, %v; want nil, ErrUserNotFound", u, err)
+	}
+	if u, err := s.GetUserByID(9999); !errors.Is(err, ErrUserNotFound) || u != nil {
+		t.Fatalf("GetUserByID of unknown user = %+v, %v; want nil, ErrUserNotFound", u, err)
+	}
+
+	// Новый пользователь не администратор; права меняются только SetUserAdmin.
+	if u, _ := s.GetUserByID(bob); u.IsAdmin {
+		t.Fatal("new user is an admin")
+	}
+	if err := s.SetUserAdmin(bob, true); err != nil {
+		t.Fatalf("SetUserAdmin error: %v", err)
+	}
+	if u, _ := s.GetUserByLogin("bob"); !u.IsAdmin {
+		t.Fatal("SetUserAdmin(true) was not stored")
+	}
+	if err := s.SetUserAdmin(9999, true); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("SetUserAdmin of unknown user = %v, want ErrUserNotFound", err)
+	}
+	users, err := s.ListUsers()
+	if err != nil || len(users) != 2 || users[0].ID != id || users[1].ID != bob || users[0].IsAdmin || !users[1].IsAdmin {
+		t.Fatalf("ListUsers = %+v, %v", users, err)
+	}
+	if err := s.SetUserAdmin(bob, false); err != nil {
+		t.Fatalf("SetUserAdmin(false) error: %v", err)
+	}
+	if u, _ := s.GetUserByID(bob); u.IsAdmin {
+		t.Fatal("SetUserAdmin(false) was not stored")
+	}
+}
+
+func conformSettingsAndQuotas(t *testing.T, s Storage) {
+	uid, _ := s.CreateUser("alice", "hash")
+
+	if settings, err := s.GetUserSettings(uid); err != nil || settings != DefaultUserSettings(uid) {
+		t.Fatalf("GetUserSettings default = %+v, %v", settings, err)
+	}
+	if err := s.SaveUserSettings(UserSettings{UserID: uid, UseResultCache: false}); err != nil {
+		t.Fatalf("SaveUserSettings error: %v", err)
+	}
+	if settings, _ := s.GetUserSettings(uid); settings.UseResultCache {
+		t.Fatal("saved settings were not returned")
+	}
+	if err := s.SaveUserSettings(UserSettings{UserID: 9999}); err == nil {
+		t.Fatal("settings of unknown user must be rejected")
+	}
+
+	if q, err := s.GetUserQuota(uid); err != nil || q != nil {
+		t.Fatalf("GetUserQuota without override = %+v, %v", q, err)
+	}
+	quota := UserQuota{UserID: uid, MaxASTNodes: sql.NullInt64{Int64: 10, Valid: true}}
+	if err := s.SaveUserQuota(quota); err != nil {
+		t.Fatalf("SaveUserQuota error: %v", err)
+	}
+	q, err := s.GetUserQuota(uid)
+	if err != nil || q == nil || q.MaxASTNodes != quota.MaxASTNodes || q.SubmissionsPerMinute.Valid || q.UpdatedAt.IsZero() {
+		t.Fatalf("GetUserQuota = %+v, %v", q, err)
+	}
+	if ok, err := s.DeleteUserQuota(uid); err != nil || !ok {
+		t.Fatalf("DeleteUserQuota = %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteUserQuota(uid); ok {
+		t.Fatal("repeated DeleteUserQuota must report false")
+	}
+}
+
+func conformExpressions(t *testing.T, s Storage) {
+	alice, _ := s.CreateUser("alice", "h")
+	bob, _ := s.CreateUser("bob", "h")
+
+	if _, err := s.CreateExpressionWithPriority(alice, "1+1", "urgent"); err == nil {
+		t.Fatal("unknown priority must be rejected")
+	}
+	if _, err := s.CreateExpression(9999, "1+1"); err == nil {
+		t.Fatal("expression of unknown user must be rejected")
+	}
+
+	first, _ := s.CreateExpression(alice, "1+1")
+	second, _ := s.CreateExpressionWithPriority(alice, "2*2", PriorityBatch)
+	s.CreateExpression(bob, "3-3")
+
+	expr, err := s.GetExpressionByID(second, alice)
+	if err != nil || expr == nil || expr.Expression != "2*2" || expr.Priority != PriorityBatch || expr.Status != StatusPending || expr.Result.Valid {
+		t.Fatalf("GetExpressionByID = %+v, %v", expr, err)
+	}
+	if expr, err := s.GetExpressionByID(second, bob); !errors.Is(err, ErrExpressionNotFound) || expr != nil {
+		t.Fatalf("GetExpressionByID of another user's expression = %+v, %v; want nil, ErrExpressionNotFound", expr, err)
+	}
+	if expr, err := s.GetExpressionByIDInternal(second); err != nil || expr == nil || expr.UserID != alice {
+		t.Fatalf("GetExpressionByIDInternal = %+v, %v", expr, err)
+	}
+	if expr, err := s.GetExpressionByIDInternal(9999); !errors.Is(err, ErrExpressionNotFound) || expr != nil {
+		t.Fatalf("GetExpressionByIDInternal of unknown expression = %+v, %v", expr, err)
+	}
+
+	list, err := s.GetExpressionsByUserID(alice)
+	if err != nil || len(list) != 2 || list[0].ID != second || list[1].ID != first {
+		t.Fatalf("GetExpressionsByUserID = %+v, %v; want newest first", list, err)
+	}
+	if n, _ := s.CountActiveExpressions(alice); n != 2 {
+		t.Fatalf("CountActiveExpressions = %d, want 2", n)
+	}
+
+	err = s.UpdateExpressionStatusResult(first, StatusDone, sql.NullFloat64{Float64: 2, Valid: true}, sql.NullString{String: "[]", Valid: true})
+	if err != nil {
+		t.Fatalf("UpdateExpressionStatusResult error: %v", err)
+	}
+	expr, _ = s.GetExpressionByID(first, alice)
+	if expr.Status != StatusDone || expr.Result.Float64 != 2 || expr.Steps.String != "[]" {
+		t.Fatalf("updated expression = %+v", expr)
+	}
+	if n, _ := s.CountActiveExpressions(alice); n != 1 {
+		t.Fatalf("CountActiveExpressions after completion = %d, want 1", n)
+	}
+}
+
+func conformCancel(t *testing.T, s Storage) {
+	alice, _ := s.CreateUser("alice", "h")
+	bob, _ := s.CreateUser("bob", "h")
+	exprID, _ := s.CreateExpression(alice, "1+2+3")
+	leasedID, _ := s.CreateTask(exprID, "+", 1, 2)
+	pendingID, _ := s.CreateTask(exprID, "+", 3, 3)
+	leased, _ := s.GetAndLeasePendingTask("a1")
+	if leased == nil || leased.ID != leasedID {
+		t.Fatalf("leased %+v, want task %d", leased, leasedID)
+	}
+
+	if ok, err := s.CancelExpression(exprID, bob); err != nil || ok {
+		t.Fatalf("CancelExpression by another user = %v, %v; want false", ok, err)
+	}
+	if ok, err := s.CancelExpression(exprID, alice); err != nil || !ok {
+		t.Fatalf("CancelExpression = %v, %v", ok, err)
+	}
+	if ok, _ := s.CancelExpression(exprID, alice); ok {
+		t.Fatal("repeated CancelExpression must report false")
+	}
+
+	if task, _ := s.GetTaskByID(pendingID); task.Status != StatusCancelled {
+		t.Fatalf("pending task after cancel: %s, want cancelled", task.Status)
+	}
+	if task, _ := s.GetTaskByID(leasedID); task.Status != StatusInProgress {
+		t.Fatalf("leased task after cancel: %s, want in_progress", task.Status)
+	}
+	if task, _ := s.GetAndLeasePendingTask("a1"); task != nil {
+		t.Fatalf("cancelled task was leased: %+v", task)
+	}
+
+	// Запоздавшее обновление не возвращает отменённое выражение к жизни.
+	s.UpdateExpressionStatusResult(exprID, StatusDone, sql.NullFloat64{Float64: 6, Valid: true}, sql.NullString{})
+	if expr, _ := s.GetExpressionByID(exprID, alice); expr.Status != StatusCancelled {
+		t.Fatalf("cancelled expression status = %s", expr.Status)
+	}
+}
+
+func conformTaskAttempts(t *testing.T, s Storage) {
+	uid, _ := s.CreateUser("alice", "h")
+	exprID, _ := s.CreateExpression(uid, "2*3")
+	tid, _ := s.CreateTask(exprID, "*", 2, 3)
+	other, _ := s.CreateExpression(uid, "1+1")
+	s.CreateCompletedTask(other, "+", 1, 1, 2, "cache")
+
+	first, _ := s.GetAndLeasePendingTask("agent-a")
+	s.FailTask(tid, first.LeaseToken, "agent-a", "переполнение")
+	second, _ := s.GetAndLeasePendingTask("agent-b")
+	s.ReleaseTask(tid, second.LeaseToken, "agent-b")
+	third, _ := s.GetAndLeasePendingTask("agent-c")
+	if attempts, _ := s.ListTaskAttempts(exprID); len(attempts) != 3 || attempts[2].Outcome != AttemptLeased || attempts[2].SubmittedAt.Valid {
+		t.Fatalf("attempt in progress: %+v", attempts)
+	}
+	s.CompleteTask(tid, third.LeaseToken, "agent-c", 6)
+	s.CompleteTask(tid, third.LeaseToken, "agent-c", 7) // Повтор не меняет журнал
+
+	attempts, err := s.ListTaskAttempts(exprID)
+	if err != nil || len(attempts) != 3 {
+		t.Fatalf("ListTaskAttempts = %+v, %v; want 3 attempts", attempts, err)
+	}
+	want := []struct{ agent, outcome string }{
+		{"agent-a", AttemptFailed}, {"agent-b", AttemptReleased}, {"agent-c", AttemptDone},
+	}
+	for i, a := range attempts {
+		if a.TaskID != tid || a.AgentID != want[i].agent || a.Outcome != want[i].outcome {
+			t.Fatalf("attempt %d = %+v, want %s by %s", i, a, want[i].outcome, want[i].agent)
+		}
+		if !a.SubmittedAt.Valid || a.SubmittedAt.Time.Before(a.LeasedAt) {
+			t.Fatalf("attempt %d times: leased %v, submitted %v", i, a.LeasedAt, a.SubmittedAt)
+		}
+	}
+	if attempts[0].ErrorMessage.String != "переполнение" || attempts[0].Result.Valid {
+		t.Fatalf("failed attempt = %+v", attempts[0])
+	}
+	if !attempts[2].Result.Valid || attempts[2].Result.Float64 != 6 || attempts[2].ErrorMessage.Valid {
+		t.Fatalf("done attempt = %+v", attempts[2])
+	}
+	if attempts, _ := s.ListTaskAttempts(other); len(attempts) != 0 {
+		t.Fatalf("task settled without an agent has attempts: %+v", attempts)
+	}
+
+	// Попытки удаляются вместе с задачей.
+	s.UpdateExpressionStatusResult(exprID, StatusDone, sql.NullFloat64{Float64: 6, Valid: true}, sql.NullString{})
+	s.PurgeTasks(time.Now().Add(time.Hour), 100)
+	if attempts, _ := s.ListTaskAttempts(exprID); len(attempts) != 0 {
+		t.Fatalf("attempts left after purging tasks: %+v", attempts)
+	}
+}
+
+func conformRetention(t *testing.T, s Storage) {
+	alice, _ := s.CreateUser("alice", "h")
+	bob, _ := s.CreateUser("bob", "h")
+	finish := func(exprID int64, status string) {
+		s.UpdateExpressionStatusResult(exprID, status, sql.NullFloat64{}, sql.NullString{})
+	}
+
+	// Отменённое выражение, задача которого ещё у агента.
+	cancelled, _ := s.CreateExpression(alice, "1+1")
+	s.CreateTask(cancelled, "+", 1, 1)
+	leased, _ := s.GetAndLeasePendingTask("a1")
+	s.CancelExpression(cancelled, alice)
+
+	done, _ := s.CreateExpression(alice, "2+2")
+	s.CreateCompletedTask(done, "+", 2, 2, 4, "cache")
+	s.CreateCompletedTask(done, "*", 4, 1, 4, "cache")
+	finish(done, StatusDone)
+	failed, _ := s.CreateExpression(alice, "1/0")
+	s.CreateTask(failed, "/", 1, 0)
+	finish(failed, StatusError)
+	active, _ := s.CreateExpression(alice, "3+3")
+	activeTask, _ := s.CreateTask(active, "+", 3, 3)
+	bobs, _ := s.CreateExpression(bob, "4+4")
+	s.CreateCompletedTask(bobs, "+", 4, 4, 8, "cache")
+	finish(bobs, StatusDone)
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if _, _, err := s.PurgeExpressions(StatusPending, future, 10); err == nil {
+		t.Fatal("PurgeExpressions must reject unfinished status")
+	}
+	if e, n, err := s.PurgeExpressions(StatusDone, past, 10); err != nil || e != 0 || n != 0 {
+		t.Fatalf("PurgeExpressions of fresh expressions = %d, %d, %v; want nothing", e, n, err)
+	}
+	if e, n, err := s.PurgeExpressions(StatusError, future, 10); err != nil || e != 1 || n != 1 {
+		t.Fatalf("PurgeExpressions(error) = %d, %d, %v; want 1 expression, 1 task", e, n, err)
+	}
+	if expr, _ := s.GetExpressionByID(failed, alice); expr != nil {
+		t.Fatalf("purged expression still exists: %+v", expr)
+	}
+
+	if n, err := s.PurgeTasks(past, 100); err != nil || n != 0 {
+		t.Fatalf("PurgeTasks of fresh tasks = %d, %v", n, err)
+	}
+	if n, err := s.PurgeTasks(future, 1); err != nil || n != 1 {
+		t.Fatalf("PurgeTasks with limit 1 = %d, %v", n, err)
+	}
+	if n, err := s.PurgeTasks(future, 100); err != nil || n != 2 {
+		t.Fatalf("PurgeTasks = %d, %v; want the 2 remaining tasks of finished expressions", n, err)
+	}
+	if task, _ := s.GetTaskByID(activeTask); task == nil {
+		t.Fatal("task of an active expression was purged")
+	}
+	if task, _ := s.GetTaskByID(leased.ID); task == nil {
+		t.Fatal("leased task was purged")
+	}
+	if expr, _ := s.GetExpressionByID(done, alice); expr == nil || expr.Status != StatusDone {
+		t.Fatalf("PurgeTasks must keep expressions: %+v", expr)
+	}
+
+	if e, n, err := s.DeleteUserHistory(alice); err != nil || e != 1 || n != 0 {
+		t.Fatalf("DeleteUserHistory = %d, %d, %v; want only the done expression", e, n, err)
+	}
+	s.CompleteTask(leased.ID, leased.LeaseToken, "agent", 2)
+	if e, n, err := s.DeleteUserHistory(alice); err != nil || e != 1 || n != 1 {
+		t.Fatalf("DeleteUserHistory after the agent finished = %d, %d, %v", e, n, err)
+	}
+	if list, _ := s.GetExpressionsByUserID(alice); len(list) != 1 || list[0].ID != active {
+		t.Fatalf("expressions left after clearing history: %+v", list)
+	}
+	if list, _ := s.GetExpressionsByUserID(bob); len(list) != 1 {
+		t.Fatalf("another user's history was touched: %+v", list)
+	}
+}
+
+func conformTaskLeases(t *testing.T, s Storage) {
+	uid, _ := s.CreateUser("alice", "h")
+	exprID, _ := s.CreateExpression(uid, "2*3")
+	if _, err := s.CreateTask(9999, "+", 1, 1); err == nil {
+		t.Fatal("task of unknown expression must be rejected")
+	}
+	if task, err := s.GetAndLeasePendingTask("a1"); err != nil || task != nil {
+		t.Fatalf("GetAndLeasePendingTask on empty queue = %+v, %v", task, err)
+	}
+
+	cachedID, err := s.CreateCompletedTask(exprID, "+", 1, 1, 2, "cache")
+	if err != nil {
+		t.Fatalf("CreateCompletedTask error: %v", err)
+	}
+	if task, _ := s.GetTaskByID(cachedID); task.Status != StatusDone || task.Result.Float64 != 2 || task.SettledBy.String != "cache" {
+		t.Fatalf("completed task = %+v", task)
+	}
+	if has, _ := s.HasPendingTasks(exprID); has {
+		t.Fatal("HasPendingTasks with only completed tasks must be false")
+	}
+
+	tid, _ := s.CreateTask(exprID, "*", 2, 3)
+	if has, _ := s.HasPendingTasks(exprID); !has {
+		t.Fatal("HasPendingTasks must be true")
+	}
+	first, err := s.GetAndLeasePendingTask("a1")
+	if err != nil || first == nil || first.ID != tid || first.Status != StatusInProgress || first.LeaseToken == "" {
+		t.Fatalf("GetAndLeasePendingTask = %+v, %v", first, err)
+	}
+	if first.Operation != "*" || first.Arg1 != 2 || first.Arg2 != 3 || first.ExpressionID != exprID {
+		t.Fatalf("leased task fields = %+v", first)
+	}
+	if has, _ := s.HasPendingTasks(exprID); !has {
+		t.Fatal("HasPendingTasks must count leased tasks")
+	}
+
+	if dup, err := s.FailTask(tid, first.LeaseToken, "a1", "boom"); err != nil || dup {
+		t.Fatalf("FailTask = %v, %v", dup, err)
+	}
+	if dup, err := s.FailTask(tid, first.LeaseToken, "a1", "boom"); err != nil || !dup {
+		t.Fatalf("repeated FailTask = %v, %v; want duplicate", dup, err)
+	}
+	if task, _ := s.GetTaskByID(tid); task.Status != StatusPending || task.Retries != 1 {
+		t.Fatalf("failed task = %+v", task)
+	}
+
+	second, _ := s.GetAndLeasePendingTask("a1")
+	if second == nil || second.LeaseToken == first.LeaseToken || second.Result.Valid || second.SettledBy.Valid {
+		t.Fatalf("second lease = %+v", second)
+	}
+	if dup, err := s.ReleaseTask(tid, second.LeaseToken, "a1"); err != nil || dup {
+		t.Fatalf("ReleaseTask = %v, %v", dup, err)
+	}
+	if task, _ := s.GetTaskByID(tid); task.Status != StatusPending || task.Retries != 1 {
+		t.Fatalf("released task = %+v, want pending without extra retry", task)
+	}
+
+	third, _ := s.GetAndLeasePendingTask("a1")
+	if dup, err := s.CompleteTask(tid, second.LeaseToken, "a1", 1); err != nil || !dup {
+		t.Fatalf("CompleteTask with a released lease = %v, %v; want duplicate", dup, err)
+	}
+	if _, err := s.CompleteTask(tid, "foreign-lease", "a1", 1); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("CompleteTask with foreign lease: %v, want ErrLeaseLost", err)
+	}
+	if _, err := s.CompleteTask(tid, "", "a1", 1); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("CompleteTask without lease: %v, want ErrLeaseLost", err)
+	}
+	if _, err := s.CompleteTask(9999, "x", "a1", 1); !errors.Is(err, ErrTaskNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("CompleteTask of unknown task: %v, want ErrTaskNotFound", err)
+	}
+	if dup, err := s.CompleteTask(tid, third.LeaseToken, "a2", 6); err != nil || dup {
+		t.Fatalf("CompleteTask = %v, %v", dup, err)
+	}
+	if dup, err := s.CompleteTask(tid, third.LeaseToken, "a2", 42); err != nil || !dup {
+		t.Fatalf("repeated CompleteTask = %v, %v; want duplicate", dup, err)
+	}
+	// Поздний повтор по первой аренде, закрытой до двух следующих, всё ещё повтор, а не чужая аренда.
+	if dup, err := s.FailTask(tid, first.LeaseToken, "a1", "boom"); err != nil || !dup {
+		t.Fatalf("FailTask retried after two more leases = %v, %v; want duplicate", dup, err)
+	}
+
+	task, err := s.GetTaskByID(tid)
+	if err != nil || task.Status != StatusDone || task.Result.Float64 != 6 || task.SettledBy.String != "a2" || task.Retries != 1 {
+		t.Fatalf("completed task = %+v, %v", task, err)
+	}
+	if task, err := s.GetTaskByID(9999); !errors.Is(err, ErrTaskNotFound) || task != nil {
+		t.Fatalf("GetTaskByID of unknown task = %+v, %v", task, err)
+	}
+	tasks, err := s.GetAllTasksForExpression(exprID)
+	if err != nil || len(tasks) != 2 || tasks[0].ID != cachedID || tasks[1].ID != tid {
+		t.Fatalf("GetAllTasksForExpression = %+v, %v", tasks, err)
+	}
+}
+
+func conformFairQueue(t *testing.T, s Storage) {
+	heavy, _ := s.CreateUser("heavy", "h")
+	light, _ := s.CreateUser("light", "h")
+	owner := make(map[int64]int64) // ID выражения -> пользователь; отрицательный — интерактивное выражение heavy
+	for i := 0; i < 20; i++ {
+		exprID, _ := s.CreateExpressionWithPriority(heavy, "1+1", PriorityBatch)
+		owner[exprID] = heavy
+		s.CreateTask(exprID, "+", 1, 1)
+	}
+	lease := func() int64 {
+		t.Helper()
+		task, err := s.GetAndLeasePendingTask("a1")
+		if err != nil || task == nil {
+			t.Fatalf("GetAndLeasePendingTask: task=%v err=%v", task, err)
+		}
+		return owner[task.ExpressionID]
+	}
+	for i := 0; i < 5; i++ {
+		lease()
+	}
+
+	exprID, _ := s.CreateExpression(light, "2*3")
+	owner[exprID] = light
+	s.CreateTask(exprID, "*", 2, 3)
+	if got := lease(); got != light {
+		t.Fatalf("expected light user's task right after it was queued, got user %d", got)
+	}
+
+	for i := 0; i < 8; i++ {
+		exprID, _ := s.CreateExpressionWithPriority(heavy, "2+2", PriorityInteractive)
+		owner[exprID] = -exprID
+		s.CreateTask(exprID, "+", 2, 2)
+	}
+	var interactive, batch int
+	for i := 0; i < 10; i++ {
+		if lease() < 0 {
+			interactive++
+		} else {
+			batch++
+		}
+	}
+	if interactive != 8 || batch != 2 {
+		t.Fatalf("interactive/batch leases = %d/%d, want 8/2", interactive, batch)
+	}
+}
+
+func conformAgentTokens(t *testing.T, s Storage) {
+	id, err := s.CreateAgentToken("agent-1", "hash-1")
+	if err != nil {
+		t.Fatalf("CreateAgentToken error: %v", err)
+	}
+	if _, err := s.CreateAgentToken("agent-2", "hash-1"); err == nil {
+		t.Fatal("duplicate token hash must be rejected")
+	}
+	s.CreateAgentToken("agent-2", "hash-2")
+
+	token, err := s.GetAgentTokenByHash("hash-1")
+	if err != nil || token == nil || token.ID != id || token.Name != "agent-1" || token.RevokedAt.Valid {
+		t.Fatalf("GetAgentTokenByHash = %+v, %v", token, err)
+	}
+	if token, err := s.GetAgentTokenByHash("unknown"); !errors.Is(err, ErrAgentTokenNotFound) || token != nil {
+		t.Fatalf("GetAgentTokenByHash of unknown hash = %+v, %v", token, err)
+	}
+
+	if ok, err := s.RevokeAgentToken(id); err != nil || !ok {
+		t.Fatalf("RevokeAgentToken = %v, %v", ok, err)
+	}
+	if ok, _ := s.RevokeAgentToken(id); ok {
+		t.Fatal("repeated RevokeAgentToken must report false")
+	}
+	if token, _ := s.GetAgentTokenByHash("hash-1"); token == nil || !token.RevokedAt.Valid {
+		t.Fatalf("revoked token = %+v", token)
+	}
+	tokens, err := s.ListAgentTokens()
+	if err != nil || len(tokens) != 2 || tokens[0].ID != id || tokens[1].Name != "agent-2" {
+		t.Fatalf("ListAgentTokens = %+v, %v", tokens, err)
+	}
+}
+
+func conformOperationTimes(t *testing.T, s Storage) {
+	admin, _ := s.CreateUser("admin", "h")
+
+	if err := s.SetOperationTime("+", 100, 9999); err == nil {
+		t.Fatal("change by unknown user must be rejected")
+	}
+	if err := s.SetOperationTime("/", 300, admin); err != nil {
+		t.Fatalf("SetOperationTime error: %v", err)
+	}
+	s.SetOperationTime("+", 100, admin)
+	s.SetOperationTime("+", 150, admin)
+
+	times, err := s.ListOperationTimes()
+	if err != nil || len(times) != 2 || times[0].Operation != "+" || times[0].TimeMs != 150 || times[1].Operation != "/" || times[0].UpdatedBy != admin {
+		t.Fatalf("ListOperationTimes = %+v, %v", times, err)
+	}
+
+	if ok, err := s.ResetOperationTime("/", admin); err != nil || !ok {
+		t.Fatalf("ResetOperationTime = %v, %v", ok, err)
+	}
+	if ok, err := s.ResetOperationTime("/", admin); err != nil || ok {
+		t.Fatalf("repeated ResetOperationTime = %v, %v; want false", ok, err)
+	}
+
+	changes, err := s.ListOperationTimeChanges(3)
+	if err != nil || len(changes) != 3 {
+		t.Fatalf("ListOperationTimeChanges = %+v, %v", changes, err)
+	}
+	reset, update := changes[0], changes[1]
+	if reset.Operation != "/" || reset.OldTimeMs.Int64 != 300 || reset.NewTimeMs.Valid || reset.ChangedByLogin != "admin" {
+		t.Fatalf("reset change = %+v", reset)
+	}
+	if update.Operation != "+" || update.OldTimeMs.Int64 != 100 || update.NewTimeMs.Int64 != 150 || update.ChangedBy != admin {
+		t.Fatalf("update change = %+v", update)
+	}
+	if changes[2].OldTimeMs.Valid {
+		t.Fatalf("first change of '+' must have no old value: %+v", changes[2])
+	}
+}
+
+func conformClose(t *testing.T, s Storage) {
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if err := s.Ping(t.Context()); err == nil {
+		t.Fatal("Ping after Close must fail")
+	}
+}
@@ -0,0 +1,39 @@
+-- Схема БД до появления версионных миграций (исходная версия оркестратора) и немного данных.
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login TEXT NOT NULL UNIQUE,
+  password_hash TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS expressions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  expression TEXT NOT NULL,
+  status TEXT NOT NULL,
+  result REAL,
+  steps TEXT,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS tasks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  expression_id INTEGER NOT NULL,
+  operation TEXT NOT NULL,
+  arg1 REAL NOT NULL,
+  arg2 REAL NOT NULL,
+  result REAL,
+  status TEXT NOT NULL,
+  retries INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(expression_id) REFERENCES expressions(id)
+);
+
+INSERT INTO users (id, login, password_hash) VALUES (1, 'alice', 'hash');
+INSERT INTO expressions (id, user_id, expression, status, result, steps) VALUES
+  (1, 1, '2+2', 'done', 4, '["Result: 4.000000"]'),
+  (2, 1, '(1+2)*3', 'in_progress', NULL, NULL);
+INSERT INTO tasks (id, expression_id, operation, arg1, arg2, result, status) VALUES
+  (1, 1, '+', 2, 2, 4, 'done'),
+  (2, 2, '+', 1, 2, NULL, 'pending');
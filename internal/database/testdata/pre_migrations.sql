@@ -0,0 +1,93 @@
+-- Схема, которую создавал InitDB непосредственно перед появлением schema_migrations:
+-- все изменения уже есть, но версия схемы не записана.
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login TEXT NOT NULL UNIQUE,
+  password_hash TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS expressions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  expression TEXT NOT NULL,
+  priority TEXT NOT NULL DEFAULT 'interactive',
+  status TEXT NOT NULL,
+  result REAL,
+  steps TEXT,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS tasks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  expression_id INTEGER NOT NULL,
+  operation TEXT NOT NULL,
+  arg1 REAL NOT NULL,
+  arg2 REAL NOT NULL,
+  result REAL,
+  status TEXT NOT NULL,
+  retries INTEGER NOT NULL DEFAULT 0,
+  lease_token TEXT,
+  settled_lease_token TEXT,
+  settled_by TEXT,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(expression_id) REFERENCES expressions(id)
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status, expression_id);
+CREATE TABLE IF NOT EXISTS fair_queue_flows (
+  user_id INTEGER NOT NULL,
+  priority TEXT NOT NULL,
+  finish_tag REAL NOT NULL,
+  PRIMARY KEY(user_id, priority)
+);
+CREATE TABLE IF NOT EXISTS fair_queue_clock (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  virtual_time REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS agent_tokens (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  revoked_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS user_settings (
+  user_id INTEGER PRIMARY KEY,
+  use_result_cache INTEGER NOT NULL DEFAULT 1,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS user_quotas (
+  user_id INTEGER PRIMARY KEY,
+  submissions_per_minute INTEGER,
+  max_active_expressions INTEGER,
+  max_ast_nodes INTEGER,
+  max_expression_length INTEGER,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS operation_times (
+  operation TEXT PRIMARY KEY,
+  time_ms INTEGER NOT NULL,
+  updated_by INTEGER NOT NULL,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(updated_by) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS operation_time_changes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  operation TEXT NOT NULL,
+  old_time_ms INTEGER,
+  new_time_ms INTEGER,
+  changed_by INTEGER NOT NULL,
+  changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(changed_by) REFERENCES users(id)
+);
+
+INSERT INTO users (id, login, password_hash) VALUES (1, 'alice', 'hash');
+INSERT INTO expressions (id, user_id, expression, status, result, steps) VALUES
+  (1, 1, '2+2', 'done', 4, '["Result: 4.000000"]'),
+  (2, 1, '(1+2)*3', 'in_progress', NULL, NULL);
+INSERT INTO tasks (id, expression_id, operation, arg1, arg2, result, status) VALUES
+  (1, 1, '+', 2, 2, 4, 'done'),
+  (2, 2, '+', 1, 2, NULL, 'pending');
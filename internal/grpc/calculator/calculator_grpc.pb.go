@@ -13,11 +13,13 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	CalculatorAgentService_GetTask_FullMethodName      = "/calculator.CalculatorAgentService/GetTask"
 	CalculatorAgentService_SubmitResult_FullMethodName = "/calculator.CalculatorAgentService/SubmitResult"
+	CalculatorAgentService_ReleaseTask_FullMethodName  = "/calculator.CalculatorAgentService/ReleaseTask"
 )
 
 type CalculatorAgentServiceClient interface {
 	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error)
 	SubmitResult(ctx context.Context, in *SubmitResultRequest, opts ...grpc.CallOption) (*SubmitResultResponse, error)
+	ReleaseTask(ctx context.Context, in *ReleaseTaskRequest, opts ...grpc.CallOption) (*ReleaseTaskResponse, error)
 }
 
 type calculatorAgentServiceClient struct {
@@ -48,9 +50,20 @@ func (c *calculatorAgentServiceClient) SubmitResult(ctx context.Context, in *Sub
 	return out, nil
 }
 
+func (c *calculatorAgentServiceClient) ReleaseTask(ctx context.Context, in *ReleaseTaskRequest, opts ...grpc.CallOption) (*ReleaseTaskResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseTaskResponse)
+	err := c.cc.Invoke(ctx, CalculatorAgentService_ReleaseTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 type CalculatorAgentServiceServer interface {
 	GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error)
 	SubmitResult(context.Context, *SubmitResultRequest) (*SubmitResultResponse, error)
+	ReleaseTask(context.Context, *ReleaseTaskRequest) (*ReleaseTaskResponse, error)
 	mustEmbedUnimplementedCalculatorAgentServiceServer()
 }
 
@@ -62,6 +75,9 @@ func (UnimplementedCalculatorAgentServiceServer) GetTask(context.Context, *GetTa
 func (UnimplementedCalculatorAgentServiceServer) SubmitResult(context.Context, *SubmitResultRequest) (*SubmitResultResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SubmitResult not implemented")
 }
+func (UnimplementedCalculatorAgentServiceServer) ReleaseTask(context.Context, *ReleaseTaskRequest) (*ReleaseTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseTask not implemented")
+}
 func (UnimplementedCalculatorAgentServiceServer) mustEmbedUnimplementedCalculatorAgentServiceServer() {
 }
 func (UnimplementedCalculatorAgentServiceServer) testEmbeddedByValue() {}
@@ -113,6 +129,24 @@ func _CalculatorAgentService_SubmitResult_Handler(srv interface{}, ctx context.C
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CalculatorAgentService_ReleaseTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorAgentServiceServer).ReleaseTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorAgentService_ReleaseTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorAgentServiceServer).ReleaseTask(ctx, req.(*ReleaseTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var CalculatorAgentService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "calculator.CalculatorAgentService",
 	HandlerType: (*CalculatorAgentServiceServer)(nil),
@@ -125,7 +159,246 @@ var CalculatorAgentService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SubmitResult",
 			Handler:    _CalculatorAgentService_SubmitResult_Handler,
 		},
+		{
+			MethodName: "ReleaseTask",
+			Handler:    _CalculatorAgentService_ReleaseTask_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "calculator.proto",
-}
\ No newline at end of file
+}
+
+const (
+	CalculatorService_Submit_FullMethodName = "/calculator.CalculatorService/Submit"
+	CalculatorService_Get_FullMethodName    = "/calculator.CalculatorService/Get"
+	CalculatorService_List_FullMethodName   = "/calculator.CalculatorService/List"
+	CalculatorService_Cancel_FullMethodName = "/calculator.CalculatorService/Cancel"
+	CalculatorService_Watch_FullMethodName  = "/calculator.CalculatorService/Watch"
+)
+
+type CalculatorServiceClient interface {
+	Submit(ctx context.Context, in *SubmitExpressionRequest, opts ...grpc.CallOption) (*Expression, error)
+	Get(ctx context.Context, in *GetExpressionRequest, opts ...grpc.CallOption) (*Expression, error)
+	List(ctx context.Context, in *ListExpressionsRequest, opts ...grpc.CallOption) (*ListExpressionsResponse, error)
+	Cancel(ctx context.Context, in *CancelExpressionRequest, opts ...grpc.CallOption) (*Expression, error)
+	Watch(ctx context.Context, in *WatchExpressionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExpressionUpdate], error)
+}
+
+type calculatorServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCalculatorServiceClient(cc grpc.ClientConnInterface) CalculatorServiceClient {
+	return &calculatorServiceClient{cc}
+}
+
+func (c *calculatorServiceClient) Submit(ctx context.Context, in *SubmitExpressionRequest, opts ...grpc.CallOption) (*Expression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Expression)
+	err := c.cc.Invoke(ctx, CalculatorService_Submit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorServiceClient) Get(ctx context.Context, in *GetExpressionRequest, opts ...grpc.CallOption) (*Expression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Expression)
+	err := c.cc.Invoke(ctx, CalculatorService_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorServiceClient) List(ctx context.Context, in *ListExpressionsRequest, opts ...grpc.CallOption) (*ListExpressionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListExpressionsResponse)
+	err := c.cc.Invoke(ctx, CalculatorService_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorServiceClient) Cancel(ctx context.Context, in *CancelExpressionRequest, opts ...grpc.CallOption) (*Expression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Expression)
+	err := c.cc.Invoke(ctx, CalculatorService_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorServiceClient) Watch(ctx context.Context, in *WatchExpressionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExpressionUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CalculatorService_ServiceDesc.Streams[0], CalculatorService_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchExpressionRequest, ExpressionUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CalculatorService_WatchClient = grpc.ServerStreamingClient[ExpressionUpdate]
+
+type CalculatorServiceServer interface {
+	Submit(context.Context, *SubmitExpressionRequest) (*Expression, error)
+	Get(context.Context, *GetExpressionRequest) (*Expression, error)
+	List(context.Context, *ListExpressionsRequest) (*ListExpressionsResponse, error)
+	Cancel(context.Context, *CancelExpressionRequest) (*Expression, error)
+	Watch(*WatchExpressionRequest, grpc.ServerStreamingServer[ExpressionUpdate]) error
+	mustEmbedUnimplementedCalculatorServiceServer()
+}
+
+type UnimplementedCalculatorServiceServer struct{}
+
+func (UnimplementedCalculatorServiceServer) Submit(context.Context, *SubmitExpressionRequest) (*Expression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedCalculatorServiceServer) Get(context.Context, *GetExpressionRequest) (*Expression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedCalculatorServiceServer) List(context.Context, *ListExpressionsRequest) (*ListExpressionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedCalculatorServiceServer) Cancel(context.Context, *CancelExpressionRequest) (*Expression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedCalculatorServiceServer) Watch(*WatchExpressionRequest, grpc.ServerStreamingServer[ExpressionUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedCalculatorServiceServer) mustEmbedUnimplementedCalculatorServiceServer() {}
+func (UnimplementedCalculatorServiceServer) testEmbeddedByValue()                           {}
+
+type UnsafeCalculatorServiceServer interface {
+	mustEmbedUnimplementedCalculatorServiceServer()
+}
+
+func RegisterCalculatorServiceServer(s grpc.ServiceRegistrar, srv CalculatorServiceServer) {
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CalculatorService_ServiceDesc, srv)
+}
+
+func _CalculatorService_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitExpressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorService_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).Submit(ctx, req.(*SubmitExpressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalculatorService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExpressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorService_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).Get(ctx, req.(*GetExpressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalculatorService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExpressionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorService_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).List(ctx, req.(*ListExpressionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalculatorService_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelExpressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorService_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).Cancel(ctx, req.(*CancelExpressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalculatorService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchExpressionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CalculatorServiceServer).Watch(m, &grpc.GenericServerStream[WatchExpressionRequest, ExpressionUpdate]{ServerStream: stream})
+}
+
+type CalculatorService_WatchServer = grpc.ServerStreamingServer[ExpressionUpdate]
+
+var CalculatorService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calculator.CalculatorService",
+	HandlerType: (*CalculatorServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _CalculatorService_Submit_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _CalculatorService_Get_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _CalculatorService_List_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _CalculatorService_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _CalculatorService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "calculator.proto",
+}
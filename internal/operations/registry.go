@@ -0,0 +1,197 @@
+// Package operations — реестр операций калькулятора. Парсер, планировщик,
+// gRPC-сервер оркестратора и агенты берут из него синтаксис, стоимость и способ
+// вычисления операций, поэтому новая операция добавляется одной регистрацией.
+package operations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Assoc — ассоциативность бинарной операции.
+type Assoc int
+
+const (
+	LeftAssoc  Assoc = iota // a-b-c = (a-b)-c
+	RightAssoc              // a^b^c = a^(b^c)
+)
+
+// Operation описывает операцию выражения.
+type Operation struct {
+	Symbol     string // Обозначение в выражении: "+", "^", "mod"
+	Name       string // Название для логов и API
+	Arity      int    // 1 — префиксная операция, 2 — инфиксная
+	Precedence int    // Чем больше, тем раньше выполняется; для префиксных не используется
+	Assoc      Assoc
+	CostMs     int    // Имитируемое время выполнения по умолчанию
+	TimeEnv    string // Переменная окружения, переопределяющая CostMs; может быть пустой
+	Eval       func(args ...float64) (float64, error)
+
+	// Алгебраические свойства бинарной операции, которыми пользуется оптимизатор
+	// оркестратора. Незаданные свойства просто не применяются.
+	Associative bool     // (a∘b)∘c = a∘(b∘c): длинные цепочки можно балансировать
+	Commutative bool     // a∘b = b∘a: нейтральный и поглощающий элементы действуют и слева
+	Identity    *float64 // Нейтральный элемент справа: x∘e = x
+	Absorbing   *float64 // Поглощающий элемент: x∘z = z для любого конечного x
+}
+
+// Registry — набор операций с уникальными обозначениями. Безопасен для
+// конкурентного использования.
+type Registry struct {
+	mu  sync.RWMutex
+	ops map[string]Operation
+}
+
+func NewRegistry() *Registry {
+	return &Registry{ops: make(map[string]Operation)}
+}
+
+// Register добавляет операцию. Обозначение не может совпадать с уже
+// зарегистрированным, даже если у операций разная арность: в задаче агенту
+// передаётся только обозначение.
+func (r *Registry) Register(op Operation) error {
+	if err := op.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.ops[op.Symbol]; exists {
+		return fmt.Errorf("операция '%s' уже зарегистрирована", op.Symbol)
+	}
+	r.ops[op.Symbol] = op
+	return nil
+}
+
+// MustRegister — Register, паникующий при ошибке; удобен в init().
+func (r *Registry) MustRegister(op Operation) {
+	if err := r.Register(op); err != nil {
+		panic(err)
+	}
+}
+
+func (op Operation) validate() error {
+	if op.Symbol == "" {
+		return errors.New("у операции должно быть обозначение")
+	}
+	for _, c := range op.Symbol {
+		if unicode.IsDigit(c) || unicode.IsSpace(c) || strings.ContainsRune(".()", c) {
+			return fmt.Errorf("обозначение операции '%s' не может содержать цифры, пробелы, точку и скобки", op.Symbol)
+		}
+	}
+	if op.Arity != 1 && op.Arity != 2 {
+		return fmt.Errorf("операция '%s': поддерживается арность 1 или 2, получено %d", op.Symbol, op.Arity)
+	}
+	if op.CostMs < 0 {
+		return fmt.Errorf("операция '%s': отрицательная стоимость %d", op.Symbol, op.CostMs)
+	}
+	if op.Eval == nil {
+		return fmt.Errorf("операция '%s': не задана функция вычисления", op.Symbol)
+	}
+	if op.Arity != 2 && (op.Associative || op.Commutative || op.Identity != nil || op.Absorbing != nil) {
+		return fmt.Errorf("операция '%s': алгебраические свойства задаются только для бинарных операций", op.Symbol)
+	}
+	return nil
+}
+
+// Lookup возвращает операцию по обозначению.
+func (r *Registry) Lookup(symbol string) (Operation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	op, ok := r.ops[symbol]
+	return op, ok
+}
+
+// Match ищет операцию заданной арности, с обозначения которой начинается s.
+// При нескольких совпадениях выбирается самое длинное обозначение.
+func (r *Registry) Match(s string, arity int) (Operation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var best Operation
+	found := false
+	for symbol, op := range r.ops {
+		if op.Arity == arity && strings.HasPrefix(s, symbol) && len(symbol) > len(best.Symbol) {
+			best, found = op, true
+		}
+	}
+	return best, found
+}
+
+// All возвращает операции, упорядоченные по приоритету и обозначению.
+func (r *Registry) All() []Operation {
+	r.mu.RLock()
+	out := make([]Operation, 0, len(r.ops))
+	for _, op := range r.ops {
+		out = append(out, op)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Precedence != out[j].Precedence {
+			return out[i].Precedence < out[j].Precedence
+		}
+		return out[i].Symbol < out[j].Symbol
+	})
+	return out
+}
+
+// Evaluate вычисляет операцию. Лишние аргументы (например, второй аргумент
+// задачи для префиксной операции) игнорируются.
+func (r *Registry) Evaluate(symbol string, args ...float64) (float64, error) {
+	op, ok := r.Lookup(symbol)
+	if !ok {
+		return 0, fmt.Errorf("неизвестная операция: %s", symbol)
+	}
+	if len(args) < op.Arity {
+		return 0, fmt.Errorf("операция '%s': ожидается аргументов: %d, получено %d", symbol, op.Arity, len(args))
+	}
+	return op.Eval(args[:op.Arity]...)
+}
+
+// Default — реестр, которым пользуются оркестратор и агенты. Операции,
+// добавленные в него, нужно регистрировать и в оркестраторе, и в агентах.
+var Default = newDefaultRegistry()
+
+// Register добавляет операцию в реестр Default.
+func Register(op Operation) error {
+	return Default.Register(op)
+}
+
+const defaultCostMs = 1000
+
+// Float возвращает указатель на v; удобен для Operation.Identity и Operation.Absorbing.
+func Float(v float64) *float64 {
+	return &v
+}
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.MustRegister(Operation{
+		Symbol: "+", Name: "addition", Arity: 2, Precedence: 10, CostMs: defaultCostMs, TimeEnv: "TIME_ADDITION_MS",
+		Associative: true, Commutative: true, Identity: Float(0),
+		Eval: func(a ...float64) (float64, error) { return a[0] + a[1], nil },
+	})
+	r.MustRegister(Operation{
+		Symbol: "-", Name: "subtraction", Arity: 2, Precedence: 10, CostMs: defaultCostMs, TimeEnv: "TIME_SUBTRACTION_MS",
+		Identity: Float(0),
+		Eval:     func(a ...float64) (float64, error) { return a[0] - a[1], nil },
+	})
+	r.MustRegister(Operation{
+		Symbol: "*", Name: "multiplication", Arity: 2, Precedence: 20, CostMs: defaultCostMs, TimeEnv: "TIME_MULTIPLICATION_MS",
+		Associative: true, Commutative: true, Identity: Float(1), Absorbing: Float(0),
+		Eval: func(a ...float64) (float64, error) { return a[0] * a[1], nil },
+	})
+	r.MustRegister(Operation{
+		Symbol: "/", Name: "division", Arity: 2, Precedence: 20, CostMs: defaultCostMs, TimeEnv: "TIME_DIVISION_MS",
+		Identity: Float(1),
+		Eval: func(a ...float64) (float64, error) {
+			if a[1] == 0 {
+				return 0, errors.New("деление на ноль")
+			}
+			return a[0] / a[1], nil
+		},
+	})
+	return r
+}
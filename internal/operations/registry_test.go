@@ -0,0 +1,72 @@
+package operations
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDefaultRegistry(t *testing.T) {
+	tests := []struct {
+		op         string
+		arg1, arg2 float64
+		want       float64
+	}{
+		{"+", 2, 3, 5},
+		{"-", 2, 3, -1},
+		{"*", 2, 3, 6},
+		{"/", 3, 2, 1.5},
+	}
+	for _, tc := range tests {
+		got, err := Default.Evaluate(tc.op, tc.arg1, tc.arg2)
+		if err != nil || got != tc.want {
+			t.Errorf("Evaluate(%q, %v, %v) = %v, %v; want %v", tc.op, tc.arg1, tc.arg2, got, err, tc.want)
+		}
+	}
+	if _, err := Default.Evaluate("/", 1, 0); err == nil {
+		t.Error("division by zero must fail")
+	}
+	if _, err := Default.Evaluate("?", 1, 2); err == nil {
+		t.Error("unknown operation must fail")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	pow := Operation{
+		Symbol: "**", Arity: 2, Precedence: 30, Assoc: RightAssoc,
+		Eval: func(a ...float64) (float64, error) { return math.Pow(a[0], a[1]), nil },
+	}
+	mul := Operation{
+		Symbol: "*", Arity: 2, Precedence: 20,
+		Eval: func(a ...float64) (float64, error) { return a[0] * a[1], nil },
+	}
+	if err := r.Register(pow); err != nil {
+		t.Fatalf("Register(**): %v", err)
+	}
+	if err := r.Register(mul); err != nil {
+		t.Fatalf("Register(*): %v", err)
+	}
+	if op, ok := r.Match("**2", 2); !ok || op.Symbol != "**" {
+		t.Errorf("Match must prefer the longest symbol, got %q", op.Symbol)
+	}
+	if op, ok := r.Match("*2", 2); !ok || op.Symbol != "*" {
+		t.Errorf("Match(*2) = %q", op.Symbol)
+	}
+	if _, ok := r.Match("*2", 1); ok {
+		t.Error("Match must respect arity")
+	}
+
+	invalid := []Operation{
+		mul, // Повторная регистрация
+		{Symbol: "", Arity: 2, Eval: mul.Eval},
+		{Symbol: "x1", Arity: 2, Eval: mul.Eval},
+		{Symbol: "#", Arity: 3, Eval: mul.Eval},
+		{Symbol: "#", Arity: 2},
+		{Symbol: "#", Arity: 2, CostMs: -1, Eval: mul.Eval},
+	}
+	for _, op := range invalid {
+		if err := r.Register(op); err == nil {
+			t.Errorf("Register(%+v) must fail", op)
+		}
+	}
+}
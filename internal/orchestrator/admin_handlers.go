@@ -0,0 +1,511 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/operations"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AdminHandlers обслуживает административное API оркестратора.
+// Все обработчики предполагают, что запрос прошёл AuthService.AdminMiddleware.
+type AdminHandlers struct {
+	db        database.Storage
+	scheduler *Scheduler
+	opTimes   *OperationTimes
+	ops       *operations.Registry
+	purger    *Purger
+	backups   *Backups
+	config    *Config
+}
+
+func NewAdminHandlers(db database.Storage, scheduler *Scheduler) *AdminHandlers {
+	h := &AdminHandlers{db: db, scheduler: scheduler, ops: operations.Default}
+	if scheduler != nil {
+		h.opTimes = scheduler.GetOperationTimes()
+	}
+	return h
+}
+
+// SetPurger подключает очистку истории к /api/v1/admin/retention.
+func (h *AdminHandlers) SetPurger(p *Purger) {
+	h.purger = p
+}
+
+// SetBackups подключает резервное копирование к /api/v1/admin/backups.
+func (h *AdminHandlers) SetBackups(b *Backups) {
+	h.backups = b
+}
+
+// SetConfig подключает действующую конфигурацию к /api/v1/admin/config.
+func (h *AdminHandlers) SetConfig(cfg Config) {
+	h.config = &cfg
+}
+
+type IssueAgentTokenRequest struct {
+	Name string `json:"name"`
+}
+
+type IssueAgentTokenResponse struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Token string `json:"token"` // Показывается только при выпуске
+}
+
+// AgentTokensHandler обслуживает /api/v1/admin/agent-tokens:
+// GET — список токенов, POST — выпуск нового, DELETE /{id} — отзыв.
+func (h *AdminHandlers) AgentTokensHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/agent-tokens"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && idStr == "":
+		tokens, err := h.db.ListAgentTokens()
+		if err != nil {
+			log.Printf("Ошибка получения списка токенов агентов: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		if tokens == nil {
+			tokens = []database.AgentToken{}
+		}
+		writeJSON(w, http.StatusOK, tokens)
+
+	case r.Method == http.MethodPost && idStr == "":
+		var req IssueAgentTokenRequest
+		if !decodeJSONBody(w, r, &req, "Ошибка декодирования запроса: ") {
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Имя агента не может быть пустым")
+			return
+		}
+		id, token, err := IssueAgentToken(h.db, name)
+		if err != nil {
+			log.Printf("Ошибка выпуска токена агента '%s': %v", name, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		writeJSON(w, http.StatusCreated, IssueAgentTokenResponse{ID: id, Name: name, Token: token})
+
+	case r.Method == http.MethodDelete && idStr != "":
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Неверный ID токена: "+idStr)
+			return
+		}
+		revoked, err := h.db.RevokeAgentToken(id)
+		if err != nil {
+			log.Printf("Ошибка отзыва токена агента ID %d: %v", id, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		if !revoked {
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Активный токен с ID %d не найден", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+	}
+}
+
+type SetOperationTimeRequest struct {
+	TimeMs *int `json:"time_ms"`
+}
+
+const (
+	maxOperationTimeMs     = 10 * 60 * 1000 // Верхняя граница имитируемого времени операции
+	defaultAuditLimit      = 100
+	maxAuditLimit          = 1000
+	operationTimesPath     = "/api/v1/admin/operation-times"
+	operationTimesAuditKey = "audit"
+)
+
+// OperationTimesHandler обслуживает /api/v1/admin/operation-times:
+// GET — действующее время всех операций, GET /audit — журнал изменений,
+// PUT /{операция} — задать время, DELETE /{операция} — вернуть значение по умолчанию.
+// Операция указывается названием ("division") или URL-кодированным обозначением ("%2F").
+func (h *AdminHandlers) OperationTimesHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), operationTimesPath), "/"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Некорректный путь: "+err.Error())
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		writeJSON(w, http.StatusOK, h.opTimes.List())
+
+	case r.Method == http.MethodGet && key == operationTimesAuditKey:
+		limit := defaultAuditLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxAuditLimit {
+				writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("limit должен быть числом от 1 до %d", maxAuditLimit))
+				return
+			}
+			limit = n
+		}
+		changes, err := h.db.ListOperationTimeChanges(limit)
+		if err != nil {
+			log.Printf("Ошибка получения журнала изменений времени операций: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		if changes == nil {
+			changes = []database.OperationTimeChange{}
+		}
+		writeJSON(w, http.StatusOK, changes)
+
+	case (r.Method == http.MethodPut || r.Method == http.MethodDelete) && key != "":
+		op, ok := h.findOperation(key)
+		if !ok {
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Операция '%s' не найдена", key))
+			return
+		}
+		userID, _ := GetUserIDFromContext(r.Context())
+
+		if r.Method == http.MethodDelete {
+			reset, err := h.opTimes.Reset(op.Symbol, userID)
+			if err != nil {
+				log.Printf("Ошибка сброса времени операции '%s': %v", op.Symbol, err)
+				writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+				return
+			}
+			if !reset {
+				writeProblem(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Время операции '%s' не задавалось", op.Symbol))
+				return
+			}
+			writeJSON(w, http.StatusOK, h.opTimes.Info(op.Symbol))
+			return
+		}
+
+		var req SetOperationTimeRequest
+		if !decodeJSONBody(w, r, &req, "Ошибка декодирования запроса: ") {
+			return
+		}
+		if req.TimeMs == nil || *req.TimeMs < 0 || *req.TimeMs > maxOperationTimeMs {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("time_ms должен быть от 0 до %d", maxOperationTimeMs))
+			return
+		}
+		if err := h.opTimes.Set(op.Symbol, *req.TimeMs, userID); err != nil {
+			log.Printf("Ошибка изменения времени операции '%s': %v", op.Symbol, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		writeJSON(w, http.StatusOK, h.opTimes.Info(op.Symbol))
+
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+	}
+}
+
+// ResultCacheStatsResponse — состояние кэша результатов. Статистика отсутствует, если кэш выключен.
+type ResultCacheStatsResponse struct {
+	Enabled bool `json:"enabled"`
+	*ResultCacheStats
+}
+
+// ResultCacheHandler обслуживает GET /api/v1/admin/result-cache: размер и доля попаданий кэша результатов.
+func (h *AdminHandlers) ResultCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+		return
+	}
+	cache := h.scheduler.ResultCache()
+	if cache == nil {
+		writeJSON(w, http.StatusOK, ResultCacheStatsResponse{})
+		return
+	}
+	stats := cache.Stats()
+	writeJSON(w, http.StatusOK, ResultCacheStatsResponse{Enabled: true, ResultCacheStats: &stats})
+}
+
+// RetentionPolicyInfo — политика хранения истории. Сроки записаны длительностями Go
+// ("720h0m0s"); "0s" — хранить бессрочно.
+type RetentionPolicyInfo struct {
+	Tasks            string `json:"tasks"`
+	Expressions      string `json:"expressions"`
+	ErrorExpressions string `json:"error_expressions"`
+	Interval         string `json:"interval"`
+	BatchSize        int    `json:"batch_size"`
+}
+
+// RetentionInfo — политика хранения и итог последней очистки.
+type RetentionInfo struct {
+	Policy    RetentionPolicyInfo `json:"policy"`
+	LastPurge *PurgeReport        `json:"last_purge"` // null, если очистки ещё не было
+}
+
+// RetentionHandler обслуживает /api/v1/admin/retention: GET — политика хранения и
+// итог последней очистки, POST — выполнить очистку сейчас и вернуть её итог.
+func (h *AdminHandlers) RetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if h.purger == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotConfigured, "Очистка истории не настроена")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		policy := h.purger.Policy()
+		writeJSON(w, http.StatusOK, RetentionInfo{
+			Policy: RetentionPolicyInfo{
+				Tasks:            policy.Tasks.String(),
+				Expressions:      policy.Expressions.String(),
+				ErrorExpressions: policy.ErrorExpressions.String(),
+				Interval:         policy.Interval.String(),
+				BatchSize:        policy.BatchSize,
+			},
+			LastPurge: h.purger.LastReport(),
+		})
+	case http.MethodPost:
+		writeJSON(w, http.StatusOK, h.purger.Purge(r.Context()))
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+	}
+}
+
+// QuotaOverrides — лимиты, заданные пользователю администратором; отсутствующее
+// поле означает значение по умолчанию.
+type QuotaOverrides struct {
+	SubmissionsPerMinute *int `json:"submissions_per_minute,omitempty"`
+	MaxActiveExpressions *int `json:"max_active_expressions,omitempty"`
+	MaxASTNodes          *int `json:"max_ast_nodes,omitempty"`
+	MaxExpressionLength  *int `json:"max_expression_length,omitempty"`
+}
+
+// UserQuotaInfo — лимиты и текущее потребление пользователя.
+type UserQuotaInfo struct {
+	UserID    int64           `json:"user_id"`
+	Login     string          `json:"login"`
+	Limits    QuotaLimits     `json:"limits"`              // Действующие значения
+	Overrides *QuotaOverrides `json:"overrides,omitempty"` // Заданные администратором
+	Usage     QuotaUsage      `json:"usage"`
+}
+
+const quotasPath = "/api/v1/admin/quotas"
+
+// QuotasHandler обслуживает /api/v1/admin/quotas: GET — лимиты и потребление всех
+// пользователей, GET /{id} — одного пользователя, PUT /{id} — задать лимиты
+// (заменяет заданные ранее), DELETE /{id} — вернуть значения по умолчанию.
+func (h *AdminHandlers) QuotasHandler(w http.ResponseWriter, r *http.Request) {
+	quotas := h.scheduler.Quotas()
+	if quotas == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotConfigured, "Лимиты пользователей не включены")
+		return
+	}
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, quotasPath), "/")
+
+	if idStr == "" {
+		if r.Method != http.MethodGet {
+			writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+			return
+		}
+		users, err := h.db.ListUsers()
+		if err != nil {
+			log.Printf("Ошибка получения списка пользователей: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		infos := make([]UserQuotaInfo, 0, len(users))
+		for _, user := range users {
+			info, err := h.userQuotaInfo(quotas, &user)
+			if err != nil {
+				log.Printf("Ошибка получения лимитов пользователя ID %d: %v", user.ID, err)
+				writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+				return
+			}
+			infos = append(infos, *info)
+		}
+		writeJSON(w, http.StatusOK, infos)
+		return
+	}
+
+	userID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Неверный ID пользователя: "+idStr)
+		return
+	}
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Ошибка получения пользователя ID %d: %v", userID, err)
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req QuotaOverrides
+		if !decodeJSONBody(w, r, &req, "Ошибка декодирования запроса: ") {
+			return
+		}
+		quota := database.UserQuota{UserID: userID}
+		for _, f := range []struct {
+			name string
+			src  *int
+			dst  *sql.NullInt64
+		}{
+			{QuotaSubmissionsPerMinute, req.SubmissionsPerMinute, &quota.SubmissionsPerMinute},
+			{QuotaMaxActiveExpressions, req.MaxActiveExpressions, &quota.MaxActiveExpressions},
+			{QuotaMaxASTNodes, req.MaxASTNodes, &quota.MaxASTNodes},
+			{QuotaMaxExpressionLength, req.MaxExpressionLength, &quota.MaxExpressionLength},
+		} {
+			if f.src == nil {
+				continue
+			}
+			if *f.src < 0 {
+				writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("%s не может быть отрицательным (0 — без ограничения)", f.name))
+				return
+			}
+			*f.dst = sql.NullInt64{Int64: int64(*f.src), Valid: true}
+		}
+		if err := h.db.SaveUserQuota(quota); err != nil {
+			log.Printf("Ошибка сохранения лимитов пользователя ID %d: %v", userID, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+	case http.MethodDelete:
+		deleted, err := h.db.DeleteUserQuota(userID)
+		if err != nil {
+			log.Printf("Ошибка удаления лимитов пользователя ID %d: %v", userID, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		if !deleted {
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Лимиты пользователя ID %d не задавались", userID))
+			return
+		}
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+		return
+	}
+
+	info, err := h.userQuotaInfo(quotas, user)
+	if err != nil {
+		log.Printf("Ошибка получения лимитов пользователя ID %d: %v", userID, err)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (h *AdminHandlers) userQuotaInfo(quotas *Quotas, user *database.User) (*UserQuotaInfo, error) {
+	override, err := h.db.GetUserQuota(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := quotas.Usage(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	info := &UserQuotaInfo{UserID: user.ID, Login: user.Login, Limits: quotas.Defaults().apply(override), Usage: usage}
+	if override != nil {
+		get := func(v sql.NullInt64) *int {
+			if !v.Valid {
+				return nil
+			}
+			n := int(v.Int64)
+			return &n
+		}
+		info.Overrides = &QuotaOverrides{
+			SubmissionsPerMinute: get(override.SubmissionsPerMinute),
+			MaxActiveExpressions: get(override.MaxActiveExpressions),
+			MaxASTNodes:          get(override.MaxASTNodes),
+			MaxExpressionLength:  get(override.MaxExpressionLength),
+		}
+	}
+	return info, nil
+}
+
+// findOperation ищет операцию по названию или обозначению.
+func (h *AdminHandlers) findOperation(key string) (operations.Operation, bool) {
+	if op, ok := h.ops.Lookup(key); ok {
+		return op, true
+	}
+	for _, op := range h.ops.All() {
+		if op.Name != "" && op.Name == key {
+			return op, true
+		}
+	}
+	return operations.Operation{}, false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Ошибка записи JSON ответа: %v", err)
+	}
+}
+
+// BackupsInfo — политика резервного копирования и имеющиеся копии.
+type BackupsInfo struct {
+	Dir      string       `json:"dir"`
+	Interval string       `json:"interval"` // "0s" — копии только по запросу
+	Keep     int          `json:"keep"`     // 0 — старые копии не удаляются
+	Backups  []BackupInfo `json:"backups"`  // Новые первыми
+}
+
+// BackupsHandler обслуживает /api/v1/admin/backups: GET — политика и список копий,
+// POST — снять копию БД сейчас, не останавливая оркестратор.
+func (h *AdminHandlers) BackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.backups == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotConfigured, "Резервное копирование не поддерживается этим хранилищем")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := h.backups.List()
+		if err != nil {
+			log.Printf("Ошибка получения списка резервных копий: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при получении списка резервных копий")
+			return
+		}
+		policy := h.backups.Policy()
+		writeJSON(w, http.StatusOK, BackupsInfo{
+			Dir: policy.Dir, Interval: policy.Interval.String(), Keep: policy.Keep, Backups: backups,
+		})
+	case http.MethodPost:
+		info, err := h.backups.Create()
+		if err != nil {
+			log.Printf("Ошибка резервного копирования: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при резервном копировании")
+			return
+		}
+		writeJSON(w, http.StatusCreated, info)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+	}
+}
+
+// ConfigHandler обслуживает GET /api/v1/admin/config: действующая конфигурация
+// оркестратора в виде YAML-файла конфигурации, секреты скрыты.
+func (h *AdminHandlers) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+		return
+	}
+	if h.config == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotConfigured, "Конфигурация недоступна")
+		return
+	}
+	redacted, err := h.config.Redacted()
+	if err != nil {
+		log.Printf("Ошибка вывода конфигурации: %v", err)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при выводе конфигурации")
+		return
+	}
+	writeJSON(w, http.StatusOK, redacted)
+}
@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "calculator/internal/grpc/calculator"
+)
+
+func TestAdminOperationTimesAPI(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	authService := NewAuthService(store, "testsecret")
+	admin := NewAdminHandlers(store, scheduler)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/admin/operation-times", authService.AdminMiddleware(http.HandlerFunc(admin.OperationTimesHandler)))
+	mux.Handle("/api/v1/admin/operation-times/", authService.AdminMiddleware(http.HandlerFunc(admin.OperationTimesHandler)))
+
+	adminID, _ := store.CreateUser("root", "hash")
+	store.SetUserAdmin(adminID, true)
+	userID, _ := store.CreateUser("plain", "hash")
+	adminJWT, _ := authService.GenerateJWT(adminID)
+	userJWT, _ := authService.GenerateJWT(userID)
+
+	do := func(method, path, jwt, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/admin/operation-times/division", userJWT, `{"time_ms":5}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin update: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	for _, body := range []string{`{}`, `{"time_ms":-1}`, `{"time_ms":999999999}`, `oops`} {
+		if rec := do(http.MethodPut, "/api/v1/admin/operation-times/division", adminJWT, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("update with %s: got %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec := do(http.MethodPut, "/api/v1/admin/operation-times/modulo", adminJWT, `{"time_ms":5}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown operation: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/admin/operation-times/%2F", adminJWT, `{"time_ms":7}`); rec.Code != http.StatusOK {
+		t.Fatalf("update by escaped symbol: got %d body=%s", rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodPut, "/api/v1/admin/operation-times/division", adminJWT, `{"time_ms":25}`)
+	var info OperationTimeInfo
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&info) != nil || info.TimeMs != 25 || info.Source != TimeSourceAdmin {
+		t.Fatalf("update by name: code %d info %+v", rec.Code, info)
+	}
+
+	// Новое время применяется к следующей выданной задаче.
+	exprID, _ := store.CreateExpression(adminID, "6/3")
+	store.CreateTask(exprID, "/", 6, 3)
+	server := NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler)
+	resp, err := server.GetTask(context.Background(), &pb.GetTaskRequest{AgentId: "a1"})
+	if err != nil || resp.GetTask().GetOperationTimeMs() != 25 {
+		t.Fatalf("GetTask after update: %v, %v; want operation_time_ms=25", resp, err)
+	}
+
+	// Значения переживают перезапуск оркестратора.
+	if got := NewScheduler(store).GetOperationTimes().Get("/"); got != 25 {
+		t.Fatalf("time after restart = %d, want 25", got)
+	}
+
+	if rec := do(http.MethodDelete, "/api/v1/admin/operation-times/division", adminJWT, ""); rec.Code != http.StatusOK {
+		t.Fatalf("reset: got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/v1/admin/operation-times/division", adminJWT, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("repeated reset: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = do(http.MethodGet, "/api/v1/admin/operation-times", adminJWT, "")
+	var list []OperationTimeInfo
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&list) != nil || len(list) != 4 {
+		t.Fatalf("list: code %d list %+v", rec.Code, list)
+	}
+	for _, item := range list {
+		if item.Operation == "/" && (item.Source == TimeSourceAdmin || item.TimeMs != item.DefaultMs) {
+			t.Errorf("division after reset: %+v", item)
+		}
+	}
+
+	rec = do(http.MethodGet, "/api/v1/admin/operation-times/audit?limit=10", adminJWT, "")
+	var audit []struct {
+		Operation      string               `json:"operation"`
+		ChangedByLogin string               `json:"changed_by_login"`
+		NewTimeMs      struct{ Valid bool } `json:"new_time_ms"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&audit) != nil || len(audit) != 3 || !audit[2].NewTimeMs.Valid {
+		t.Fatalf("audit: code %d body %s", rec.Code, rec.Body.String())
+	}
+	if audit[0].Operation != "/" || audit[0].NewTimeMs.Valid || audit[0].ChangedByLogin != "root" {
+		t.Errorf("latest audit entry must be the reset by root, got %+v", audit[0])
+	}
+}
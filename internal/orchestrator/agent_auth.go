@@ -0,0 +1,105 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const agentTokenPrefix = "agt_"
+
+// AgentTokenAuthenticator проверяет bearer-токен агента из gRPC-метаданных.
+// Вызовы, уже авторизованные по клиентскому сертификату, пропускаются без токена;
+// вызовы других сервисов не проверяются.
+type AgentTokenAuthenticator struct {
+	dbStore database.AgentTokenStore
+}
+
+func NewAgentTokenAuthenticator(db database.AgentTokenStore) *AgentTokenAuthenticator {
+	return &AgentTokenAuthenticator{dbStore: db}
+}
+
+// IssueAgentToken выпускает новый токен агента. Открытое значение возвращается
+// только один раз, в БД хранится лишь его хэш.
+func IssueAgentToken(db database.AgentTokenStore, name string) (int64, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return 0, "", fmt.Errorf("ошибка генерации токена агента: %w", err)
+	}
+	token := agentTokenPrefix + hex.EncodeToString(b)
+	id, err := db.CreateAgentToken(name, hashAgentToken(token))
+	if err != nil {
+		return 0, "", err
+	}
+	return id, token, nil
+}
+
+// Токен агента — случайные 256 бит, поэтому медленный bcrypt не нужен:
+// достаточно SHA-256, который проверяется на каждом RPC.
+func hashAgentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *AgentTokenAuthenticator) authenticate(ctx context.Context) (context.Context, error) {
+	if _, ok := AgentIdentityFromContext(ctx); ok {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "отсутствует токен агента")
+	}
+	parts := strings.SplitN(values[0], " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" || parts[1] == "" {
+		return nil, status.Error(codes.Unauthenticated, "некорректный формат токена агента (ожидается 'Bearer <token>')")
+	}
+
+	token, err := a.dbStore.GetAgentTokenByHash(hashAgentToken(parts[1]))
+	if err != nil && !errors.Is(err, database.ErrAgentTokenNotFound) {
+		log.Printf("gRPC: Ошибка проверки токена агента: %v", err)
+		return nil, status.Error(codes.Internal, "ошибка проверки токена агента")
+	}
+	if token == nil || token.RevokedAt.Valid {
+		return nil, status.Error(codes.Unauthenticated, "недействительный токен агента")
+	}
+	return context.WithValue(ctx, agentIdentityContextKey, token.Name), nil
+}
+
+func (a *AgentTokenAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isAgentMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := a.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *AgentTokenAuthenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isAgentMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := a.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
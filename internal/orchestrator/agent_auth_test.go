@@ -0,0 +1,140 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestAgentTokenAuth(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	auth := NewAgentTokenAuthenticator(store)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryInterceptor()),
+		grpc.StreamInterceptor(auth.StreamInterceptor()),
+	)
+	pb.RegisterCalculatorAgentServiceServer(srv, NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler))
+	lis := bufconn.Listen(bufSize)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewCalculatorAgentServiceClient(conn)
+
+	tokenID, token, err := IssueAgentToken(store, "worker-a")
+	if err != nil {
+		t.Fatalf("IssueAgentToken error: %v", err)
+	}
+	withToken := func(tok string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+tok)
+	}
+
+	if _, err := client.GetTask(context.Background(), &pb.GetTaskRequest{AgentId: "x"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("GetTask without token: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetTask(withToken("agt_bogus"), &pb.GetTaskRequest{AgentId: "x"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("GetTask with unknown token: got %v, want Unauthenticated", err)
+	}
+
+	uid, _ := store.CreateUser("owner", "hash")
+	exprID, _ := store.CreateExpression(uid, "1+2")
+	taskID, _ := store.CreateTask(exprID, "+", 1, 2)
+
+	resp, err := client.GetTask(withToken(token), &pb.GetTaskRequest{AgentId: "spoofed"})
+	if err != nil {
+		t.Fatalf("GetTask with valid token: %v", err)
+	}
+	task := resp.GetTask()
+	if task == nil || task.Id != taskID {
+		t.Fatalf("expected task %d, got %+v", taskID, resp)
+	}
+	_, err = client.SubmitResult(withToken(token), &pb.SubmitResultRequest{
+		TaskId:       task.Id,
+		AgentId:      "spoofed",
+		LeaseToken:   task.LeaseToken,
+		ResultStatus: &pb.SubmitResultRequest_Result{Result: 3},
+	})
+	if err != nil {
+		t.Fatalf("SubmitResult with valid token: %v", err)
+	}
+	stored, _ := store.GetTaskByID(taskID)
+	if stored.SettledBy.String != "worker-a" {
+		t.Errorf("result attributed to %q, want authenticated identity %q", stored.SettledBy.String, "worker-a")
+	}
+
+	if _, err := store.RevokeAgentToken(tokenID); err != nil {
+		t.Fatalf("RevokeAgentToken error: %v", err)
+	}
+	if _, err := client.GetTask(withToken(token), &pb.GetTaskRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("GetTask with revoked token: got %v, want Unauthenticated", err)
+	}
+}
+
+func TestAdminAgentTokensAPI(t *testing.T) {
+	store, _ := newTestStore(t)
+	authService := NewAuthService(store, "testsecret")
+	handler := authService.AdminMiddleware(http.HandlerFunc(NewAdminHandlers(store, nil).AgentTokensHandler))
+
+	adminID, _ := store.CreateUser("root", "hash")
+	store.SetUserAdmin(adminID, true)
+	userID, _ := store.CreateUser("plain", "hash")
+	adminJWT, _ := authService.GenerateJWT(adminID)
+	userJWT, _ := authService.GenerateJWT(userID)
+
+	do := func(method, path, jwt, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/admin/agent-tokens", userJWT, `{"name":"w1"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin issue: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec := do(http.MethodPost, "/api/v1/admin/agent-tokens", adminJWT, `{"name":"w1"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("admin issue: got %d body=%s", rec.Code, rec.Body.String())
+	}
+	var issued IssueAgentTokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&issued); err != nil || !strings.HasPrefix(issued.Token, agentTokenPrefix) {
+		t.Fatalf("issue response %+v, err %v", issued, err)
+	}
+	stored, _ := store.GetAgentTokenByHash(hashAgentToken(issued.Token))
+	if stored == nil || stored.TokenHash == issued.Token {
+		t.Fatalf("token must be stored hashed, got %+v", stored)
+	}
+
+	rec = do(http.MethodGet, "/api/v1/admin/agent-tokens", adminJWT, "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), issued.Token) {
+		t.Fatalf("list: code %d, body must not leak the token: %s", rec.Code, rec.Body.String())
+	}
+
+	path := fmt.Sprintf("/api/v1/admin/agent-tokens/%d", issued.ID)
+	if rec := do(http.MethodDelete, path, adminJWT, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, path, adminJWT, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("repeated revoke: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var jwtKey []byte
@@ -25,11 +30,15 @@ type Claims struct {
 }
 
 type AuthService struct {
-	dbStore   *database.Store
+	dbStore   database.Storage
 	jwtSecret string
+	tokenTTL  time.Duration // Срок действия выдаваемых JWT
 }
 
-func NewAuthService(db *database.Store, secret string) *AuthService {
+// DefaultTokenTTL — срок действия JWT, если он не задан конфигурацией.
+const DefaultTokenTTL = 24 * time.Hour
+
+func NewAuthService(db database.Storage, secret string) *AuthService {
 	if secret == "" {
 		panic("JWT secret cannot be empty")
 	}
@@ -37,9 +46,15 @@ func NewAuthService(db *database.Store, secret string) *AuthService {
 	return &AuthService{
 		dbStore:   db,
 		jwtSecret: secret,
+		tokenTTL:  DefaultTokenTTL,
 	}
 }
 
+// SetTokenTTL задаёт срок действия JWT, выдаваемых при входе.
+func (s *AuthService) SetTokenTTL(ttl time.Duration) {
+	s.tokenTTL = ttl
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(bytes), err
@@ -51,7 +66,7 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 func (s *AuthService) GenerateJWT(userID int64) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Токен действителен 24 часа
+	expirationTime := time.Now().Add(s.tokenTTL)
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -88,30 +103,99 @@ func (s *AuthService) ValidateJWT(tokenStr string) (int64, error) {
 	return claims.UserID, nil
 }
 
+// UserIDFromAuthorization проверяет значение заголовка (или gRPC-метаданных)
+// Authorization вида "Bearer <token>" и возвращает ID пользователя.
+func (s *AuthService) UserIDFromAuthorization(authHeader string) (int64, error) {
+	if authHeader == "" {
+		return 0, fmt.Errorf("отсутствует заголовок Authorization")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return 0, fmt.Errorf("некорректный формат заголовка Authorization (ожидается 'Bearer <token>')")
+	}
+
+	userID, err := s.ValidateJWT(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("ошибка валидации токена: %w", err)
+	}
+	return userID, nil
+}
+
 func (s *AuthService) JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Отсутствует заголовок Authorization", http.StatusUnauthorized)
+		userID, err := s.UserIDFromAuthorization(r.Header.Get("Authorization"))
+		if err != nil {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, err.Error())
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			http.Error(w, "Некорректный формат заголовка Authorization (ожидается 'Bearer <token>')", http.StatusUnauthorized)
+		ctx := context.WithValue(r.Context(), userContextKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AdminMiddleware пропускает только аутентифицированных администраторов. Права
+// хранятся у пользователя в БД (User.IsAdmin) и выдаются командой "orchestrator admin",
+// а не регистрацией: логин сам по себе прав не даёт.
+func (s *AuthService) AdminMiddleware(next http.Handler) http.Handler {
+	return s.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := GetUserIDFromContext(r.Context())
+		user, err := s.dbStore.GetUserByID(userID)
+		if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+			log.Printf("Ошибка получения пользователя ID %d для проверки прав администратора: %v", userID, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "Доступ разрешён только администраторам")
 			return
 		}
+		next.ServeHTTP(w, r)
+	}))
+}
 
-		tokenStr := parts[1]
-		userID, err := s.ValidateJWT(tokenStr)
+// authenticateGRPC проверяет JWT из метаданных authorization и кладёт ID
+// пользователя в контекст — так же, как JWTMiddleware для HTTP.
+func (s *AuthService) authenticateGRPC(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "отсутствует JWT в метаданных authorization")
+	}
+	userID, err := s.UserIDFromAuthorization(values[0])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, userContextKey, userID), nil
+}
+
+// JWTUnaryInterceptor аутентифицирует вызовы клиентского gRPC API (CalculatorService) по JWT.
+func (s *AuthService) JWTUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isClientMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := s.authenticateGRPC(ctx)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Ошибка валидации токена: %v", err), http.StatusUnauthorized)
-			return
+			return nil, err
 		}
+		return handler(ctx, req)
+	}
+}
 
-		ctx := context.WithValue(r.Context(), userContextKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// JWTStreamInterceptor — то же для потоковых методов (Watch).
+func (s *AuthService) JWTStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isClientMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := s.authenticateGRPC(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
 }
 
 func GetUserIDFromContext(ctx context.Context) (int64, bool) {
@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupPrefix     = "calculator-"
+	backupExt        = ".db"
+	backupNameLayout = "20060102-150405.000" // Время копии в UTC: имена сортируются по времени
+)
+
+// BackupPolicy задаёт резервное копирование БД.
+type BackupPolicy struct {
+	Dir      string        `yaml:"dir"`      // Каталог резервных копий
+	Interval time.Duration `yaml:"interval"` // Период копирования по расписанию; 0 — только по запросу
+	Keep     int           `yaml:"keep"`     // Сколько последних копий хранить; 0 — хранить все
+}
+
+// DefaultBackupPolicy хранит семь последних копий в каталоге backups, копирование — по запросу.
+var DefaultBackupPolicy = BackupPolicy{Dir: "backups", Keep: 7}
+
+// BackupInfo описывает файл резервной копии.
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Backups снимает резервные копии БД по расписанию и по запросу администратора
+// и удаляет старые копии сверх BackupPolicy.Keep.
+type Backups struct {
+	store  database.Backuper
+	policy BackupPolicy
+	now    func() time.Time
+
+	mu sync.Mutex // Копии не снимаются одновременно
+}
+
+func NewBackups(store database.Backuper, policy BackupPolicy) *Backups {
+	if policy.Dir == "" {
+		policy.Dir = DefaultBackupPolicy.Dir
+	}
+	return &Backups{store: store, policy: policy, now: time.Now}
+}
+
+// Policy возвращает действующую политику резервного копирования.
+func (b *Backups) Policy() BackupPolicy {
+	return b.policy
+}
+
+// Run снимает копию каждые Interval до отмены ctx.
+func (b *Backups) Run(ctx context.Context) {
+	if b.policy.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(b.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := b.Create(); err != nil {
+				log.Printf("Ошибка резервного копирования по расписанию: %v", err)
+			}
+		}
+	}
+}
+
+// Create снимает копию БД в каталог политики и удаляет лишние старые копии.
+func (b *Backups) Create() (BackupInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := backupPrefix + b.now().UTC().Format(backupNameLayout) + backupExt
+	path := filepath.Join(b.policy.Dir, name)
+	if err := b.store.Backup(path); err != nil {
+		return BackupInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("ошибка чтения резервной копии %s: %w", path, err)
+	}
+	if err := b.rotate(); err != nil {
+		// Копия уже снята: ошибка удаления старых копий её не отменяет.
+		log.Printf("Ошибка удаления старых резервных копий: %v", err)
+	}
+	return BackupInfo{Name: name, Size: info.Size(), CreatedAt: info.ModTime()}, nil
+}
+
+// List возвращает резервные копии из каталога политики, новые первыми.
+func (b *Backups) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(b.policy.Dir)
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения каталога резервных копий: %w", err)
+	}
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Файл удалён между чтением каталога и Info
+		}
+		backups = append(backups, BackupInfo{Name: name, Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// rotate удаляет копии сверх Keep, начиная с самых старых.
+func (b *Backups) rotate() error {
+	if b.policy.Keep <= 0 {
+		return nil
+	}
+	backups, err := b.List()
+	if err != nil {
+		return err
+	}
+	for _, old := range backups[min(b.policy.Keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(b.policy.Dir, old.Name)); err != nil {
+			return err
+		}
+		log.Printf("Удалена старая резервная копия: %s", old.Name)
+	}
+	return nil
+}
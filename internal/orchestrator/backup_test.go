@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupsRotationAndAPI(t *testing.T) {
+	dir := t.TempDir()
+	store, err := database.NewStore(filepath.Join(dir, "calculator.db"))
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	defer store.Close()
+	if err := store.InitDB(); err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+
+	authService := NewAuthService(store, "testsecret")
+	admin := NewAdminHandlers(store, nil)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/admin/backups", authService.AdminMiddleware(http.HandlerFunc(admin.BackupsHandler)))
+	adminID, _ := store.CreateUser("root", "hash")
+	store.SetUserAdmin(adminID, true)
+	adminJWT, _ := authService.GenerateJWT(adminID)
+	do := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/backups", nil)
+		req.Header.Set("Authorization", "Bearer "+adminJWT)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost); rec.Code != http.StatusNotFound {
+		t.Fatalf("backup without Backups: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	backups := NewBackups(store, BackupPolicy{Dir: filepath.Join(dir, "backups"), Keep: 2})
+	admin.SetBackups(backups)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	backups.now = func() time.Time { clock = clock.Add(time.Hour); return clock }
+
+	var created []BackupInfo
+	for i := 0; i < 3; i++ {
+		rec := do(http.MethodPost)
+		var info BackupInfo
+		if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&info) != nil || info.Size == 0 {
+			t.Fatalf("POST backup %d: code %d body %s", i, rec.Code, rec.Body.String())
+		}
+		if _, err := database.ValidateBackup(filepath.Join(dir, "backups", info.Name)); err != nil {
+			t.Fatalf("backup %s is not valid: %v", info.Name, err)
+		}
+		created = append(created, info)
+	}
+
+	rec := do(http.MethodGet)
+	var info BackupsInfo
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&info) != nil {
+		t.Fatalf("GET backups: code %d body %s", rec.Code, rec.Body.String())
+	}
+	// Самая старая копия удалена, остальные перечислены от новой к старой.
+	if len(info.Backups) != 2 || info.Backups[0].Name != created[2].Name || info.Backups[1].Name != created[1].Name {
+		t.Fatalf("backups after rotation = %+v, created %+v", info.Backups, created)
+	}
+	if info.Keep != 2 || info.Interval != "0s" {
+		t.Fatalf("backup policy = %+v", info)
+	}
+}
@@ -0,0 +1,260 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	pb "calculator/internal/grpc/calculator"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchPollInterval — период перечитывания выражения в Watch на случай,
+// если изменение произошло в обход планировщика.
+const watchPollInterval = time.Second
+
+type calculatorService struct {
+	pb.UnimplementedCalculatorServiceServer
+	auth      *AuthService
+	dbStore   database.Storage
+	scheduler *Scheduler
+
+	shutdownOnce sync.Once
+	shutdown     chan struct{} // Закрывается при остановке оркестратора
+}
+
+// NewCalculatorServiceServer создаёт клиентский gRPC API. Он использует те же
+// планировщик, хранилище и JWT, что и HTTP-обработчики.
+func NewCalculatorServiceServer(auth *AuthService, db database.Storage, scheduler *Scheduler) *calculatorService {
+	return &calculatorService{
+		auth:      auth,
+		dbStore:   db,
+		scheduler: scheduler,
+		shutdown:  make(chan struct{}),
+	}
+}
+
+// Shutdown завершает активные потоки Watch, чтобы они не задерживали остановку gRPC-сервера.
+func (s *calculatorService) Shutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// userID возвращает пользователя, установленного AuthService.JWTUnaryInterceptor
+// (или JWTStreamInterceptor). Его отсутствие — ошибка настройки сервера, а не клиента.
+func (s *calculatorService) userID(ctx context.Context) (int64, error) {
+	userID, ok := GetUserIDFromContext(ctx)
+	if !ok {
+		log.Println("gRPC: Ошибка: нет userID в контексте — JWT-перехватчик не подключён")
+		return 0, status.Error(codes.Internal, "внутренняя ошибка сервера (контекст пользователя)")
+	}
+	return userID, nil
+}
+
+func (s *calculatorService) Submit(ctx context.Context, req *pb.SubmitExpressionRequest) (*pb.Expression, error) {
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exprStr := strings.TrimSpace(req.Expression)
+	if exprStr == "" {
+		return nil, status.Error(codes.InvalidArgument, "пустое выражение недопустимо")
+	}
+
+	if req.Priority != "" && !database.IsValidPriority(req.Priority) {
+		return nil, status.Errorf(codes.InvalidArgument, "неизвестный приоритет %q", req.Priority)
+	}
+
+	exprID, err := s.scheduler.SubmitExpression(userID, exprStr, req.Priority)
+	var limitErr *ExpressionLimitError
+	if errors.As(err, &limitErr) {
+		return nil, status.Error(codes.InvalidArgument, "выражение слишком сложное: "+limitErr.Error())
+	}
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return nil, quotaStatus(quotaErr)
+	}
+	if err != nil {
+		log.Printf("gRPC: Ошибка создания выражения для пользователя %d: %v", userID, err)
+		return nil, status.Error(codes.Internal, "ошибка сохранения выражения")
+	}
+	return s.getExpression(exprID, userID)
+}
+
+// quotaStatus превращает превышение лимита в ResourceExhausted; пауза до повтора
+// передаётся в google.rpc.RetryInfo.
+func quotaStatus(e *QuotaError) error {
+	st := status.New(codes.ResourceExhausted, e.Message)
+	if e.RetryAfter <= 0 {
+		return st.Err()
+	}
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func (s *calculatorService) Get(ctx context.Context, req *pb.GetExpressionRequest) (*pb.Expression, error) {
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.getExpression(req.Id, userID)
+}
+
+func (s *calculatorService) List(ctx context.Context, req *pb.ListExpressionsRequest) (*pb.ListExpressionsResponse, error) {
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expressions, err := s.dbStore.GetExpressionsByUserID(userID)
+	if err != nil {
+		log.Printf("gRPC: Ошибка получения списка выражений для пользователя %d: %v", userID, err)
+		return nil, status.Error(codes.Internal, "ошибка получения выражений")
+	}
+
+	resp := &pb.ListExpressionsResponse{}
+	for i := range expressions {
+		resp.Expressions = append(resp.Expressions, expressionToProto(&expressions[i]))
+	}
+	return resp, nil
+}
+
+func (s *calculatorService) Cancel(ctx context.Context, req *pb.CancelExpressionRequest) (*pb.Expression, error) {
+	userID, err := s.userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cancelled, err := s.scheduler.CancelExpression(req.Id, userID)
+	if err != nil {
+		log.Printf("gRPC: Ошибка отмены выражения ID %d: %v", req.Id, err)
+		return nil, status.Error(codes.Internal, "ошибка отмены выражения")
+	}
+
+	expr, err := s.getExpression(req.Id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, status.Errorf(codes.FailedPrecondition, "выражение ID %d уже в статусе '%s'", req.Id, expr.Status)
+	}
+	return expr, nil
+}
+
+// Watch отправляет снимок выражения и его шагов при каждом изменении
+// и завершает поток, когда выражение переходит в конечный статус.
+func (s *calculatorService) Watch(req *pb.WatchExpressionRequest, stream pb.CalculatorService_WatchServer) error {
+	userID, err := s.userID(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	updates, unsubscribe := s.scheduler.Subscribe(req.Id)
+	defer unsubscribe()
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	var last *pb.ExpressionUpdate
+	for {
+		update, err := s.snapshot(req.Id, userID)
+		if err != nil {
+			return err
+		}
+		if last == nil || !sameUpdate(last, update) {
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+			last = update
+		}
+		if database.IsFinalStatus(update.Expression.Status) {
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.shutdown:
+			return status.Error(codes.Unavailable, "оркестратор останавливается, переподключитесь позже")
+		case <-updates:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *calculatorService) getExpression(id, userID int64) (*pb.Expression, error) {
+	expr, err := s.dbStore.GetExpressionByID(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "выражение с ID %d не найдено или доступ запрещен", id)
+	}
+	if err != nil {
+		log.Printf("gRPC: Ошибка получения выражения ID %d: %v", id, err)
+		return nil, status.Error(codes.Internal, "ошибка получения выражения")
+	}
+	return expressionToProto(expr), nil
+}
+
+func (s *calculatorService) snapshot(id, userID int64) (*pb.ExpressionUpdate, error) {
+	expr, err := s.getExpression(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.dbStore.GetAllTasksForExpression(id)
+	if err != nil {
+		log.Printf("gRPC: Ошибка получения задач выражения ID %d: %v", id, err)
+		return nil, status.Error(codes.Internal, "ошибка получения шагов выражения")
+	}
+
+	update := &pb.ExpressionUpdate{Expression: expr}
+	for _, t := range tasks {
+		step := &pb.Step{
+			TaskId:    t.ID,
+			Operation: t.Operation,
+			Arg1:      t.Arg1,
+			Arg2:      t.Arg2,
+			Status:    t.Status,
+		}
+		if t.Result.Valid {
+			step.Result = &t.Result.Float64
+		}
+		update.Steps = append(update.Steps, step)
+	}
+	return update, nil
+}
+
+// sameUpdate сравнивает снимки без учёта времени обновления.
+func sameUpdate(a, b *pb.ExpressionUpdate) bool {
+	if a.Expression.Status != b.Expression.Status || a.Expression.GetResult() != b.Expression.GetResult() ||
+		a.Expression.Steps != b.Expression.Steps || len(a.Steps) != len(b.Steps) {
+		return false
+	}
+	for i := range a.Steps {
+		if a.Steps[i].TaskId != b.Steps[i].TaskId || a.Steps[i].Status != b.Steps[i].Status {
+			return false
+		}
+	}
+	return true
+}
+
+func expressionToProto(expr *database.Expression) *pb.Expression {
+	out := &pb.Expression{
+		Id:         expr.ID,
+		Expression: expr.Expression,
+		Priority:   expr.Priority,
+		Status:     expr.Status,
+		Steps:      expr.Steps.String,
+		CreatedAt:  timestamppb.New(expr.CreatedAt),
+		UpdatedAt:  timestamppb.New(expr.UpdatedAt),
+	}
+	if expr.Result.Valid {
+		result := expr.Result.Float64
+		out.Result = &result
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package orchestrator
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"calculator/internal/database"
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type calculatorServiceEnv struct {
+	store  database.Storage
+	client pb.CalculatorServiceClient
+	agent  pb.CalculatorAgentServiceClient
+	ctx    context.Context // С JWT пользователя в метаданных
+}
+
+func newCalculatorServiceEnv(t *testing.T) *calculatorServiceEnv {
+	t.Helper()
+	store, scheduler := newTestStore(t)
+	auth := NewAuthService(store, "testsecret")
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.JWTUnaryInterceptor()),
+		grpc.ChainStreamInterceptor(auth.JWTStreamInterceptor()),
+	)
+	pb.RegisterCalculatorAgentServiceServer(srv, NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler))
+	pb.RegisterCalculatorServiceServer(srv, NewCalculatorServiceServer(auth, store, scheduler))
+	lis := bufconn.Listen(bufSize)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	uid, _ := store.CreateUser("client", "hash")
+	jwt, _ := auth.GenerateJWT(uid)
+	return &calculatorServiceEnv{
+		store:  store,
+		client: pb.NewCalculatorServiceClient(conn),
+		agent:  pb.NewCalculatorAgentServiceClient(conn),
+		ctx:    metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+jwt),
+	}
+}
+
+// leaseTask ждёт, пока планировщик создаст задачу, и берёт её как агент.
+func (e *calculatorServiceEnv) leaseTask(t *testing.T) *pb.Task {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := e.agent.GetTask(context.Background(), &pb.GetTaskRequest{AgentId: "test-agent"})
+		if err != nil {
+			t.Fatalf("GetTask error: %v", err)
+		}
+		if task := resp.GetTask(); task != nil {
+			return task
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no task was scheduled")
+	return nil
+}
+
+func TestCalculatorService_Unauthenticated(t *testing.T) {
+	env := newCalculatorServiceEnv(t)
+	_, err := env.client.Submit(context.Background(), &pb.SubmitExpressionRequest{Expression: "1+1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Submit without JWT: got %v, want Unauthenticated", err)
+	}
+}
+
+func TestCalculatorService_SubmitWatch(t *testing.T) {
+	env := newCalculatorServiceEnv(t)
+
+	expr, err := env.client.Submit(env.ctx, &pb.SubmitExpressionRequest{Expression: "2+3"})
+	if err != nil {
+		t.Fatalf("Submit error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(env.ctx, 10*time.Second)
+	defer cancel()
+	stream, err := env.client.Watch(ctx, &pb.WatchExpressionRequest{Id: expr.Id})
+	if err != nil {
+		t.Fatalf("Watch error: %v", err)
+	}
+
+	task := env.leaseTask(t)
+	_, err = env.agent.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+		TaskId:       task.Id,
+		LeaseToken:   task.LeaseToken,
+		ResultStatus: &pb.SubmitResultRequest_Result{Result: 5},
+	})
+	if err != nil {
+		t.Fatalf("SubmitResult error: %v", err)
+	}
+
+	var last *pb.ExpressionUpdate
+	for {
+		update, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Watch Recv error: %v", err)
+		}
+		last = update
+	}
+	if last == nil || last.Expression.Status != database.StatusDone || last.Expression.GetResult() != 5 {
+		t.Fatalf("last update = %+v, want done with result 5", last)
+	}
+	if len(last.Steps) != 1 || last.Steps[0].GetResult() != 5 {
+		t.Fatalf("steps = %+v, want one completed step", last.Steps)
+	}
+
+	list, err := env.client.List(env.ctx, &pb.ListExpressionsRequest{})
+	if err != nil || len(list.Expressions) != 1 {
+		t.Fatalf("List: %+v, %v", list, err)
+	}
+}
+
+func TestCalculatorService_Cancel(t *testing.T) {
+	env := newCalculatorServiceEnv(t)
+
+	expr, err := env.client.Submit(env.ctx, &pb.SubmitExpressionRequest{Expression: "(1+2)*(3+4)"})
+	if err != nil {
+		t.Fatalf("Submit error: %v", err)
+	}
+	task := env.leaseTask(t)
+
+	cancelled, err := env.client.Cancel(env.ctx, &pb.CancelExpressionRequest{Id: expr.Id})
+	if err != nil || cancelled.Status != database.StatusCancelled {
+		t.Fatalf("Cancel: %+v, %v", cancelled, err)
+	}
+	if _, err := env.client.Cancel(env.ctx, &pb.CancelExpressionRequest{Id: expr.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("repeated Cancel: got %v, want FailedPrecondition", err)
+	}
+
+	// Результат уже выданной задачи принимается, но выражение остаётся отменённым.
+	_, err = env.agent.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+		TaskId:       task.Id,
+		LeaseToken:   task.LeaseToken,
+		ResultStatus: &pb.SubmitResultRequest_Result{Result: 3},
+	})
+	if err != nil {
+		t.Fatalf("SubmitResult error: %v", err)
+	}
+	resp, err := env.agent.GetTask(context.Background(), &pb.GetTaskRequest{})
+	if err != nil || resp.GetTask() != nil {
+		t.Fatalf("cancelled expression still hands out tasks: %+v, %v", resp, err)
+	}
+
+	got, err := env.client.Get(env.ctx, &pb.GetExpressionRequest{Id: expr.Id})
+	if err != nil || got.Status != database.StatusCancelled {
+		t.Fatalf("Get after cancel: %+v, %v", got, err)
+	}
+	if _, err := env.client.Get(env.ctx, &pb.GetExpressionRequest{Id: 999}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Get unknown: got %v, want NotFound", err)
+	}
+}
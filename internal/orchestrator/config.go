@@ -0,0 +1,435 @@
+package orchestrator
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config — настройки оркестратора. Источники применяются по возрастанию приоритета:
+// значения по умолчанию, YAML-файл (-config или ORCHESTRATOR_CONFIG), переменные окружения, флаги.
+type Config struct {
+	HTTPAddr     string            `yaml:"http_addr"`
+	GRPCAddr     string            `yaml:"grpc_addr"`
+	Storage      string            `yaml:"storage"`      // sqlite, postgres или memory
+	DBPath       string            `yaml:"db_path"`      // Файл БД для sqlite
+	PostgresDSN  string            `yaml:"postgres_dsn"` // Строка подключения для postgres
+	StaticDir    string            `yaml:"static_dir"`   // Каталог, заменяющий встроенный веб-интерфейс (для разработки)
+	JWT          JWTConfig         `yaml:"jwt"`
+	Timeouts     TimeoutsConfig    `yaml:"timeouts"`
+	GRPCTLS      GRPCTLSConfig     `yaml:"grpc_tls"`
+	AgentAuth    string            `yaml:"agent_auth"`             // none или token — требовать токен агента
+	AdminLogins  []string          `yaml:"admin_logins,omitempty"` // Устарело: права администратора выдаёт "orchestrator admin"
+	Reflection   bool              `yaml:"reflection"`             // gRPC reflection для grpcurl и подобных клиентов
+	Optimization string            `yaml:"optimization"`           // Уровень оптимизации выражений: none, basic, full
+	ResultCache  ResultCacheConfig `yaml:"result_cache"`
+	Quotas       QuotaLimits       `yaml:"quotas"`
+	Retention    RetentionPolicy   `yaml:"retention"`
+	Backup       BackupPolicy      `yaml:"backup"`
+}
+
+type JWTConfig struct {
+	Secret string        `yaml:"secret"`
+	TTL    time.Duration `yaml:"ttl"` // Срок действия выдаваемых токенов
+}
+
+// TimeoutsConfig ограничивает HTTP-запросы и остановку оркестратора. Нулевой тайм-аут
+// HTTP-сервера — без ограничения.
+type TimeoutsConfig struct {
+	HTTPReadHeader time.Duration `yaml:"http_read_header"`
+	HTTPRead       time.Duration `yaml:"http_read"`
+	HTTPWrite      time.Duration `yaml:"http_write"`
+	HTTPIdle       time.Duration `yaml:"http_idle"`
+	Shutdown       time.Duration `yaml:"shutdown"` // Сколько ждать завершения запросов при остановке
+}
+
+type GRPCTLSConfig struct {
+	Cert          string   `yaml:"cert"`           // Сертификат gRPC-сервера (PEM)
+	Key           string   `yaml:"key"`            // Ключ gRPC-сервера (PEM)
+	ClientCA      string   `yaml:"client_ca"`      // CA клиентских сертификатов агентов, включает mTLS
+	AllowedAgents []string `yaml:"allowed_agents"` // CN или SAN сертификатов допущенных агентов
+}
+
+// ResultCacheConfig задаёт кэш результатов операций; нулевой размер выключает кэш.
+type ResultCacheConfig struct {
+	Size int           `yaml:"size"`
+	TTL  time.Duration `yaml:"ttl"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		HTTPAddr: ":8080",
+		GRPCAddr: ":50051",
+		Storage:  "sqlite",
+		DBPath:   "calculator.db",
+		JWT:      JWTConfig{TTL: DefaultTokenTTL},
+		Timeouts: TimeoutsConfig{
+			HTTPReadHeader: 10 * time.Second,
+			HTTPRead:       30 * time.Second,
+			HTTPWrite:      60 * time.Second,
+			HTTPIdle:       2 * time.Minute,
+			Shutdown:       30 * time.Second,
+		},
+		AgentAuth:    "none",
+		Reflection:   true,
+		Optimization: OptimizeBasic.String(),
+		ResultCache:  ResultCacheConfig{TTL: 10 * time.Minute},
+		Quotas:       DefaultQuotaLimits,
+		Retention:    DefaultRetentionPolicy,
+		Backup:       DefaultBackupPolicy,
+	}
+}
+
+// LoadConfig собирает конфигурацию из файла, окружения и аргументов командной строки
+// и проверяет её.
+func LoadConfig(args []string) (Config, error) {
+	cfg, err := ReadConfig(args)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// ReadConfig собирает конфигурацию, не проверяя её целиком.
+func ReadConfig(args []string) (Config, error) {
+	cfg, rest, err := ReadCommandConfig(args)
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("неожиданные аргументы: %s", strings.Join(rest, " "))
+	}
+	return cfg, err
+}
+
+// ReadCommandConfig — ReadConfig для служебных команд (migrate, backup, restore):
+// флаги конфигурации идут перед аргументами команды и возвращаются отдельно от них.
+// Конфигурация целиком не проверяется: командам нужны только настройки БД, а не секрет JWT.
+func ReadCommandConfig(args []string) (Config, []string, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet("orchestrator", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("ORCHESTRATOR_CONFIG"), "путь к YAML-файлу конфигурации")
+	httpAddr := fs.String("http-addr", "", "адрес HTTP-сервера, например :8080")
+	grpcAddr := fs.String("grpc-addr", "", "адрес gRPC-сервера, например :50051")
+	storage := fs.String("storage", "", "хранилище: sqlite (файл -db), postgres (строка подключения в POSTGRES_DSN) или memory (данные теряются при остановке)")
+	dbPath := fs.String("db", "", "файл БД SQLite")
+	staticDir := fs.String("static-dir", "", "каталог с index.html вместо встроенного веб-интерфейса")
+	jwtTTL := fs.Duration("jwt-ttl", 0, "срок действия JWT")
+	optimization := fs.String("optimization", "", "уровень оптимизации выражений: none, basic или full")
+	reflection := fs.Bool("reflection", true, "включить gRPC reflection")
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return cfg, nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "http-addr":
+			cfg.HTTPAddr = *httpAddr
+		case "grpc-addr":
+			cfg.GRPCAddr = *grpcAddr
+		case "storage":
+			cfg.Storage = *storage
+		case "db":
+			cfg.DBPath = *dbPath
+		case "static-dir":
+			cfg.StaticDir = *staticDir
+		case "jwt-ttl":
+			cfg.JWT.TTL = *jwtTTL
+		case "optimization":
+			cfg.Optimization = *optimization
+		case "reflection":
+			cfg.Reflection = *reflection
+		}
+	})
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения конфигурации %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("ошибка разбора конфигурации %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv читает переменные окружения. Сроки хранения истории задаются в днях
+// (RETENTION_*_DAYS), как и до появления файла конфигурации.
+func (c *Config) loadEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = splitList(v)
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: некорректная длительность %q", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	integer := func(key string, dst *int) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: ожидается целое число, получено %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	days := func(key string, dst *time.Duration) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				errs = append(errs, fmt.Errorf("%s: ожидается неотрицательное число дней, получено %q", key, v))
+				return
+			}
+			*dst = time.Duration(n) * 24 * time.Hour
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v := os.Getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: ожидается true/false, получено %q", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("HTTP_ADDR", &c.HTTPAddr)
+	str("GRPC_ADDR", &c.GRPCAddr)
+	str("STORAGE", &c.Storage)
+	str("DB_PATH", &c.DBPath)
+	str("POSTGRES_DSN", &c.PostgresDSN)
+	str("STATIC_DIR", &c.StaticDir)
+	str("JWT_SECRET", &c.JWT.Secret)
+	duration("JWT_TTL", &c.JWT.TTL)
+	duration("HTTP_READ_HEADER_TIMEOUT", &c.Timeouts.HTTPReadHeader)
+	duration("HTTP_READ_TIMEOUT", &c.Timeouts.HTTPRead)
+	duration("HTTP_WRITE_TIMEOUT", &c.Timeouts.HTTPWrite)
+	duration("HTTP_IDLE_TIMEOUT", &c.Timeouts.HTTPIdle)
+	duration("SHUTDOWN_TIMEOUT", &c.Timeouts.Shutdown)
+	str("GRPC_TLS_CERT", &c.GRPCTLS.Cert)
+	str("GRPC_TLS_KEY", &c.GRPCTLS.Key)
+	str("GRPC_TLS_CLIENT_CA", &c.GRPCTLS.ClientCA)
+	list("GRPC_TLS_ALLOWED_AGENTS", &c.GRPCTLS.AllowedAgents)
+	str("GRPC_AGENT_AUTH", &c.AgentAuth)
+	list("ADMIN_LOGINS", &c.AdminLogins)
+	boolean("GRPC_REFLECTION", &c.Reflection)
+	str("OPTIMIZATION_LEVEL", &c.Optimization)
+	integer("RESULT_CACHE_SIZE", &c.ResultCache.Size)
+	duration("RESULT_CACHE_TTL", &c.ResultCache.TTL)
+	integer("QUOTA_SUBMISSIONS_PER_MINUTE", &c.Quotas.SubmissionsPerMinute)
+	integer("QUOTA_MAX_ACTIVE_EXPRESSIONS", &c.Quotas.MaxActiveExpressions)
+	integer("QUOTA_MAX_AST_NODES", &c.Quotas.MaxASTNodes)
+	integer("QUOTA_MAX_EXPRESSION_LENGTH", &c.Quotas.MaxExpressionLength)
+	days("RETENTION_TASKS_DAYS", &c.Retention.Tasks)
+	days("RETENTION_EXPRESSIONS_DAYS", &c.Retention.Expressions)
+	days("RETENTION_ERROR_EXPRESSIONS_DAYS", &c.Retention.ErrorExpressions)
+	integer("RETENTION_BATCH_SIZE", &c.Retention.BatchSize)
+	duration("RETENTION_INTERVAL", &c.Retention.Interval)
+	str("BACKUP_DIR", &c.Backup.Dir)
+	duration("BACKUP_INTERVAL", &c.Backup.Interval)
+	integer("BACKUP_KEEP", &c.Backup.Keep)
+
+	return errors.Join(errs...)
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки сразу.
+func (c Config) Validate() error {
+	var errs []error
+	nonNegative := func(name string, v time.Duration) {
+		if v < 0 {
+			errs = append(errs, fmt.Errorf("%s не может быть отрицательным, получено %v", name, v))
+		}
+	}
+
+	// Права по логину получал бы тот, кто первым зарегистрирует этот логин, поэтому
+	// список не применяется молча, а запуск останавливается с подсказкой.
+	if len(c.AdminLogins) > 0 {
+		errs = append(errs, fmt.Errorf("admin_logins (ADMIN_LOGINS) больше не поддерживается: выдайте права командой \"orchestrator admin grant <логин>\" и уберите настройку"))
+	}
+
+	for name, addr := range map[string]string{"http_addr": c.HTTPAddr, "grpc_addr": c.GRPCAddr} {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: ожидается [host]:port", name, addr))
+			continue
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			errs = append(errs, fmt.Errorf("%s %q: некорректный порт", name, addr))
+		}
+	}
+	switch c.Storage {
+	case "sqlite":
+		if c.DBPath == "" {
+			errs = append(errs, errors.New("для хранилища sqlite нужен db_path"))
+		}
+	case "postgres":
+		if c.PostgresDSN == "" {
+			errs = append(errs, errors.New("для хранилища postgres задайте postgres_dsn (POSTGRES_DSN)"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("неизвестное хранилище %q: ожидается sqlite, postgres или memory", c.Storage))
+	}
+	if c.StaticDir != "" {
+		if info, err := os.Stat(filepath.Join(c.StaticDir, "index.html")); err != nil || info.IsDir() {
+			errs = append(errs, fmt.Errorf("static_dir %q: нет файла index.html", c.StaticDir))
+		}
+	}
+
+	if c.JWT.Secret == "" {
+		errs = append(errs, errors.New("не задан секрет JWT (jwt.secret или JWT_SECRET)"))
+	}
+	if c.JWT.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("jwt.ttl должен быть положительным, получено %v", c.JWT.TTL))
+	}
+	nonNegative("timeouts.http_read_header", c.Timeouts.HTTPReadHeader)
+	nonNegative("timeouts.http_read", c.Timeouts.HTTPRead)
+	nonNegative("timeouts.http_write", c.Timeouts.HTTPWrite)
+	nonNegative("timeouts.http_idle", c.Timeouts.HTTPIdle)
+	if c.Timeouts.Shutdown <= 0 {
+		errs = append(errs, fmt.Errorf("timeouts.shutdown должен быть положительным, получено %v", c.Timeouts.Shutdown))
+	}
+
+	if (c.GRPCTLS.Cert == "") != (c.GRPCTLS.Key == "") {
+		errs = append(errs, errors.New("для TLS gRPC-сервера нужны и grpc_tls.cert, и grpc_tls.key"))
+	}
+	if c.GRPCTLS.ClientCA != "" && c.GRPCTLS.Cert == "" {
+		errs = append(errs, errors.New("grpc_tls.client_ca задан без grpc_tls.cert и grpc_tls.key"))
+	}
+	if c.AgentAuth != "" && c.AgentAuth != "none" && c.AgentAuth != "token" {
+		errs = append(errs, fmt.Errorf("неизвестный режим agent_auth %q (ожидается token или none)", c.AgentAuth))
+	}
+	if _, err := ParseOptimizationLevel(c.Optimization); err != nil {
+		errs = append(errs, fmt.Errorf("optimization: %w", err))
+	}
+
+	if c.ResultCache.Size < 0 {
+		errs = append(errs, fmt.Errorf("result_cache.size не может быть отрицательным, получено %d", c.ResultCache.Size))
+	}
+	if c.ResultCache.Size > 0 && c.ResultCache.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("result_cache.ttl должен быть положительным, получено %v", c.ResultCache.TTL))
+	}
+	for name, v := range map[string]int{
+		"quotas.submissions_per_minute": c.Quotas.SubmissionsPerMinute,
+		"quotas.max_active_expressions": c.Quotas.MaxActiveExpressions,
+		"quotas.max_ast_nodes":          c.Quotas.MaxASTNodes,
+		"quotas.max_expression_length":  c.Quotas.MaxExpressionLength,
+		"backup.keep":                   c.Backup.Keep,
+	} {
+		if v < 0 {
+			errs = append(errs, fmt.Errorf("%s не может быть отрицательным, получено %d", name, v))
+		}
+	}
+	nonNegative("retention.tasks", c.Retention.Tasks)
+	nonNegative("retention.expressions", c.Retention.Expressions)
+	nonNegative("retention.error_expressions", c.Retention.ErrorExpressions)
+	nonNegative("retention.interval", c.Retention.Interval)
+	if c.Retention.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("retention.batch_size должен быть положительным, получено %d", c.Retention.BatchSize))
+	}
+	nonNegative("backup.interval", c.Backup.Interval)
+	if c.Backup.Dir == "" {
+		errs = append(errs, errors.New("не задан каталог резервных копий backup.dir"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("некорректная конфигурация оркестратора:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// redactedValue заменяет секреты в выводе конфигурации.
+const redactedValue = "REDACTED"
+
+// dsnSecretKeys — параметры строки подключения PostgreSQL, значения которых скрываются:
+// пароли и ключ клиентского сертификата (в libpq это путь, но он выдаёт, где лежит ключ).
+var dsnSecretKeys = []string{"password", "sslpassword", "sslkey"}
+
+// dsnSecret находит значения dsnSecretKeys в строке подключения в формате key=value;
+// значение может быть в одинарных кавычках с экранированными символами внутри.
+var dsnSecret = regexp.MustCompile(`\b((?:` + strings.Join(dsnSecretKeys, "|") + `)\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted возвращает конфигурацию в том же виде, что и YAML-файл (длительности —
+// строками вроде "30s"), с заменёнными секретами: секретом JWT и паролями в строке
+// подключения PostgreSQL — как в userinfo URL, так и в параметрах (dsnSecretKeys).
+func (c Config) Redacted() (map[string]interface{}, error) {
+	if c.JWT.Secret != "" {
+		c.JWT.Secret = redactedValue
+	}
+	if c.PostgresDSN != "" {
+		if u, err := url.Parse(c.PostgresDSN); err == nil && u.Scheme != "" {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redactedValue)
+			}
+			// Нераспознанные пары ParseQuery отбрасывает: лучше потерять их в выводе,
+			// чем показать секрет.
+			query, _ := url.ParseQuery(u.RawQuery)
+			for _, key := range dsnSecretKeys {
+				if query.Has(key) {
+					query.Set(key, redactedValue)
+				}
+			}
+			u.RawQuery = query.Encode()
+			c.PostgresDSN = u.String()
+		} else {
+			c.PostgresDSN = dsnSecret.ReplaceAllString(c.PostgresDSN, "${1}"+redactedValue)
+		}
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
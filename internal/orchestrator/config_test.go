@@ -0,0 +1,190 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticDir создаёт каталог веб-интерфейса с index.html.
+func staticDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.yaml")
+	yamlConfig := `
+http_addr: ":9090"
+grpc_addr: ":9091"
+db_path: from-file.db
+jwt:
+  secret: file-secret
+  ttl: 1h
+timeouts:
+  shutdown: 5s
+quotas:
+  max_ast_nodes: 50
+retention:
+  expressions: 720h
+`
+	if err := os.WriteFile(path, []byte(yamlConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ORCHESTRATOR_CONFIG", path)
+	t.Setenv("STATIC_DIR", staticDir(t))
+	t.Setenv("GRPC_ADDR", ":7000")
+	t.Setenv("JWT_SECRET", "env-secret")
+	t.Setenv("RETENTION_TASKS_DAYS", "2")
+	t.Setenv("DB_PATH", "from-env.db")
+
+	cfg, err := LoadConfig([]string{"-db", "from-flag.db", "-reflection=false"})
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.HTTPAddr != ":9090" || cfg.JWT.TTL != time.Hour || cfg.Timeouts.Shutdown != 5*time.Second {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.GRPCAddr != ":7000" || cfg.JWT.Secret != "env-secret" {
+		t.Errorf("env values not applied: grpc %q, secret %q", cfg.GRPCAddr, cfg.JWT.Secret)
+	}
+	if cfg.DBPath != "from-flag.db" || cfg.Reflection {
+		t.Errorf("DBPath = %q, Reflection = %v; want flags to win", cfg.DBPath, cfg.Reflection)
+	}
+	if cfg.Quotas.MaxASTNodes != 50 || cfg.Quotas.SubmissionsPerMinute != DefaultQuotaLimits.SubmissionsPerMinute {
+		t.Errorf("Quotas = %+v, want file value over defaults", cfg.Quotas)
+	}
+	if cfg.Retention.Expressions != 30*24*time.Hour || cfg.Retention.Tasks != 48*time.Hour {
+		t.Errorf("Retention = %+v", cfg.Retention)
+	}
+	if cfg.Storage != "sqlite" {
+		t.Errorf("Storage = %q", cfg.Storage)
+	}
+}
+
+func TestReadCommandConfig(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_CONFIG", "")
+	t.Setenv("DB_PATH", "from-env.db")
+	t.Setenv("JWT_SECRET", "")
+
+	// Флаги перед аргументами команды имеют приоритет над окружением, секрет JWT не нужен.
+	cfg, args, err := ReadCommandConfig([]string{"-db", "from-flag.db", "status"})
+	if err != nil || cfg.DBPath != "from-flag.db" || len(args) != 1 || args[0] != "status" {
+		t.Fatalf("ReadCommandConfig = %q, %v, %v; want flag DB path and [status]", cfg.DBPath, args, err)
+	}
+	cfg, args, err = ReadCommandConfig([]string{"up", "3"})
+	if err != nil || cfg.DBPath != "from-env.db" || len(args) != 2 {
+		t.Fatalf("ReadCommandConfig without flags = %q, %v, %v", cfg.DBPath, args, err)
+	}
+	if _, err := ReadConfig([]string{"-db", "x.db", "status"}); err == nil || !strings.Contains(err.Error(), "status") {
+		t.Fatalf("ReadConfig error = %v, want unexpected argument", err)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"NoSecret", map[string]string{"JWT_SECRET": ""}, nil, "JWT_SECRET"},
+		{"BadAddress", nil, []string{"-http-addr", "localhost"}, "http_addr"},
+		{"BadPort", map[string]string{"GRPC_ADDR": ":70000"}, nil, "некорректный порт"},
+		{"UnknownStorage", nil, []string{"-storage", "redis"}, "неизвестное хранилище"},
+		{"PostgresWithoutDSN", map[string]string{"POSTGRES_DSN": ""}, []string{"-storage", "postgres"}, "postgres_dsn"},
+		{"NoIndex", nil, []string{"-static-dir", os.TempDir()}, "index.html"},
+		{"CertWithoutKey", map[string]string{"GRPC_TLS_CERT": "server.pem"}, nil, "grpc_tls.key"},
+		{"AgentAuth", map[string]string{"GRPC_AGENT_AUTH": "password"}, nil, "agent_auth"},
+		{"Optimization", nil, []string{"-optimization", "max"}, "optimization"},
+		{"BadDuration", map[string]string{"RESULT_CACHE_TTL": "soon"}, nil, "RESULT_CACHE_TTL"},
+		{"NegativeDays", map[string]string{"RETENTION_EXPRESSIONS_DAYS": "-1"}, nil, "RETENTION_EXPRESSIONS_DAYS"},
+		{"NegativeQuota", map[string]string{"QUOTA_MAX_AST_NODES": "-5"}, nil, "quotas.max_ast_nodes"},
+		{"ZeroShutdown", map[string]string{"SHUTDOWN_TIMEOUT": "0s"}, nil, "timeouts.shutdown"},
+		{"AdminLogins", map[string]string{"ADMIN_LOGINS": "root"}, nil, "orchestrator admin grant"},
+		{"UnknownFlag", nil, []string{"-bogus"}, "bogus"},
+		{"ExtraArgs", nil, []string{"serve"}, "serve"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "secret")
+			t.Setenv("STATIC_DIR", staticDir(t))
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadConfig(tc.args)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("LoadConfig error = %v, want mention of %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownFileField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.yaml")
+	if err := os.WriteFile(path, []byte("http_port: 8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), "http_port") {
+		t.Fatalf("LoadConfig error = %v, want unknown field error", err)
+	}
+}
+
+func TestConfigAPIRedactsSecrets(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	authService := NewAuthService(store, "testsecret")
+	admin := NewAdminHandlers(store, scheduler)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/admin/config", authService.AdminMiddleware(http.HandlerFunc(admin.ConfigHandler)))
+	adminID, _ := store.CreateUser("root", "hash")
+	store.SetUserAdmin(adminID, true)
+	adminJWT, _ := authService.GenerateJWT(adminID)
+
+	for _, dsn := range []string{
+		"postgres://calc:hunter2@db:5432/calc?sslmode=disable",
+		"postgres://calc@db:5432/calc?password=hunter2&sslmode=require",
+		"postgres://calc@db:5432/calc?sslpassword=hunter2&sslkey=/secrets/hunter2.key",
+		"host=db user=calc password=hunter2 dbname=calc",
+		"host=db user=calc sslpassword=hunter2 sslkey=/secrets/hunter2.key dbname=calc",
+		`host=db user=calc password='hunter2 \' hunter2' dbname=calc`,
+	} {
+		cfg := DefaultConfig()
+		cfg.JWT.Secret = "topsecret"
+		cfg.PostgresDSN = dsn
+		admin.SetConfig(cfg)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/config", nil)
+		req.Header.Set("Authorization", "Bearer "+adminJWT)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET config: code %d body %s", rec.Code, body)
+		}
+		if strings.Contains(body, "topsecret") || strings.Contains(body, "hunter2") {
+			t.Fatalf("config leaks secrets: %s", body)
+		}
+		var got struct {
+			HTTPAddr    string `json:"http_addr"`
+			PostgresDSN string `json:"postgres_dsn"`
+			Timeouts    struct {
+				Shutdown string `json:"shutdown"`
+			} `json:"timeouts"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("config is not JSON: %v", err)
+		}
+		if got.HTTPAddr != ":8080" || got.Timeouts.Shutdown != "30s" || !strings.Contains(got.PostgresDSN, "calc") {
+			t.Fatalf("config = %+v", got)
+		}
+	}
+}
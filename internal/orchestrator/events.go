@@ -0,0 +1,59 @@
+package orchestrator
+
+import "sync"
+
+// expressionEvents оповещает подписчиков об изменениях выражений.
+// Уведомление не несёт данных: подписчик сам перечитывает состояние из БД.
+type expressionEvents struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan struct{}]struct{}
+
+	// publish, если задан, передаёт изменение другим оркестраторам с общей БД;
+	// их события приходят обратно через deliver.
+	publish func(exprID int64)
+}
+
+func newExpressionEvents() *expressionEvents {
+	return &expressionEvents{subs: make(map[int64]map[chan struct{}]struct{})}
+}
+
+// subscribe возвращает канал уведомлений по выражению и функцию отписки.
+func (e *expressionEvents) subscribe(exprID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	e.mu.Lock()
+	if e.subs[exprID] == nil {
+		e.subs[exprID] = make(map[chan struct{}]struct{})
+	}
+	e.subs[exprID][ch] = struct{}{}
+	e.mu.Unlock()
+
+	return ch, func() {
+		e.mu.Lock()
+		delete(e.subs[exprID], ch)
+		if len(e.subs[exprID]) == 0 {
+			delete(e.subs, exprID)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// notify оповещает подписчиков этого оркестратора и, если задан publish, остальных.
+func (e *expressionEvents) notify(exprID int64) {
+	e.deliver(exprID)
+	if e.publish != nil {
+		e.publish(exprID)
+	}
+}
+
+// deliver оповещает только подписчиков этого оркестратора.
+func (e *expressionEvents) deliver(exprID int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subs[exprID] {
+		select {
+		case ch <- struct{}{}:
+		default: // Подписчик ещё не обработал предыдущее уведомление
+		}
+	}
+}
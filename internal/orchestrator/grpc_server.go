@@ -4,6 +4,7 @@ import (
 	"calculator/internal/database"
 	pb "calculator/internal/grpc/calculator" // Обновленный импорт gRPC кода
 	"context"
+	"errors"
 	"log"
 
 	"google.golang.org/grpc/codes"
@@ -12,12 +13,12 @@ import (
 
 type grpcServer struct {
 	pb.UnimplementedCalculatorAgentServiceServer // Встраивание для обратной совместимости
-	dbStore                                      *database.Store
+	dbStore                                      database.Storage
 	opTimes                                      *OperationTimes // Нужны для заполнения operation_time_ms в задаче
 	scheduler                                    *Scheduler      // Добавляем планировщик для обработки завершения
 }
 
-func NewCalculatorGRPCServer(db *database.Store, opTimes *OperationTimes, scheduler *Scheduler) *grpcServer {
+func NewCalculatorGRPCServer(db database.Storage, opTimes *OperationTimes, scheduler *Scheduler) *grpcServer {
 	return &grpcServer{
 		dbStore:   db,
 		opTimes:   opTimes,
@@ -26,9 +27,10 @@ func NewCalculatorGRPCServer(db *database.Store, opTimes *OperationTimes, schedu
 }
 
 func (s *grpcServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.GetTaskResponse, error) {
-	log.Printf("gRPC: Получен запрос GetTask от агента ID: %s", req.AgentId)
+	agentID := agentName(ctx, req.AgentId)
+	log.Printf("gRPC: Получен запрос GetTask от агента ID: %s", agentID)
 
-	task, err := s.dbStore.GetAndLeasePendingTask()
+	task, err := s.dbStore.GetAndLeasePendingTask(agentID)
 	if err != nil {
 		log.Printf("gRPC: Ошибка получения задачи из БД: %v", err)
 		return nil, status.Errorf(codes.Internal, "ошибка БД при получении задачи: %v", err)
@@ -45,7 +47,7 @@ func (s *grpcServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.G
 		}, nil
 	}
 
-	log.Printf("gRPC: Отправка задачи ID %d агенту %s", task.ID, req.AgentId)
+	log.Printf("gRPC: Отправка задачи ID %d агенту %s", task.ID, agentID)
 	return &pb.GetTaskResponse{
 		TaskInfo: &pb.GetTaskResponse_Task{
 			Task: &pb.Task{
@@ -54,18 +56,21 @@ func (s *grpcServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.G
 				Arg2:            task.Arg2,
 				Operation:       task.Operation,
 				OperationTimeMs: s.getOperationTimeMs(task.Operation), // Получаем время для операции
+				LeaseToken:      task.LeaseToken,
 			},
 		},
 	}, nil
 }
 
 func (s *grpcServer) SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error) {
-	log.Printf("gRPC: Получен результат SubmitResult для задачи ID %d от агента ID: %s", req.TaskId, req.AgentId)
+	agentID := agentName(ctx, req.AgentId)
+	log.Printf("gRPC: Получен результат SubmitResult для задачи ID %d от агента ID: %s", req.TaskId, agentID)
 	var taskErr error
+	var duplicate bool
 
 	switch result := req.ResultStatus.(type) {
 	case *pb.SubmitResultRequest_Result:
-		taskErr = s.dbStore.CompleteTask(req.TaskId, result.Result)
+		duplicate, taskErr = s.dbStore.CompleteTask(req.TaskId, req.LeaseToken, agentID, result.Result)
 		if taskErr == nil {
 			log.Printf("gRPC: Задача ID %d успешно завершена в БД", req.TaskId)
 		} else {
@@ -73,7 +78,7 @@ func (s *grpcServer) SubmitResult(ctx context.Context, req *pb.SubmitResultReque
 		}
 	case *pb.SubmitResultRequest_Error:
 		log.Printf("gRPC: Задача ID %d завершилась ошибкой: %s", req.TaskId, result.Error.Message)
-		taskErr = s.dbStore.FailTask(req.TaskId)
+		duplicate, taskErr = s.dbStore.FailTask(req.TaskId, req.LeaseToken, agentID, result.Error.GetMessage())
 		if taskErr != nil {
 			log.Printf("gRPC: Ошибка отметки задачи ID %d как ошибочной в БД: %v", req.TaskId, taskErr)
 		}
@@ -82,28 +87,52 @@ func (s *grpcServer) SubmitResult(ctx context.Context, req *pb.SubmitResultReque
 		return nil, status.Error(codes.InvalidArgument, "некорректный формат статуса результата")
 	}
 
-	if taskErr != nil {
+	switch {
+	case errors.Is(taskErr, database.ErrTaskNotFound):
+		return nil, status.Errorf(codes.NotFound, "задача ID %d не найдена", req.TaskId)
+	case errors.Is(taskErr, database.ErrLeaseLost):
+		return nil, status.Errorf(codes.FailedPrecondition, "задача ID %d больше не удерживается этой арендой", req.TaskId)
+	case taskErr != nil:
 		return nil, status.Errorf(codes.Internal, "ошибка БД при обновлении задачи: %v", taskErr)
 	}
 
-	go s.scheduler.ProcessTaskCompletion(req.TaskId)
+	if duplicate {
+		// Результат по этой аренде уже принят: подтверждаем повторно без побочных эффектов.
+		return &pb.SubmitResultResponse{Acknowledged: true}, nil
+	}
+
+	s.scheduler.ProcessTaskCompletionAsync(req.TaskId)
 
 	return &pb.SubmitResultResponse{Acknowledged: true}, nil
 }
 
-func (s *grpcServer) getOperationTimeMs(op string) int32 {
-	var t int
-	switch op {
-	case "+":
-		t = s.opTimes.Addition
-	case "-":
-		t = s.opTimes.Subtraction
-	case "*":
-		t = s.opTimes.Multiplication
-	case "/":
-		t = s.opTimes.Division
-	default:
-		t = 1000 // Время по умолчанию
+// ReleaseTask возвращает задачу в очередь без результата. Агент вызывает его,
+// когда останавливается, не успев досчитать задачу.
+func (s *grpcServer) ReleaseTask(ctx context.Context, req *pb.ReleaseTaskRequest) (*pb.ReleaseTaskResponse, error) {
+	agentID := agentName(ctx, req.AgentId)
+	log.Printf("gRPC: Агент %s возвращает задачу ID %d", agentID, req.TaskId)
+
+	_, err := s.dbStore.ReleaseTask(req.TaskId, req.LeaseToken, agentID)
+	switch {
+	case errors.Is(err, database.ErrTaskNotFound):
+		return nil, status.Errorf(codes.NotFound, "задача ID %d не найдена", req.TaskId)
+	case errors.Is(err, database.ErrLeaseLost):
+		return nil, status.Errorf(codes.FailedPrecondition, "задача ID %d больше не удерживается этой арендой", req.TaskId)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "ошибка БД при возврате задачи: %v", err)
 	}
-	return int32(t)
+	return &pb.ReleaseTaskResponse{Released: true}, nil
+}
+
+// agentName возвращает проверенную идентичность агента, если она установлена
+// перехватчиком авторизации, иначе ID, заявленный самим агентом.
+func agentName(ctx context.Context, claimed string) string {
+	if id, ok := AgentIdentityFromContext(ctx); ok {
+		return id
+	}
+	return claimed
+}
+
+func (s *grpcServer) getOperationTimeMs(op string) int32 {
+	return int32(s.opTimes.Get(op))
 }
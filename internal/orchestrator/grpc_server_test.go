@@ -10,26 +10,33 @@ import (
 	pb "calculator/internal/grpc/calculator"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
 
 const bufSize = 1024 * 1024
 
 func dialer() (*grpc.ClientConn, func(), error) {
+	conn, _, cleanup, err := dialerWithStore()
+	return conn, cleanup, err
+}
+
+func dialerWithStore() (*grpc.ClientConn, database.Storage, func(), error) {
 	lis := bufconn.Listen(bufSize)
 	srv := grpc.NewServer()
 	store, err := database.NewStore(":memory:")
 	if err != nil {
 		if strings.Contains(err.Error(), "requires cgo") {
-			return nil, nil, nil
+			return nil, nil, nil, nil
 		}
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	if err := store.InitDB(); err != nil {
 		if strings.Contains(err.Error(), "requires cgo") {
-			return nil, nil, nil
+			return nil, nil, nil, nil
 		}
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	scheduler := NewScheduler(store)
 	pb.RegisterCalculatorAgentServiceServer(srv, NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler))
@@ -40,10 +47,26 @@ func dialer() (*grpc.ClientConn, func(), error) {
 		return lis.Dial()
 	}), grpc.WithInsecure())
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	cleanup := func() { conn.Close(); srv.Stop() }
-	return conn, cleanup, nil
+	return conn, store, cleanup, nil
+}
+
+func newTestStore(t *testing.T) (database.Storage, *Scheduler) {
+	t.Helper()
+	store, err := database.NewStore(":memory:")
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skip: %v", err)
+		}
+		t.Fatalf("NewStore error: %v", err)
+	}
+	if err := store.InitDB(); err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, NewScheduler(store)
 }
 
 func TestGetTask_NoTask(t *testing.T) {
@@ -64,4 +87,54 @@ func TestGetTask_NoTask(t *testing.T) {
 	if _, ok := resp.TaskInfo.(*pb.GetTaskResponse_NoTask); !ok {
 		t.Errorf("expected NoTaskAvailable, got %T", resp.TaskInfo)
 	}
-}
\ No newline at end of file
+}
+
+func TestSubmitResult_LeaseToken(t *testing.T) {
+	conn, store, cleanup, err := dialerWithStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == nil {
+		t.Skip("skip gRPC tests: cgo disabled or in-memory DB not available")
+	}
+	defer cleanup()
+
+	uid, _ := store.CreateUser("agent-test", "hash")
+	exprID, _ := store.CreateExpression(uid, "2+3")
+	if _, err := store.CreateTask(exprID, "+", 2, 3); err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+
+	client := pb.NewCalculatorAgentServiceClient(conn)
+	resp, err := client.GetTask(context.Background(), &pb.GetTaskRequest{AgentId: "a1"})
+	if err != nil {
+		t.Fatalf("GetTask error: %v", err)
+	}
+	task := resp.GetTask()
+	if task == nil || task.LeaseToken == "" {
+		t.Fatalf("expected leased task with token, got %+v", resp)
+	}
+
+	req := &pb.SubmitResultRequest{
+		TaskId:       task.Id,
+		AgentId:      "a1",
+		LeaseToken:   task.LeaseToken,
+		ResultStatus: &pb.SubmitResultRequest_Result{Result: 5},
+	}
+	for i := 0; i < 2; i++ {
+		ack, err := client.SubmitResult(context.Background(), req)
+		if err != nil || !ack.Acknowledged {
+			t.Fatalf("SubmitResult attempt %d: ack=%v err=%v", i+1, ack, err)
+		}
+	}
+
+	_, err = client.SubmitResult(context.Background(), &pb.SubmitResultRequest{
+		TaskId:       task.Id,
+		AgentId:      "a2",
+		LeaseToken:   "foreign-lease",
+		ResultStatus: &pb.SubmitResultRequest_Result{Result: 7},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("SubmitResult with foreign lease: got %v, want FailedPrecondition", err)
+	}
+}
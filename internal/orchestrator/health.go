@@ -0,0 +1,83 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	pb "calculator/internal/grpc/calculator"
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	healthCheckInterval   = 5 * time.Second
+	healthPingTimeout     = 2 * time.Second
+	schedulerStallTimeout = 30 * time.Second
+)
+
+// HealthReporter периодически проверяет БД и планировщик и публикует
+// статусы grpc.health.v1.Health по именам сервисов:
+//   - сервис агентов работает, только если доступны и БД, и планировщик;
+//   - клиентский сервис требует только БД: при зависшем планировщике
+//     выражения принимаются, но вычисляются с задержкой;
+//   - пустое имя ("") отражает общее состояние оркестратора.
+type HealthReporter struct {
+	server    *health.Server
+	dbStore   database.Storage
+	scheduler *Scheduler
+}
+
+func NewHealthReporter(db database.Storage, scheduler *Scheduler) *HealthReporter {
+	return &HealthReporter{
+		server:    health.NewServer(),
+		dbStore:   db,
+		scheduler: scheduler,
+	}
+}
+
+// Server возвращает реализацию grpc.health.v1.Health для регистрации на gRPC-сервере.
+func (h *HealthReporter) Server() *health.Server {
+	return h.server
+}
+
+// Run обновляет статусы до отмены ctx, после чего помечает все сервисы как NOT_SERVING.
+func (h *HealthReporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		h.Check(ctx)
+		select {
+		case <-ctx.Done():
+			h.server.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check выполняет одну проверку и обновляет статусы.
+func (h *HealthReporter) Check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	dbErr := h.dbStore.Ping(pingCtx)
+	cancel()
+	if dbErr != nil {
+		log.Printf("Health: БД недоступна: %v", dbErr)
+	}
+	schedulerErr := h.scheduler.Ping(schedulerStallTimeout)
+	if schedulerErr != nil {
+		log.Printf("Health: %v", schedulerErr)
+	}
+
+	h.server.SetServingStatus(pb.CalculatorAgentService_ServiceDesc.ServiceName, servingStatus(dbErr == nil && schedulerErr == nil))
+	h.server.SetServingStatus(pb.CalculatorService_ServiceDesc.ServiceName, servingStatus(dbErr == nil))
+	h.server.SetServingStatus("", servingStatus(dbErr == nil && schedulerErr == nil))
+}
+
+func servingStatus(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func healthStatus(t *testing.T, h *HealthReporter, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := h.Server().Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q) error: %v", service, err)
+	}
+	return resp.Status
+}
+
+func TestHealthReporter(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	h := NewHealthReporter(store, scheduler)
+	agentService := pb.CalculatorAgentService_ServiceDesc.ServiceName
+	clientService := pb.CalculatorService_ServiceDesc.ServiceName
+
+	h.Check(context.Background())
+	for _, svc := range []string{"", agentService, clientService} {
+		if got := healthStatus(t, h, svc); got != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("healthy: %q = %v, want SERVING", svc, got)
+		}
+	}
+
+	// Зависший планировщик: операция начата давно и не завершилась.
+	done := scheduler.track()
+	atomic.StoreInt64(&scheduler.lastProgress, time.Now().Add(-time.Hour).UnixNano())
+	h.Check(context.Background())
+	if got := healthStatus(t, h, agentService); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("stalled scheduler: agent service = %v, want NOT_SERVING", got)
+	}
+	if got := healthStatus(t, h, clientService); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("stalled scheduler: client service = %v, want SERVING", got)
+	}
+	done()
+
+	store.Close()
+	h.Check(context.Background())
+	for _, svc := range []string{"", agentService, clientService} {
+		if got := healthStatus(t, h, svc); got != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("closed DB: %q = %v, want NOT_SERVING", svc, got)
+		}
+	}
+}
+
+func TestReflectionListsAgentService(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	srv := grpc.NewServer()
+	pb.RegisterCalculatorAgentServiceServer(srv, NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler))
+	reflection.Register(srv)
+	lis := bufconn.Listen(bufSize)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerReflectionInfo error: %v", err)
+	}
+	if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv error: %v", err)
+	}
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		if svc.Name == pb.CalculatorAgentService_ServiceDesc.ServiceName {
+			return
+		}
+	}
+	t.Fatalf("reflection does not list %s: %v", pb.CalculatorAgentService_ServiceDesc.ServiceName, resp)
+}
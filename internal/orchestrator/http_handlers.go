@@ -3,20 +3,42 @@ package orchestrator
 import (
 	"calculator/internal/database"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// maxRequestBodyBytes ограничивает тело JSON-запроса до его разбора.
+const maxRequestBodyBytes = 1 << 20
+
+// decodeJSONBody читает JSON-тело запроса не длиннее maxRequestBodyBytes. При ошибке
+// отвечает 413 (слишком большое тело) или 400 с пояснением errPrefix+ошибка и возвращает false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, errPrefix string) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("Тело запроса больше %d байт", tooLarge.Limit))
+		return false
+	}
+	writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, errPrefix+err.Error())
+	return false
+}
+
 type HTTPHandlers struct {
 	auth      *AuthService
-	db        *database.Store
+	db        database.Storage
 	scheduler *Scheduler // Добавлена зависимость от планировщика
 }
 
-func NewHTTPHandlers(auth *AuthService, db *database.Store, scheduler *Scheduler) *HTTPHandlers {
+func NewHTTPHandlers(auth *AuthService, db database.Storage, scheduler *Scheduler) *HTTPHandlers {
 	return &HTTPHandlers{
 		auth:      auth,
 		db:        db,
@@ -35,13 +57,12 @@ type LoginResponse struct {
 
 func (h *HTTPHandlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
 		return
 	}
 
 	var req AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Ошибка декодирования запроса: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req, "Ошибка декодирования запроса: ") {
 		return
 	}
 
@@ -49,29 +70,29 @@ func (h *HTTPHandlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	password := strings.TrimSpace(req.Password)
 
 	if login == "" || password == "" {
-		http.Error(w, "Логин и пароль не могут быть пустыми", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Логин и пароль не могут быть пустыми")
 		return
 	}
 
 	if len(password) < 6 {
-		http.Error(w, "Пароль должен быть не менее 6 символов", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Пароль должен быть не менее 6 символов")
 		return
 	}
 
 	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		log.Printf("Ошибка хэширования пароля для пользователя %s: %v", login, err)
-		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
 		return
 	}
 
 	_, err = h.db.CreateUser(login, hashedPassword)
 	if err != nil {
-		if strings.Contains(err.Error(), "уже существует") {
-			http.Error(w, err.Error(), http.StatusConflict) // 409 Conflict
+		if errors.Is(err, database.ErrUserExists) {
+			writeError(w, r, err) // 409 Conflict
 		} else {
 			log.Printf("Ошибка создания пользователя %s в БД: %v", login, err)
-			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
 		}
 		return
 	}
@@ -82,13 +103,12 @@ func (h *HTTPHandlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
 func (h *HTTPHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
 		return
 	}
 
 	var req AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Ошибка декодирования запроса: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req, "Ошибка декодирования запроса: ") {
 		return
 	}
 
@@ -96,26 +116,26 @@ func (h *HTTPHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	password := strings.TrimSpace(req.Password)
 
 	if login == "" || password == "" {
-		http.Error(w, "Логин и пароль не могут быть пустыми", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Логин и пароль не могут быть пустыми")
 		return
 	}
 
 	user, err := h.db.GetUserByLogin(login)
-	if err != nil {
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
 		log.Printf("Ошибка получения пользователя %s из БД: %v", login, err)
-		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
 		return
 	}
 
 	if user == nil || !CheckPasswordHash(password, user.PasswordHash) {
-		http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, CodeBadCredentials, "Неверный логин или пароль")
 		return
 	}
 
 	tokenString, err := h.auth.GenerateJWT(user.ID)
 	if err != nil {
 		log.Printf("Ошибка генерации JWT для пользователя %s (ID: %d): %v", login, user.ID, err)
-		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
 		return
 	}
 
@@ -127,53 +147,60 @@ func (h *HTTPHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 type CalculateRequest struct {
 	Expression string `json:"expression"`
+	Priority   string `json:"priority"` // interactive (по умолчанию) или batch
 }
 
 func (h *HTTPHandlers) CalculateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
 		return
 	}
 
 	userID, ok := GetUserIDFromContext(r.Context())
 	if !ok {
 		log.Println("Ошибка: не удалось получить userID из контекста в CalculateHandler")
-		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера (контекст пользователя)")
 		return
 	}
 
 	var req CalculateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req, "Ошибка декодирования JSON: ") {
 		return
 	}
 	exprStr := strings.TrimSpace(req.Expression) // Восстановлено определение exprStr
 
 	if exprStr == "" {
-		http.Error(w, "Пустое выражение недопустимо", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Пустое выражение недопустимо")
+		return
+	}
+	priority := req.Priority
+	if priority == "" {
+		priority = database.PriorityInteractive
+	}
+	if !database.IsValidPriority(priority) {
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Неизвестный приоритет %q: ожидается %s или %s", priority, database.PriorityInteractive, database.PriorityBatch))
 		return
 	}
 
-
-	exprID, err := h.db.CreateExpression(userID, exprStr) // userID и exprStr теперь определены
+	exprID, err := h.scheduler.SubmitExpression(userID, exprStr, priority)
+	var limitErr *ExpressionLimitError
+	var quotaErr *QuotaError
+	if errors.As(err, &limitErr) || errors.As(err, &quotaErr) {
+		writeError(w, r, err)
+		return
+	}
 	if err != nil {
 		log.Printf("Ошибка создания выражения в БД для пользователя %d: %v", userID, err)
-		http.Error(w, "Внутренняя ошибка сервера при сохранении выражения", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при сохранении выражения")
 		return
 	}
 
 	log.Printf("Создано выражение ID %d для пользователя %d: %s", exprID, userID, exprStr)
 
-	go func(id int64, expression string) {
-		err := h.scheduler.ScheduleTasks(id, expression)
-		if err != nil {
-			log.Printf("Асинхронная ошибка планирования задач для выражения ID %d: %v", id, err)
-		}
-	}(exprID, exprStr)
-
 	respData := map[string]interface{}{
 		"id":         exprID,
 		"expression": exprStr,
+		"priority":   priority,
 		"status":     database.StatusPending, // Начальный статус
 	}
 
@@ -184,6 +211,90 @@ func (h *HTTPHandlers) CalculateHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// ExplainRequest — запрос дерева выражения до и после оптимизации.
+type ExplainRequest struct {
+	Expression string `json:"expression"`
+	Level      string `json:"level"` // none, basic или full; пусто — уровень оркестратора
+}
+
+// ExplainHandler обслуживает POST /api/v1/explain: показывает, как выражение будет
+// разбито на задачи, не сохраняя его.
+func (h *HTTPHandlers) ExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+		return
+	}
+
+	var req ExplainRequest
+	if !decodeJSONBody(w, r, &req, "Ошибка декодирования JSON: ") {
+		return
+	}
+	level := h.scheduler.OptimizationLevel()
+	if req.Level != "" {
+		var err error
+		if level, err = ParseOptimizationLevel(req.Level); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	explanation, err := h.scheduler.Explain(strings.TrimSpace(req.Expression), level)
+	var limitErr *ExpressionLimitError
+	if errors.As(err, &limitErr) {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeParseError, "Ошибка парсинга: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+// UpdateSettingsRequest — изменение настроек; отсутствующие поля не меняются.
+type UpdateSettingsRequest struct {
+	UseResultCache *bool `json:"use_result_cache"`
+}
+
+// SettingsHandler обслуживает /api/v1/settings: GET — настройки пользователя, PUT — их изменение.
+func (h *HTTPHandlers) SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в SettingsHandler")
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера (контекст пользователя)")
+		return
+	}
+
+	settings, err := h.db.GetUserSettings(userID)
+	if err != nil {
+		log.Printf("Ошибка получения настроек пользователя %d: %v", userID, err)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var req UpdateSettingsRequest
+		if !decodeJSONBody(w, r, &req, "Ошибка декодирования JSON: ") {
+			return
+		}
+		if req.UseResultCache != nil {
+			settings.UseResultCache = *req.UseResultCache
+		}
+		if err := h.db.SaveUserSettings(settings); err != nil {
+			log.Printf("Ошибка сохранения настроек пользователя %d: %v", userID, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
+
 func EnableCORS(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")                                                                                   // Разрешаем все источники (для разработки)
@@ -199,21 +310,110 @@ func EnableCORS(handler http.Handler) http.Handler {
 	})
 }
 
+// ClearHistoryResponse — сколько строк удалено при очистке истории пользователя.
+type ClearHistoryResponse struct {
+	DeletedExpressions int64 `json:"deleted_expressions"`
+	DeletedTasks       int64 `json:"deleted_tasks"`
+}
+
+// TaskAttemptInfo — одна выдача задачи агенту и её исход.
+type TaskAttemptInfo struct {
+	AgentID     string     `json:"agent_id"`
+	LeasedAt    time.Time  `json:"leased_at"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+	DurationMs  *int64     `json:"duration_ms,omitempty"` // От выдачи до ответа агента
+	Outcome     string     `json:"outcome"`               // leased, done, failed, released
+	Error       string     `json:"error,omitempty"`
+	Result      *float64   `json:"result,omitempty"` // Значение, которое вернул агент
+}
+
+// TaskHistory — задача выражения вместе со всеми попытками её выполнения.
+type TaskHistory struct {
+	ID        int64             `json:"id"`
+	Operation string            `json:"operation"`
+	Arg1      float64           `json:"arg1"`
+	Arg2      float64           `json:"arg2"`
+	Status    string            `json:"status"`
+	Result    *float64          `json:"result,omitempty"`
+	Retries   int               `json:"retries"`
+	SettledBy string            `json:"settled_by,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Attempts  []TaskAttemptInfo `json:"attempts"`
+}
+
+// taskHistory собирает задачи выражения и журнал их попыток.
+func (h *HTTPHandlers) taskHistory(expressionID int64) ([]TaskHistory, error) {
+	tasks, err := h.db.GetAllTasksForExpression(expressionID)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := h.db.ListTaskAttempts(expressionID)
+	if err != nil {
+		return nil, err
+	}
+	byTask := make(map[int64][]TaskAttemptInfo)
+	for _, a := range attempts {
+		info := TaskAttemptInfo{AgentID: a.AgentID, LeasedAt: a.LeasedAt, Outcome: a.Outcome, Error: a.ErrorMessage.String}
+		if a.SubmittedAt.Valid {
+			submitted := a.SubmittedAt.Time
+			duration := submitted.Sub(a.LeasedAt).Milliseconds()
+			info.SubmittedAt, info.DurationMs = &submitted, &duration
+		}
+		if a.Result.Valid {
+			result := a.Result.Float64
+			info.Result = &result
+		}
+		byTask[a.TaskID] = append(byTask[a.TaskID], info)
+	}
+	history := make([]TaskHistory, 0, len(tasks))
+	for _, t := range tasks {
+		item := TaskHistory{
+			ID: t.ID, Operation: t.Operation, Arg1: t.Arg1, Arg2: t.Arg2, Status: t.Status,
+			Retries: t.Retries, SettledBy: t.SettledBy.String, CreatedAt: t.CreatedAt, UpdatedAt: t.UpdatedAt,
+			Attempts: byTask[t.ID],
+		}
+		if t.Result.Valid {
+			result := t.Result.Float64
+			item.Result = &result
+		}
+		if item.Attempts == nil {
+			item.Attempts = []TaskAttemptInfo{}
+		}
+		history = append(history, item)
+	}
+	return history, nil
+}
+
+// ExpressionsHandler обслуживает /api/v1/expressions: GET — список выражений пользователя,
+// GET /{id} — одно выражение, GET /{id}/tasks — задачи выражения с журналом попыток агентов,
+// DELETE — очистить историю (удалить завершённые выражения).
 func (h *HTTPHandlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions")
+	idStr := strings.Trim(path, "/")
+
+	if r.Method != http.MethodGet && (r.Method != http.MethodDelete || idStr != "") {
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
 		return
 	}
 
 	userID, ok := GetUserIDFromContext(r.Context())
 	if !ok {
 		log.Println("Ошибка: не удалось получить userID из контекста в ExpressionsHandler")
-		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера (контекст пользователя)")
 		return
 	}
 
-	path := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions")
-	idStr := strings.Trim(path, "/")
+	if r.Method == http.MethodDelete {
+		expressions, tasks, err := h.db.DeleteUserHistory(userID)
+		if err != nil {
+			log.Printf("Ошибка очистки истории пользователя %d: %v", userID, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при очистке истории")
+			return
+		}
+		writeJSON(w, http.StatusOK, ClearHistoryResponse{DeletedExpressions: expressions, DeletedTasks: tasks})
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
@@ -221,7 +421,7 @@ func (h *HTTPHandlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request
 		expressions, err := h.db.GetExpressionsByUserID(userID)
 		if err != nil {
 			log.Printf("Ошибка получения списка выражений для пользователя %d: %v", userID, err)
-			http.Error(w, "Внутренняя ошибка сервера при получении выражений", http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при получении выражений")
 			return
 		}
 		if expressions == nil {
@@ -233,21 +433,32 @@ func (h *HTTPHandlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	idStr, withTasks := strings.CutSuffix(idStr, "/tasks")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Неверный ID выражения: "+idStr, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "Неверный ID выражения: "+idStr)
 		return
 	}
 
 	expression, err := h.db.GetExpressionByID(id, userID)
 	if err != nil {
-		log.Printf("Ошибка получения выражения ID %d для пользователя %d: %v", id, userID, err)
-		http.Error(w, "Внутренняя ошибка сервера при получении выражения", http.StatusInternalServerError)
+		if !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Ошибка получения выражения ID %d для пользователя %d: %v", id, userID, err)
+		}
+		writeError(w, r, err) // 404, если выражения нет или оно чужое
 		return
 	}
 
-	if expression == nil {
-		http.Error(w, fmt.Sprintf("Выражение с ID %d не найдено или доступ запрещен", id), http.StatusNotFound)
+	if withTasks {
+		history, err := h.taskHistory(id)
+		if err != nil {
+			log.Printf("Ошибка получения задач выражения ID %d: %v", id, err)
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера при получении задач выражения")
+			return
+		}
+		if err := json.NewEncoder(w).Encode(history); err != nil {
+			log.Printf("Ошибка записи JSON ответа для задач выражения ID %d (userID: %d): %v", id, userID, err)
+		}
 		return
 	}
 
@@ -2,7 +2,9 @@ package orchestrator
 
 import (
 	"calculator/internal/database"
+	pb "calculator/internal/grpc/calculator"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -28,6 +30,11 @@ func setupHandlers(t *testing.T) *HTTPHandlers {
 	return NewHTTPHandlers(authService, store, scheduler)
 }
 
+// serveAuthenticated пропускает запрос через JWTMiddleware, как маршрутизатор оркестратора.
+func serveAuthenticated(h *HTTPHandlers, handler http.HandlerFunc, rec *httptest.ResponseRecorder, req *http.Request) {
+	h.auth.JWTMiddleware(handler).ServeHTTP(rec, req)
+}
+
 func TestRegisterLoginCalculateFlow(t *testing.T) {
 	h := setupHandlers(t)
 
@@ -65,7 +72,7 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	rec = httptest.NewRecorder()
 	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"2+2"}`))
 	req.Header.Set("Content-Type", "application/json")
-	h.CalculateHandler(rec, req)
+	serveAuthenticated(h, h.CalculateHandler, rec, req)
 	if rec.Code != http.StatusUnauthorized {
 		t.Fatalf("Calculate without auth expected %d, got %d", http.StatusUnauthorized, rec.Code)
 	}
@@ -74,7 +81,7 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"2+2"}`))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+loginResp.Token)
-	h.CalculateHandler(rec, req)
+	serveAuthenticated(h, h.CalculateHandler, rec, req)
 	if rec.Code != http.StatusCreated {
 		t.Fatalf("Calculate with auth expected %d, got %d body=%s", http.StatusCreated, rec.Code, rec.Body.String())
 	}
@@ -93,7 +100,7 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	rec = httptest.NewRecorder()
 	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil)
 	req.Header.Set("Authorization", "Bearer "+loginResp.Token)
-	h.ExpressionsHandler(rec, req)
+	serveAuthenticated(h, h.ExpressionsHandler, rec, req)
 	if rec.Code != http.StatusOK {
 		t.Fatalf("Expressions list expected %d, got %d", http.StatusOK, rec.Code)
 	}
@@ -104,4 +111,131 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	if len(list) != 1 {
 		t.Fatalf("Expected 1 expression, got %d", len(list))
 	}
-}
\ No newline at end of file
+}
+func TestCalculateHandlerComplexityLimits(t *testing.T) {
+	h := setupHandlers(t)
+	uid, _ := h.db.CreateUser("user", "hash")
+	token, _ := h.auth.GenerateJWT(uid)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+		code string
+	}{
+		{"body too large", `{"expression":"` + strings.Repeat("1", maxRequestBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, CodePayloadTooLarge},
+		{"expression too long", `{"expression":"` + strings.Repeat("1", DefaultParserLimits.MaxLength+1) + `"}`, http.StatusRequestEntityTooLarge, CodeExpressionLimit},
+		{"nesting too deep", `{"expression":"` + strings.Repeat("(", DefaultParserLimits.MaxDepth+1) + "1" + strings.Repeat(")", DefaultParserLimits.MaxDepth+1) + `"}`, http.StatusUnprocessableEntity, CodeExpressionLimit},
+		{"too many operators", `{"expression":"1` + strings.Repeat("+1", DefaultParserLimits.MaxOperators+1) + `"}`, http.StatusUnprocessableEntity, CodeExpressionLimit},
+	}
+	// Explain разбирает выражение тем же парсером и отвечает на превышение лимитов так же.
+	for _, endpoint := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/api/v1/calculate", h.CalculateHandler},
+		{"/api/v1/explain", h.ExplainHandler},
+	} {
+		for _, tc := range tests {
+			req := httptest.NewRequest(http.MethodPost, endpoint.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			serveAuthenticated(h, endpoint.handler, rec, req)
+			var problem Problem
+			if rec.Code != tc.want || json.NewDecoder(rec.Body).Decode(&problem) != nil || problem.Code != tc.code {
+				t.Errorf("%s %s: got %d %q, want %d %q", endpoint.path, tc.name, rec.Code, problem.Code, tc.want, tc.code)
+			}
+		}
+	}
+	if list, _ := h.db.GetExpressionsByUserID(uid); len(list) != 0 {
+		t.Errorf("rejected expressions must not be stored, got %d", len(list))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", strings.NewReader(`{"expression":"2+"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	serveAuthenticated(h, h.ExplainHandler, rec, req)
+	var problem Problem
+	if rec.Code != http.StatusUnprocessableEntity || json.NewDecoder(rec.Body).Decode(&problem) != nil || problem.Code != CodeParseError {
+		t.Errorf("explain of malformed expression: got %d %q, want %d %q", rec.Code, problem.Code, http.StatusUnprocessableEntity, CodeParseError)
+	}
+}
+
+func TestHandlersRequireMiddlewareUser(t *testing.T) {
+	h := setupHandlers(t)
+	uid, _ := h.db.CreateUser("user", "hash")
+	token, _ := h.auth.GenerateJWT(uid)
+
+	// Обработчик, подключённый без JWTMiddleware, не проверяет заголовок сам:
+	// отсутствие пользователя в контексте — ошибка сервера, а не клиента.
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"2+2"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.CalculateHandler(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("handler without middleware: got %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestTaskAttemptsAPI(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	srv := NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler)
+	authService := NewAuthService(store, "testsecret")
+	handlers := NewHTTPHandlers(authService, store, scheduler)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/expressions/", authService.JWTMiddleware(http.HandlerFunc(handlers.ExpressionsHandler)))
+
+	ownerID, _ := store.CreateUser("owner", "hash")
+	strangerID, _ := store.CreateUser("stranger", "hash")
+	exprID, _ := store.CreateExpression(ownerID, "1/0")
+	store.CreateTask(exprID, "/", 1, 0)
+
+	// Первый агент возвращает задачу, второй сообщает об ошибке вычисления.
+	lease := func(agent string) *pb.Task {
+		t.Helper()
+		resp, err := srv.GetTask(t.Context(), &pb.GetTaskRequest{AgentId: agent})
+		if err != nil || resp.GetTask() == nil {
+			t.Fatalf("GetTask(%s) = %+v, %v", agent, resp, err)
+		}
+		return resp.GetTask()
+	}
+	task := lease("a1")
+	if _, err := srv.ReleaseTask(t.Context(), &pb.ReleaseTaskRequest{TaskId: task.Id, AgentId: "a1", LeaseToken: task.LeaseToken}); err != nil {
+		t.Fatalf("ReleaseTask error: %v", err)
+	}
+	task = lease("a2")
+	if _, err := srv.SubmitResult(t.Context(), &pb.SubmitResultRequest{
+		TaskId: task.Id, AgentId: "a2", LeaseToken: task.LeaseToken,
+		ResultStatus: &pb.SubmitResultRequest_Error{Error: &pb.TaskError{Message: "деление на ноль"}},
+	}); err != nil {
+		t.Fatalf("SubmitResult error: %v", err)
+	}
+
+	get := func(userID int64) *httptest.ResponseRecorder {
+		jwt, _ := authService.GenerateJWT(userID)
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/expressions/%d/tasks", exprID), nil)
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get(strangerID); rec.Code != http.StatusNotFound {
+		t.Fatalf("another user's tasks: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec := get(ownerID)
+	var history []TaskHistory
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&history) != nil {
+		t.Fatalf("GET tasks: code %d body %s", rec.Code, rec.Body.String())
+	}
+	if len(history) != 1 || len(history[0].Attempts) != 2 {
+		t.Fatalf("task history = %+v, want 1 task with 2 attempts", history)
+	}
+	released, failed := history[0].Attempts[0], history[0].Attempts[1]
+	if released.AgentID != "a1" || released.Outcome != database.AttemptReleased || released.SubmittedAt == nil {
+		t.Fatalf("released attempt = %+v", released)
+	}
+	if failed.AgentID != "a2" || failed.Outcome != database.AttemptFailed || failed.Error != "деление на ноль" ||
+		failed.Result != nil || failed.DurationMs == nil || *failed.DurationMs < 0 {
+		t.Fatalf("failed attempt = %+v", failed)
+	}
+}
@@ -0,0 +1,204 @@
+package orchestrator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"calculator/internal/operations"
+)
+
+// OptimizationLevel — насколько агрессивно оркестратор упрощает дерево выражения
+// перед планированием задач.
+type OptimizationLevel int
+
+const (
+	// OptimizeNone — дерево планируется в том виде, в каком его построил парсер.
+	OptimizeNone OptimizationLevel = iota
+	// OptimizeBasic — точные упрощения: свёртка унарного минуса над числом
+	// (-1*5 → -5, -1*(-1*x) → x) и нейтральные/поглощающие элементы операций
+	// (x*1, x+0, x-0, x/1 → x; 5*0 → 0). Результат не меняется.
+	OptimizeBasic
+	// OptimizeFull — дополнительно балансирует длинные цепочки ассоциативных
+	// операций: a+b+c+d выполняется за 2 раунда задач вместо 3. Порядок сложения
+	// чисел с плавающей точкой меняется, поэтому возможны расхождения в последних знаках.
+	OptimizeFull
+)
+
+var optimizationLevelNames = map[OptimizationLevel]string{
+	OptimizeNone:  "none",
+	OptimizeBasic: "basic",
+	OptimizeFull:  "full",
+}
+
+func (l OptimizationLevel) String() string {
+	if name, ok := optimizationLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("OptimizationLevel(%d)", int(l))
+}
+
+// ParseOptimizationLevel разбирает уровень оптимизации: название (none, basic, full) или номер 0–2.
+func ParseOptimizationLevel(s string) (OptimizationLevel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for level, name := range optimizationLevelNames {
+		if s == name || s == fmt.Sprint(int(level)) {
+			return level, nil
+		}
+	}
+	return OptimizeNone, fmt.Errorf("неизвестный уровень оптимизации %q: ожидается none, basic или full", s)
+}
+
+// Optimize возвращает упрощённую копию дерева; исходное дерево не меняется.
+func Optimize(node *Node, level OptimizationLevel, ops *operations.Registry) *Node {
+	node = node.clone()
+	if level >= OptimizeBasic {
+		node = simplify(node, ops)
+	}
+	if level >= OptimizeFull {
+		node = rebalance(node, ops)
+	}
+	return node
+}
+
+func (n *Node) clone() *Node {
+	if n == nil {
+		return nil
+	}
+	c := &Node{Op: n.Op, Left: n.Left.clone(), Right: n.Right.clone()}
+	if n.Value != nil {
+		v := *n.Value
+		c.Value = &v
+	}
+	return c
+}
+
+func isLiteral(n *Node, v float64) bool {
+	return n != nil && n.Value != nil && *n.Value == v
+}
+
+// simplify применяет точные упрощения снизу вверх.
+func simplify(n *Node, ops *operations.Registry) *Node {
+	if n == nil || n.Value != nil {
+		return n
+	}
+	n.Left = simplify(n.Left, ops)
+	n.Right = simplify(n.Right, ops)
+	if n.Right == nil {
+		return n
+	}
+
+	// Унарный минус парсер представляет как -1*x (см. parseFactor).
+	if n.Op == "*" {
+		if neg, ok := foldNegation(n.Left, n.Right); ok {
+			return neg
+		}
+		if neg, ok := foldNegation(n.Right, n.Left); ok {
+			return neg
+		}
+	}
+
+	op, ok := ops.Lookup(n.Op)
+	if !ok {
+		return n
+	}
+	if op.Identity != nil {
+		if isLiteral(n.Right, *op.Identity) {
+			return n.Left
+		}
+		if op.Commutative && isLiteral(n.Left, *op.Identity) {
+			return n.Right
+		}
+	}
+	// Поглощающий элемент применяется, только если второй операнд — конечное
+	// число: подвыражение может оказаться бесконечностью или ошибкой (1/0*0).
+	if op.Absorbing != nil {
+		z := *op.Absorbing
+		if isLiteral(n.Right, z) && isFinite(n.Left) || op.Commutative && isLiteral(n.Left, z) && isFinite(n.Right) {
+			return &Node{Value: &z}
+		}
+	}
+	return n
+}
+
+// foldNegation сворачивает minusOne*x, если x — число или сам является -1*y.
+func foldNegation(minusOne, x *Node) (*Node, bool) {
+	if !isLiteral(minusOne, -1) {
+		return nil, false
+	}
+	if x.Value != nil {
+		v := -*x.Value
+		return &Node{Value: &v}, true
+	}
+	if x.Op == "*" && x.Right != nil {
+		if isLiteral(x.Left, -1) {
+			return x.Right, true
+		}
+		if isLiteral(x.Right, -1) {
+			return x.Left, true
+		}
+	}
+	return nil, false
+}
+
+func isFinite(n *Node) bool {
+	return n.Value != nil && !math.IsInf(*n.Value, 0) && !math.IsNaN(*n.Value)
+}
+
+// rebalance перестраивает цепочки одной ассоциативной операции в сбалансированные
+// деревья. Порядок операндов сохраняется, поэтому коммутативность не требуется.
+func rebalance(n *Node, ops *operations.Registry) *Node {
+	if n == nil || n.Value != nil {
+		return n
+	}
+	if n.Right == nil {
+		n.Left = rebalance(n.Left, ops)
+		return n
+	}
+	op, ok := ops.Lookup(n.Op)
+	if !ok || !op.Associative {
+		n.Left = rebalance(n.Left, ops)
+		n.Right = rebalance(n.Right, ops)
+		return n
+	}
+
+	operands := chainOperands(n, n.Op, nil)
+	for i := range operands {
+		operands[i] = rebalance(operands[i], ops)
+	}
+	return balancedChain(n.Op, operands)
+}
+
+// chainOperands собирает слева направо операнды цепочки операций op.
+func chainOperands(n *Node, op string, out []*Node) []*Node {
+	if n.Value == nil && n.Op == op && n.Right != nil {
+		out = chainOperands(n.Left, op, out)
+		return chainOperands(n.Right, op, out)
+	}
+	return append(out, n)
+}
+
+func balancedChain(op string, operands []*Node) *Node {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	mid := (len(operands) + 1) / 2
+	return &Node{Op: op, Left: balancedChain(op, operands[:mid]), Right: balancedChain(op, operands[mid:])}
+}
+
+// rounds — число последовательных раундов задач, необходимых для вычисления
+// дерева (длина критического пути).
+func (n *Node) rounds() int {
+	if n == nil || n.Value != nil {
+		return 0
+	}
+	return 1 + max(n.Left.rounds(), n.Right.rounds())
+}
+
+// operationCount — число узлов-операций дерева.
+func (n *Node) operationCount() int {
+	if n == nil || n.Value != nil {
+		return 0
+	}
+	return 1 + n.Left.operationCount() + n.Right.operationCount()
+}
@@ -0,0 +1,70 @@
+package orchestrator
+
+import (
+	"testing"
+
+	"calculator/internal/operations"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input string
+		level OptimizationLevel
+		want  string
+	}{
+		{"2*1+0", OptimizeNone, "((2*1)+0)"},
+		{"(2+3)*1", OptimizeBasic, "(2+3)"},
+		{"0+(2+3)", OptimizeBasic, "(2+3)"},
+		{"(2+3)-0", OptimizeBasic, "(2+3)"},
+		{"0-(2+3)", OptimizeBasic, "(0-(2+3))"},
+		{"(4*2)/1", OptimizeBasic, "(4*2)"},
+		{"-(5*1)", OptimizeBasic, "(-5)"},
+		{"-(-(2+3))", OptimizeBasic, "(2+3)"},
+		{"7*0+1", OptimizeBasic, "1"},
+		{"(1/0)*0", OptimizeBasic, "((1/0)*0)"},
+		{"1+2+3+4", OptimizeBasic, "(((1+2)+3)+4)"},
+		{"1+2+3+4", OptimizeFull, "((1+2)+(3+4))"},
+		{"2*3*4*5*6-7", OptimizeFull, "((((2*3)*4)*(5*6))-7)"},
+		{"1-2-3-4", OptimizeFull, "(((1-2)-3)-4)"},
+	}
+	for _, tc := range tests {
+		ast, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tc.input, err)
+		}
+		before := ast.String()
+		got := Optimize(ast, tc.level, operations.Default).String()
+		if got != tc.want {
+			t.Errorf("Optimize(%q, %s) = %q, want %q", tc.input, tc.level, got, tc.want)
+		}
+		if ast.String() != before {
+			t.Errorf("Optimize(%q) modified the source tree: %q", tc.input, ast.String())
+		}
+	}
+}
+
+func TestOptimizeRounds(t *testing.T) {
+	input := "1+2+3+4+5+6+7+8+9+10+11+12+13+14+15+16"
+	ast, _ := NewParser(input).Parse()
+	if got := ast.rounds(); got != 15 {
+		t.Fatalf("parsed chain rounds = %d, want 15", got)
+	}
+	optimized := Optimize(ast, OptimizeFull, operations.Default)
+	if got := optimized.rounds(); got != 4 {
+		t.Errorf("balanced chain rounds = %d, want 4", got)
+	}
+	if got := optimized.operationCount(); got != 15 {
+		t.Errorf("balanced chain operations = %d, want 15", got)
+	}
+}
+
+func TestParseOptimizationLevel(t *testing.T) {
+	for input, want := range map[string]OptimizationLevel{"none": OptimizeNone, "1": OptimizeBasic, " FULL ": OptimizeFull} {
+		if got, err := ParseOptimizationLevel(input); err != nil || got != want {
+			t.Errorf("ParseOptimizationLevel(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseOptimizationLevel("max"); err == nil {
+		t.Error("unknown level must fail")
+	}
+}
@@ -1,30 +1,117 @@
 package orchestrator
 
 import (
+	"calculator/internal/operations"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 )
 
 type Node struct {
-	Op    string   // Операция (+, -, *, /) или пустая строка для числа
+	Op    string   // Обозначение операции из реестра или пустая строка для числа
 	Value *float64 // Значение, если узел - число (лист дерева)
-	Left  *Node    // Левый дочерний узел
-	Right *Node    // Правый дочерний узел
+	Left  *Node    // Левый дочерний узел (единственный операнд префиксной операции)
+	Right *Node    // Правый дочерний узел; nil у префиксной операции
+}
+
+// ParserLimits ограничивает сложность выражения, чтобы огромный или глубоко
+// вложенный ввод не исчерпал стек и память парсера и планировщика. 0 — без ограничения.
+type ParserLimits struct {
+	MaxLength    int // Длина выражения в байтах
+	MaxDepth     int // Вложенность скобок, унарных минусов и префиксных операций
+	MaxOperators int // Число операций
+}
+
+// DefaultParserLimits действуют для парсеров, созданных NewParser и NewParserWithRegistry.
+var DefaultParserLimits = ParserLimits{
+	MaxLength:    100000,
+	MaxDepth:     200,
+	MaxOperators: 10000,
+}
+
+// Названия ограничений в ExpressionLimitError.Limit.
+const (
+	LimitLength    = "length"
+	LimitDepth     = "depth"
+	LimitOperators = "operators"
+)
+
+// ExpressionLimitError — выражение превышает ParserLimits.
+type ExpressionLimitError struct {
+	Limit string
+	Max   int
+}
+
+func (e *ExpressionLimitError) Error() string {
+	switch e.Limit {
+	case LimitLength:
+		return fmt.Sprintf("выражение длиннее %d символов", e.Max)
+	case LimitDepth:
+		return fmt.Sprintf("вложенность выражения больше %d уровней", e.Max)
+	default:
+		return fmt.Sprintf("в выражении больше %d операций", e.Max)
+	}
+}
+
+// HTTPStatus — код ответа HTTP: 413 для длины, 422 для вложенности и числа операций.
+func (e *ExpressionLimitError) HTTPStatus() int {
+	if e.Limit == LimitLength {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusUnprocessableEntity
 }
 
 type Parser struct {
-	input string
-	pos   int
-	ch    byte
+	input  string
+	pos    int
+	ch     byte
+	ops    *operations.Registry
+	limits ParserLimits
+
+	depth     int // Текущая вложенность
+	operators int // Разобрано операций
 }
 
+// NewParser создаёт парсер, понимающий операции реестра operations.Default.
 func NewParser(input string) *Parser {
-	p := &Parser{input: input, pos: -1}
+	return NewParserWithRegistry(input, operations.Default)
+}
+
+// NewParserWithRegistry создаёт парсер с заданным реестром операций.
+func NewParserWithRegistry(input string, ops *operations.Registry) *Parser {
+	p := &Parser{input: input, pos: -1, ops: ops, limits: DefaultParserLimits}
 	p.next()
 	return p
 }
 
+// SetLimits заменяет ограничения сложности выражения.
+func (p *Parser) SetLimits(limits ParserLimits) {
+	p.limits = limits
+}
+
+// enter увеличивает вложенность; вызывающий обязан вызвать leave.
+func (p *Parser) enter() error {
+	p.depth++
+	if p.limits.MaxDepth > 0 && p.depth > p.limits.MaxDepth {
+		return &ExpressionLimitError{Limit: LimitDepth, Max: p.limits.MaxDepth}
+	}
+	return nil
+}
+
+func (p *Parser) leave() {
+	p.depth--
+}
+
+// countOperator учитывает очередную операцию.
+func (p *Parser) countOperator() error {
+	p.operators++
+	if p.limits.MaxOperators > 0 && p.operators > p.limits.MaxOperators {
+		return &ExpressionLimitError{Limit: LimitOperators, Max: p.limits.MaxOperators}
+	}
+	return nil
+}
+
 
 func (p *Parser) next() {
 	p.pos++
@@ -43,10 +130,14 @@ func (p *Parser) skipWhitespace() {
 
 
 func (p *Parser) Parse() (*Node, error) {
+	if p.limits.MaxLength > 0 && len(p.input) > p.limits.MaxLength {
+		return nil, &ExpressionLimitError{Limit: LimitLength, Max: p.limits.MaxLength}
+	}
 	if len(strings.TrimSpace(p.input)) == 0 {
 		return nil, fmt.Errorf("пустое выражение")
 	}
 	p.pos = -1 // Сброс перед началом
+	p.depth, p.operators = 0, 0
 	p.next()
 
 	node, err := p.parseExpression()
@@ -62,67 +153,79 @@ func (p *Parser) Parse() (*Node, error) {
 	return node, nil
 }
 
+// advance пропускает n символов.
+func (p *Parser) advance(n int) {
+	for i := 0; i < n; i++ {
+		p.next()
+	}
+}
+
+// peekOperator ищет в текущей позиции операцию заданной арности.
+func (p *Parser) peekOperator(arity int) (operations.Operation, bool) {
+	if p.pos >= len(p.input) {
+		return operations.Operation{}, false
+	}
+	return p.ops.Match(p.input[p.pos:], arity)
+}
+
 func (p *Parser) parseExpression() (*Node, error) {
-	left, err := p.parseTerm()
+	node, err := p.parseBinary(0)
 	if err != nil {
 		return nil, err
 	}
-	if p.pos < len(p.input) && left == nil {
-		if p.ch == '+' || p.ch == '-' || p.ch == '/' || p.ch == '*' || p.ch == ')' {
+	if p.pos < len(p.input) && node == nil {
+		if op, ok := p.peekOperator(2); ok {
+			return nil, fmt.Errorf("ожидался операнд перед '%s'", op.Symbol)
+		}
+		if p.ch == ')' {
 			return nil, fmt.Errorf("ожидался операнд перед '%c'", p.ch)
 		}
 		return nil, fmt.Errorf("некорректное выражение, ожидался операнд")
 	}
-
-	for {
-		p.skipWhitespace()
-		if p.ch == '+' || p.ch == '-' {
-			op := string(p.ch)
-			p.next()
-			right, err := p.parseTerm()
-			if err != nil {
-				return nil, err
-			}
-			if right == nil {
-				return nil, fmt.Errorf("ожидался операнд после '%s'", op)
-			}
-			left = &Node{
-				Op:    op,
-				Left:  left,
-				Right: right,
-			}
-		} else {
-			break
-		}
-	}
-	return left, nil
+	return node, nil
 }
 
-func (p *Parser) parseTerm() (*Node, error) {
+// parseBinary разбирает цепочку бинарных операций с приоритетом не ниже minPrec
+// (precedence climbing). Приоритет и ассоциативность берутся из реестра операций.
+func (p *Parser) parseBinary(minPrec int) (*Node, error) {
 	left, err := p.parseFactor()
-	if err != nil {
-		return nil, err
+	if err != nil || left == nil {
+		return left, err
 	}
 
 	for {
 		p.skipWhitespace()
-		if p.ch == '*' || p.ch == '/' {
-			op := string(p.ch)
-			p.next()
-			right, err := p.parseFactor()
-			if err != nil {
+		op, ok := p.peekOperator(2)
+		if !ok || op.Precedence < minPrec {
+			break
+		}
+		p.advance(len(op.Symbol))
+		if err := p.countOperator(); err != nil {
+			return nil, err
+		}
+
+		nextPrec := op.Precedence + 1
+		if op.Assoc == operations.RightAssoc {
+			// Правоассоциативная цепочка разбирается рекурсией, её длина — тоже вложенность.
+			nextPrec = op.Precedence
+			if err := p.enter(); err != nil {
 				return nil, err
 			}
-			if right == nil {
-				return nil, fmt.Errorf("ожидался операнд после '%s'", op)
-			}
-			left = &Node{
-				Op:    op,
-				Left:  left,
-				Right: right,
-			}
-		} else {
-			break
+		}
+		right, err := p.parseBinary(nextPrec)
+		if op.Assoc == operations.RightAssoc {
+			p.leave()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, fmt.Errorf("ожидался операнд после '%s'", op.Symbol)
+		}
+		left = &Node{
+			Op:    op.Symbol,
+			Left:  left,
+			Right: right,
 		}
 	}
 	return left, nil
@@ -133,7 +236,11 @@ func (p *Parser) parseFactor() (*Node, error) {
 
 	if p.ch == '-' {
 		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
 		factor, err := p.parseFactor()
+		p.leave()
 		if err != nil {
 			return nil, err
 		}
@@ -144,6 +251,9 @@ func (p *Parser) parseFactor() (*Node, error) {
 			*factor.Value = -(*factor.Value)
 			return factor, nil
 		} else {
+			if err := p.countOperator(); err != nil {
+				return nil, err
+			}
 			minusOne := -1.0
 			return &Node{
 				Op:    "*",
@@ -153,9 +263,33 @@ func (p *Parser) parseFactor() (*Node, error) {
 		}
 	}
 
+	// Префиксные операции из реестра связывают ближайший множитель.
+	if op, ok := p.peekOperator(1); ok {
+		p.advance(len(op.Symbol))
+		if err := p.countOperator(); err != nil {
+			return nil, err
+		}
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseFactor()
+		p.leave()
+		if err != nil {
+			return nil, err
+		}
+		if operand == nil {
+			return nil, fmt.Errorf("ожидался операнд после '%s'", op.Symbol)
+		}
+		return &Node{Op: op.Symbol, Left: operand}, nil
+	}
+
 	if p.ch == '(' {
 		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
 		node, err := p.parseExpression() // Рекурсия для выражения в скобках
+		p.leave()
 		if err != nil {
 			return nil, err
 		}
@@ -203,5 +337,23 @@ func (n *Node) String() string {
 		}
 		return s
 	}
+	if n.Right == nil {
+		return fmt.Sprintf("(%s%s)", n.Op, n.Left.String())
+	}
 	return fmt.Sprintf("(%s%s%s)", n.Left.String(), n.Op, n.Right.String())
+}
+
+// taskArgs возвращает аргументы задачи для узла-операции, если все его операнды
+// уже вычислены. У префиксной операции второй аргумент равен нулю.
+func (n *Node) taskArgs() (arg1, arg2 float64, ready bool) {
+	if n.Op == "" || n.Left == nil || n.Left.Value == nil {
+		return 0, 0, false
+	}
+	if n.Right == nil {
+		return *n.Left.Value, 0, true
+	}
+	if n.Right.Value == nil {
+		return 0, 0, false
+	}
+	return *n.Left.Value, *n.Right.Value, true
 }
\ No newline at end of file
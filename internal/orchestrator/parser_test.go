@@ -1,7 +1,12 @@
 package orchestrator
 
 import (
+	"errors"
+	"math"
+	"strings"
 	"testing"
+
+	"calculator/internal/operations"
 )
 
 func TestParserAST(t *testing.T) {
@@ -24,4 +29,100 @@ func TestParserAST(t *testing.T) {
 			t.Errorf("Parse(%q).String() = %q, want %q", tc.input, got, tc.want)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestParserCustomOperations(t *testing.T) {
+	ops := operations.NewRegistry()
+	for _, op := range operations.Default.All() {
+		ops.MustRegister(op)
+	}
+	ops.MustRegister(operations.Operation{
+		Symbol: "^", Arity: 2, Precedence: 30, Assoc: operations.RightAssoc,
+		Eval: func(a ...float64) (float64, error) { return math.Pow(a[0], a[1]), nil },
+	})
+	ops.MustRegister(operations.Operation{
+		Symbol: "sqrt", Arity: 1,
+		Eval: func(a ...float64) (float64, error) { return math.Sqrt(a[0]), nil },
+	})
+
+	tests := []struct{ input, want string }{
+		{"2^3^2", "(2^(3^2))"},
+		{"2*3^2", "(2*(3^2))"},
+		{"sqrt 4 + 1", "((sqrt4)+1)"},
+		{"sqrt(2*8)", "(sqrt(2*8))"},
+	}
+	for _, tc := range tests {
+		node, err := NewParserWithRegistry(tc.input, ops).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tc.input, err)
+			continue
+		}
+		if got := node.String(); got != tc.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+
+	if _, err := NewParser("2^3").Parse(); err == nil {
+		t.Error("default registry must not know '^'")
+	}
+	if _, err := NewParserWithRegistry("2^", ops).Parse(); err == nil {
+		t.Error("missing operand must fail")
+	}
+}
+
+func TestParserLimits(t *testing.T) {
+	limits := ParserLimits{MaxLength: 1000, MaxDepth: 10, MaxOperators: 20}
+	tests := []struct {
+		input string
+		limit string // Пусто — выражение укладывается в ограничения
+	}{
+		{strings.Repeat("(", 10) + "1" + strings.Repeat(")", 10), ""},
+		{strings.Repeat("(", 11) + "1" + strings.Repeat(")", 11), LimitDepth},
+		{strings.Repeat("-", 11) + "1", LimitDepth},
+		{"1" + strings.Repeat("+1", 20), ""},
+		{"1" + strings.Repeat("+1", 21), LimitOperators},
+		{strings.Repeat(" ", 1000) + "1", LimitLength},
+	}
+	for _, tc := range tests {
+		p := NewParser(tc.input)
+		p.SetLimits(limits)
+		_, err := p.Parse()
+		var limitErr *ExpressionLimitError
+		switch {
+		case tc.limit == "" && err != nil:
+			t.Errorf("Parse(%.30q) returned error: %v", tc.input, err)
+		case tc.limit != "" && (!errors.As(err, &limitErr) || limitErr.Limit != tc.limit):
+			t.Errorf("Parse(%.30q) = %v, want %s limit error", tc.input, err, tc.limit)
+		}
+	}
+
+	// С ограничениями по умолчанию огромная вложенность отклоняется, а не переполняет стек.
+	for _, input := range []string{strings.Repeat("(", 50000) + "1" + strings.Repeat(")", 50000), strings.Repeat("-", 1000000) + "1"} {
+		var limitErr *ExpressionLimitError
+		if _, err := NewParser(input).Parse(); !errors.As(err, &limitErr) {
+			t.Errorf("Parse of %d bytes: got %v, want limit error", len(input), err)
+		}
+	}
+}
+
+func FuzzParserParse(f *testing.F) {
+	for _, seed := range []string{"2+2", "(1+2)*3", "-5+10", "--(-(3))", "4*(3-1)/0", " 7 - 2 / 1 ", "1..2", "((", "-", "2*", "1e5", ")("} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		limits := ParserLimits{MaxLength: 1000, MaxDepth: 50, MaxOperators: 100}
+		p := NewParser(input)
+		p.SetLimits(limits)
+		node, err := p.Parse()
+		if err != nil {
+			return
+		}
+		if node == nil {
+			t.Fatalf("Parse(%q) returned nil node without error", input)
+		}
+		if n := node.operationCount(); n > limits.MaxOperators {
+			t.Fatalf("Parse(%q) produced %d operations, limit %d", input, n, limits.MaxOperators)
+		}
+		_ = node.String()
+		Optimize(node, OptimizeFull, operations.Default)
+	})
+}
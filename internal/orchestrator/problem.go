@@ -0,0 +1,163 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Стабильные коды ошибок HTTP API. Клиенты различают ошибки по Problem.Code:
+// тексты сообщений могут меняться, коды — нет.
+const (
+	CodeBadRequest       = "bad_request"         // Некорректные параметры запроса
+	CodeInvalidJSON      = "invalid_json"        // Тело запроса не разбирается как JSON
+	CodePayloadTooLarge  = "payload_too_large"   // Тело запроса больше maxRequestBodyBytes
+	CodeUnauthorized     = "unauthorized"        // Нет токена или он недействителен
+	CodeBadCredentials   = "invalid_credentials" // Неверный логин или пароль
+	CodeForbidden        = "forbidden"           // Недостаточно прав
+	CodeNotFound         = "not_found"           // Запись не найдена или принадлежит другому пользователю
+	CodeNotConfigured    = "not_configured"      // Функция выключена в конфигурации оркестратора
+	CodeMethodNotAllowed = "method_not_allowed"  // Метод не поддерживается этим путём
+	CodeUserExists       = "user_exists"         // Логин уже занят
+	CodeParseError       = "parse_error"         // Выражение не разбирается
+	CodeExpressionLimit  = "expression_too_complex"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeInternal         = "internal"
+)
+
+// problemContentType — тип ответа об ошибке по RFC 7807.
+const problemContentType = "application/problem+json"
+
+// problemTitles — краткие описания кодов на поддерживаемых языках; первый язык — по умолчанию.
+var problemTitles = map[string][2]string{
+	CodeBadRequest:       {"Некорректный запрос", "Bad request"},
+	CodeInvalidJSON:      {"Некорректный JSON", "Invalid JSON"},
+	CodePayloadTooLarge:  {"Слишком большое тело запроса", "Payload too large"},
+	CodeUnauthorized:     {"Требуется аутентификация", "Authentication required"},
+	CodeBadCredentials:   {"Неверный логин или пароль", "Invalid login or password"},
+	CodeForbidden:        {"Доступ запрещён", "Forbidden"},
+	CodeNotFound:         {"Не найдено", "Not found"},
+	CodeNotConfigured:    {"Функция не настроена", "Feature not configured"},
+	CodeMethodNotAllowed: {"Метод не разрешен", "Method not allowed"},
+	CodeUserExists:       {"Пользователь уже существует", "User already exists"},
+	CodeParseError:       {"Ошибка разбора выражения", "Expression parse error"},
+	CodeExpressionLimit:  {"Выражение слишком сложное", "Expression too complex"},
+	CodeQuotaExceeded:    {"Лимит превышен", "Quota exceeded"},
+	CodeInternal:         {"Внутренняя ошибка сервера", "Internal server error"},
+}
+
+// Problem — ответ об ошибке в формате RFC 7807. Title зависит только от Code и
+// языка клиента (Accept-Language), Detail уточняет конкретный случай.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code"`
+	Details  interface{} `json:"details,omitempty"` // Машиночитаемые подробности, зависят от кода
+}
+
+// LimitDetails — подробности ошибок expression_too_complex и quota_exceeded.
+type LimitDetails struct {
+	Limit             string `json:"limit"`
+	Max               int    `json:"max,omitempty"`
+	RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+}
+
+// writeProblem отвечает ошибкой status с кодом code и пояснением detail.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	renderProblem(w, r, Problem{Status: status, Code: code, Detail: detail})
+}
+
+// WriteStatusProblem отвечает ошибкой status без подробностей. Через неё ошибки
+// других обработчиков оркестратора (веб-интерфейса) выглядят так же, как ошибки API.
+func WriteStatusProblem(w http.ResponseWriter, r *http.Request, status int) {
+	code := CodeInternal
+	switch status {
+	case http.StatusBadRequest:
+		code = CodeBadRequest
+	case http.StatusNotFound:
+		code = CodeNotFound
+	case http.StatusMethodNotAllowed:
+		code = CodeMethodNotAllowed
+	}
+	writeProblem(w, r, status, code, "")
+}
+
+// writeError отвечает ошибкой, соответствующей err: известные ошибки хранилища,
+// планировщика и лимитов получают свой код, остальные — 500 без подробностей
+// (их текст может раскрыть устройство сервера, поэтому его нужно залогировать заранее).
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		limitErr *ExpressionLimitError
+		quotaErr *QuotaError
+	)
+	switch {
+	case errors.As(err, &limitErr):
+		renderProblem(w, r, Problem{
+			Status: limitErr.HTTPStatus(), Code: CodeExpressionLimit, Detail: limitErr.Error(),
+			Details: LimitDetails{Limit: limitErr.Limit, Max: limitErr.Max},
+		})
+	case errors.As(err, &quotaErr):
+		details := LimitDetails{Limit: quotaErr.Limit}
+		if quotaErr.RetryAfter > 0 {
+			details.RetryAfterSeconds = int(math.Ceil(quotaErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(details.RetryAfterSeconds))
+		}
+		renderProblem(w, r, Problem{Status: quotaErr.HTTPStatus(), Code: CodeQuotaExceeded, Detail: quotaErr.Message, Details: details})
+	case errors.Is(err, database.ErrUserExists):
+		writeProblem(w, r, http.StatusConflict, CodeUserExists, err.Error())
+	case errors.Is(err, database.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, err.Error())
+	default:
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+	}
+}
+
+// renderProblem дополняет p типом, заголовком на языке клиента и путём запроса и записывает ответ.
+func renderProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	lang := problemLanguage(r)
+	titles, ok := problemTitles[p.Code]
+	if !ok {
+		titles = problemTitles[CodeInternal]
+	}
+	p.Title = titles[0]
+	if lang == "en" {
+		p.Title = titles[1]
+	}
+	p.Type = "urn:calculator:problem:" + p.Code
+	if r != nil {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("Ошибка записи ответа об ошибке: %v", err)
+	}
+}
+
+// problemLanguage выбирает язык заголовка ошибки по Accept-Language: первый из
+// перечисленных клиентом поддерживаемых языков (ru или en), по умолчанию ru.
+// Веса q не учитываются — браузеры перечисляют языки по убыванию предпочтения.
+func problemLanguage(r *http.Request) string {
+	if r == nil {
+		return "ru"
+	}
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(strings.TrimSpace(tag), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if lang == "ru" || lang == "en" {
+			return lang
+		}
+	}
+	return "ru"
+}
@@ -0,0 +1,75 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemResponses(t *testing.T) {
+	h := setupHandlers(t)
+	serve := func(handler http.HandlerFunc, method, path, body, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	if rec := serve(h.RegisterHandler, http.MethodPost, "/api/v1/register", `{"login":"user","password":"pass123"}`, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("register: got %d body=%s", rec.Code, rec.Body.String())
+	}
+	protected := h.auth.JWTMiddleware(http.HandlerFunc(h.CalculateHandler)).ServeHTTP
+	token, _ := h.auth.GenerateJWT(1)
+	missing := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/999", nil)
+	missing.Header.Set("Authorization", "Bearer "+token)
+	missingRec := httptest.NewRecorder()
+	serveAuthenticated(h, h.ExpressionsHandler, missingRec, missing)
+	// Неизвестные пути /api/v1/ попадают в обработчик веб-интерфейса, который отвечает через WriteStatusProblem.
+	unknownRec := httptest.NewRecorder()
+	WriteStatusProblem(unknownRec, httptest.NewRequest(http.MethodGet, "/api/v1/unknown", nil), http.StatusNotFound)
+
+	tests := []struct {
+		name   string
+		rec    *httptest.ResponseRecorder
+		status int
+		code   string
+		title  string
+		lang   string
+	}{
+		{"duplicate login", serve(h.RegisterHandler, http.MethodPost, "/api/v1/register", `{"login":"user","password":"pass123"}`, ""),
+			http.StatusConflict, CodeUserExists, "Пользователь уже существует", "ru"},
+		// Заголовок переводится по первому поддерживаемому языку из Accept-Language.
+		{"wrong password in English", serve(h.LoginHandler, http.MethodPost, "/api/v1/login", `{"login":"user","password":"wrong-password"}`, "de-DE, en-US;q=0.8, ru;q=0.5"),
+			http.StatusUnauthorized, CodeBadCredentials, "Invalid login or password", "en"},
+		{"invalid json", serve(h.LoginHandler, http.MethodPost, "/api/v1/login", `{"login":`, "ru-RU"),
+			http.StatusBadRequest, CodeInvalidJSON, "Некорректный JSON", "ru"},
+		{"method not allowed", serve(h.CalculateHandler, http.MethodGet, "/api/v1/calculate", "", ""),
+			http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Метод не разрешен", "ru"},
+		{"middleware without token", serve(protected, http.MethodPost, "/api/v1/calculate", `{"expression":"2+2"}`, ""),
+			http.StatusUnauthorized, CodeUnauthorized, "Требуется аутентификация", "ru"},
+		{"unknown expression", missingRec, http.StatusNotFound, CodeNotFound, "Не найдено", "ru"},
+		{"unknown path", unknownRec, http.StatusNotFound, CodeNotFound, "Не найдено", "ru"},
+	}
+	for _, tc := range tests {
+		if tc.rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, tc.rec.Code, tc.status)
+		}
+		if ct := tc.rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: Content-Type %q", tc.name, ct)
+		}
+		if lang := tc.rec.Header().Get("Content-Language"); lang != tc.lang {
+			t.Errorf("%s: Content-Language %q, want %q", tc.name, lang, tc.lang)
+		}
+		var p Problem
+		if err := json.NewDecoder(tc.rec.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: decode problem: %v", tc.name, err)
+		}
+		if p.Status != tc.status || p.Code != tc.code || p.Title != tc.title || p.Type != "urn:calculator:problem:"+tc.code || !strings.HasPrefix(p.Instance, "/api/v1/") {
+			t.Errorf("%s: unexpected problem %+v", tc.name, p)
+		}
+	}
+}
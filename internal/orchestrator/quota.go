@@ -0,0 +1,299 @@
+package orchestrator
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"calculator/internal/database"
+	"calculator/internal/operations"
+)
+
+// QuotaLimits — лимиты пользователя на отправку выражений. 0 — ограничения нет.
+type QuotaLimits struct {
+	SubmissionsPerMinute int `json:"submissions_per_minute" yaml:"submissions_per_minute"`
+	MaxActiveExpressions int `json:"max_active_expressions" yaml:"max_active_expressions"` // Выражения в статусах pending и in_progress
+	MaxASTNodes          int `json:"max_ast_nodes" yaml:"max_ast_nodes"`                   // Числа и операции дерева выражения
+	MaxExpressionLength  int `json:"max_expression_length" yaml:"max_expression_length"`   // В байтах
+}
+
+// DefaultQuotaLimits — лимиты, действующие, пока администратор не задал пользователю свои.
+var DefaultQuotaLimits = QuotaLimits{
+	SubmissionsPerMinute: 60,
+	MaxActiveExpressions: 20,
+	MaxASTNodes:          1000,
+	MaxExpressionLength:  10000,
+}
+
+// Названия лимитов в QuotaError.Limit.
+const (
+	QuotaSubmissionsPerMinute = "submissions_per_minute"
+	QuotaMaxActiveExpressions = "max_active_expressions"
+	QuotaMaxASTNodes          = "max_ast_nodes"
+	QuotaMaxExpressionLength  = "max_expression_length"
+)
+
+// activeRetryAfter — сколько предлагать подождать, когда превышено число активных выражений.
+const activeRetryAfter = 5 * time.Second
+
+const quotaWindow = time.Minute
+
+// QuotaError — выражение отклонено из-за лимита. RetryAfter > 0, если повтор
+// позже может пройти; лимиты на размер выражения от времени не зависят.
+type QuotaError struct {
+	Limit      string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+// HTTPStatus — код ответа HTTP для ошибки: 429 для любого лимита пользователя.
+// У лимитов на размер выражения нет RetryAfter: повтор того же выражения не пройдёт.
+func (e *QuotaError) HTTPStatus() int {
+	return http.StatusTooManyRequests
+}
+
+// QuotaUsage — текущее потребление лимитов пользователем.
+type QuotaUsage struct {
+	SubmissionsLastMinute int `json:"submissions_last_minute"`
+	ActiveExpressions     int `json:"active_expressions"`
+}
+
+// Quotas проверяет лимиты пользователей при отправке выражений. Отправки за
+// последнюю минуту учитываются в памяти (скользящее окно) и после перезапуска
+// оркестратора считаются заново.
+type Quotas struct {
+	db       database.Storage
+	ops      *operations.Registry
+	defaults QuotaLimits
+
+	mu          sync.Mutex
+	submissions map[int64][]time.Time // Время отправок за последнюю минуту, по возрастанию
+	reserved    map[int64]int         // Допущенные, но ещё не сохранённые выражения
+	users       map[int64]*userQuotaLock
+	now         func() time.Time
+}
+
+// userQuotaLock упорядочивает резервы одного пользователя: подсчёт его активных
+// выражений в БД идёт под ней, а не под общей q.mu, и не задерживает других.
+type userQuotaLock struct {
+	mu   sync.Mutex
+	refs int // Сколько вызовов держат или ждут блокировку; при 0 она удаляется из q.users
+}
+
+// QuotaReservation — место под выражение, допущенное Quotas.Reserve. Пока выражение
+// сохраняется, резерв учитывается в лимитах частоты и числа активных выражений,
+// так что параллельные запросы не проходят лимит все сразу.
+type QuotaReservation struct {
+	q      *Quotas
+	userID int64
+	done   bool
+}
+
+func NewQuotas(db database.Storage, ops *operations.Registry, defaults QuotaLimits) *Quotas {
+	return &Quotas{
+		db:          db,
+		ops:         ops,
+		defaults:    defaults,
+		submissions: make(map[int64][]time.Time),
+		reserved:    make(map[int64]int),
+		users:       make(map[int64]*userQuotaLock),
+		now:         time.Now,
+	}
+}
+
+// Defaults возвращает лимиты по умолчанию.
+func (q *Quotas) Defaults() QuotaLimits {
+	return q.defaults
+}
+
+// Limits возвращает действующие лимиты пользователя с учётом заданных администратором.
+func (q *Quotas) Limits(userID int64) (QuotaLimits, error) {
+	override, err := q.db.GetUserQuota(userID)
+	if err != nil {
+		return q.defaults, err
+	}
+	return q.defaults.apply(override), nil
+}
+
+func (l QuotaLimits) apply(o *database.UserQuota) QuotaLimits {
+	if o == nil {
+		return l
+	}
+	set := func(dst *int, v sql.NullInt64) {
+		if v.Valid {
+			*dst = int(v.Int64)
+		}
+	}
+	set(&l.SubmissionsPerMinute, o.SubmissionsPerMinute)
+	set(&l.MaxActiveExpressions, o.MaxActiveExpressions)
+	set(&l.MaxASTNodes, o.MaxASTNodes)
+	set(&l.MaxExpressionLength, o.MaxExpressionLength)
+	return l
+}
+
+// Reserve проверяет, может ли пользователь отправить выражение, и резервирует под
+// него место. Возвращает *QuotaError, если лимит превышен. Резерв завершается
+// Commit после сохранения выражения или Cancel, если сохранить не удалось.
+func (q *Quotas) Reserve(userID int64, expression string) (*QuotaReservation, error) {
+	limits, err := q.Limits(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits.MaxExpressionLength > 0 && len(expression) > limits.MaxExpressionLength {
+		return nil, &QuotaError{
+			Limit:   QuotaMaxExpressionLength,
+			Message: fmt.Sprintf("выражение длиннее %d символов", limits.MaxExpressionLength),
+		}
+	}
+	if limits.MaxASTNodes > 0 {
+		// Некорректное выражение пропускается: ошибку разбора пользователь увидит в статусе выражения.
+		if ast, err := NewParserWithRegistry(expression, q.ops).Parse(); err == nil {
+			if nodes := ast.nodeCount(); nodes > limits.MaxASTNodes {
+				return nil, &QuotaError{
+					Limit:   QuotaMaxASTNodes,
+					Message: fmt.Sprintf("выражение содержит %d чисел и операций при лимите %d", nodes, limits.MaxASTNodes),
+				}
+			}
+		}
+	}
+
+	// Пока идёт подсчёт, резервы пользователя не создаются и не завершаются, поэтому
+	// сохранённое выражение учитывается либо в БД, либо в q.reserved. Сразу после
+	// сохранения выражение может быть учтено дважды — это лишь строже лимита.
+	unlock := q.lockUser(userID)
+	defer unlock()
+	active := 0
+	if limits.MaxActiveExpressions > 0 {
+		if active, err = q.db.CountActiveExpressions(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reserved := q.reserved[userID]
+	if limits.MaxActiveExpressions > 0 {
+		if active+reserved >= limits.MaxActiveExpressions {
+			return nil, &QuotaError{
+				Limit:      QuotaMaxActiveExpressions,
+				Message:    fmt.Sprintf("одновременно может вычисляться не больше %d выражений", limits.MaxActiveExpressions),
+				RetryAfter: activeRetryAfter,
+			}
+		}
+	}
+
+	now := q.now()
+	recent := q.recentLocked(userID, now)
+	if limits.SubmissionsPerMinute > 0 && len(recent)+reserved >= limits.SubmissionsPerMinute {
+		// Место освободится, когда из окна выйдет столько отправок, сколько в нём лишних;
+		// если лишние — одни незавершённые резервы, предлагается подождать всё окно.
+		retryAfter := quotaWindow
+		if n := len(recent) + reserved - limits.SubmissionsPerMinute; n < len(recent) {
+			retryAfter = recent[n].Add(quotaWindow).Sub(now)
+		}
+		return nil, &QuotaError{
+			Limit:      QuotaSubmissionsPerMinute,
+			Message:    fmt.Sprintf("превышен лимит: %d выражений в минуту", limits.SubmissionsPerMinute),
+			RetryAfter: retryAfter,
+		}
+	}
+	q.reserved[userID]++
+	return &QuotaReservation{q: q, userID: userID}, nil
+}
+
+// Commit учитывает отправку сохранённого выражения в лимите частоты и снимает резерв.
+func (r *QuotaReservation) Commit() {
+	r.finish(true)
+}
+
+// Cancel снимает резерв, не расходуя лимит частоты: выражение не сохранено.
+func (r *QuotaReservation) Cancel() {
+	r.finish(false)
+}
+
+func (r *QuotaReservation) finish(submitted bool) {
+	q := r.q
+	unlock := q.lockUser(r.userID)
+	defer unlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	q.reserved[r.userID]--
+	if q.reserved[r.userID] <= 0 {
+		delete(q.reserved, r.userID)
+	}
+	if submitted {
+		now := q.now()
+		q.submissions[r.userID] = append(q.recentLocked(r.userID, now), now)
+	}
+}
+
+// lockUser берёт блокировку резервов пользователя и возвращает функцию её снятия.
+func (q *Quotas) lockUser(userID int64) func() {
+	q.mu.Lock()
+	l := q.users[userID]
+	if l == nil {
+		l = &userQuotaLock{}
+		q.users[userID] = l
+	}
+	l.refs++
+	q.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		q.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(q.users, userID)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// recentLocked отбрасывает отправки старше минуты. Вызывается под q.mu.
+func (q *Quotas) recentLocked(userID int64, now time.Time) []time.Time {
+	recent := q.submissions[userID]
+	cutoff := now.Add(-quotaWindow)
+	i := 0
+	for i < len(recent) && !recent[i].After(cutoff) {
+		i++
+	}
+	recent = recent[i:]
+	if len(recent) == 0 {
+		delete(q.submissions, userID)
+		return nil
+	}
+	q.submissions[userID] = recent
+	return recent
+}
+
+// Usage возвращает текущее потребление лимитов пользователем.
+func (q *Quotas) Usage(userID int64) (QuotaUsage, error) {
+	active, err := q.db.CountActiveExpressions(userID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	q.mu.Lock()
+	submissions := len(q.recentLocked(userID, q.now()))
+	q.mu.Unlock()
+	return QuotaUsage{SubmissionsLastMinute: submissions, ActiveExpressions: active}, nil
+}
+
+// nodeCount — число узлов дерева: чисел и операций.
+func (n *Node) nodeCount() int {
+	if n == nil {
+		return 0
+	}
+	return 1 + n.Left.nodeCount() + n.Right.nodeCount()
+}
@@ -0,0 +1,273 @@
+package orchestrator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"calculator/internal/database"
+	"calculator/internal/operations"
+)
+
+func TestQuotasReserve(t *testing.T) {
+	store, _ := newTestStore(t)
+	quotas := NewQuotas(store, operations.Default, QuotaLimits{SubmissionsPerMinute: 2, MaxASTNodes: 5, MaxExpressionLength: 20})
+	now := time.Unix(1000, 0)
+	quotas.now = func() time.Time { return now }
+	uid, _ := store.CreateUser("u", "hash")
+
+	// admit резервирует место и сразу подтверждает его, как после сохранения выражения.
+	admit := func(uid int64, expression string) error {
+		reservation, err := quotas.Reserve(uid, expression)
+		if err == nil {
+			reservation.Commit()
+		}
+		return err
+	}
+	limitOf := func(err error) (string, time.Duration) {
+		t.Helper()
+		var qe *QuotaError
+		if !errors.As(err, &qe) {
+			t.Fatalf("expected *QuotaError, got %v", err)
+		}
+		return qe.Limit, qe.RetryAfter
+	}
+
+	if err := admit(uid, "1+2"); err != nil {
+		t.Fatalf("first submission: %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	if err := admit(uid, "1+2"); err != nil {
+		t.Fatalf("second submission: %v", err)
+	}
+	now = now.Add(10 * time.Second)
+	if limit, retry := limitOf(admit(uid, "1+2")); limit != QuotaSubmissionsPerMinute || retry != 30*time.Second {
+		t.Fatalf("rate limit: got %s retry %v, want %s retry 30s", limit, retry, QuotaSubmissionsPerMinute)
+	}
+	now = now.Add(31 * time.Second)
+	if err := admit(uid, "1+2"); err != nil {
+		t.Fatalf("submission after the window slid: %v", err)
+	}
+	if usage, _ := quotas.Usage(uid); usage.SubmissionsLastMinute != 2 {
+		t.Errorf("usage = %+v, want 2 submissions in the last minute", usage)
+	}
+
+	if limit, retry := limitOf(admit(uid, "1+2+3+4")); limit != QuotaMaxASTNodes || retry != 0 {
+		t.Fatalf("node limit: got %s retry %v", limit, retry)
+	}
+	if limit, _ := limitOf(admit(uid, strings.Repeat(" ", 20)+"1")); limit != QuotaMaxExpressionLength {
+		t.Fatalf("length limit: got %s", limit)
+	}
+
+	// Лимиты, заданные пользователю, заменяют значения по умолчанию.
+	store.CreateExpression(uid, "1+2")
+	store.SaveUserQuota(database.UserQuota{
+		UserID:               uid,
+		SubmissionsPerMinute: sql.NullInt64{Int64: 0, Valid: true},
+		MaxActiveExpressions: sql.NullInt64{Int64: 1, Valid: true},
+	})
+	limits, _ := quotas.Limits(uid)
+	if limits.SubmissionsPerMinute != 0 || limits.MaxActiveExpressions != 1 || limits.MaxASTNodes != 5 {
+		t.Fatalf("effective limits = %+v", limits)
+	}
+	if limit, retry := limitOf(admit(uid, "1+2")); limit != QuotaMaxActiveExpressions || retry <= 0 {
+		t.Fatalf("active limit: got %s retry %v", limit, retry)
+	}
+}
+
+func TestCalculateHandlerQuota(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	scheduler.SetQuotas(NewQuotas(store, operations.Default, QuotaLimits{SubmissionsPerMinute: 1}))
+	authService := NewAuthService(store, "testsecret")
+	h := NewHTTPHandlers(authService, store, scheduler)
+	admin := authService.AdminMiddleware(http.HandlerFunc(NewAdminHandlers(store, scheduler).QuotasHandler))
+	uid, _ := store.CreateUser("user", "hash")
+	adminID, _ := store.CreateUser("root", "hash")
+	store.SetUserAdmin(adminID, true)
+	userJWT, _ := authService.GenerateJWT(uid)
+	adminJWT, _ := authService.GenerateJWT(adminID)
+
+	calculate := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"2+2"}`))
+		req.Header.Set("Authorization", "Bearer "+userJWT)
+		rec := httptest.NewRecorder()
+		serveAuthenticated(h, h.CalculateHandler, rec, req)
+		return rec
+	}
+	if rec := calculate(); rec.Code != http.StatusCreated {
+		t.Fatalf("first calculate: got %d body=%s", rec.Code, rec.Body.String())
+	}
+	rec := calculate()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("second calculate: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var problem Problem
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil || problem.Code != CodeQuotaExceeded {
+		t.Fatalf("second calculate: problem %+v, err %v", problem, err)
+	}
+	if details, _ := problem.Details.(map[string]interface{}); details["retry_after_seconds"] != float64(60) {
+		t.Errorf("quota details: %+v", problem.Details)
+	}
+	scheduler.Drain(t.Context())
+
+	adminDo := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminJWT)
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := adminDo(http.MethodPut, "/api/v1/admin/quotas/1", `{"submissions_per_minute":-1}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("negative limit: got %d", rec.Code)
+	}
+	if rec := adminDo(http.MethodPut, "/api/v1/admin/quotas/1", `{"submissions_per_minute":5}`); rec.Code != http.StatusOK {
+		t.Fatalf("set quota: got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := calculate(); rec.Code != http.StatusCreated {
+		t.Fatalf("calculate after raising the limit: got %d body=%s", rec.Code, rec.Body.String())
+	}
+	scheduler.Drain(t.Context())
+
+	rec = adminDo(http.MethodGet, "/api/v1/admin/quotas", "")
+	var infos []UserQuotaInfo
+	if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil || len(infos) != 2 {
+		t.Fatalf("list quotas: code %d, %+v, err %v", rec.Code, infos, err)
+	}
+	if got := infos[0]; got.Limits.SubmissionsPerMinute != 5 || got.Usage.SubmissionsLastMinute != 2 || got.Overrides == nil {
+		t.Errorf("user quota info = %+v", got)
+	}
+	if rec := adminDo(http.MethodDelete, "/api/v1/admin/quotas/1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("reset quota: got %d", rec.Code)
+	}
+	if rec := adminDo(http.MethodDelete, "/api/v1/admin/quotas/1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("repeated reset: got %d", rec.Code)
+	}
+}
+
+func TestQuotasConcurrentSubmissions(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	scheduler.SetQuotas(NewQuotas(store, operations.Default, QuotaLimits{MaxActiveExpressions: 3}))
+	uid, _ := store.CreateUser("u", "hash")
+
+	// Параллельные запросы не должны пройти лимит активных выражений все сразу.
+	var (
+		wg       sync.WaitGroup
+		admitted atomic.Int32
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := scheduler.SubmitExpression(uid, "1+2", "")
+			var qe *QuotaError
+			switch {
+			case err == nil:
+				admitted.Add(1)
+			case !errors.As(err, &qe) || qe.Limit != QuotaMaxActiveExpressions:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	scheduler.Drain(t.Context())
+	if got := admitted.Load(); got != 3 {
+		t.Fatalf("admitted %d concurrent submissions, want 3", got)
+	}
+}
+
+// failingCreateStore не сохраняет выражения, пока fail == true.
+type failingCreateStore struct {
+	database.Storage
+	fail bool
+}
+
+func (s *failingCreateStore) CreateExpressionWithPriority(userID int64, expression, priority string) (int64, error) {
+	if s.fail {
+		return 0, errors.New("insert failed")
+	}
+	return s.Storage.CreateExpressionWithPriority(userID, expression, priority)
+}
+
+func TestQuotasFailedCreateKeepsBudget(t *testing.T) {
+	base, _ := newTestStore(t)
+	store := &failingCreateStore{Storage: base, fail: true}
+	scheduler := NewScheduler(store)
+	scheduler.SetQuotas(NewQuotas(store, operations.Default, QuotaLimits{SubmissionsPerMinute: 1}))
+	uid, _ := store.CreateUser("u", "hash")
+
+	var qe *QuotaError
+	if _, err := scheduler.SubmitExpression(uid, "1+2", ""); err == nil || errors.As(err, &qe) {
+		t.Fatalf("failed insert: got %v, want the storage error", err)
+	}
+	store.fail = false
+	if _, err := scheduler.SubmitExpression(uid, "1+2", ""); err != nil {
+		t.Fatalf("a failed insert must not use up the rate budget: %v", err)
+	}
+	if _, err := scheduler.SubmitExpression(uid, "1+2", ""); !errors.As(err, &qe) || qe.Limit != QuotaSubmissionsPerMinute {
+		t.Fatalf("third submission: got %v, want %s", err, QuotaSubmissionsPerMinute)
+	}
+	scheduler.Drain(t.Context())
+}
+
+// slowCountStore задерживает подсчёт активных выражений пользователя slow до закрытия release.
+type slowCountStore struct {
+	database.Storage
+	slow    int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowCountStore) CountActiveExpressions(userID int64) (int, error) {
+	if userID == s.slow {
+		close(s.started)
+		<-s.release
+	}
+	return s.Storage.CountActiveExpressions(userID)
+}
+
+func TestQuotasSlowCountDoesNotBlockOtherUsers(t *testing.T) {
+	base, _ := newTestStore(t)
+	slow, _ := base.CreateUser("slow", "hash")
+	fast, _ := base.CreateUser("fast", "hash")
+	store := &slowCountStore{Storage: base, slow: slow, started: make(chan struct{}), release: make(chan struct{})}
+	quotas := NewQuotas(store, operations.Default, QuotaLimits{MaxActiveExpressions: 3})
+
+	done := make(chan error, 1)
+	go func() {
+		r, err := quotas.Reserve(slow, "1+2")
+		if err == nil {
+			r.Cancel()
+		}
+		done <- err
+	}()
+	<-store.started
+
+	reserved := make(chan error, 1)
+	go func() {
+		r, err := quotas.Reserve(fast, "1+2")
+		if err == nil {
+			r.Commit()
+		}
+		reserved <- err
+	}()
+	select {
+	case err := <-reserved:
+		if err != nil {
+			t.Fatalf("Reserve for another user: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reserve for another user waited for a slow count")
+	}
+
+	close(store.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Reserve for the slow user: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package orchestrator
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// PrecisionMode определяет, какие операнды считаются одинаковыми при поиске в кэше.
+type PrecisionMode string
+
+// PrecisionExact — операнды совпадают побитово (float64), результат кэша идентичен вычисленному агентом.
+const PrecisionExact PrecisionMode = "exact"
+
+// resultKey адресует результат операции по её содержимому.
+type resultKey struct {
+	op         string
+	arg1, arg2 uint64 // math.Float64bits: различает 0 и -0, NaN с разной мантиссой
+	precision  PrecisionMode
+}
+
+func newResultKey(op string, arg1, arg2 float64, precision PrecisionMode) resultKey {
+	return resultKey{op: op, arg1: math.Float64bits(arg1), arg2: math.Float64bits(arg2), precision: precision}
+}
+
+type cacheEntry struct {
+	key     resultKey
+	value   float64
+	expires time.Time
+}
+
+// ResultCache — общий для всех выражений кэш результатов операций.
+// Размер ограничен: при переполнении вытесняется давно не использованная запись.
+// Записи старше TTL не выдаются и удаляются при обращении.
+type ResultCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	entries map[resultKey]*list.Element
+	lru     *list.List // Начало — недавно использованные
+	now     func() time.Time
+
+	hits, misses, evictions, expirations int64
+}
+
+// ResultCacheStats — статистика кэша для администраторов.
+type ResultCacheStats struct {
+	Entries     int     `json:"entries"`
+	MaxEntries  int     `json:"max_entries"`
+	TTLSeconds  float64 `json:"ttl_seconds"`
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	HitRate     float64 `json:"hit_rate"` // Доля попаданий среди всех обращений, от 0 до 1
+	Evictions   int64   `json:"evictions"`
+	Expirations int64   `json:"expirations"`
+}
+
+func NewResultCache(maxSize int, ttl time.Duration) *ResultCache {
+	return &ResultCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		entries: make(map[resultKey]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get ищет результат операции.
+func (c *ResultCache) Get(op string, arg1, arg2 float64, precision PrecisionMode) (float64, bool) {
+	key := newResultKey(op, arg1, arg2, precision)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && c.now().After(elem.Value.(*cacheEntry).expires) {
+		c.remove(elem)
+		c.expirations++
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return 0, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// Put сохраняет результат операции.
+func (c *ResultCache) Put(op string, arg1, arg2 float64, precision PrecisionMode, value float64) {
+	key := newResultKey(op, arg1, arg2, precision)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value, entry.expires = value, expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+	for c.lru.Len() >= c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+}
+
+func (c *ResultCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (c *ResultCache) Stats() ResultCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := ResultCacheStats{
+		Entries:     c.lru.Len(),
+		MaxEntries:  c.maxSize,
+		TTLSeconds:  c.ttl.Seconds(),
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
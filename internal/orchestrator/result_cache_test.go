@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"testing"
+	"time"
+
+	"calculator/internal/database"
+)
+
+func TestResultCacheLRUAndTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewResultCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Put("+", 1, 2, PrecisionExact, 3)
+	cache.Put("*", 2, 3, PrecisionExact, 6)
+	if _, ok := cache.Get("+", 1, 2, PrecisionExact); !ok {
+		t.Fatal("expected hit for 1+2")
+	}
+	cache.Put("-", 5, 1, PrecisionExact, 4) // Вытесняет 2*3: к 1+2 обращались позже
+	if _, ok := cache.Get("*", 2, 3, PrecisionExact); ok {
+		t.Fatal("least recently used entry must be evicted")
+	}
+	if _, ok := cache.Get("+", 0, -0.0, PrecisionExact); ok {
+		t.Fatal("unexpected hit for unknown operands")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.Get("+", 1, 2, PrecisionExact); ok {
+		t.Fatal("expired entry must not be returned")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 1 || stats.Expirations != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.HitRate != 0.25 {
+		t.Errorf("hit rate = %v, want 0.25", stats.HitRate)
+	}
+}
+
+func TestSchedulerCommonSubexpressions(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	uid, _ := store.CreateUser("u", "hash")
+	exprID, _ := store.CreateExpression(uid, "(2*3)+(2*3)")
+	if err := scheduler.ScheduleTasks(exprID, "(2*3)+(2*3)"); err != nil {
+		t.Fatalf("ScheduleTasks error: %v", err)
+	}
+	tasks, _ := store.GetAllTasksForExpression(exprID)
+	if len(tasks) != 1 || tasks[0].Operation != "*" {
+		t.Fatalf("expected a single task for 2*3, got %+v", tasks)
+	}
+
+	// Повторное планирование не создаёт дубликатов уже поставленных задач.
+	scheduler.ProcessTaskCompletion(tasks[0].ID)
+	if tasks, _ = store.GetAllTasksForExpression(exprID); len(tasks) != 1 {
+		t.Fatalf("re-planning created duplicates: %+v", tasks)
+	}
+}
+
+func TestSchedulerResultCache(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	scheduler.SetResultCache(NewResultCache(10, time.Minute))
+	scheduler.ResultCache().Put("*", 2, 3, PrecisionExact, 6)
+	uid, _ := store.CreateUser("u", "hash")
+
+	exprID, _ := store.CreateExpression(uid, "2*3")
+	if err := scheduler.ScheduleTasks(exprID, "2*3"); err != nil {
+		t.Fatalf("ScheduleTasks error: %v", err)
+	}
+	expr, _ := store.GetExpressionByIDInternal(exprID)
+	if expr.Status != database.StatusDone || expr.Result.Float64 != 6 {
+		t.Fatalf("expected expression answered from cache, got %+v", expr)
+	}
+	tasks, _ := store.GetAllTasksForExpression(exprID)
+	if len(tasks) != 1 || tasks[0].SettledBy.String != cacheSettledBy {
+		t.Fatalf("expected one task settled by cache, got %+v", tasks)
+	}
+
+	// Пользователь, отключивший кэш, получает задачу для агента.
+	settings := database.DefaultUserSettings(uid)
+	settings.UseResultCache = false
+	if err := store.SaveUserSettings(settings); err != nil {
+		t.Fatalf("SaveUserSettings error: %v", err)
+	}
+	exprID, _ = store.CreateExpression(uid, "2*3")
+	if err := scheduler.ScheduleTasks(exprID, "2*3"); err != nil {
+		t.Fatalf("ScheduleTasks error: %v", err)
+	}
+	tasks, _ = store.GetAllTasksForExpression(exprID)
+	if len(tasks) != 1 || tasks[0].Status != database.StatusPending {
+		t.Fatalf("opt-out must bypass the cache, got %+v", tasks)
+	}
+}
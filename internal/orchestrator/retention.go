@@ -0,0 +1,175 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPurgeInterval  = time.Hour
+	defaultPurgeBatchSize = 500
+	// purgeBatchPause — пауза между пакетами удаления: запись БД не занята
+	// очисткой надолго, и выдача задач агентам между пакетами не ждёт.
+	purgeBatchPause = 50 * time.Millisecond
+)
+
+// RetentionPolicy задаёт, сколько хранить историю вычислений. Нулевой срок — хранить всегда.
+type RetentionPolicy struct {
+	Tasks            time.Duration `yaml:"tasks"`             // Задачи завершённых выражений; сами выражения и их шаги остаются
+	Expressions      time.Duration `yaml:"expressions"`       // Выражения, вычисленные или отменённые
+	ErrorExpressions time.Duration `yaml:"error_expressions"` // Выражения с ошибкой: их обычно хранят дольше для разбора
+	Interval         time.Duration `yaml:"interval"`          // Период очистки и обслуживания БД; 0 — не запускать
+	BatchSize        int           `yaml:"batch_size"`        // Сколько строк удалять в одной транзакции
+}
+
+// DefaultRetentionPolicy хранит историю бессрочно, но раз в час обслуживает файлы БД.
+var DefaultRetentionPolicy = RetentionPolicy{Interval: defaultPurgeInterval, BatchSize: defaultPurgeBatchSize}
+
+// PurgeReport — итог одной очистки.
+type PurgeReport struct {
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Expressions int64     `json:"expressions"` // Удалено выражений
+	Tasks       int64     `json:"tasks"`       // Удалено задач, включая задачи удалённых выражений
+	Compacted   bool      `json:"compacted"`   // Файл БД перестроен (VACUUM)
+	Error       string    `json:"error,omitempty"`
+}
+
+// Purger по расписанию удаляет историю старше сроков RetentionPolicy небольшими
+// пакетами, а затем, если хранилище это поддерживает, обслуживает файлы БД.
+type Purger struct {
+	store  database.RetentionStore
+	policy RetentionPolicy
+	now    func() time.Time
+
+	runMu sync.Mutex // Очистки по расписанию и по запросу администратора не идут одновременно
+	mu    sync.Mutex
+	last  *PurgeReport
+}
+
+func NewPurger(store database.RetentionStore, policy RetentionPolicy) *Purger {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = defaultPurgeBatchSize
+	}
+	return &Purger{store: store, policy: policy, now: time.Now}
+}
+
+// Policy возвращает действующую политику хранения.
+func (p *Purger) Policy() RetentionPolicy {
+	return p.policy
+}
+
+// LastReport возвращает итог последней очистки или nil, если её ещё не было.
+func (p *Purger) LastReport() *PurgeReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last == nil {
+		return nil
+	}
+	report := *p.last
+	return &report
+}
+
+// Run выполняет очистку каждые Interval до отмены ctx.
+func (p *Purger) Run(ctx context.Context) {
+	if p.policy.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Purge(ctx)
+		}
+	}
+}
+
+// Purge удаляет устаревшую историю и возвращает итог. Ошибка не прерывает
+// остальные шаги: она записывается в отчёт, а очистка повторится по расписанию.
+func (p *Purger) Purge(ctx context.Context) PurgeReport {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	now := p.now()
+	report := PurgeReport{StartedAt: now}
+	var errs []error
+
+	// Сначала выражения: их задачи удаляются вместе с ними.
+	purgeExpressions := func(status string, ttl time.Duration) {
+		if ttl <= 0 {
+			return
+		}
+		err := p.inBatches(ctx, func(limit int) (int64, error) {
+			expressions, tasks, err := p.store.PurgeExpressions(status, now.Add(-ttl), limit)
+			report.Expressions += expressions
+			report.Tasks += tasks
+			return expressions, err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("выражения %s: %w", status, err))
+		}
+	}
+	purgeExpressions(database.StatusDone, p.policy.Expressions)
+	purgeExpressions(database.StatusCancelled, p.policy.Expressions)
+	purgeExpressions(database.StatusError, p.policy.ErrorExpressions)
+
+	if p.policy.Tasks > 0 {
+		err := p.inBatches(ctx, func(limit int) (int64, error) {
+			tasks, err := p.store.PurgeTasks(now.Add(-p.policy.Tasks), limit)
+			report.Tasks += tasks
+			return tasks, err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("задачи: %w", err))
+		}
+	}
+
+	if compactor, ok := p.store.(database.Compactor); ok && ctx.Err() == nil {
+		compacted, err := compactor.Compact()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("обслуживание БД: %w", err))
+		}
+		report.Compacted = compacted
+	}
+
+	report.FinishedAt = p.now()
+	if len(errs) > 0 {
+		report.Error = errors.Join(errs...).Error()
+		log.Printf("Ошибка очистки истории: %s", report.Error)
+	}
+	if report.Expressions > 0 || report.Tasks > 0 {
+		log.Printf("Очистка истории: удалено выражений %d, задач %d за %v",
+			report.Expressions, report.Tasks, report.FinishedAt.Sub(report.StartedAt).Round(time.Millisecond))
+	}
+
+	p.mu.Lock()
+	p.last = &report
+	p.mu.Unlock()
+	return report
+}
+
+// inBatches повторяет purge пакетами по BatchSize, пока пакет не окажется неполным.
+func (p *Purger) inBatches(ctx context.Context, purge func(limit int) (int64, error)) error {
+	for {
+		n, err := purge(p.policy.BatchSize)
+		if err != nil {
+			return err
+		}
+		if n < int64(p.policy.BatchSize) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(purgeBatchPause):
+		}
+	}
+}
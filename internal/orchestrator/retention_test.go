@@ -0,0 +1,144 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPurgerRetentionPolicy(t *testing.T) {
+	store := database.NewMemoryStore()
+	uid, _ := store.CreateUser("alice", "h")
+	finish := func(expression, status string, tasks int) int64 {
+		exprID, _ := store.CreateExpression(uid, expression)
+		for i := 0; i < tasks; i++ {
+			store.CreateCompletedTask(exprID, "+", 1, 1, 2, "cache")
+		}
+		store.UpdateExpressionStatusResult(exprID, status, sql.NullFloat64{}, sql.NullString{})
+		return exprID
+	}
+	done := finish("1+1+1+1", database.StatusDone, 3)
+	failed := finish("1/0", database.StatusError, 1)
+	active, _ := store.CreateExpression(uid, "2+2")
+	activeTask, _ := store.CreateTask(active, "+", 2, 2)
+
+	day := 24 * time.Hour
+	purger := NewPurger(store, RetentionPolicy{Tasks: day, Expressions: 7 * day, ErrorExpressions: 30 * day, BatchSize: 2})
+	purgeAt := func(after time.Duration) PurgeReport {
+		t.Helper()
+		purger.now = func() time.Time { return time.Now().Add(after) }
+		report := purger.Purge(t.Context())
+		if report.Error != "" {
+			t.Fatalf("Purge error: %s", report.Error)
+		}
+		return report
+	}
+
+	if report := purgeAt(0); report.Expressions != 0 || report.Tasks != 0 {
+		t.Fatalf("fresh history was purged: %+v", report)
+	}
+	// Через два дня удаляются задачи завершённых выражений (в два пакета), выражения остаются.
+	if report := purgeAt(2 * day); report.Expressions != 0 || report.Tasks != 4 {
+		t.Fatalf("purge after 2 days: %+v, want 4 tasks", report)
+	}
+	if expr, _ := store.GetExpressionByID(done, uid); expr == nil {
+		t.Fatal("expression was purged together with its tasks")
+	}
+	// Через неделю удаляется вычисленное выражение, выражение с ошибкой хранится дольше.
+	if report := purgeAt(8 * day); report.Expressions != 1 {
+		t.Fatalf("purge after 8 days: %+v, want 1 expression", report)
+	}
+	if expr, _ := store.GetExpressionByID(failed, uid); expr == nil {
+		t.Fatal("error expression was purged before its retention period")
+	}
+	if report := purgeAt(31 * day); report.Expressions != 1 {
+		t.Fatalf("purge after 31 days: %+v, want 1 expression", report)
+	}
+
+	if task, _ := store.GetTaskByID(activeTask); task == nil {
+		t.Fatal("task of an active expression was purged")
+	}
+	if list, _ := store.GetExpressionsByUserID(uid); len(list) != 1 || list[0].ID != active {
+		t.Fatalf("expressions left: %+v", list)
+	}
+	if last := purger.LastReport(); last == nil || last.Expressions != 1 {
+		t.Fatalf("LastReport = %+v", last)
+	}
+}
+
+func TestRetentionAPI(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	authService := NewAuthService(store, "testsecret")
+	admin := NewAdminHandlers(store, scheduler)
+	handlers := NewHTTPHandlers(authService, store, scheduler)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/admin/retention", authService.AdminMiddleware(http.HandlerFunc(admin.RetentionHandler)))
+	mux.Handle("/api/v1/expressions", authService.JWTMiddleware(http.HandlerFunc(handlers.ExpressionsHandler)))
+	mux.Handle("/api/v1/expressions/", authService.JWTMiddleware(http.HandlerFunc(handlers.ExpressionsHandler)))
+
+	adminID, _ := store.CreateUser("root", "hash")
+	store.SetUserAdmin(adminID, true)
+	userID, _ := store.CreateUser("plain", "hash")
+	adminJWT, _ := authService.GenerateJWT(adminID)
+	userJWT, _ := authService.GenerateJWT(userID)
+	do := func(method, path, jwt string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/api/v1/admin/retention", adminJWT); rec.Code != http.StatusNotFound {
+		t.Fatalf("retention without purger: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	admin.SetPurger(NewPurger(store, RetentionPolicy{Expressions: time.Hour}))
+
+	rec := do(http.MethodGet, "/api/v1/admin/retention", adminJWT)
+	var info RetentionInfo
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&info) != nil {
+		t.Fatalf("GET retention: code %d body %s", rec.Code, rec.Body.String())
+	}
+	if info.Policy.Expressions != "1h0m0s" || info.Policy.Tasks != "0s" || info.LastPurge != nil {
+		t.Fatalf("GET retention = %+v", info)
+	}
+	if rec := do(http.MethodPost, "/api/v1/admin/retention", userJWT); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin purge: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do(http.MethodPost, "/api/v1/admin/retention", adminJWT); rec.Code != http.StatusOK {
+		t.Fatalf("POST retention: code %d body %s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodGet, "/api/v1/admin/retention", adminJWT)
+	if json.NewDecoder(rec.Body).Decode(&info) != nil || info.LastPurge == nil {
+		t.Fatalf("last purge is not reported: %s", rec.Body.String())
+	}
+
+	// Пользователь очищает свою историю: незавершённые выражения остаются.
+	finished, _ := store.CreateExpression(userID, "1+1")
+	store.CreateCompletedTask(finished, "+", 1, 1, 2, "cache")
+	store.UpdateExpressionStatusResult(finished, database.StatusDone, sql.NullFloat64{Float64: 2, Valid: true}, sql.NullString{})
+	active, _ := store.CreateExpression(userID, "2+2")
+	store.CreateExpression(adminID, "3+3")
+
+	if rec := do(http.MethodDelete, "/api/v1/expressions/1", userJWT); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE of a single expression: got %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	rec = do(http.MethodDelete, "/api/v1/expressions", userJWT)
+	var cleared ClearHistoryResponse
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&cleared) != nil {
+		t.Fatalf("clear history: code %d body %s", rec.Code, rec.Body.String())
+	}
+	if cleared.DeletedExpressions != 1 || cleared.DeletedTasks != 1 {
+		t.Fatalf("clear history = %+v, want 1 expression and 1 task", cleared)
+	}
+	if list, _ := store.GetExpressionsByUserID(userID); len(list) != 1 || list[0].ID != active {
+		t.Fatalf("expressions after clearing history: %+v", list)
+	}
+	if list, _ := store.GetExpressionsByUserID(adminID); len(list) != 1 {
+		t.Fatalf("another user's history was touched: %+v", list)
+	}
+}
@@ -2,36 +2,326 @@ package orchestrator
 
 import (
 	"calculator/internal/database"
+	"calculator/internal/operations"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// OperationTimes — имитируемая длительность операций в миллисекундах по обозначению
+// операции. Приоритет источников: значение, заданное администратором (хранится в БД),
+// затем переменная окружения операции (Operation.TimeEnv), затем стоимость из реестра.
 type OperationTimes struct {
-	Addition       int
-	Subtraction    int
-	Multiplication int
-	Division       int
+	ops       *operations.Registry
+	db        database.OperationTimeStore
+	mu        sync.RWMutex
+	defaults  map[string]OperationTimeInfo // Значения из окружения или реестра
+	overrides map[string]int               // Значения, заданные администратором
+}
+
+// Источники времени операции в OperationTimeInfo.Source.
+const (
+	TimeSourceRegistry = "default"
+	TimeSourceEnv      = "env"
+	TimeSourceAdmin    = "admin"
+)
+
+// OperationTimeInfo описывает действующее время операции.
+type OperationTimeInfo struct {
+	Operation string `json:"operation"`
+	Name      string `json:"name,omitempty"`
+	TimeMs    int    `json:"time_ms"`
+	DefaultMs int    `json:"default_ms"` // Значение, которое действует без заданного администратором
+	Source    string `json:"source"`
+}
+
+func newOperationTimes(ops *operations.Registry, db database.OperationTimeStore) *OperationTimes {
+	t := &OperationTimes{ops: ops, db: db, defaults: make(map[string]OperationTimeInfo), overrides: make(map[string]int)}
+	for _, op := range ops.All() {
+		t.defaults[op.Symbol] = defaultOperationTime(op)
+	}
+	if db != nil {
+		stored, err := db.ListOperationTimes()
+		if err != nil {
+			log.Printf("Ошибка загрузки времени операций из БД, используются значения по умолчанию: %v", err)
+		}
+		for _, st := range stored {
+			t.overrides[st.Operation] = st.TimeMs
+		}
+	}
+	return t
+}
+
+// defaultOperationTimeMs используется для операций, отсутствующих в реестре.
+const defaultOperationTimeMs = 1000
+
+// Get возвращает длительность операции. Операции, зарегистрированные после
+// создания OperationTimes, подхватываются при первом обращении.
+func (t *OperationTimes) Get(symbol string) int {
+	return t.Info(symbol).TimeMs
+}
+
+func (t *OperationTimes) defaultFor(symbol string) OperationTimeInfo {
+	t.mu.RLock()
+	info, ok := t.defaults[symbol]
+	t.mu.RUnlock()
+	if ok {
+		return info
+	}
+
+	op, ok := t.ops.Lookup(symbol)
+	if !ok {
+		return OperationTimeInfo{Operation: symbol, TimeMs: defaultOperationTimeMs, DefaultMs: defaultOperationTimeMs, Source: TimeSourceRegistry}
+	}
+	info = defaultOperationTime(op)
+	t.mu.Lock()
+	t.defaults[symbol] = info
+	t.mu.Unlock()
+	return info
+}
+
+// Set сохраняет время операции, заданное администратором userID. Новое значение
+// применяется к задачам, выданным агентам после вызова.
+func (t *OperationTimes) Set(symbol string, ms int, userID int64) error {
+	if err := t.db.SetOperationTime(symbol, ms, userID); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.overrides[symbol] = ms
+	t.mu.Unlock()
+	return nil
+}
+
+// Reset удаляет заданное администратором время операции. Возвращает false,
+// если оно не было задано.
+func (t *OperationTimes) Reset(symbol string, userID int64) (bool, error) {
+	reset, err := t.db.ResetOperationTime(symbol, userID)
+	if err != nil {
+		return false, err
+	}
+	t.mu.Lock()
+	delete(t.overrides, symbol)
+	t.mu.Unlock()
+	return reset, nil
+}
+
+// Info возвращает действующее время операции и его источник.
+func (t *OperationTimes) Info(symbol string) OperationTimeInfo {
+	info := t.defaultFor(symbol)
+	t.mu.RLock()
+	if ms, ok := t.overrides[symbol]; ok {
+		info.TimeMs, info.Source = ms, TimeSourceAdmin
+	}
+	t.mu.RUnlock()
+	return info
+}
+
+// List возвращает время всех операций реестра.
+func (t *OperationTimes) List() []OperationTimeInfo {
+	var out []OperationTimeInfo
+	for _, op := range t.ops.All() {
+		out = append(out, t.Info(op.Symbol))
+	}
+	return out
+}
+
+func defaultOperationTime(op operations.Operation) OperationTimeInfo {
+	info := OperationTimeInfo{Operation: op.Symbol, Name: op.Name, TimeMs: op.CostMs, Source: TimeSourceRegistry}
+	if op.TimeEnv != "" {
+		if ms, ok := readTimeEnv(op.TimeEnv, op.CostMs); ok {
+			info.TimeMs, info.Source = ms, TimeSourceEnv
+		}
+	}
+	info.DefaultMs = info.TimeMs
+	return info
 }
 
 type Scheduler struct {
-	dbStore *database.Store
+	dbStore database.Storage
+	ops     *operations.Registry
 	opTimes *OperationTimes
+	events  *expressionEvents
+
+	inflight     int64 // Число выполняемых сейчас операций планирования (atomic)
+	lastProgress int64 // Время завершения последней операции планирования, UnixNano (atomic)
+
+	background sync.WaitGroup // Фоновые операции планирования, которые дожидается Drain
+
+	cache    *ResultCache      // Кэш результатов операций; nil — выключен
+	optLevel OptimizationLevel // Уровень упрощения дерева перед планированием
+	quotas   *Quotas           // Лимиты пользователей; nil — без ограничений
 }
 
-func NewScheduler(db *database.Store) *Scheduler {
-	return &Scheduler{
+func NewScheduler(db database.Storage) *Scheduler {
+	s := &Scheduler{
 		dbStore: db,
-		opTimes: initOperationTimes(), // Инициализируем время операций
+		ops:     operations.Default,
+		opTimes: newOperationTimes(operations.Default, db),
+		events:  newExpressionEvents(),
+
+		optLevel:     OptimizeBasic,
+		lastProgress: time.Now().UnixNano(),
+	}
+	if bus, ok := db.(database.ExpressionEventBus); ok {
+		s.events.publish = func(exprID int64) {
+			if err := bus.PublishExpressionChanged(exprID); err != nil {
+				log.Printf("Ошибка рассылки изменения выражения ID %d: %v", exprID, err)
+			}
+		}
 	}
+	return s
+}
+
+// RelayExpressionEvents передаёт подписчикам Subscribe изменения выражений, сделанные
+// другими оркестраторами с той же БД, пока не отменён ctx. Если хранилище не
+// разделяется между оркестраторами, сразу возвращает nil.
+func (s *Scheduler) RelayExpressionEvents(ctx context.Context) error {
+	bus, ok := s.dbStore.(database.ExpressionEventBus)
+	if !ok {
+		return nil
+	}
+	return bus.ListenExpressionChanges(ctx, s.events.deliver)
+}
+
+// track отмечает начало операции планирования; возвращаемую функцию нужно вызвать по её завершении.
+func (s *Scheduler) track() func() {
+	if atomic.AddInt64(&s.inflight, 1) == 1 {
+		// После простоя отсчёт зависания начинается с первой новой операции.
+		atomic.StoreInt64(&s.lastProgress, time.Now().UnixNano())
+	}
+	return func() {
+		atomic.StoreInt64(&s.lastProgress, time.Now().UnixNano())
+		atomic.AddInt64(&s.inflight, -1)
+	}
+}
+
+// Ping сообщает о зависании планировщика: операции планирования выполняются,
+// но ни одна не завершилась за stallTimeout.
+func (s *Scheduler) Ping(stallTimeout time.Duration) error {
+	if atomic.LoadInt64(&s.inflight) == 0 {
+		return nil
+	}
+	last := time.Unix(0, atomic.LoadInt64(&s.lastProgress))
+	if since := time.Since(last); since > stallTimeout {
+		return fmt.Errorf("планировщик не завершил ни одной операции за %v", since.Round(time.Second))
+	}
+	return nil
+}
+
+// SubmitExpression сохраняет выражение пользователя и асинхронно планирует его задачи.
+// Общая точка входа для HTTP и gRPC API. Пустой priority означает PriorityInteractive.
+// Если выражение не проходит лимиты пользователя, возвращается *QuotaError,
+// если превышает ограничения парсера — *ExpressionLimitError.
+func (s *Scheduler) SubmitExpression(userID int64, expression, priority string) (int64, error) {
+	if priority == "" {
+		priority = database.PriorityInteractive
+	}
+	// Выражение сверх ограничений парсера не сохраняется: его нельзя даже разобрать.
+	if _, err := NewParserWithRegistry(expression, s.ops).Parse(); err != nil {
+		var limitErr *ExpressionLimitError
+		if errors.As(err, &limitErr) {
+			return 0, limitErr
+		}
+	}
+	var reservation *QuotaReservation
+	if s.quotas != nil {
+		var err error
+		if reservation, err = s.quotas.Reserve(userID, expression); err != nil {
+			return 0, err
+		}
+	}
+	exprID, err := s.dbStore.CreateExpressionWithPriority(userID, expression, priority)
+	if reservation != nil {
+		// Отправка расходует лимит частоты, только если выражение сохранено.
+		if err != nil {
+			reservation.Cancel()
+		} else {
+			reservation.Commit()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	s.spawn(func() {
+		err := s.ScheduleTasks(exprID, expression)
+		if err != nil {
+			log.Printf("Асинхронная ошибка планирования задач для выражения ID %d: %v", exprID, err)
+		}
+	})
+
+	return exprID, nil
+}
+
+// ProcessTaskCompletionAsync запускает ProcessTaskCompletion в фоне.
+func (s *Scheduler) ProcessTaskCompletionAsync(taskID int64) {
+	s.spawn(func() { s.ProcessTaskCompletion(taskID) })
+}
+
+func (s *Scheduler) spawn(fn func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+}
+
+// Drain ждёт завершения фоновых операций планирования. Вызывается при остановке
+// после того, как серверы перестали принимать запросы.
+func (s *Scheduler) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("не дождались завершения планирования: %w", ctx.Err())
+	}
+}
+
+// CancelExpression отменяет выражение пользователя. Возвращает false,
+// если выражение не найдено или уже завершено.
+func (s *Scheduler) CancelExpression(exprID, userID int64) (bool, error) {
+	cancelled, err := s.dbStore.CancelExpression(exprID, userID)
+	if err != nil {
+		return false, err
+	}
+	if cancelled {
+		s.events.notify(exprID)
+	}
+	return cancelled, nil
+}
+
+// Subscribe подписывает на изменения выражения. Возвращаемую функцию отписки нужно вызвать.
+func (s *Scheduler) Subscribe(exprID int64) (<-chan struct{}, func()) {
+	return s.events.subscribe(exprID)
 }
 
 func (s *Scheduler) ScheduleTasks(expressionID int64, expression string) error {
-	parser := NewParser(expression)
-	ast, err := parser.Parse()
+	defer s.track()()
+	defer s.events.notify(expressionID)
+
+	expr, err := s.dbStore.GetExpressionByIDInternal(expressionID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения выражения ID %d: %w", expressionID, err)
+	}
+	if database.IsFinalStatus(expr.Status) {
+		log.Printf("Выражение ID %d уже в статусе '%s', планирование пропущено.", expressionID, expr.Status)
+		return nil
+	}
+
+	ast, err := s.buildAST(expression)
 	if err != nil {
 		errMsg := fmt.Sprintf("Ошибка парсинга: %v", err)
 		s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusError, sql.NullFloat64{}, sql.NullString{String: errMsg, Valid: true})
@@ -40,7 +330,7 @@ func (s *Scheduler) ScheduleTasks(expressionID int64, expression string) error {
 
 	log.Printf("AST для выражения ID %d построено. Начинаем планирование задач.", expressionID)
 
-	err = s.planTasksRecursive(ast, expressionID)
+	err = s.planTasksRecursive(ast, s.newTaskPlan(expr, nil))
 	if err != nil {
 		errMsg := fmt.Sprintf("Ошибка планирования задач: %v", err)
 		s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusError, sql.NullFloat64{}, sql.NullString{String: errMsg, Valid: true})
@@ -53,7 +343,7 @@ func (s *Scheduler) ScheduleTasks(expressionID int64, expression string) error {
 			log.Printf("Ошибка обновления статуса на in_progress для выражения ID %d: %v", expressionID, err)
 		}
 	} else {
-		log.Printf("Выражение ID %d является числом (%f), завершаем сразу.", expressionID, *ast.Value)
+		log.Printf("Выражение ID %d вычислено без агентов (%f), завершаем сразу.", expressionID, *ast.Value)
 		stepsJSON, _ := json.Marshal([]string{fmt.Sprintf("Result: %f", *ast.Value)})
 		err = s.dbStore.UpdateExpressionStatusResult(expressionID,
 			database.StatusDone,
@@ -69,36 +359,159 @@ func (s *Scheduler) ScheduleTasks(expressionID int64, expression string) error {
 	return nil
 }
 
-func (s *Scheduler) planTasksRecursive(node *Node, expressionID int64) error {
+// cacheSettledBy — значение settled_by у шагов, результат которых взят из кэша.
+const cacheSettledBy = "cache"
+
+// taskPlan — состояние планирования задач одного выражения.
+type taskPlan struct {
+	expressionID int64
+	useCache     bool
+	planned      map[resultKey]bool // Операции, для которых задача уже создана и ещё не выполнена
+}
+
+// newTaskPlan учитывает уже созданные невыполненные задачи выражения, чтобы
+// одинаковые подвыражения и повторное планирование не порождали дубликатов.
+func (s *Scheduler) newTaskPlan(expr *database.Expression, tasks []database.Task) *taskPlan {
+	plan := &taskPlan{expressionID: expr.ID, planned: make(map[resultKey]bool)}
+	for _, t := range tasks {
+		if t.Status == database.StatusPending || t.Status == database.StatusInProgress {
+			plan.planned[newResultKey(t.Operation, t.Arg1, t.Arg2, PrecisionExact)] = true
+		}
+	}
+
+	if s.cache != nil {
+		settings, err := s.dbStore.GetUserSettings(expr.UserID)
+		if err != nil {
+			log.Printf("Scheduler: %v; кэш результатов для выражения ID %d не используется", err, expr.ID)
+		}
+		plan.useCache = err == nil && settings.UseResultCache
+	}
+	return plan
+}
+
+// planTasksRecursive создаёт задачи для операций, операнды которых уже известны.
+// Одинаковые операции выражения выполняются одной задачей: результат заполнит
+// все такие узлы (см. fillASTValues). Если разрешено, результат сначала ищется в кэше.
+func (s *Scheduler) planTasksRecursive(node *Node, plan *taskPlan) error {
 	if node == nil || node.Value != nil { // Базовый случай: лист (число) или пустой узел
 		return nil
 	}
 
-	if err := s.planTasksRecursive(node.Left, expressionID); err != nil {
+	if err := s.planTasksRecursive(node.Left, plan); err != nil {
 		return err
 	}
-	if err := s.planTasksRecursive(node.Right, expressionID); err != nil {
+	if err := s.planTasksRecursive(node.Right, plan); err != nil {
 		return err
 	}
 
-	leftReady := node.Left != nil && node.Left.Value != nil
-	rightReady := node.Right != nil && node.Right.Value != nil
+	arg1, arg2, ready := node.taskArgs()
+	if !ready {
+		return nil
+	}
+	key := newResultKey(node.Op, arg1, arg2, PrecisionExact)
+	if plan.planned[key] {
+		return nil
+	}
 
-	if leftReady && rightReady {
-		_, err := s.dbStore.CreateTask(
-			expressionID,
-			node.Op,
-			*node.Left.Value,
-			*node.Right.Value,
-		)
-		if err != nil {
-			return fmt.Errorf("ошибка создания задачи для операции '%s' выражения ID %d: %w", node.Op, expressionID, err)
+	if plan.useCache {
+		if result, ok := s.cache.Get(node.Op, arg1, arg2, PrecisionExact); ok {
+			if _, err := s.dbStore.CreateCompletedTask(plan.expressionID, node.Op, arg1, arg2, result, cacheSettledBy); err != nil {
+				return fmt.Errorf("ошибка сохранения шага из кэша для операции '%s' выражения ID %d: %w", node.Op, plan.expressionID, err)
+			}
+			node.Value = &result
+			return nil
 		}
 	}
 
+	_, err := s.dbStore.CreateTask(
+		plan.expressionID,
+		node.Op,
+		arg1,
+		arg2,
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка создания задачи для операции '%s' выражения ID %d: %w", node.Op, plan.expressionID, err)
+	}
+	plan.planned[key] = true
 	return nil
 }
 
+// SetOptimizationLevel задаёт уровень упрощения дерева выражения. Вызывается до
+// начала обработки выражений: дерево строится заново при каждом завершении задачи,
+// и у выражения в процессе вычисления оно должно оставаться тем же.
+func (s *Scheduler) SetOptimizationLevel(level OptimizationLevel) {
+	s.optLevel = level
+}
+
+// buildAST разбирает выражение и упрощает дерево согласно уровню оптимизации.
+func (s *Scheduler) buildAST(expression string) (*Node, error) {
+	ast, err := NewParserWithRegistry(expression, s.ops).Parse()
+	if err != nil {
+		return nil, err
+	}
+	return Optimize(ast, s.optLevel, s.ops), nil
+}
+
+// PlanExplanation — дерево выражения до и после оптимизации, для отладки.
+type PlanExplanation struct {
+	Expression string          `json:"expression"`
+	Level      string          `json:"level"`
+	Parsed     TreeExplanation `json:"parsed"`
+	Optimized  TreeExplanation `json:"optimized"`
+}
+
+// TreeExplanation описывает одно дерево: запись со скобками, число операций
+// и число последовательных раундов задач.
+type TreeExplanation struct {
+	Tree       string `json:"tree"`
+	Operations int    `json:"operations"`
+	Rounds     int    `json:"rounds"`
+}
+
+func explainTree(n *Node) TreeExplanation {
+	return TreeExplanation{Tree: n.String(), Operations: n.operationCount(), Rounds: n.rounds()}
+}
+
+// Explain показывает, как выражение будет спланировано на заданном уровне оптимизации.
+func (s *Scheduler) Explain(expression string, level OptimizationLevel) (*PlanExplanation, error) {
+	ast, err := NewParserWithRegistry(expression, s.ops).Parse()
+	if err != nil {
+		return nil, err
+	}
+	return &PlanExplanation{
+		Expression: expression,
+		Level:      level.String(),
+		Parsed:     explainTree(ast),
+		Optimized:  explainTree(Optimize(ast, level, s.ops)),
+	}, nil
+}
+
+// OptimizationLevel возвращает уровень оптимизации, с которым планируются выражения.
+func (s *Scheduler) OptimizationLevel() OptimizationLevel {
+	return s.optLevel
+}
+
+// SetQuotas включает проверку лимитов пользователей при отправке выражений.
+func (s *Scheduler) SetQuotas(quotas *Quotas) {
+	s.quotas = quotas
+}
+
+// Quotas возвращает лимиты пользователей или nil, если они не проверяются.
+func (s *Scheduler) Quotas() *Quotas {
+	return s.quotas
+}
+
+// SetResultCache включает общий для выражений кэш результатов операций.
+// Вызывается до начала обработки выражений.
+func (s *Scheduler) SetResultCache(cache *ResultCache) {
+	s.cache = cache
+}
+
+// ResultCache возвращает кэш результатов или nil, если он выключен.
+func (s *Scheduler) ResultCache() *ResultCache {
+	return s.cache
+}
+
 func (s *Scheduler) GetOperationTimes() *OperationTimes {
 	return s.opTimes
 }
@@ -117,10 +530,10 @@ func fillASTValues(node *Node, doneTasks []database.Task) {
 	// Заполняем дочерние узлы сначала
 	fillASTValues(node.Left, doneTasks)
 	fillASTValues(node.Right, doneTasks)
-	// Если оба дочерних значения известны, ищем соответствующую задачу
-	if node.Op != "" && node.Left != nil && node.Left.Value != nil && node.Right != nil && node.Right.Value != nil {
+	// Если значения всех операндов известны, ищем соответствующую задачу
+	if arg1, arg2, ready := node.taskArgs(); ready {
 		for _, t := range doneTasks {
-			if t.Operation == node.Op && t.Arg1 == *node.Left.Value && t.Arg2 == *node.Right.Value {
+			if t.Operation == node.Op && t.Arg1 == arg1 && t.Arg2 == arg2 {
 				val := t.Result.Float64
 				node.Value = &val
 				break
@@ -131,6 +544,7 @@ func fillASTValues(node *Node, doneTasks []database.Task) {
 
 // ProcessTaskCompletion обрабатывает результаты задач, обновляет AST и поочередно создает новые задачи
 func (s *Scheduler) ProcessTaskCompletion(taskID int64) {
+	defer s.track()()
 	log.Printf("Scheduler: Обработка завершения/ошибки задачи ID %d", taskID)
 
 	// Получаем задачу
@@ -139,25 +553,21 @@ func (s *Scheduler) ProcessTaskCompletion(taskID int64) {
 		log.Printf("Scheduler: Ошибка получения задачи ID %d из БД: %v", taskID, err)
 		return
 	}
-	if task == nil {
-		log.Printf("Scheduler: Задача ID %d не найдена", taskID)
-		return
-	}
 
 	// Получаем выражение
 	expr, err := s.dbStore.GetExpressionByIDInternal(task.ExpressionID)
 	if err != nil {
-		log.Printf("Scheduler: Ошибка получения выражения ID %d из БД: %v", task.ExpressionID, err)
+		log.Printf("Scheduler: Ошибка получения выражения ID %d для задачи ID %d из БД: %v", task.ExpressionID, taskID, err)
 		return
 	}
-	if expr == nil {
-		log.Printf("Scheduler: Выражение ID %d для задачи ID %d не найдено", task.ExpressionID, taskID)
+	if database.IsFinalStatus(expr.Status) {
+		log.Printf("Scheduler: Выражение ID %d уже в статусе '%s', задача ID %d не продвигает его", expr.ID, expr.Status, taskID)
 		return
 	}
+	defer s.events.notify(expr.ID)
 
 	// Парсим AST выражения
-	parser := NewParser(expr.Expression)
-	ast, err := parser.Parse()
+	ast, err := s.buildAST(expr.Expression)
 	if err != nil {
 		errMsg := fmt.Sprintf("Ошибка парсинга выражения при обработке задачи ID %d: %v", taskID, err)
 		log.Printf("Scheduler: %s", errMsg)
@@ -180,12 +590,15 @@ func (s *Scheduler) ProcessTaskCompletion(taskID int64) {
 			doneTasks = append(doneTasks, t)
 		}
 	}
+	if s.cache != nil && task.Status == database.StatusDone && task.Result.Valid {
+		s.cache.Put(task.Operation, task.Arg1, task.Arg2, PrecisionExact, task.Result.Float64)
+	}
 
 	// Заполняем AST значениями из выполненных задач
 	fillASTValues(ast, doneTasks)
 
 	// Планируем следующие задачи
-	err = s.planTasksRecursive(ast, expr.ID)
+	err = s.planTasksRecursive(ast, s.newTaskPlan(expr, allTasks))
 	if err != nil {
 		log.Printf("Scheduler: Ошибка планирования задач для выражения ID %d: %v", expr.ID, err)
 		return
@@ -210,22 +623,15 @@ func (s *Scheduler) ProcessTaskCompletion(taskID int64) {
 	}
 }
 
-func initOperationTimes() *OperationTimes {
-	return &OperationTimes{
-		Addition:       readTimeEnv("TIME_ADDITION_MS", 1000),
-		Subtraction:    readTimeEnv("TIME_SUBTRACTION_MS", 1000),
-		Multiplication: readTimeEnv("TIME_MULTIPLICATION_MS", 1000),
-		Division:       readTimeEnv("TIME_DIVISION_MS", 1000),
-	}
-}
-
-func readTimeEnv(key string, defaultValue int) int {
+// readTimeEnv читает время операции из переменной окружения. ok=false, если
+// переменная не задана или некорректна и используется defaultValue.
+func readTimeEnv(key string, defaultValue int) (ms int, ok bool) {
 	if v := os.Getenv(key); v != "" {
 		if t, err := strconv.Atoi(v); err == nil && t >= 0 {
-			return t
+			return t, true
 		} else {
 			fmt.Printf("Предупреждение: Неверное значение для %s ('%s'), используется значение по умолчанию %d\n", key, v, defaultValue)
 		}
 	}
-	return defaultValue
+	return defaultValue, false
 }
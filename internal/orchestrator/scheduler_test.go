@@ -0,0 +1,135 @@
+package orchestrator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"calculator/internal/database"
+	"calculator/internal/operations"
+)
+
+// TestInteractiveExpressionNotStarvedByBatch проверяет, что короткое выражение
+// одного пользователя вычисляется за несколько выдач задач, пока агенты разбирают
+// большую пакетную очередь другого пользователя.
+func TestInteractiveExpressionNotStarvedByBatch(t *testing.T) {
+	store, scheduler := newTestStore(t)
+	scheduler.SetOptimizationLevel(OptimizeNone)
+	batchUser, _ := store.CreateUser("batch", "hash")
+	user, _ := store.CreateUser("user", "hash")
+
+	const batchSize = 200
+	for i := 0; i < batchSize; i++ {
+		exprID, _ := store.CreateExpressionWithPriority(batchUser, "1+2+3", database.PriorityBatch)
+		if err := scheduler.ScheduleTasks(exprID, "1+2+3"); err != nil {
+			t.Fatalf("ScheduleTasks error: %v", err)
+		}
+	}
+	// Агенты уже заняты пакетной очередью.
+	runAgent := func() {
+		t.Helper()
+		task, err := store.GetAndLeasePendingTask("a1")
+		if err != nil || task == nil {
+			t.Fatalf("GetAndLeasePendingTask: task=%v err=%v", task, err)
+		}
+		result, _ := operations.Default.Evaluate(task.Operation, task.Arg1, task.Arg2)
+		if _, err := store.CompleteTask(task.ID, task.LeaseToken, "agent", result); err != nil {
+			t.Fatalf("CompleteTask error: %v", err)
+		}
+		scheduler.ProcessTaskCompletion(task.ID)
+	}
+	for i := 0; i < 20; i++ {
+		runAgent()
+	}
+
+	exprID, _ := store.CreateExpression(user, "(1+2)*(3+4)-5")
+	if err := scheduler.ScheduleTasks(exprID, "(1+2)*(3+4)-5"); err != nil {
+		t.Fatalf("ScheduleTasks error: %v", err)
+	}
+	leases := 0
+	for {
+		expr, _ := store.GetExpressionByID(exprID, user)
+		if expr.Status == database.StatusDone {
+			if expr.Result.Float64 != 16 {
+				t.Fatalf("result = %v, want 16", expr.Result.Float64)
+			}
+			break
+		}
+		if leases > 12 {
+			t.Fatalf("interactive expression still %q after %d leases", expr.Status, leases)
+		}
+		runAgent()
+		leases++
+	}
+
+	exprs, _ := store.GetExpressionsByUserID(batchUser)
+	done := 0
+	for _, e := range exprs {
+		if e.Status == database.StatusDone {
+			done++
+		}
+	}
+	if done == batchSize {
+		t.Fatal("batch must still be draining when the interactive expression completes")
+	}
+}
+
+// sharedStore — хранилище в памяти с шиной событий, как у PostgresStore: все
+// оркестраторы, созданные поверх него, получают события друг друга.
+type sharedStore struct {
+	*database.MemoryStore
+	mu        sync.Mutex
+	listeners []func(int64)
+	listening sync.WaitGroup
+}
+
+func (s *sharedStore) PublishExpressionChanged(exprID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, handle := range s.listeners {
+		handle(exprID)
+	}
+	return nil
+}
+
+func (s *sharedStore) ListenExpressionChanges(ctx context.Context, handle func(int64)) error {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, handle)
+	s.mu.Unlock()
+	s.listening.Done()
+	<-ctx.Done()
+	return nil
+}
+
+// TestExpressionEventsAcrossOrchestrators проверяет, что подписчик одного
+// оркестратора узнаёт об отмене выражения через другой.
+func TestExpressionEventsAcrossOrchestrators(t *testing.T) {
+	store := &sharedStore{MemoryStore: database.NewMemoryStore()}
+	first, second := NewScheduler(store), NewScheduler(store)
+	store.listening.Add(2)
+	for _, s := range []*Scheduler{first, second} {
+		go s.RelayExpressionEvents(t.Context())
+	}
+	store.listening.Wait()
+
+	uid, _ := store.CreateUser("user", "hash")
+	exprID, _ := store.CreateExpression(uid, "1+2")
+	updates, unsubscribe := second.Subscribe(exprID)
+	defer unsubscribe()
+
+	if ok, err := first.CancelExpression(exprID, uid); err != nil || !ok {
+		t.Fatalf("CancelExpression = %v, %v", ok, err)
+	}
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("subscriber of another orchestrator was not notified")
+	}
+
+	// Без шины событий RelayExpressionEvents сразу завершается.
+	_, local := newTestStore(t)
+	if err := local.RelayExpressionEvents(t.Context()); err != nil {
+		t.Fatalf("RelayExpressionEvents without event bus: %v", err)
+	}
+}
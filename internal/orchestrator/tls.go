@@ -0,0 +1,149 @@
+package orchestrator
+
+import (
+	pb "calculator/internal/grpc/calculator"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const agentIdentityContextKey contextKey = "agentIdentity"
+
+// ServerTLSConfig собирает TLS-конфигурацию gRPC-сервера оркестратора.
+// Если задан clientCAFile, предъявленные клиентские сертификаты проверяются по этому CA.
+// Обязательность сертификата для агентов обеспечивает CertAuthorizer: клиентский
+// CalculatorService на том же порту работает без сертификата.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки сертификата сервера: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения CA %s: %w", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("в файле %s не найдено ни одного сертификата CA", caFile)
+	}
+	return pool, nil
+}
+
+// CertAuthorizer авторизует агентов по идентичности их клиентского сертификата
+// (CommonName или DNS SAN). Пустой список allowed допускает любой сертификат,
+// успешно прошедший проверку по CA. Вызовы других сервисов не проверяются.
+type CertAuthorizer struct {
+	allowed map[string]bool
+}
+
+func NewCertAuthorizer(allowed []string) *CertAuthorizer {
+	a := &CertAuthorizer{allowed: make(map[string]bool)}
+	for _, name := range allowed {
+		if name = strings.TrimSpace(name); name != "" {
+			a.allowed[name] = true
+		}
+	}
+	return a
+}
+
+func (a *CertAuthorizer) authorize(ctx context.Context) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "не удалось определить агента")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "требуется проверенный клиентский сертификат")
+	}
+
+	leaf := tlsInfo.State.VerifiedChains[0][0]
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if len(a.allowed) == 0 || a.allowed[name] {
+			return context.WithValue(ctx, agentIdentityContextKey, name), nil
+		}
+	}
+	return nil, status.Errorf(codes.PermissionDenied, "агент с сертификатом '%s' не авторизован", leaf.Subject.CommonName)
+}
+
+func (a *CertAuthorizer) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isAgentMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := a.authorize(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *CertAuthorizer) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isAgentMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := a.authorize(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// isAgentMethod сообщает, относится ли метод к сервису агентов.
+func isAgentMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+pb.CalculatorAgentService_ServiceDesc.ServiceName+"/")
+}
+
+// isClientMethod сообщает, относится ли метод к клиентскому API (CalculatorService).
+func isClientMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+pb.CalculatorService_ServiceDesc.ServiceName+"/")
+}
+
+// authenticatedStream подменяет контекст потока контекстом с идентичностью агента или пользователя.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// AgentIdentityFromContext возвращает идентичность агента, установленную перехватчиком авторизации.
+func AgentIdentityFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(agentIdentityContextKey).(string)
+	return id, ok && id != ""
+}
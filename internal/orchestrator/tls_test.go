@@ -0,0 +1,181 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"calculator/internal/agent"
+	pb "calculator/internal/grpc/calculator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testCA — одноразовый удостоверяющий центр для тестов TLS.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, filepath.Join(ca.dir, "ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+// issue выпускает сертификат и возвращает пути к файлам сертификата и ключа.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certFile := filepath.Join(ca.dir, commonName+".pem")
+	keyFile := filepath.Join(ca.dir, commonName+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func (ca *testCA) caFile() string {
+	return filepath.Join(ca.dir, "ca.pem")
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// startTLSServer поднимает gRPC-сервер агентов с mTLS поверх bufconn.
+func startTLSServer(t *testing.T, ca *testCA, allowed []string) *bufconn.Listener {
+	t.Helper()
+	store, scheduler := newTestStore(t)
+	serverCert, serverKey := ca.issue(t, "orchestrator", x509.ExtKeyUsageServerAuth)
+	tlsConfig, err := ServerTLSConfig(serverCert, serverKey, ca.caFile())
+	if err != nil {
+		t.Fatalf("ServerTLSConfig error: %v", err)
+	}
+	authorizer := NewCertAuthorizer(allowed)
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(authorizer.UnaryInterceptor()),
+	)
+	pb.RegisterCalculatorAgentServiceServer(srv, NewCalculatorGRPCServer(store, scheduler.GetOperationTimes(), scheduler))
+
+	lis := bufconn.Listen(bufSize)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis
+}
+
+func dialTLS(t *testing.T, lis *bufconn.Listener, tlsConfig *tls.Config) pb.CalculatorAgentServiceClient {
+	t.Helper()
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewCalculatorAgentServiceClient(conn)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	lis := startTLSServer(t, ca, []string{"agent-1"})
+
+	allowedCert, allowedKey := ca.issue(t, "agent-1", x509.ExtKeyUsageClientAuth)
+	strangerCert, strangerKey := ca.issue(t, "agent-2", x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantCode codes.Code
+	}{
+		{"AllowedAgent", allowedCert, allowedKey, codes.OK},
+		{"UnknownAgent", strangerCert, strangerKey, codes.PermissionDenied},
+		{"NoClientCert", "", "", codes.Unauthenticated},
+	}
+	for _, tc := range tests {
+		tlsConfig, err := agent.ClientTLSConfig(ca.caFile(), tc.certFile, tc.keyFile, "orchestrator")
+		if err != nil {
+			t.Fatalf("%s: ClientTLSConfig error: %v", tc.name, err)
+		}
+		client := dialTLS(t, lis, tlsConfig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = client.GetTask(ctx, &pb.GetTaskRequest{AgentId: "spoofed"})
+		cancel()
+		if got := status.Code(err); got != tc.wantCode {
+			t.Errorf("%s: GetTask code = %v (%v), want %v", tc.name, got, err, tc.wantCode)
+		}
+	}
+}
+
+func TestServerTLSConfig_BadFiles(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "orchestrator", x509.ExtKeyUsageServerAuth)
+	if _, err := ServerTLSConfig(cert, key, filepath.Join(ca.dir, "missing.pem")); err == nil {
+		t.Error("expected error for missing client CA file")
+	}
+	if _, err := ServerTLSConfig(cert, cert, ""); err == nil {
+		t.Error("expected error for mismatched key file")
+	}
+}
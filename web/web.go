@@ -0,0 +1,97 @@
+// Package web содержит веб-интерфейс калькулятора, встроенный в бинарный файл
+// оркестратора: интерфейс работает, из какого бы каталога ни был запущен оркестратор.
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed index.html
+var embedded embed.FS
+
+// ErrorWriter отвечает ошибкой с кодом status. Оркестратор передаёт функцию, которая
+// пишет ошибку в том же формате, что и его API; nil — ответ текстом.
+type ErrorWriter func(w http.ResponseWriter, r *http.Request, status int)
+
+// Handler отдаёт файлы веб-интерфейса; "/" — index.html. Если dir не пуст, файлы
+// читаются из этого каталога при каждом запросе и не кэшируются браузером: правки
+// видны без пересборки. Иначе отдаются встроенные файлы с ETag.
+func Handler(dir string, writeError ErrorWriter) http.Handler {
+	if writeError == nil {
+		writeError = func(w http.ResponseWriter, r *http.Request, status int) {
+			http.Error(w, http.StatusText(status), status)
+		}
+	}
+	if dir != "" {
+		return &handler{fsys: os.DirFS(dir), writeError: writeError}
+	}
+	h := &handler{fsys: embedded, etags: make(map[string]string), writeError: writeError}
+	fs.WalkDir(embedded, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			data, _ := embedded.ReadFile(name)
+			sum := sha256.Sum256(data)
+			h.etags[name] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		}
+		return nil
+	})
+	return h
+}
+
+type handler struct {
+	fsys  fs.FS
+	etags map[string]string // Только для встроенных файлов: они не меняются, пока работает процесс
+
+	writeError ErrorWriter
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.writeError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	data, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		h.writeError(w, r, http.StatusNotFound)
+		return
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if h.etags == nil {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("ETag", h.etags[name])
+		w.Header().Set("Cache-Control", cacheControl(name))
+	}
+	// ServeContent отвечает 304 на If-None-Match и поддерживает HEAD и Range.
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// cacheControl: страницу браузер перепроверяет при каждом открытии (по ETag это
+// дёшево), чтобы после обновления оркестратора сразу получить новую версию интерфейса;
+// остальные файлы можно держать в кэше час.
+func cacheControl(name string) string {
+	if strings.HasSuffix(name, ".html") {
+		return "no-cache"
+	}
+	return "public, max-age=3600"
+}
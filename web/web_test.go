@@ -0,0 +1,92 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func get(h http.Handler, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEmbeddedUI(t *testing.T) {
+	h := Handler("", nil)
+
+	rec := get(h, "/")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<html") {
+		t.Fatalf("GET /: code %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("caching headers: ETag %q, Cache-Control %q", etag, rec.Header().Get("Cache-Control"))
+	}
+	if rec := get(h, "/index.html"); rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Fatalf("GET /index.html: code %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	// Браузер с актуальной версией получает 304 без тела.
+	if rec := get(h, "/", "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("revalidation: code %d, body %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	for _, path := range []string{"/missing.js", "/../web.go", "/api/v1/unknown"} {
+		if rec := get(h, path); rec.Code != http.StatusNotFound {
+			t.Fatalf("GET %s: code %d, want 404", path, rec.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /: code %d, want 405", rec.Code)
+	}
+}
+
+func TestErrorWriter(t *testing.T) {
+	var statuses []int
+	h := Handler("", func(w http.ResponseWriter, r *http.Request, status int) {
+		statuses = append(statuses, status)
+		w.WriteHeader(status)
+	})
+	get(h, "/missing.js")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/", nil))
+	if len(statuses) != 2 || statuses[0] != http.StatusNotFound || statuses[1] != http.StatusMethodNotAllowed {
+		t.Fatalf("ErrorWriter statuses = %v, want [404 405]", statuses)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Fatalf("Allow = %q", allow)
+	}
+}
+
+func TestOverrideDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>dev</html>"), 0600)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0600)
+	h := Handler(dir, nil)
+
+	rec := get(h, "/")
+	if rec.Body.String() != "<html>dev</html>" || rec.Header().Get("Cache-Control") != "no-store" || rec.Header().Get("ETag") != "" {
+		t.Fatalf("GET / from dir: body %q, headers %v", rec.Body.String(), rec.Header())
+	}
+	// Правка файла видна сразу, без перезапуска.
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>edited</html>"), 0600)
+	if rec := get(h, "/"); rec.Body.String() != "<html>edited</html>" {
+		t.Fatalf("edited file is not served: %q", rec.Body.String())
+	}
+	if rec := get(h, "/app.js"); !strings.Contains(rec.Header().Get("Content-Type"), "javascript") {
+		t.Fatalf("app.js Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+}